@@ -23,5 +23,6 @@ import (
 type API interface {
 	Add(args *cniSkel.CmdArgs) error
 	Del(args *cniSkel.CmdArgs) error
+	Check(args *cniSkel.CmdArgs) error
 	GetVersion() cniVersion.PluginInfo
 }
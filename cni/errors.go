@@ -0,0 +1,76 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cni
+
+import (
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+)
+
+// Error codes returned by plugins in CNI error objects. Codes 0-99 are reserved by the CNI spec.
+// Orchestrator agents can use the code to distinguish retryable from terminal failures.
+//
+//   Code  Failure class                                  Retryable
+//   100   Internal error, e.g. a recovered panic         No
+//   101   Invalid network configuration or arguments     No
+//   102   Network namespace not found or not usable      No
+//   103   Trunk interface not found or not usable        Yes
+//   104   Branch link creation failed                    Yes
+//   105   IP address assignment failed                   Yes
+//   106   Other link or route setup failed               Yes
+//   107   Link configuration check failed                No
+//   108   Command did not complete within its timeout    Yes
+//   109   IPAM plugin failed to allocate IP addresses    Yes
+//   110   Gateway not reachable after setup              Yes
+const (
+	ErrCodeInternal          uint = 100
+	ErrCodeInvalidConfig     uint = 101
+	ErrCodeNetNS             uint = 102
+	ErrCodeTrunkNotFound     uint = 103
+	ErrCodeLinkCreation      uint = 104
+	ErrCodeAddressAssignment uint = 105
+	ErrCodeLinkSetup         uint = 106
+	ErrCodeLinkCheck         uint = 107
+	ErrCodeTimeout           uint = 108
+	ErrCodeIPAM              uint = 109
+	ErrCodeGatewayCheck      uint = 110
+)
+
+// errorMessages maps error codes to their consistent error messages.
+var errorMessages = map[uint]string{
+	ErrCodeInternal:          "internal error",
+	ErrCodeInvalidConfig:     "invalid network configuration",
+	ErrCodeNetNS:             "failed to find network namespace",
+	ErrCodeTrunkNotFound:     "failed to find trunk interface",
+	ErrCodeLinkCreation:      "failed to create branch link",
+	ErrCodeAddressAssignment: "failed to assign IP address",
+	ErrCodeLinkSetup:         "failed to setup link",
+	ErrCodeLinkCheck:         "link configuration does not match",
+	ErrCodeTimeout:           "operation timed out",
+	ErrCodeIPAM:              "failed to allocate IP address from IPAM",
+	ErrCodeGatewayCheck:      "gateway is not reachable",
+}
+
+// NewError creates a new CNI error object with the given code, wrapping the given error as details.
+// If err is already a CNI error object, it is returned as is.
+func NewError(code uint, err error) *cniTypes.Error {
+	if cniErr, ok := err.(*cniTypes.Error); ok {
+		return cniErr
+	}
+
+	return &cniTypes.Error{
+		Code:    code,
+		Msg:     errorMessages[code],
+		Details: err.Error(),
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/aws/amazon-vpc-cni-plugins/capabilities"
 	"github.com/aws/amazon-vpc-cni-plugins/logger"
+	"github.com/aws/amazon-vpc-cni-plugins/metrics"
 	"github.com/aws/amazon-vpc-cni-plugins/version"
 
 	log "github.com/cihub/seelog"
@@ -105,7 +106,7 @@ func (plugin *Plugin) Run() *cniTypes.Error {
 			len := runtime.Stack(buf, false)
 
 			cniErr := &cniTypes.Error{
-				Code:    100,
+				Code:    ErrCodeInternal,
 				Msg:     fmt.Sprintf("%v", r),
 				Details: string(buf[:len]),
 			}
@@ -118,8 +119,13 @@ func (plugin *Plugin) Run() *cniTypes.Error {
 	log.Infof("Plugin %s version %s executing CNI command.", plugin.Name, version.Version)
 
 	// Execute CNI command handlers.
+	about := fmt.Sprintf("%s CNI plugin version %s", plugin.Name, version.Version)
 	cniErr := cniSkel.PluginMainWithError(
-		plugin.Commands.Add, plugin.Commands.Del, plugin.Commands.GetVersion())
+		plugin.withMetrics("ADD", plugin.Commands.Add),
+		plugin.withMetrics("CHECK", plugin.Commands.Check),
+		plugin.withMetrics("DEL", plugin.Commands.Del),
+		plugin.Commands.GetVersion(),
+		about)
 	if cniErr != nil {
 		log.Errorf("CNI command failed: %+v", cniErr)
 	}
@@ -127,6 +133,22 @@ func (plugin *Plugin) Run() *cniTypes.Error {
 	return cniErr
 }
 
+// withMetrics wraps a CNI command handler to emit its duration and error class to the metrics sink.
+func (plugin *Plugin) withMetrics(command string, handler func(*cniSkel.CmdArgs) error) func(*cniSkel.CmdArgs) error {
+	return func(args *cniSkel.CmdArgs) error {
+		timer := metrics.Start(plugin.Name, command)
+		err := handler(args)
+
+		metricsErr := timer.Stop(err)
+		if metricsErr != nil {
+			// Log and ignore the failure.
+			log.Errorf("Failed to emit metrics: %v.", metricsErr)
+		}
+
+		return err
+	}
+}
+
 // Add is an empty CNI ADD command handler to ensure all CNI plugins implement CNIAPI.
 func (plugin *Plugin) Add(args *cniSkel.CmdArgs) error {
 	return nil
@@ -137,6 +159,11 @@ func (plugin *Plugin) Del(args *cniSkel.CmdArgs) error {
 	return nil
 }
 
+// Check is an empty CNI CHECK command handler to ensure all CNI plugins implement CNIAPI.
+func (plugin *Plugin) Check(args *cniSkel.CmdArgs) error {
+	return nil
+}
+
 // GetVersion is the default CNI VERSION command handler.
 func (plugin *Plugin) GetVersion() cniVersion.PluginInfo {
 	return plugin.SpecVersions
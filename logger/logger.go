@@ -14,8 +14,12 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/cihub/seelog"
 )
@@ -24,25 +28,80 @@ const (
 	// Environment variables for custom log settings.
 	envLogLevel    = "VPC_CNI_LOG_LEVEL"
 	envLogFilePath = "VPC_CNI_LOG_FILE"
+	envLogFormat   = "VPC_CNI_LOG_FORMAT"
+	envWarnStderr  = "VPC_CNI_WARN_STDERR"
+
+	// Environment variables for size-based log rotation. The maximum size is in megabytes.
+	envLogMaxSize    = "VPC_CNI_LOG_MAX_SIZE"
+	envLogMaxBackups = "VPC_CNI_LOG_MAX_BACKUPS"
+
+	// Default number of rotated log files kept with size-based rotation.
+	defaultLogMaxBackups = 5
+
+	// Log format values.
+	logFormatJSON = "json"
+
+	// Log record formats used by seelog.
+	textLogFormat = "%UTCDate(2006-01-02T15:04:05Z07:00) [%LEVEL] %ContainerID%Msg%n"
+	jsonLogFormat = "%JSONRecord%n"
+
+	// Names of the custom seelog formatters for JSON records and container IDs.
+	jsonRecordFormatterName  = "JSONRecord"
+	containerIDFormatterName = "ContainerID"
 
 	// Log configuration used by seelog.
 	logConfigFormat = `
 <seelog type="asyncloop" minlevel="%s">
  <outputs formatid="main">
-  <rollingfile filename="%s" type="date" datepattern="2006-01-02-15" archivetype="none" maxrolls="24" />
+  %s
  </outputs>
  <formats>
-  <format id="main" format="%%UTCDate(2006-01-02T15:04:05Z07:00) [%%LEVEL] %%Msg%%n" />
+  <format id="main" format="%s" />
  </formats>
 </seelog>
 `
+
+	// Log file outputs used by seelog. By default, the log file is rotated every hour. With
+	// size-based rotation, rotated files are suffixed with increasing numbers, and the oldest
+	// ones beyond the maximum number of backups are deleted.
+	dateRollingFileFormat = `<rollingfile filename="%s" type="date" datepattern="2006-01-02-15" archivetype="none" maxrolls="24" />`
+	sizeRollingFileFormat = `<rollingfile filename="%s" type="size" maxsize="%d" archivetype="none" maxrolls="%d" />`
 )
 
+// jsonRecord is a structured log record.
+type jsonRecord struct {
+	Time        string `json:"time"`
+	Level       string `json:"level"`
+	Msg         string `json:"msg"`
+	Command     string `json:"command,omitempty"`
+	ContainerID string `json:"containerID,omitempty"`
+	Netns       string `json:"netns,omitempty"`
+	IfName      string `json:"ifName,omitempty"`
+}
+
+// containerID is the ID of the container that the current CNI command is executed for.
+var containerID string
+
+func init() {
+	err := log.RegisterCustomFormatter(jsonRecordFormatterName, newJSONRecordFormatter)
+	if err != nil {
+		fmt.Println("Failed to register JSON log formatter: ", err)
+	}
+
+	err = log.RegisterCustomFormatter(containerIDFormatterName, newContainerIDFormatter)
+	if err != nil {
+		fmt.Println("Failed to register container ID log formatter: ", err)
+	}
+}
+
+// SetContainerID sets the container ID that is added to all subsequent log records.
+func SetContainerID(id string) {
+	containerID = id
+}
+
 // Setup sets up a file logger.
 func Setup(logFilePath string) {
-	config := fmt.Sprintf(logConfigFormat, getLogLevel(), getLogFilePath(logFilePath))
-
-	logger, err := log.LoggerFromConfigAsString(config)
+	logger, err := log.LoggerFromConfigAsString(getLogConfig(logFilePath))
 	if err != nil {
 		fmt.Println("Failed to setup logger: ", err)
 		return
@@ -51,6 +110,37 @@ func Setup(logFilePath string) {
 	log.ReplaceLogger(logger)
 }
 
+// Warnf logs a warning about a condition that does not fail the CNI command. Since CNI results
+// have no place for warnings, they are also written to stderr for the container runtime to capture
+// if VPC_CNI_WARN_STDERR is set to 1.
+func Warnf(format string, params ...interface{}) {
+	log.Warnf(format, params...)
+	if os.Getenv(envWarnStderr) == "1" {
+		fmt.Fprintf(os.Stderr, "WARNING: "+format+"\n", params...)
+	}
+}
+
+// getLogConfig returns the seelog configuration for the given default log file path.
+func getLogConfig(defaultLogFilePath string) string {
+	return fmt.Sprintf(logConfigFormat, getLogLevel(), getLogOutput(getLogFilePath(defaultLogFilePath)), getLogFormat())
+}
+
+// getLogOutput returns the seelog output writing to the given log file. The log file is rotated
+// by size if a valid maximum size is set, and by date otherwise.
+func getLogOutput(logFilePath string) string {
+	maxSize, err := strconv.Atoi(os.Getenv(envLogMaxSize))
+	if err != nil || maxSize <= 0 {
+		return fmt.Sprintf(dateRollingFileFormat, logFilePath)
+	}
+
+	maxBackups, err := strconv.Atoi(os.Getenv(envLogMaxBackups))
+	if err != nil || maxBackups <= 0 {
+		maxBackups = defaultLogMaxBackups
+	}
+
+	return fmt.Sprintf(sizeRollingFileFormat, logFilePath, maxSize*1024*1024, maxBackups)
+}
+
 // GetLogLevel returns the effective log level.
 func getLogLevel() string {
 	logLevel, ok := log.LogLevelFromString(os.Getenv(envLogLevel))
@@ -61,6 +151,64 @@ func getLogLevel() string {
 	return logLevel.String()
 }
 
+// getLogFormat returns the effective seelog record format.
+func getLogFormat() string {
+	switch strings.ToLower(os.Getenv(envLogFormat)) {
+	case logFormatJSON:
+		return jsonLogFormat
+	default:
+		return textLogFormat
+	}
+}
+
+// newJSONRecordFormatter creates a seelog formatter that emits each message as a JSON record
+// tagged with the CNI invocation context.
+func newJSONRecordFormatter(param string) log.FormatterFunc {
+	return func(message string, level log.LogLevel, context log.LogContextInterface) interface{} {
+		return formatJSONRecord(message, level, context.CallTime())
+	}
+}
+
+// newContainerIDFormatter creates a seelog formatter that emits the container ID, if known,
+// as a prefix to each text log message.
+func newContainerIDFormatter(param string) log.FormatterFunc {
+	return func(message string, level log.LogLevel, context log.LogContextInterface) interface{} {
+		if containerID == "" {
+			return ""
+		}
+		return fmt.Sprintf("[%s] ", containerID)
+	}
+}
+
+// formatJSONRecord formats a log message as a JSON record.
+func formatJSONRecord(message string, level log.LogLevel, callTime time.Time) string {
+	record := jsonRecord{
+		Time:        callTime.UTC().Format(time.RFC3339),
+		Level:       level.String(),
+		Msg:         message,
+		Command:     os.Getenv("CNI_COMMAND"),
+		ContainerID: getContainerID(),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return message
+	}
+
+	return string(data)
+}
+
+// getContainerID returns the container ID set by the plugin, or the one from CNI_CONTAINERID.
+func getContainerID() string {
+	if containerID != "" {
+		return containerID
+	}
+
+	return os.Getenv("CNI_CONTAINERID")
+}
+
 // GetLogFilePath returns the effective log file path.
 func getLogFilePath(defaultLogFilePath string) string {
 	logFilePath := os.Getenv(envLogFilePath)
@@ -14,11 +14,19 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	log "github.com/cihub/seelog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetLogFilePathReturnsOverriddenPath(t *testing.T) {
@@ -34,6 +42,48 @@ func TestGetLogFilePathReturnsDefaultPath(t *testing.T) {
 	assert.Equal(t, path, getLogFilePath(path))
 }
 
+func TestLogOutputDefaultsToDateRotation(t *testing.T) {
+	assert.Equal(t, fmt.Sprintf(dateRollingFileFormat, "/tmp/foo"), getLogOutput("/tmp/foo"))
+
+	os.Setenv(envLogMaxSize, "none")
+	defer os.Unsetenv(envLogMaxSize)
+	assert.Equal(t, fmt.Sprintf(dateRollingFileFormat, "/tmp/foo"), getLogOutput("/tmp/foo"))
+}
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv(envLogMaxSize, "1")
+	defer os.Unsetenv(envLogMaxSize)
+	os.Setenv(envLogMaxBackups, "2")
+	defer os.Unsetenv(envLogMaxBackups)
+
+	logFilePath := filepath.Join(dir, "plugin.log")
+	assert.Equal(t, fmt.Sprintf(sizeRollingFileFormat, logFilePath, 1024*1024, 2), getLogOutput(logFilePath))
+
+	testLogger, err := log.LoggerFromConfigAsString(getLogConfig(logFilePath))
+	require.NoError(t, err)
+
+	// Write about 3MB of log records, past the 1MB threshold.
+	msg := strings.Repeat("x", 1024)
+	for i := 0; i < 3*1024; i++ {
+		testLogger.Info(msg)
+	}
+	testLogger.Close()
+
+	// Only the maximum number of rotated files are kept, each at most slightly past the threshold.
+	rolled, err := filepath.Glob(logFilePath + ".*")
+	require.NoError(t, err)
+	assert.Len(t, rolled, 2)
+	for _, path := range append(rolled, logFilePath) {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, info.Size() <= 1024*1024+2048, "%s has size %d", path, info.Size())
+	}
+}
+
 func TestLogLevelReturnsOverriddenLevel(t *testing.T) {
 	os.Setenv(envLogLevel, "debug")
 	defer os.Unsetenv(envLogLevel)
@@ -57,3 +107,71 @@ func TestLogLevelReturnsDefaultLevelWhenEnvSetToInvalidValue(t *testing.T) {
 	expectedLogLevel = log.InfoLvl
 	assert.Equal(t, expectedLogLevel.String(), getLogLevel())
 }
+
+func TestLogFormatReturnsTextWhenEnvNotSet(t *testing.T) {
+	assert.Equal(t, textLogFormat, getLogFormat())
+}
+
+func TestLogFormatReturnsJSONWhenEnvSet(t *testing.T) {
+	os.Setenv(envLogFormat, "JSON")
+	defer os.Unsetenv(envLogFormat)
+
+	assert.Equal(t, jsonLogFormat, getLogFormat())
+}
+
+func TestTextRecordIncludesContainerID(t *testing.T) {
+	var buf bytes.Buffer
+	testLogger, err := log.LoggerFromWriterWithMinLevelAndFormat(&buf, log.InfoLvl, textLogFormat)
+	assert.NoError(t, err)
+	defer testLogger.Close()
+
+	testLogger.Info("Creating branch link.")
+	SetContainerID("container_1")
+	defer SetContainerID("")
+	testLogger.Info("Creating branch link.")
+	testLogger.Flush()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasSuffix(lines[0], "[INFO] Creating branch link."), lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], "[INFO] [container_1] Creating branch link."), lines[1])
+}
+
+func TestFormatJSONRecordPrefersSetContainerID(t *testing.T) {
+	os.Setenv("CNI_CONTAINERID", "container_1")
+	defer os.Unsetenv("CNI_CONTAINERID")
+	SetContainerID("container_2")
+	defer SetContainerID("")
+
+	output := formatJSONRecord("Creating branch link.", log.InfoLvl, time.Now())
+
+	var record jsonRecord
+	err := json.Unmarshal([]byte(output), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, "container_2", record.ContainerID)
+}
+
+func TestFormatJSONRecordIncludesCNIContext(t *testing.T) {
+	os.Setenv("CNI_COMMAND", "ADD")
+	defer os.Unsetenv("CNI_COMMAND")
+	os.Setenv("CNI_CONTAINERID", "container_1")
+	defer os.Unsetenv("CNI_CONTAINERID")
+	os.Setenv("CNI_NETNS", "/var/run/netns/test")
+	defer os.Unsetenv("CNI_NETNS")
+	os.Setenv("CNI_IFNAME", "eth0")
+	defer os.Unsetenv("CNI_IFNAME")
+
+	callTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	output := formatJSONRecord(`Creating "branch" link.`, log.InfoLvl, callTime)
+
+	var record jsonRecord
+	err := json.Unmarshal([]byte(output), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-01-02T03:04:05Z", record.Time)
+	assert.Equal(t, "info", record.Level)
+	assert.Equal(t, `Creating "branch" link.`, record.Msg)
+	assert.Equal(t, "ADD", record.Command)
+	assert.Equal(t, "container_1", record.ContainerID)
+	assert.Equal(t, "/var/run/netns/test", record.Netns)
+	assert.Equal(t, "eth0", record.IfName)
+}
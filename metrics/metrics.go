@@ -0,0 +1,124 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+)
+
+const (
+	// Environment variable for the path of the metrics file or unix socket.
+	envMetricsPath = "VPC_CNI_METRICS_PATH"
+
+	// Names of the emitted metrics.
+	durationMetricName = "vpc_cni_command_duration_seconds"
+	errorsMetricName   = "vpc_cni_command_errors_total"
+
+	// Permissions of a newly created metrics file.
+	metricsFileMode = 0644
+)
+
+// now returns the current time. It is a variable so that it can be replaced in unit tests.
+var now = time.Now
+
+// Timer measures the execution of a single CNI command.
+// CNI plugins are short-lived processes, so each timer emits its samples once, when stopped,
+// in Prometheus text exposition format with explicit timestamps. The consumer of the metrics
+// sink is expected to aggregate the samples across plugin invocations.
+type Timer struct {
+	plugin  string
+	command string
+	start   time.Time
+}
+
+// Start starts a timer for the given plugin and CNI command.
+func Start(plugin string, command string) *Timer {
+	return &Timer{
+		plugin:  plugin,
+		command: command,
+		start:   now(),
+	}
+}
+
+// Stop stops the timer and emits the duration of the command, and the error class if the
+// command failed, to the metrics sink. It is a no-op if no metrics sink is configured.
+func (timer *Timer) Stop(err error) error {
+	path := os.Getenv(envMetricsPath)
+	if path == "" {
+		return nil
+	}
+
+	return write(path, timer.format(now(), err))
+}
+
+// format returns the metric samples for the command in Prometheus text exposition format.
+func (timer *Timer) format(end time.Time, err error) []byte {
+	var buf bytes.Buffer
+	timestamp := end.UnixNano() / int64(time.Millisecond)
+	labels := fmt.Sprintf(`plugin="%s",command="%s"`, timer.plugin, timer.command)
+
+	fmt.Fprintf(&buf, "%s{%s} %g %d\n",
+		durationMetricName, labels, end.Sub(timer.start).Seconds(), timestamp)
+
+	if err != nil {
+		fmt.Fprintf(&buf, "%s{%s,code=\"%d\"} 1 %d\n",
+			errorsMetricName, labels, getErrorCode(err), timestamp)
+	}
+
+	return buf.Bytes()
+}
+
+// getErrorCode returns the CNI error code that classifies the given error.
+func getErrorCode(err error) uint {
+	if cniErr, ok := err.(*cniTypes.Error); ok {
+		return cniErr.Code
+	}
+
+	// Plain errors are reported to the runtime as internal errors.
+	return cniTypes.ErrInternal
+}
+
+// write writes data to the unix socket or the file at the given path. Files are appended to with
+// a single write, so that samples from concurrent plugin invocations are not interleaved.
+func write(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err == nil && info.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		_, err = conn.Write(data)
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, metricsFileMode)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testStartTime = time.Unix(1500000000, 0)
+	testEndTime   = testStartTime.Add(250 * time.Millisecond)
+)
+
+// runTestCommand times a command that takes 250ms and fails with the given error.
+func runTestCommand(t *testing.T, err error) {
+	now = func() time.Time { return testStartTime }
+	defer func() { now = time.Now }()
+
+	timer := Start("vpc-branch-eni", "ADD")
+	now = func() time.Time { return testEndTime }
+	require.NoError(t, timer.Stop(err))
+}
+
+// setupMetricsFile points the metrics sink to a file in a temporary directory.
+func setupMetricsFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "metrics")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "metrics.prom")
+	os.Setenv(envMetricsPath, path)
+
+	return path, func() {
+		os.Unsetenv(envMetricsPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSuccessfulAdd(t *testing.T) {
+	path, cleanup := setupMetricsFile(t)
+	defer cleanup()
+
+	runTestCommand(t, nil)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`vpc_cni_command_duration_seconds{plugin="vpc-branch-eni",command="ADD"} 0.25 1500000000250`+"\n",
+		string(data))
+}
+
+func TestFailedAdd(t *testing.T) {
+	path, cleanup := setupMetricsFile(t)
+	defer cleanup()
+
+	runTestCommand(t, &cniTypes.Error{Code: 105, Msg: "failed to assign IP address"})
+	runTestCommand(t, errors.New("plain error"))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`vpc_cni_command_duration_seconds{plugin="vpc-branch-eni",command="ADD"} 0.25 1500000000250`+"\n"+
+			`vpc_cni_command_errors_total{plugin="vpc-branch-eni",command="ADD",code="105"} 1 1500000000250`+"\n"+
+			`vpc_cni_command_duration_seconds{plugin="vpc-branch-eni",command="ADD"} 0.25 1500000000250`+"\n"+
+			`vpc_cni_command_errors_total{plugin="vpc-branch-eni",command="ADD",code="999"} 1 1500000000250`+"\n",
+		string(data))
+}
+
+func TestUnixSocketSink(t *testing.T) {
+	path, cleanup := setupMetricsFile(t)
+	defer cleanup()
+
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan string)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	runTestCommand(t, nil)
+
+	assert.Equal(t,
+		`vpc_cni_command_duration_seconds{plugin="vpc-branch-eni",command="ADD"} 0.25 1500000000250`+"\n",
+		<-received)
+}
+
+func TestNoMetricsSink(t *testing.T) {
+	os.Unsetenv(envMetricsPath)
+	assert.NoError(t, Start("vpc-branch-eni", "DEL").Stop(nil))
+}
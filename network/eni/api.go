@@ -31,6 +31,7 @@ type API interface {
 
 	SetLinkName(name string) error
 	SetLinkMTU(mtu uint) error
+	SetLinkAlias(alias string) error
 	SetOpState(up bool) error
 	SetNetNS(ns netns.NetNS) error
 	SetMACAddress(address net.HardwareAddr) error
@@ -16,19 +16,49 @@
 package eni
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"os"
+	"sort"
 
 	log "github.com/cihub/seelog"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
+// VLANProtocol is the EtherType of the VLAN tag added by a branch VLAN link.
+type VLANProtocol uint16
+
+const (
+	VLANProtocol8021Q  VLANProtocol = 0x8100
+	VLANProtocol8021AD VLANProtocol = 0x88a8
+
+	// Netlink link kind of VLAN links.
+	vlanLinkKind = "vlan"
+
+	// Netlink attribute type of each entry in a VLAN QoS map (IFLA_VLAN_QOS_MAPPING).
+	vlanQoSMappingAttr = 1
+)
+
+// VLANQoSMap maps priorities between skb priorities and VLAN (802.1p) priorities. Egress maps
+// are keyed by skb priority, and ingress maps by VLAN priority.
+type VLANQoSMap map[uint32]uint32
+
+// linkAdd creates a link. It is a variable so that it can be replaced in unit tests.
+var linkAdd = netlink.LinkAdd
+
 // Branch represents a VPC branch ENI.
 type Branch struct {
 	ENI
-	isolationID int
-	trunk       *Trunk
+	isolationID  int
+	vlanProtocol VLANProtocol
+	egressQoS    VLANQoSMap
+	ingressQoS   VLANQoSMap
+	macvlan      bool
+	macvlanMode  netlink.MacvlanMode
+	trunk        *Trunk
 }
 
 // NewBranch creates a new Branch object.
@@ -46,13 +76,47 @@ func NewBranch(trunk *Trunk, linkName string, macAddress net.HardwareAddr, isola
 			linkName:   linkName,
 			macAddress: macAddress,
 		},
-		isolationID: isolationID,
+		isolationID:  isolationID,
+		vlanProtocol: VLANProtocol8021Q,
+		trunk:        trunk,
+	}
+
+	return branch, nil
+}
+
+// NewMACVLANBranch creates a new Branch object attached to a MACVLAN link in the given mode
+// instead of a VLAN link. Traffic to and from the branch is untagged on the trunk.
+func NewMACVLANBranch(trunk *Trunk, linkName string, macAddress net.HardwareAddr, mode netlink.MacvlanMode) (*Branch, error) {
+	if trunk == nil {
+		return nil, fmt.Errorf("Invalid trunk")
+	}
+
+	branch := &Branch{
+		ENI: ENI{
+			linkName:   linkName,
+			macAddress: macAddress,
+		},
+		macvlan:     true,
+		macvlanMode: mode,
 		trunk:       trunk,
 	}
 
 	return branch, nil
 }
 
+// SetVLANProtocol sets the protocol of the VLAN link created for the branch ENI.
+// It must be called before the branch ENI is attached to a link.
+func (branch *Branch) SetVLANProtocol(protocol VLANProtocol) {
+	branch.vlanProtocol = protocol
+}
+
+// SetVLANQoSMaps sets the egress and ingress priority mappings of the VLAN link created for the
+// branch ENI. It must be called before the branch ENI is attached to a link.
+func (branch *Branch) SetVLANQoSMaps(egress VLANQoSMap, ingress VLANQoSMap) {
+	branch.egressQoS = egress
+	branch.ingressQoS = ingress
+}
+
 // AttachToLink attaches the branch ENI to a link.
 func (branch *Branch) AttachToLink(setMACAddress bool) error {
 	// Create the VLAN link.
@@ -67,10 +131,20 @@ func (branch *Branch) AttachToLink(setMACAddress bool) error {
 			branch.linkName, setMACAddress)
 	}
 
+	if branch.macvlan {
+		return branch.attachToMACVLANLink(la)
+	}
+
 	vlanLink := &netlink.Vlan{LinkAttrs: la, VlanId: branch.isolationID}
 
-	log.Infof("Creating VLAN link for branch %s: %+v", branch.linkName, vlanLink)
-	err := netlink.LinkAdd(vlanLink)
+	log.Infof("Creating VLAN link for branch %s with protocol %#x: %+v",
+		branch.linkName, uint16(branch.vlanProtocol), vlanLink)
+	var err error
+	if branch.vlanProtocol == VLANProtocol8021Q && len(branch.egressQoS) == 0 && len(branch.ingressQoS) == 0 {
+		err = linkAdd(vlanLink)
+	} else {
+		err = addVLANLink(newVLANLinkRequest(vlanLink, branch.vlanProtocol, branch.egressQoS, branch.ingressQoS), vlanLink)
+	}
 	if err != nil {
 		if os.IsExist(err) {
 			log.Infof("Found existing VLAN link for branch %s.", branch.linkName)
@@ -84,18 +158,108 @@ func (branch *Branch) AttachToLink(setMACAddress bool) error {
 	return nil
 }
 
+// attachToMACVLANLink attaches the branch ENI to a new MACVLAN link with the given attributes.
+func (branch *Branch) attachToMACVLANLink(la netlink.LinkAttrs) error {
+	macvlanLink := &netlink.Macvlan{LinkAttrs: la, Mode: branch.macvlanMode}
+
+	log.Infof("Creating MACVLAN link for branch %s with mode %d: %+v",
+		branch.linkName, branch.macvlanMode, macvlanLink)
+	err := linkAdd(macvlanLink)
+	if err != nil {
+		if os.IsExist(err) {
+			log.Infof("Found existing MACVLAN link for branch %s.", branch.linkName)
+		} else {
+			log.Errorf("Failed to add MACVLAN link for branch %s: %v", branch.linkName, err)
+		}
+		return err
+	}
+
+	branch.linkIndex = macvlanLink.Index
+	return nil
+}
+
+// addVLANLink creates a VLAN link with the given request. The netlink library only creates
+// 802.1Q VLAN links without QoS maps, so the request is built here for the other cases.
+func addVLANLink(req *nl.NetlinkRequest, vlanLink *netlink.Vlan) error {
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	if err != nil {
+		return err
+	}
+
+	// Look up the index of the new link.
+	link, err := netlink.LinkByName(vlanLink.Name)
+	if err != nil {
+		return err
+	}
+
+	vlanLink.Index = link.Attrs().Index
+	return nil
+}
+
+// newVLANLinkRequest returns the netlink request that creates the given VLAN link with the given
+// protocol and QoS maps.
+func newVLANLinkRequest(vlanLink *netlink.Vlan, protocol VLANProtocol, egressQoS VLANQoSMap, ingressQoS VLANQoSMap) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(vlanLink.Name)))
+	req.AddData(nl.NewRtAttr(unix.IFLA_LINK, nl.Uint32Attr(uint32(vlanLink.ParentIndex))))
+	if vlanLink.HardwareAddr != nil {
+		req.AddData(nl.NewRtAttr(unix.IFLA_ADDRESS, []byte(vlanLink.HardwareAddr)))
+	}
+
+	// The VLAN protocol is carried in network byte order.
+	protocolAttr := make([]byte, 2)
+	binary.BigEndian.PutUint16(protocolAttr, uint16(protocol))
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated(vlanLinkKind))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	nl.NewRtAttrChild(data, nl.IFLA_VLAN_ID, nl.Uint16Attr(uint16(vlanLink.VlanId)))
+	nl.NewRtAttrChild(data, nl.IFLA_VLAN_PROTOCOL, protocolAttr)
+	if len(egressQoS) != 0 {
+		addVLANQoSMapAttr(data, nl.IFLA_VLAN_EGRESS_QOS, egressQoS)
+	}
+	if len(ingressQoS) != 0 {
+		addVLANQoSMapAttr(data, nl.IFLA_VLAN_INGRESS_QOS, ingressQoS)
+	}
+	req.AddData(linkInfo)
+
+	return req
+}
+
+// addVLANQoSMapAttr adds the given QoS map as a nested attribute of the given type, with one
+// struct ifla_vlan_qos_mapping entry per priority in ascending order.
+func addVLANQoSMapAttr(parent *nl.RtAttr, attrType int, qosMap VLANQoSMap) {
+	from := make([]uint32, 0, len(qosMap))
+	for priority := range qosMap {
+		from = append(from, priority)
+	}
+	sort.Slice(from, func(i, j int) bool { return from[i] < from[j] })
+
+	qosAttr := nl.NewRtAttrChild(parent, attrType, nil)
+	for _, priority := range from {
+		mapping := make([]byte, 8)
+		nl.NativeEndian().PutUint32(mapping[0:], priority)
+		nl.NativeEndian().PutUint32(mapping[4:], qosMap[priority])
+		nl.NewRtAttrChild(qosAttr, vlanQoSMappingAttr, mapping)
+	}
+}
+
 // DetachFromLink detaches the branch ENI from a link.
 func (branch *Branch) DetachFromLink() error {
-	// Delete the VLAN link.
+	// Delete the VLAN or MACVLAN link.
 	la := netlink.NewLinkAttrs()
 	la.Name = branch.linkName
 	la.ParentIndex = branch.trunk.linkIndex
-	vlanLink := &netlink.Vlan{LinkAttrs: la, VlanId: branch.isolationID}
+	var link netlink.Link = &netlink.Vlan{LinkAttrs: la, VlanId: branch.isolationID}
+	if branch.macvlan {
+		link = &netlink.Macvlan{LinkAttrs: la, Mode: branch.macvlanMode}
+	}
 
-	log.Infof("Deleting VLAN link for branch %s: %+v", branch.linkName, vlanLink)
-	err := netlink.LinkDel(vlanLink)
+	log.Infof("Deleting %s link for branch %s: %+v", link.Type(), branch.linkName, link)
+	err := netlink.LinkDel(link)
 	if err != nil {
-		log.Errorf("Failed to delete VLAN link for branch %s: %v", branch.linkName, err)
+		log.Errorf("Failed to delete %s link for branch %s: %v", link.Type(), branch.linkName, err)
 		return err
 	}
 
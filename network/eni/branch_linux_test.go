@@ -0,0 +1,157 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+
+package eni
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// findAttr returns the value of the route attribute with the given type.
+func findAttr(t *testing.T, attrs []syscall.NetlinkRouteAttr, attrType uint16) []byte {
+	for _, attr := range attrs {
+		if attr.Attr.Type == attrType {
+			return attr.Value
+		}
+	}
+
+	require.Failf(t, "missing attribute", "attribute type %d", attrType)
+	return nil
+}
+
+// TestNewVLANLinkRequestStacked tests the request for an 802.1ad branch stacked on a VLAN trunk.
+func TestNewVLANLinkRequestStacked(t *testing.T) {
+	mac, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	la := netlink.NewLinkAttrs()
+	la.Name = "eth1.100.101"
+	la.ParentIndex = 7
+	la.HardwareAddr = mac
+	vlanLink := &netlink.Vlan{LinkAttrs: la, VlanId: 101}
+
+	req := newVLANLinkRequest(vlanLink, VLANProtocol8021AD, nil, nil)
+	assert.Equal(t, uint16(unix.RTM_NEWLINK), req.Type)
+
+	// Skip the netlink message header and the interface info message.
+	data := req.Serialize()
+	attrs, err := nl.ParseRouteAttr(data[unix.SizeofNlMsghdr+unix.SizeofIfInfomsg:])
+	require.NoError(t, err)
+
+	assert.Equal(t, "eth1.100.101", nl.BytesToString(findAttr(t, attrs, unix.IFLA_IFNAME)))
+	assert.Equal(t, uint32(7), nl.NativeEndian().Uint32(findAttr(t, attrs, unix.IFLA_LINK)))
+	assert.Equal(t, []byte(mac), findAttr(t, attrs, unix.IFLA_ADDRESS))
+
+	linkInfo, err := nl.ParseRouteAttr(findAttr(t, attrs, unix.IFLA_LINKINFO))
+	require.NoError(t, err)
+	assert.Equal(t, "vlan", string(findAttr(t, linkInfo, nl.IFLA_INFO_KIND)))
+
+	vlanInfo, err := nl.ParseRouteAttr(findAttr(t, linkInfo, nl.IFLA_INFO_DATA))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(101), nl.NativeEndian().Uint16(findAttr(t, vlanInfo, nl.IFLA_VLAN_ID)))
+	assert.Equal(t, uint16(0x88a8), binary.BigEndian.Uint16(findAttr(t, vlanInfo, nl.IFLA_VLAN_PROTOCOL)))
+}
+
+// TestNewVLANLinkRequestQoSMaps tests that the QoS maps are included in the VLAN link request.
+func TestNewVLANLinkRequestQoSMaps(t *testing.T) {
+	la := netlink.NewLinkAttrs()
+	la.Name = "eth1.101"
+	la.ParentIndex = 7
+	vlanLink := &netlink.Vlan{LinkAttrs: la, VlanId: 101}
+
+	req := newVLANLinkRequest(vlanLink, VLANProtocol8021Q, VLANQoSMap{6: 5, 0: 1}, VLANQoSMap{3: 4})
+	data := req.Serialize()
+	attrs, err := nl.ParseRouteAttr(data[unix.SizeofNlMsghdr+unix.SizeofIfInfomsg:])
+	require.NoError(t, err)
+	linkInfo, err := nl.ParseRouteAttr(findAttr(t, attrs, unix.IFLA_LINKINFO))
+	require.NoError(t, err)
+	vlanInfo, err := nl.ParseRouteAttr(findAttr(t, linkInfo, nl.IFLA_INFO_DATA))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0x8100), binary.BigEndian.Uint16(findAttr(t, vlanInfo, nl.IFLA_VLAN_PROTOCOL)))
+
+	// Each mapping is a pair of native endian priorities, in ascending order.
+	for attrType, expected := range map[uint16][][2]uint32{
+		nl.IFLA_VLAN_EGRESS_QOS:  {{0, 1}, {6, 5}},
+		nl.IFLA_VLAN_INGRESS_QOS: {{3, 4}},
+	} {
+		mappings, err := nl.ParseRouteAttr(findAttr(t, vlanInfo, attrType))
+		require.NoError(t, err)
+		require.Len(t, mappings, len(expected))
+		for i, mapping := range mappings {
+			assert.Equal(t, uint16(vlanQoSMappingAttr), mapping.Attr.Type)
+			assert.Equal(t, expected[i][0], nl.NativeEndian().Uint32(mapping.Value[0:]))
+			assert.Equal(t, expected[i][1], nl.NativeEndian().Uint32(mapping.Value[4:]))
+		}
+	}
+
+	// Without QoS maps, the attributes are omitted.
+	req = newVLANLinkRequest(vlanLink, VLANProtocol8021AD, nil, nil)
+	data = req.Serialize()
+	attrs, err = nl.ParseRouteAttr(data[unix.SizeofNlMsghdr+unix.SizeofIfInfomsg:])
+	require.NoError(t, err)
+	linkInfo, err = nl.ParseRouteAttr(findAttr(t, attrs, unix.IFLA_LINKINFO))
+	require.NoError(t, err)
+	vlanInfo, err = nl.ParseRouteAttr(findAttr(t, linkInfo, nl.IFLA_INFO_DATA))
+	require.NoError(t, err)
+	assert.Len(t, vlanInfo, 2)
+}
+
+// TestNewBranchDefaultVLANProtocol tests that branches default to 802.1Q VLAN links.
+func TestNewBranchDefaultVLANProtocol(t *testing.T) {
+	branch, err := NewBranch(&Trunk{}, "eth1.101", nil, 101)
+	require.NoError(t, err)
+	assert.Equal(t, VLANProtocol8021Q, branch.vlanProtocol)
+
+	branch.SetVLANProtocol(VLANProtocol8021AD)
+	assert.Equal(t, VLANProtocol8021AD, branch.vlanProtocol)
+}
+
+// TestAttachToMACVLANLink tests the request for a branch MACVLAN link in the requested mode.
+func TestAttachToMACVLANLink(t *testing.T) {
+	defer func() { linkAdd = netlink.LinkAdd }()
+
+	mac, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	for _, mode := range []netlink.MacvlanMode{
+		netlink.MACVLAN_MODE_BRIDGE,
+		netlink.MACVLAN_MODE_PRIVATE,
+		netlink.MACVLAN_MODE_VEPA,
+	} {
+		var added netlink.Link
+		linkAdd = func(link netlink.Link) error {
+			added = link
+			link.Attrs().Index = 42
+			return nil
+		}
+
+		branch, err := NewMACVLANBranch(&Trunk{ENI: ENI{linkIndex: 7}}, "mv4875", mac, mode)
+		require.NoError(t, err)
+		err = branch.AttachToLink(true)
+		require.NoError(t, err)
+
+		macvlanLink, ok := added.(*netlink.Macvlan)
+		require.True(t, ok, "expected a macvlan link, got %T", added)
+		assert.Equal(t, mode, macvlanLink.Mode)
+		assert.Equal(t, "mv4875", macvlanLink.Name)
+		assert.Equal(t, 7, macvlanLink.ParentIndex)
+		assert.Equal(t, mac, macvlanLink.HardwareAddr)
+		assert.Equal(t, 42, branch.GetLinkIndex())
+	}
+}
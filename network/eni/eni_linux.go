@@ -21,6 +21,9 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
+// addrAdd adds an IP address to a link. It is a variable so that it can be replaced in unit tests.
+var addrAdd = netlink.AddrAdd
+
 // SetLinkName sets the name of the ENI.
 func (eni *ENI) SetLinkName(name string) error {
 	la := netlink.NewLinkAttrs()
@@ -45,6 +48,14 @@ func (eni *ENI) SetLinkMTU(mtu uint) error {
 	return netlink.LinkSetMTU(link, int(mtu))
 }
 
+// SetLinkAlias sets the alias of the ENI, which is shown by tools such as ip link.
+func (eni *ENI) SetLinkAlias(alias string) error {
+	la := netlink.NewLinkAttrs()
+	la.Name = eni.linkName
+	link := &netlink.Dummy{LinkAttrs: la}
+	return netlink.LinkSetAlias(link, alias)
+}
+
 // SetOpState sets the operational state of the ENI.
 func (eni *ENI) SetOpState(up bool) error {
 	var err error
@@ -88,12 +99,23 @@ func (eni *ENI) SetMACAddress(address net.HardwareAddr) error {
 
 // AddIPAddress assigns the given IP address to the ENI.
 func (eni *ENI) AddIPAddress(address *net.IPNet) error {
+	return eni.AddIPAddressWithFlags(address, 0)
+}
+
+// AddIPAddressWithFlags assigns the given IP address to the ENI with the given IFA_F_* flags.
+func (eni *ENI) AddIPAddressWithFlags(address *net.IPNet, flags int) error {
+	return eni.AddScopedIPAddress(address, netlink.SCOPE_UNIVERSE, flags)
+}
+
+// AddScopedIPAddress assigns the given IP address to the ENI with the given scope and IFA_F_*
+// flags. Addresses with host or link scope are not used as a source for off-link destinations.
+func (eni *ENI) AddScopedIPAddress(address *net.IPNet, scope netlink.Scope, flags int) error {
 	la := netlink.NewLinkAttrs()
 	la.Index = eni.linkIndex
 	link := &netlink.Dummy{LinkAttrs: la}
-	addr := &netlink.Addr{IPNet: address}
+	addr := &netlink.Addr{IPNet: address, Flags: flags, Scope: int(scope)}
 
-	return netlink.AddrAdd(link, addr)
+	return addrAdd(link, addr)
 }
 
 // DeleteIPAddress deletes the given IP address from the ENI.
@@ -0,0 +1,75 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package eni
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// TestAddIPAddressWithFlags tests that the address flags are passed in the address add request.
+func TestAddIPAddressWithFlags(t *testing.T) {
+	var addrs []*netlink.Addr
+	addrAdd = func(link netlink.Link, addr *netlink.Addr) error {
+		assert.Equal(t, 7, link.Attrs().Index)
+		addrs = append(addrs, addr)
+		return nil
+	}
+	defer func() { addrAdd = netlink.AddrAdd }()
+
+	_, address, _ := net.ParseCIDR("2600:1f13:4d9:e611::5/64")
+	eni := &ENI{linkIndex: 7}
+
+	err := eni.AddIPAddress(address)
+	require.NoError(t, err)
+	err = eni.AddIPAddressWithFlags(address, unix.IFA_F_NOPREFIXROUTE)
+	require.NoError(t, err)
+
+	require.Len(t, addrs, 2)
+	assert.Equal(t, address, addrs[0].IPNet)
+	assert.Zero(t, addrs[0].Flags)
+	assert.Equal(t, address, addrs[1].IPNet)
+	assert.Equal(t, unix.IFA_F_NOPREFIXROUTE, addrs[1].Flags&unix.IFA_F_NOPREFIXROUTE)
+}
+
+// TestAddScopedIPAddress tests that the address scope is passed in the address add request.
+func TestAddScopedIPAddress(t *testing.T) {
+	var addrs []*netlink.Addr
+	addrAdd = func(link netlink.Link, addr *netlink.Addr) error {
+		addrs = append(addrs, addr)
+		return nil
+	}
+	defer func() { addrAdd = netlink.AddrAdd }()
+
+	_, primary, _ := net.ParseCIDR("10.11.12.13/16")
+	_, management, _ := net.ParseCIDR("169.254.100.1/32")
+	eni := &ENI{linkIndex: 7}
+
+	require.NoError(t, eni.AddIPAddress(primary))
+	require.NoError(t, eni.AddScopedIPAddress(management, netlink.SCOPE_LINK, 0))
+	require.NoError(t, eni.AddScopedIPAddress(management, netlink.SCOPE_HOST, unix.IFA_F_NOPREFIXROUTE))
+
+	require.Len(t, addrs, 3)
+	assert.Equal(t, primary, addrs[0].IPNet)
+	assert.Equal(t, int(netlink.SCOPE_UNIVERSE), addrs[0].Scope)
+	assert.Equal(t, management, addrs[1].IPNet)
+	assert.Equal(t, int(netlink.SCOPE_LINK), addrs[1].Scope)
+	assert.Equal(t, int(netlink.SCOPE_HOST), addrs[2].Scope)
+	assert.Equal(t, unix.IFA_F_NOPREFIXROUTE, addrs[2].Flags)
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build linux
+
+package eni
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+var (
+	// pciDevicesPath is the sysfs directory listing PCI devices.
+	pciDevicesPath = "/sys/bus/pci/devices"
+)
+
+// GetLinkNameByPCIAddress returns the name of the network interface at the given PCI address.
+func GetLinkNameByPCIAddress(pciAddress string) (string, error) {
+	netPath := filepath.Join(pciDevicesPath, pciAddress, "net")
+
+	files, err := ioutil.ReadDir(netPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to find network interface at PCI address %s: %v", pciAddress, err)
+	}
+
+	if len(files) != 1 {
+		return "", fmt.Errorf("found %d network interfaces at PCI address %s, expected 1",
+			len(files), pciAddress)
+	}
+
+	return files[0].Name(), nil
+}
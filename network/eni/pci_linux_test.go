@@ -0,0 +1,52 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package eni
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLinkNameByPCIAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eni-pci-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	origPCIDevicesPath := pciDevicesPath
+	pciDevicesPath = dir
+	defer func() { pciDevicesPath = origPCIDevicesPath }()
+
+	// A PCI device with a single network interface.
+	err = os.MkdirAll(filepath.Join(dir, "0000:00:06.0", "net", "ens6"), 0755)
+	require.NoError(t, err)
+
+	// A PCI device with no network interfaces.
+	err = os.MkdirAll(filepath.Join(dir, "0000:00:07.0"), 0755)
+	require.NoError(t, err)
+
+	linkName, err := GetLinkNameByPCIAddress("0000:00:06.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "ens6", linkName)
+
+	_, err = GetLinkNameByPCIAddress("0000:00:07.0")
+	assert.Error(t, err)
+
+	_, err = GetLinkNameByPCIAddress("0000:00:08.0")
+	assert.Error(t, err)
+}
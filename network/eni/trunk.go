@@ -20,6 +20,7 @@ import (
 	"net"
 
 	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
 )
 
 // IsolationMode represents the trunk's isolation mode.
@@ -64,3 +65,14 @@ func NewTrunk(linkName string, macAddress net.HardwareAddr, isolationMode Isolat
 
 	return trunk, nil
 }
+
+// IsVLAN returns whether the trunk ENI is itself a VLAN link, in which case branch VLAN links are
+// stacked on top of it.
+func (trunk *Trunk) IsVLAN() (bool, error) {
+	link, err := netlink.LinkByIndex(trunk.linkIndex)
+	if err != nil {
+		return false, err
+	}
+
+	return link.Type() == vlanLinkKind, nil
+}
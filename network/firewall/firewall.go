@@ -0,0 +1,114 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package firewall installs packet filtering rules, expressed as iptables rule specifications,
+// with either the iptables or the nftables backend of the Linux kernel netfilter module. The
+// nftables backend runs the nft command rather than using the netlink API directly.
+package firewall
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+	"github.com/coreos/go-iptables/iptables"
+)
+
+const (
+	// Firewall backend values.
+	BackendAuto     = "auto"
+	BackendIPTables = "iptables"
+	BackendNFTables = "nftables"
+
+	// Name of the iptables executable, and the variant it reports when it is backed by nftables.
+	iptablesExe             = "iptables"
+	iptablesNFTablesVariant = "(nf_tables)"
+)
+
+// Client is the subset of the iptables API used to install and delete rules.
+type Client interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	Delete(table, chain string, rulespec ...string) error
+}
+
+var (
+	// nftablesAvailable returns whether the nftables backend can be used. It is a variable so
+	// that it can be replaced in unit tests.
+	nftablesAvailable = func() bool {
+		_, err := runNFT("list", "tables")
+		return err == nil
+	}
+
+	// iptablesLegacy returns whether the iptables command installed on the host uses the legacy
+	// backend. Versions before 1.8 do not report a variant, and are all legacy. It is a variable
+	// so that it can be replaced in unit tests.
+	iptablesLegacy = func() bool {
+		output, err := exec.Command(iptablesExe, "--version").Output()
+		if err != nil {
+			return false
+		}
+		return !strings.Contains(string(output), iptablesNFTablesVariant)
+	}
+
+	// detectedBackend caches the backend selected automatically for the lifetime of the process.
+	detectedBackend     string
+	detectedBackendLock sync.Mutex
+)
+
+// New creates a client for the given protocol that installs rules with the given backend.
+func New(backend string, proto iptables.Protocol) (Client, error) {
+	backend, err := SelectBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	if backend == BackendNFTables {
+		return newNFTables(proto)
+	}
+
+	return iptables.NewWithProtocol(proto)
+}
+
+// SelectBackend returns the backend to use for the given backend value. The auto backend selects
+// nftables if the nft command is present and can query the kernel, unless the iptables command
+// uses the legacy backend. Rules of the host and of the plugins then stay in the same backend.
+// It selects iptables otherwise.
+func SelectBackend(backend string) (string, error) {
+	switch backend {
+	case BackendIPTables, BackendNFTables:
+		return backend, nil
+	case BackendAuto:
+		return detectBackend(), nil
+	default:
+		return "", fmt.Errorf("unknown firewall backend %s", backend)
+	}
+}
+
+// detectBackend returns the backend selected automatically.
+func detectBackend() string {
+	detectedBackendLock.Lock()
+	defer detectedBackendLock.Unlock()
+
+	if detectedBackend == "" {
+		detectedBackend = BackendIPTables
+		if !iptablesLegacy() && nftablesAvailable() {
+			detectedBackend = BackendNFTables
+		}
+		log.Infof("Selected %s firewall backend.", detectedBackend)
+	}
+
+	return detectedBackend
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package firewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Real backend detection operations.
+var (
+	realNFTablesAvailable = nftablesAvailable
+	realIPTablesLegacy    = iptablesLegacy
+)
+
+// mockNFTablesAvailable replaces the nftables detection with one returning the given result and
+// counting its calls, and clears the cached backend. The iptables command is reported to be
+// backed by nftables.
+func mockNFTablesAvailable(available bool) *int {
+	calls := 0
+	nftablesAvailable = func() bool {
+		calls++
+		return available
+	}
+	iptablesLegacy = func() bool {
+		return false
+	}
+	detectedBackend = ""
+	return &calls
+}
+
+// restoreNFTablesAvailable restores the real backend detection.
+func restoreNFTablesAvailable() {
+	nftablesAvailable = realNFTablesAvailable
+	iptablesLegacy = realIPTablesLegacy
+	detectedBackend = ""
+}
+
+// TestSelectBackend tests that explicitly requested backends are used without detection.
+func TestSelectBackend(t *testing.T) {
+	calls := mockNFTablesAvailable(true)
+	defer restoreNFTablesAvailable()
+
+	for _, backend := range []string{BackendIPTables, BackendNFTables} {
+		selected, err := SelectBackend(backend)
+		assert.NoError(t, err)
+		assert.Equal(t, backend, selected)
+	}
+	assert.Zero(t, *calls)
+
+	_, err := SelectBackend("ebtables")
+	assert.Error(t, err)
+	_, err = New("ebtables", 0)
+	assert.Error(t, err)
+}
+
+// TestSelectBackendAuto tests that the auto backend selects nftables if it is available, and
+// that the detection result is cached.
+func TestSelectBackendAuto(t *testing.T) {
+	calls := mockNFTablesAvailable(true)
+	defer restoreNFTablesAvailable()
+
+	for i := 0; i < 2; i++ {
+		selected, err := SelectBackend(BackendAuto)
+		assert.NoError(t, err)
+		assert.Equal(t, BackendNFTables, selected)
+	}
+	assert.Equal(t, 1, *calls)
+}
+
+// TestSelectBackendAutoFallback tests that the auto backend falls back to iptables if nftables
+// is not available.
+func TestSelectBackendAutoFallback(t *testing.T) {
+	calls := mockNFTablesAvailable(false)
+	defer restoreNFTablesAvailable()
+
+	selected, err := SelectBackend(BackendAuto)
+	assert.NoError(t, err)
+	assert.Equal(t, BackendIPTables, selected)
+	assert.Equal(t, 1, *calls)
+}
+
+// TestSelectBackendAutoLegacy tests that the auto backend selects iptables on hosts using the
+// legacy iptables, even if nftables is available.
+func TestSelectBackendAutoLegacy(t *testing.T) {
+	mockNFTablesAvailable(true)
+	defer restoreNFTablesAvailable()
+	iptablesLegacy = func() bool {
+		return true
+	}
+
+	selected, err := SelectBackend(BackendAuto)
+	assert.NoError(t, err)
+	assert.Equal(t, BackendIPTables, selected)
+}
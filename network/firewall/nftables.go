@@ -0,0 +1,288 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+const (
+	// Name of the nft executable, the user space application of the nftables backend.
+	nftExe = "nft"
+
+	// Name of the nftables table holding the rules installed by the plugins. Each iptables
+	// table and chain pair maps to a base chain named after both in this table.
+	nftTableName = "aws-vpc-cni"
+
+	// Error reported by nft for missing tables, chains and rules.
+	nftNotFoundError = "No such file or directory"
+)
+
+var (
+	// nftHandleRegex matches the handle of a rule in the output of "nft -a list".
+	nftHandleRegex = regexp.MustCompile(`# handle (\d+)$`)
+
+	// runNFT runs the nft command with the given arguments and returns its output. It is a
+	// variable so that it can be replaced in unit tests.
+	runNFT = func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(nftExe, args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		err := cmd.Run()
+		if err != nil {
+			return "", fmt.Errorf("%s %s failed: %v: %s",
+				nftExe, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+
+		return stdout.String(), nil
+	}
+)
+
+// nftables installs iptables rules as equivalent nftables rules. Each rule is tagged with a
+// comment holding its iptables rule specification, so that it can be found again.
+type nftables struct {
+	family string
+}
+
+// newNFTables creates an nftables client for the given protocol.
+func newNFTables(proto iptables.Protocol) (*nftables, error) {
+	_, err := exec.LookPath(nftExe)
+	if err != nil {
+		return nil, err
+	}
+
+	family := "ip"
+	if proto == iptables.ProtocolIPv6 {
+		family = "ip6"
+	}
+
+	return &nftables{family: family}, nil
+}
+
+// AppendUnique appends the given rule to the given chain, unless it already exists. The table
+// and base chain are created if they do not exist yet.
+func (n *nftables) AppendUnique(table, chain string, rulespec ...string) error {
+	chainName, chainSpec, err := getBaseChain(table, chain)
+	if err != nil {
+		return err
+	}
+
+	expr, err := translateRule(n.family, rulespec)
+	if err != nil {
+		return err
+	}
+
+	exists, err := n.Exists(table, chain, rulespec...)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = runNFT("add", "table", n.family, nftTableName)
+	if err != nil {
+		return err
+	}
+
+	_, err = runNFT(append([]string{"add", "chain", n.family, nftTableName, chainName}, chainSpec...)...)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{"add", "rule", n.family, nftTableName, chainName}, expr...)
+	_, err = runNFT(append(args, "comment", getRuleComment(rulespec))...)
+	return err
+}
+
+// Exists returns whether the given rule exists in the given chain.
+func (n *nftables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	handle, err := n.findRule(table, chain, rulespec)
+	return handle != "", err
+}
+
+// Delete deletes the given rule from the given chain.
+func (n *nftables) Delete(table, chain string, rulespec ...string) error {
+	handle, err := n.findRule(table, chain, rulespec)
+	if err != nil {
+		return err
+	}
+	if handle == "" {
+		return fmt.Errorf("rule %s does not exist in chain %s of table %s", strings.Join(rulespec, " "), chain, table)
+	}
+
+	chainName, _, _ := getBaseChain(table, chain)
+	_, err = runNFT("delete", "rule", n.family, nftTableName, chainName, "handle", handle)
+	return err
+}
+
+// findRule returns the handle of the given rule in the given chain, or an empty string if the
+// rule, the chain or the table does not exist.
+func (n *nftables) findRule(table, chain string, rulespec []string) (string, error) {
+	chainName, _, err := getBaseChain(table, chain)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := runNFT("-a", "list", "chain", n.family, nftTableName, chainName)
+	if err != nil {
+		if strings.Contains(err.Error(), nftNotFoundError) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	comment := "comment " + getRuleComment(rulespec)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.Contains(line, comment) {
+			continue
+		}
+		if match := nftHandleRegex.FindStringSubmatch(line); match != nil {
+			return match[1], nil
+		}
+	}
+
+	return "", nil
+}
+
+// getBaseChain returns the name and the specification of the nftables base chain that is
+// equivalent to the given built-in iptables chain, with the same hook and priority.
+func getBaseChain(table, chain string) (string, []string, error) {
+	hook := strings.ToLower(chain)
+	switch hook {
+	case "prerouting", "input", "forward", "output", "postrouting":
+	default:
+		return "", nil, fmt.Errorf("unsupported iptables chain %s", chain)
+	}
+
+	chainType := "filter"
+	var priority string
+	switch table {
+	case "raw":
+		priority = "-300"
+	case "mangle":
+		priority = "-150"
+		// Packets marked in output are rerouted, as is done by iptables.
+		if hook == "output" {
+			chainType = "route"
+		}
+	case "filter":
+		priority = "0"
+	case "nat":
+		// Destinations are translated before routing, and sources after.
+		chainType = "nat"
+		priority = "-100"
+		if hook == "postrouting" || hook == "input" {
+			priority = "100"
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported iptables table %s", table)
+	}
+
+	name := table + "-" + hook
+	spec := []string{"{", "type", chainType, "hook", hook, "priority", priority, ";", "}"}
+	return name, spec, nil
+}
+
+// translateRule returns the nftables expression of the given iptables rule specification. Only
+// the matches and targets of the rules installed by the plugins are supported.
+func translateRule(family string, rulespec []string) ([]string, error) {
+	var expr []string
+	for i := 0; i < len(rulespec); i++ {
+		option := rulespec[i]
+
+		// Options without a value.
+		if option == "--clamp-mss-to-pmtu" {
+			expr = append(expr, "tcp", "option", "maxseg", "size", "set", "rt", "mtu")
+			continue
+		}
+
+		// Options with a single value.
+		if i+1 >= len(rulespec) {
+			return nil, fmt.Errorf("missing value of iptables option %s", option)
+		}
+		i++
+		value := rulespec[i]
+
+		switch option {
+		case "-i":
+			expr = append(expr, "iifname", quote(value))
+		case "-o":
+			expr = append(expr, "oifname", quote(value))
+		case "-s":
+			expr = append(expr, family, "saddr", value)
+		case "-d":
+			expr = append(expr, family, "daddr", value)
+		case "-p":
+			expr = append(expr, "meta", "l4proto", value)
+		case "--dport":
+			expr = append(expr, "th", "dport", value)
+		case "-m":
+			// Matches are inferred from their options.
+			if value != "conntrack" {
+				return nil, fmt.Errorf("unsupported iptables match %s", value)
+			}
+		case "--ctstate":
+			expr = append(expr, "ct", "state", strings.ToLower(value))
+		case "--tcp-flags":
+			// The flags to compare follow the mask.
+			if i+1 >= len(rulespec) {
+				return nil, fmt.Errorf("missing value of iptables option %s", option)
+			}
+			i++
+			expr = append(expr, "tcp", "flags", "&",
+				"("+getTCPFlags(value)+")", "==", getTCPFlags(rulespec[i]))
+		case "--set-mark":
+			expr = append(expr, "meta", "mark", "set", value)
+		case "--set-mss":
+			expr = append(expr, "tcp", "option", "maxseg", "size", "set", value)
+		case "--to-destination":
+			expr = append(expr, "dnat", "to", value)
+		case "-j":
+			switch value {
+			case "ACCEPT", "DROP", "REJECT", "MASQUERADE":
+				expr = append(expr, strings.ToLower(value))
+			case "DNAT", "MARK", "TCPMSS":
+				// The target is translated from its options.
+			default:
+				return nil, fmt.Errorf("unsupported iptables target %s", value)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported iptables option %s", option)
+		}
+	}
+
+	return expr, nil
+}
+
+// getTCPFlags returns the nftables expression of the given comma-separated iptables TCP flags.
+func getTCPFlags(flags string) string {
+	return strings.ToLower(strings.Replace(flags, ",", "|", -1))
+}
+
+// getRuleComment returns the comment tagging the nftables rule for the given iptables rule.
+func getRuleComment(rulespec []string) string {
+	return quote(strings.Join(rulespec, " "))
+}
+
+// quote returns the given string as an nftables quoted string.
+func quote(s string) string {
+	return `"` + s + `"`
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package firewall
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// Listing of a chain holding the MSS clamping rule, as output by "nft -a list chain".
+	testNFTChainListing = `table ip aws-vpc-cni {
+	chain mangle-postrouting { # handle 2
+		type filter hook postrouting priority mangle; policy accept;
+		oifname "eth0" meta l4proto tcp tcp flags & (syn | rst) == syn tcp option maxseg size set 8961 comment "-o eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 8961" # handle 5
+	}
+}
+`
+)
+
+var (
+	// realRunNFT is the nft command used outside of unit tests.
+	realRunNFT = runNFT
+
+	// testMSSClampRule is the iptables rule in the chain listing.
+	testMSSClampRule = []string{"-o", "eth0", "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--set-mss", "8961"}
+)
+
+// mockRunNFT replaces the nft command with one recording its arguments, and returning the given
+// chain listing, or a not found error if it is empty.
+func mockRunNFT(listing string) *[]string {
+	var cmds []string
+	runNFT = func(args ...string) (string, error) {
+		cmd := strings.Join(args, " ")
+		cmds = append(cmds, cmd)
+		if args[0] == "-a" {
+			if listing == "" {
+				return "", errors.New("nft " + cmd + " failed: exit status 1: Error: No such file or directory")
+			}
+			return listing, nil
+		}
+		return "", nil
+	}
+	return &cmds
+}
+
+// TestTranslateRule tests that the iptables rules installed by the plugins are translated to
+// equivalent nftables expressions.
+func TestTranslateRule(t *testing.T) {
+	for _, tc := range []struct {
+		family   string
+		rulespec []string
+		expr     string
+	}{
+		{"ip", []string{"-d", "169.254.169.254/32", "-j", "REJECT"},
+			`ip daddr 169.254.169.254/32 reject`},
+		{"ip6", []string{"-d", "fd00:ec2::254/128", "-j", "REJECT"},
+			`ip6 daddr fd00:ec2::254/128 reject`},
+		{"ip", []string{"-o", "eth0", "-j", "MARK", "--set-mark", "0x2a"},
+			`oifname "eth0" meta mark set 0x2a`},
+		{"ip", []string{"-i", "eth0", "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT"},
+			`iifname "eth0" ct state established,related accept`},
+		{"ip6", []string{"-i", "eth0", "-m", "conntrack", "--ctstate", "NEW", "-j", "DROP"},
+			`iifname "eth0" ct state new drop`},
+		{"ip", testMSSClampRule,
+			`oifname "eth0" meta l4proto tcp tcp flags & (syn|rst) == syn tcp option maxseg size set 8961`},
+		{"ip", []string{"-s", "10.0.0.0/8", "-p", "tcp", "-j", "TCPMSS", "--clamp-mss-to-pmtu"},
+			`ip saddr 10.0.0.0/8 meta l4proto tcp tcp option maxseg size set rt mtu`},
+		{"ip", []string{"-d", "127.0.0.53/32", "-p", "udp", "--dport", "53", "-j", "DNAT", "--to-destination", "10.0.0.2:53"},
+			`ip daddr 127.0.0.53/32 meta l4proto udp th dport 53 dnat to 10.0.0.2:53`},
+		{"ip", []string{"-s", "127.0.0.0/8", "-o", "eth0", "-j", "MASQUERADE"},
+			`ip saddr 127.0.0.0/8 oifname "eth0" masquerade`},
+	} {
+		expr, err := translateRule(tc.family, tc.rulespec)
+		require.NoError(t, err, tc.rulespec)
+		assert.Equal(t, tc.expr, strings.Join(expr, " "))
+	}
+
+	for _, rulespec := range [][]string{
+		{"-j", "SNAT", "--to-source", "10.0.0.1"},
+		{"-m", "addrtype", "--dst-type", "LOCAL"},
+		{"--sport", "80"},
+		{"-d"},
+		{"-p", "tcp", "--tcp-flags", "SYN"},
+	} {
+		_, err := translateRule("ip", rulespec)
+		assert.Error(t, err, rulespec)
+	}
+}
+
+// TestGetBaseChain tests that iptables chains map to base chains with the same hook and priority.
+func TestGetBaseChain(t *testing.T) {
+	for _, tc := range []struct {
+		table string
+		chain string
+		name  string
+		spec  string
+	}{
+		{"filter", "OUTPUT", "filter-output", "{ type filter hook output priority 0 ; }"},
+		{"mangle", "POSTROUTING", "mangle-postrouting", "{ type filter hook postrouting priority -150 ; }"},
+		{"mangle", "OUTPUT", "mangle-output", "{ type route hook output priority -150 ; }"},
+		{"raw", "PREROUTING", "raw-prerouting", "{ type filter hook prerouting priority -300 ; }"},
+		{"nat", "OUTPUT", "nat-output", "{ type nat hook output priority -100 ; }"},
+		{"nat", "POSTROUTING", "nat-postrouting", "{ type nat hook postrouting priority 100 ; }"},
+	} {
+		name, spec, err := getBaseChain(tc.table, tc.chain)
+		require.NoError(t, err)
+		assert.Equal(t, tc.name, name)
+		assert.Equal(t, tc.spec, strings.Join(spec, " "))
+	}
+
+	_, _, err := getBaseChain("security", "OUTPUT")
+	assert.Error(t, err)
+	_, _, err = getBaseChain("filter", "DOCKER")
+	assert.Error(t, err)
+}
+
+// TestNFTablesAppendUnique tests that the table, chain and rule are added if the rule does not
+// exist, and that nothing is added otherwise.
+func TestNFTablesAppendUnique(t *testing.T) {
+	cmds := mockRunNFT("")
+	defer func() { runNFT = realRunNFT }()
+
+	n := &nftables{family: "ip"}
+	require.NoError(t, n.AppendUnique("mangle", "POSTROUTING", testMSSClampRule...))
+	assert.Equal(t, []string{
+		"-a list chain ip aws-vpc-cni mangle-postrouting",
+		"add table ip aws-vpc-cni",
+		"add chain ip aws-vpc-cni mangle-postrouting { type filter hook postrouting priority -150 ; }",
+		`add rule ip aws-vpc-cni mangle-postrouting oifname "eth0" meta l4proto tcp ` +
+			`tcp flags & (syn|rst) == syn tcp option maxseg size set 8961 ` +
+			`comment "-o eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 8961"`,
+	}, *cmds)
+
+	cmds = mockRunNFT(testNFTChainListing)
+	require.NoError(t, n.AppendUnique("mangle", "POSTROUTING", testMSSClampRule...))
+	assert.Equal(t, []string{"-a list chain ip aws-vpc-cni mangle-postrouting"}, *cmds)
+
+	// Unsupported rules are rejected before running any command.
+	cmds = mockRunNFT("")
+	assert.Error(t, n.AppendUnique("nat", "POSTROUTING", "-j", "SNAT", "--to-source", "10.0.0.1"))
+	assert.Empty(t, *cmds)
+}
+
+// TestNFTablesExistsDelete tests that rules are found by their comment and deleted by handle.
+func TestNFTablesExistsDelete(t *testing.T) {
+	cmds := mockRunNFT(testNFTChainListing)
+	defer func() { runNFT = realRunNFT }()
+
+	n := &nftables{family: "ip"}
+	exists, err := n.Exists("mangle", "POSTROUTING", testMSSClampRule...)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	other := append([]string{}, testMSSClampRule...)
+	other[1] = "eth1"
+	exists, err = n.Exists("mangle", "POSTROUTING", other...)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Error(t, n.Delete("mangle", "POSTROUTING", other...))
+
+	*cmds = nil
+	require.NoError(t, n.Delete("mangle", "POSTROUTING", testMSSClampRule...))
+	assert.Equal(t, []string{
+		"-a list chain ip aws-vpc-cni mangle-postrouting",
+		"delete rule ip aws-vpc-cni mangle-postrouting handle 5",
+	}, *cmds)
+
+	// Missing tables and chains hold no rules.
+	mockRunNFT("")
+	exists, err = n.Exists("mangle", "POSTROUTING", testMSSClampRule...)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
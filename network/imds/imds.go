@@ -14,27 +14,96 @@
 package imds
 
 import (
+	"fmt"
 	"net"
 	"syscall"
 
+	"github.com/aws/amazon-vpc-cni-plugins/network/firewall"
 	"github.com/aws/amazon-vpc-cni-plugins/network/vpc"
 
 	log "github.com/cihub/seelog"
+	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
 )
 
-// BlockInstanceMetadataEndpoint adds a blackhole rule for IMDS endpoint.
-func BlockInstanceMetadataEndpoint() error {
-	log.Infof("Adding route to block instance metadata endpoint %s", vpc.InstanceMetadataEndpoint)
-	_, imdsNetwork, err := net.ParseCIDR(vpc.InstanceMetadataEndpoint)
+const (
+	// Methods for blocking the IMDS endpoint.
+	BlockMethodRoute    = "route"
+	BlockMethodIPTables = "iptables"
+	BlockMethodAuto     = "auto"
+
+	// Table of iptables rules blocking the IMDS endpoint.
+	iptablesTable = "filter"
+)
+
+// iptablesClient is the subset of the iptables API used to block the IMDS endpoint.
+type iptablesClient interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+}
+
+var (
+	// iptablesChains are the chains of iptables rules blocking the IMDS endpoint.
+	iptablesChains = []string{"OUTPUT", "FORWARD"}
+
+	// routeAdd adds a route. It is a variable so that it can be replaced in unit tests.
+	routeAdd = netlink.RouteAdd
+
+	// newIPTables creates an iptables client using the given firewall backend. It is a variable
+	// so that it can be replaced in unit tests.
+	newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+		return firewall.New(backend, proto)
+	}
+)
+
+// BlockInstanceMetadataEndpoint blocks the IMDS endpoint using the given method.
+// The route method adds a blackhole route, the iptables method adds an iptables REJECT rule,
+// and the auto method falls back to iptables if the route cannot be added. The iptables rules
+// are installed with the given firewall backend.
+// If blockIPv6 is true, the IPv6 IMDS endpoint is blocked as well.
+func BlockInstanceMetadataEndpoint(method string, blockIPv6 bool, firewallBackend string) error {
+	endpoints := []string{vpc.InstanceMetadataEndpoint}
+	if blockIPv6 {
+		endpoints = append(endpoints, vpc.InstanceMetadataIPv6Endpoint)
+	}
+
+	for _, endpoint := range endpoints {
+		var err error
+
+		switch method {
+		case BlockMethodRoute:
+			err = blockEndpointWithRoute(endpoint)
+		case BlockMethodIPTables:
+			err = blockEndpointWithIPTables(endpoint, firewallBackend)
+		case BlockMethodAuto:
+			err = blockEndpointWithRoute(endpoint)
+			if err != nil {
+				log.Infof("Falling back to iptables to block instance metadata endpoint %s", endpoint)
+				err = blockEndpointWithIPTables(endpoint, firewallBackend)
+			}
+		default:
+			err = fmt.Errorf("unknown instance metadata blocking method %s", method)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blockEndpointWithRoute adds a blackhole route for the given endpoint.
+func blockEndpointWithRoute(endpoint string) error {
+	log.Infof("Adding route to block instance metadata endpoint %s", endpoint)
+	_, imdsNetwork, err := net.ParseCIDR(endpoint)
 	if err != nil {
 		// This should never happen because we always expect
-		// 169.254.169.254/32 to be parsed without any errors.
-		log.Errorf("Unable to parse instance metadata endpoint %s", vpc.InstanceMetadataEndpoint)
+		// the IMDS endpoints to be parsed without any errors.
+		log.Errorf("Unable to parse instance metadata endpoint %s", endpoint)
 		return err
 	}
 
-	err = netlink.RouteAdd(&netlink.Route{
+	err = routeAdd(&netlink.Route{
 		Dst:  imdsNetwork,
 		Type: syscall.RTN_BLACKHOLE,
 	})
@@ -44,4 +113,36 @@ func BlockInstanceMetadataEndpoint() error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// blockEndpointWithIPTables adds iptables REJECT rules for the given endpoint with the given
+// firewall backend.
+func blockEndpointWithIPTables(endpoint string, firewallBackend string) error {
+	log.Infof("Adding iptables rules to block instance metadata endpoint %s", endpoint)
+	ip, _, err := net.ParseCIDR(endpoint)
+	if err != nil {
+		log.Errorf("Unable to parse instance metadata endpoint %s", endpoint)
+		return err
+	}
+
+	proto := iptables.ProtocolIPv4
+	if ip.To4() == nil {
+		proto = iptables.ProtocolIPv6
+	}
+
+	ipt, err := newIPTables(firewallBackend, proto)
+	if err != nil {
+		log.Errorf("Unable to create iptables client: %v", err)
+		return err
+	}
+
+	for _, chain := range iptablesChains {
+		err = ipt.AppendUnique(iptablesTable, chain, "-d", endpoint, "-j", "REJECT")
+		if err != nil {
+			log.Errorf("Unable to add iptables rule to block instance metadata: %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
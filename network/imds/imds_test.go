@@ -0,0 +1,171 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package imds
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/firewall"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// mockIPTables records the iptables rules requested for each protocol.
+type mockIPTables struct {
+	proto iptables.Protocol
+	rules *[]string
+}
+
+func (m *mockIPTables) AppendUnique(table, chain string, rulespec ...string) error {
+	*m.rules = append(*m.rules, fmt.Sprintf("%d %s %s %s", m.proto, table, chain, strings.Join(rulespec, " ")))
+	return nil
+}
+
+// mockLayers replaces the netlink and iptables layers with mocks recording the requests.
+// Route requests fail with routeErr if it is not nil.
+func mockLayers(routeErr error) (*[]*netlink.Route, *[]string) {
+	var routes []*netlink.Route
+	var rules []string
+
+	routeAdd = func(route *netlink.Route) error {
+		if routeErr != nil {
+			return routeErr
+		}
+		routes = append(routes, route)
+		return nil
+	}
+
+	newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+		if backend != firewall.BackendIPTables {
+			rules = append(rules, "backend "+backend)
+		}
+		return &mockIPTables{proto: proto, rules: &rules}, nil
+	}
+
+	return &routes, &rules
+}
+
+// restoreLayers restores the real netlink and iptables layers.
+func restoreLayers() {
+	routeAdd = netlink.RouteAdd
+	newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+		return firewall.New(backend, proto)
+	}
+}
+
+func TestBlockInstanceMetadataEndpoint(t *testing.T) {
+	routes, rules := mockLayers(nil)
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodRoute, false, firewall.BackendIPTables)
+	assert.NoError(t, err)
+
+	assert.Len(t, *routes, 1)
+	assert.Equal(t, "169.254.169.254/32", (*routes)[0].Dst.String())
+	assert.Equal(t, syscall.RTN_BLACKHOLE, (*routes)[0].Type)
+	assert.Empty(t, *rules)
+}
+
+func TestBlockInstanceMetadataEndpointWithIPv6(t *testing.T) {
+	routes, _ := mockLayers(nil)
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodRoute, true, firewall.BackendIPTables)
+	assert.NoError(t, err)
+
+	assert.Len(t, *routes, 2)
+	assert.Equal(t, "169.254.169.254/32", (*routes)[0].Dst.String())
+	assert.Equal(t, "fd00:ec2::254/128", (*routes)[1].Dst.String())
+	for _, route := range *routes {
+		assert.Equal(t, syscall.RTN_BLACKHOLE, route.Type)
+	}
+}
+
+func TestBlockInstanceMetadataEndpointWithIPTables(t *testing.T) {
+	routes, rules := mockLayers(nil)
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodIPTables, true, firewall.BackendIPTables)
+	assert.NoError(t, err)
+
+	assert.Empty(t, *routes)
+	assert.Equal(t, []string{
+		"0 filter OUTPUT -d 169.254.169.254/32 -j REJECT",
+		"0 filter FORWARD -d 169.254.169.254/32 -j REJECT",
+		"1 filter OUTPUT -d fd00:ec2::254/128 -j REJECT",
+		"1 filter FORWARD -d fd00:ec2::254/128 -j REJECT",
+	}, *rules)
+}
+
+func TestBlockInstanceMetadataEndpointAutoUsesRoute(t *testing.T) {
+	routes, rules := mockLayers(nil)
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodAuto, false, firewall.BackendIPTables)
+	assert.NoError(t, err)
+
+	assert.Len(t, *routes, 1)
+	assert.Empty(t, *rules)
+}
+
+func TestBlockInstanceMetadataEndpointAutoFallsBackToIPTables(t *testing.T) {
+	routes, rules := mockLayers(errors.New("operation not supported"))
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodAuto, false, firewall.BackendIPTables)
+	assert.NoError(t, err)
+
+	assert.Empty(t, *routes)
+	assert.Equal(t, []string{
+		"0 filter OUTPUT -d 169.254.169.254/32 -j REJECT",
+		"0 filter FORWARD -d 169.254.169.254/32 -j REJECT",
+	}, *rules)
+}
+
+func TestBlockInstanceMetadataEndpointWithNFTables(t *testing.T) {
+	_, rules := mockLayers(nil)
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodIPTables, false, firewall.BackendNFTables)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"backend nftables",
+		"0 filter OUTPUT -d 169.254.169.254/32 -j REJECT",
+		"0 filter FORWARD -d 169.254.169.254/32 -j REJECT",
+	}, *rules)
+}
+
+func TestBlockInstanceMetadataEndpointRouteFailure(t *testing.T) {
+	_, rules := mockLayers(errors.New("operation not supported"))
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint(BlockMethodRoute, false, firewall.BackendIPTables)
+	assert.Error(t, err)
+	assert.Empty(t, *rules)
+}
+
+func TestBlockInstanceMetadataEndpointUnknownMethod(t *testing.T) {
+	mockLayers(nil)
+	defer restoreLayers()
+
+	err := BlockInstanceMetadataEndpoint("nftables", false, firewall.BackendIPTables)
+	assert.Error(t, err)
+}
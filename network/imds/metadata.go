@@ -0,0 +1,161 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package imds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// Paths of the IMDSv2 session token and of the ENI metadata.
+	metadataTokenPath          = "/latest/api/token"
+	metadataMACsPath           = "/latest/meta-data/network/interfaces/macs/"
+	metadataDeviceNumberFormat = metadataMACsPath + "%s/device-number"
+
+	// Headers requesting and carrying an IMDSv2 session token, and the token lifetime in seconds.
+	metadataTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	metadataTokenHeader    = "X-aws-ec2-metadata-token"
+	metadataTokenTTL       = "60"
+
+	// Timeout of each request to IMDS.
+	metadataRequestTimeout = 2 * time.Second
+)
+
+var (
+	// metadataServiceURL is the base URL of IMDS. It is a variable so that it can be replaced
+	// in unit tests.
+	metadataServiceURL = "http://169.254.169.254"
+
+	// interfaceMACAddresses caches the MAC addresses of the ENIs by device index for the
+	// lifetime of the process, as ENIs keep their device index while they are attached.
+	interfaceMACAddresses     map[int]net.HardwareAddr
+	interfaceMACAddressesLock sync.Mutex
+)
+
+// GetInterfaceMACAddress returns the MAC address of the ENI attached to the instance at the given
+// device index, as reported by IMDS. IMDSv2 is used if available.
+func GetInterfaceMACAddress(deviceIndex int) (net.HardwareAddr, error) {
+	interfaceMACAddressesLock.Lock()
+	defer interfaceMACAddressesLock.Unlock()
+
+	if macAddress, ok := interfaceMACAddresses[deviceIndex]; ok {
+		return macAddress, nil
+	}
+
+	macAddresses, err := getInterfaceMACAddresses()
+	if err != nil {
+		log.Errorf("Failed to query ENIs from instance metadata: %v.", err)
+		return nil, err
+	}
+	interfaceMACAddresses = macAddresses
+
+	macAddress, ok := macAddresses[deviceIndex]
+	if !ok {
+		return nil, fmt.Errorf("no ENI attached at device index %d", deviceIndex)
+	}
+
+	log.Infof("Found ENI %s at device index %d in instance metadata.", macAddress, deviceIndex)
+	return macAddress, nil
+}
+
+// getInterfaceMACAddresses queries IMDS for the MAC addresses of all ENIs by device index.
+func getInterfaceMACAddresses() (map[int]net.HardwareAddr, error) {
+	client := &http.Client{Timeout: metadataRequestTimeout}
+
+	// IMDSv1 is used if no session token can be obtained.
+	token, err := getMetadataToken(client)
+	if err != nil {
+		log.Infof("Failed to get instance metadata token, falling back to IMDSv1: %v.", err)
+	}
+
+	macs, err := getMetadata(client, token, metadataMACsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	macAddresses := make(map[int]net.HardwareAddr)
+	for _, mac := range strings.Fields(macs) {
+		mac = strings.TrimSuffix(mac, "/")
+		macAddress, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENI MAC address %s: %v", mac, err)
+		}
+
+		deviceNumber, err := getMetadata(client, token, fmt.Sprintf(metadataDeviceNumberFormat, mac))
+		if err != nil {
+			return nil, err
+		}
+		deviceIndex, err := strconv.Atoi(strings.TrimSpace(deviceNumber))
+		if err != nil {
+			return nil, fmt.Errorf("invalid device number %s of ENI %s", deviceNumber, mac)
+		}
+
+		macAddresses[deviceIndex] = macAddress
+	}
+
+	return macAddresses, nil
+}
+
+// getMetadataToken requests an IMDSv2 session token.
+func getMetadataToken(client *http.Client) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, metadataServiceURL+metadataTokenPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(metadataTokenTTLHeader, metadataTokenTTL)
+
+	return doMetadataRequest(client, req)
+}
+
+// getMetadata returns the instance metadata at the given path.
+func getMetadata(client *http.Client, token string, path string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataServiceURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		req.Header.Set(metadataTokenHeader, token)
+	}
+
+	return doMetadataRequest(client, req)
+}
+
+// doMetadataRequest sends the given request to IMDS and returns the response body.
+func doMetadataRequest(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s returned status %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package imds
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testMetadataToken = "test-token"
+)
+
+// newStubMetadataService starts a stub IMDS serving the given ENI device numbers by MAC address.
+// It requires an IMDSv2 session token unless imdsV1 is true, and counts the metadata requests.
+func newStubMetadataService(deviceNumbers map[string]int, imdsV1 bool, requests *int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(metadataTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		if imdsV1 || r.Method != http.MethodPut || r.Header.Get(metadataTokenTTLHeader) == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, testMetadataToken)
+	})
+	mux.HandleFunc(metadataMACsPath, func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if !imdsV1 && r.Header.Get(metadataTokenHeader) != testMetadataToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == metadataMACsPath {
+			for mac := range deviceNumbers {
+				fmt.Fprintf(w, "%s/\n", mac)
+			}
+			return
+		}
+
+		for mac, deviceNumber := range deviceNumbers {
+			if r.URL.Path == fmt.Sprintf(metadataDeviceNumberFormat, mac) {
+				fmt.Fprint(w, deviceNumber)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	server := httptest.NewServer(mux)
+	metadataServiceURL = server.URL
+	interfaceMACAddresses = nil
+	return server
+}
+
+// TestGetInterfaceMACAddress tests that ENIs are looked up by device index with IMDSv2, and that
+// the results are cached.
+func TestGetInterfaceMACAddress(t *testing.T) {
+	var requests int
+	server := newStubMetadataService(map[string]int{"02:71:ca:81:41:1e": 0, "02:e1:48:75:86:a4": 2}, false, &requests)
+	defer server.Close()
+
+	macAddress, err := GetInterfaceMACAddress(2)
+	require.NoError(t, err)
+	assert.Equal(t, "02:e1:48:75:86:a4", macAddress.String())
+	assert.Equal(t, 3, requests)
+
+	macAddress, err = GetInterfaceMACAddress(0)
+	require.NoError(t, err)
+	assert.Equal(t, "02:71:ca:81:41:1e", macAddress.String())
+	assert.Equal(t, 3, requests)
+
+	_, err = GetInterfaceMACAddress(1)
+	assert.Error(t, err)
+}
+
+// TestGetInterfaceMACAddressIMDSv1 tests that IMDSv1 is used if no session token can be obtained.
+func TestGetInterfaceMACAddressIMDSv1(t *testing.T) {
+	var requests int
+	server := newStubMetadataService(map[string]int{"02:e1:48:75:86:a4": 1}, true, &requests)
+	defer server.Close()
+
+	macAddress, err := GetInterfaceMACAddress(1)
+	require.NoError(t, err)
+	assert.Equal(t, "02:e1:48:75:86:a4", macAddress.String())
+}
+
+// TestGetInterfaceMACAddressUnavailable tests that IMDS failures are returned and not cached.
+func TestGetInterfaceMACAddressUnavailable(t *testing.T) {
+	var requests int
+	server := newStubMetadataService(nil, false, &requests)
+	server.Close()
+
+	_, err := GetInterfaceMACAddress(1)
+	assert.Error(t, err)
+	assert.Nil(t, interfaceMACAddresses)
+}
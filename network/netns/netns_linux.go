@@ -24,8 +24,13 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// netNsMountPath specifies the filesystem directory where netns are mounted.
-const netNsMountPath = "/var/run/netns"
+const (
+	// netNsMountPath specifies the filesystem directory where netns are mounted.
+	netNsMountPath = "/var/run/netns"
+
+	// pidNetNSPathFormat is the path to the netns of a process.
+	pidNetNSPathFormat = "/proc/%d/ns/net"
+)
 
 // netNS represent a Linux network namespace.
 type netNS struct {
@@ -104,19 +109,67 @@ func GetNetNS(nameOrPath string) (NetNS, error) {
 	}
 }
 
+// GetNetNSByPid creates a new netNS object representing the netns of an existing process.
+func GetNetNSByPid(pid int) (NetNS, error) {
+	return GetNetNSByPath(fmt.Sprintf(pidNetNSPathFormat, pid))
+}
+
 // GetNetNSByName creates a new netNS object representing an existing netns by name.
 func GetNetNSByName(name string) (NetNS, error) {
 	return GetNetNSByPath(path.Join(netNsMountPath, name))
 }
 
 // GetNetNSByPath creates a new netNS object representing an existing netns by path.
+// The netns is not owned by the returned object and is left alone when it is closed.
+// Call DeleteNetNSByName to delete a named netns.
 func GetNetNSByPath(path string) (NetNS, error) {
 	fd, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return &netNS{file: fd, mounted: true}, nil
+	err = checkNetNSFile(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return &netNS{file: fd}, nil
+}
+
+// DeleteNetNSByName unmounts and removes an existing named netns.
+func DeleteNetNSByName(name string) error {
+	return unmountNetNS(path.Join(netNsMountPath, name))
+}
+
+// unmountNetNS unmounts and removes the netns mounted at the given path.
+func unmountNetNS(nsPath string) error {
+	err := unix.Unmount(nsPath, unix.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("Failed to unmount namespace %s: %v", nsPath, err)
+	}
+	err = os.RemoveAll(nsPath)
+	if err != nil {
+		return fmt.Errorf("Failed to clean up namespace %s: %v", nsPath, err)
+	}
+
+	return nil
+}
+
+// checkNetNSFile returns an error if the given file does not refer to a netns.
+func checkNetNSFile(file *os.File) error {
+	var fs unix.Statfs_t
+	err := unix.Fstatfs(int(file.Fd()), &fs)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", file.Name(), err)
+	}
+
+	// Namespace files are on nsfs, or on procfs before Linux 3.19.
+	if fs.Type != unix.NSFS_MAGIC && fs.Type != unix.PROC_SUPER_MAGIC {
+		return fmt.Errorf("%s is not a network namespace", file.Name())
+	}
+
+	return nil
 }
 
 // Close releases the reference to the underlying netns.
@@ -132,13 +185,9 @@ func (ns *netNS) Close() error {
 	ns.closed = true
 
 	if ns.mounted {
-		err = unix.Unmount(ns.file.Name(), unix.MNT_DETACH)
-		if err != nil {
-			return fmt.Errorf("Failed to unmount namespace %s: %v", ns.file.Name(), err)
-		}
-		err = os.RemoveAll(ns.file.Name())
+		err = unmountNetNS(ns.file.Name())
 		if err != nil {
-			return fmt.Errorf("Failed to clean up namespace %s: %v", ns.file.Name(), err)
+			return err
 		}
 		ns.mounted = false
 	}
@@ -0,0 +1,96 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package netns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetNetNSByPid tests that the netns of a process can be opened by its pid or by its pid-based
+// path, and that closing it leaves the procfs path alone.
+func TestGetNetNSByPid(t *testing.T) {
+	pidPath := fmt.Sprintf("/proc/%d/ns/net", os.Getpid())
+
+	ns, err := GetNetNSByPid(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, pidPath, ns.GetPath())
+	assert.NoError(t, ns.Close())
+
+	for _, nameOrPath := range []string{pidPath, "/proc/self/ns/net"} {
+		ns, err := GetNetNS(nameOrPath)
+		require.NoError(t, err, nameOrPath)
+		assert.False(t, ns.(*netNS).mounted, nameOrPath)
+
+		err = ns.Run(func() error { return nil })
+		assert.NoError(t, err, nameOrPath)
+		err = ns.Close()
+		assert.NoError(t, err, nameOrPath)
+	}
+
+	_, err = os.Stat(pidPath)
+	assert.NoError(t, err)
+
+	// A bare pid is a netns name, and not the pid of a process.
+	_, err = GetNetNS(strconv.Itoa(os.Getpid()))
+	assert.Error(t, err)
+}
+
+// TestGetNetNSByPathNotNetNS tests that opening a file that is not a netns fails.
+func TestGetNetNSByPathNotNetNS(t *testing.T) {
+	file, err := ioutil.TempFile("", "netns")
+	require.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	_, err = GetNetNSByPath(file.Name())
+	assert.Error(t, err)
+}
+
+// TestGetNetNSByName tests that closing a named netns opened by name leaves it alone, and that
+// it is removed by DeleteNetNSByName.
+func TestGetNetNSByName(t *testing.T) {
+	name := fmt.Sprintf("netns-test-%d", os.Getpid())
+	createdNS, err := NewNetNS(name)
+	require.NoError(t, err)
+	nsPath := createdNS.GetPath()
+
+	ns, err := GetNetNSByName(name)
+	require.NoError(t, err)
+	err = ns.Close()
+	assert.NoError(t, err)
+	_, err = os.Stat(nsPath)
+	assert.NoError(t, err)
+
+	err = createdNS.Close()
+	assert.NoError(t, err)
+	_, err = os.Stat(nsPath)
+	assert.True(t, os.IsNotExist(err))
+
+	createdNS, err = NewNetNS(name)
+	require.NoError(t, err)
+	createdNS.(*netNS).mounted = false
+	createdNS.Close()
+
+	err = DeleteNetNSByName(name)
+	assert.NoError(t, err)
+	_, err = os.Stat(nsPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -43,3 +43,44 @@ func CompareMACAddress(addr1, addr2 net.HardwareAddr) bool {
 
 	return true
 }
+
+// IsUnicastMACAddress returns whether a MAC address is a valid non-zero unicast address.
+// Both universally and locally administered addresses are considered valid.
+func IsUnicastMACAddress(addr net.HardwareAddr) bool {
+	if len(addr) == 0 {
+		return false
+	}
+
+	// The least significant bit of the first octet is the multicast bit.
+	if addr[0]&0x01 != 0 {
+		return false
+	}
+
+	for _, octet := range addr {
+		if octet != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GenerateMACAddress returns a deterministic, locally administered unicast MAC address derived
+// from the given IP address. The last four octets are the IPv4 address, or the last four bytes
+// of the IPv6 address, and the second octet tells the two families apart.
+func GenerateMACAddress(ipAddress net.IP) net.HardwareAddr {
+	mac := net.HardwareAddr{0x02, 0x00, 0, 0, 0, 0}
+
+	ip := ipAddress.To4()
+	if ip == nil {
+		ip = ipAddress.To16()
+		if ip == nil {
+			return nil
+		}
+		mac[1] = 0x06
+		ip = ip[net.IPv6len-4:]
+	}
+
+	copy(mac[2:], ip)
+	return mac
+}
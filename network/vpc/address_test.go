@@ -0,0 +1,41 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package vpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerateMACAddress tests that MAC addresses generated from IP addresses are deterministic
+// locally administered unicast addresses.
+func TestGenerateMACAddress(t *testing.T) {
+	mac := GenerateMACAddress(net.ParseIP("10.11.12.13"))
+	assert.Equal(t, "02:00:0a:0b:0c:0d", mac.String())
+	assert.True(t, IsUnicastMACAddress(mac))
+	assert.Equal(t, byte(0x02), mac[0]&0x02, "not locally administered")
+
+	// The same IP address yields the same MAC address.
+	assert.Equal(t, mac, GenerateMACAddress(net.ParseIP("10.11.12.13")))
+	assert.NotEqual(t, mac, GenerateMACAddress(net.ParseIP("10.11.12.14")))
+
+	mac = GenerateMACAddress(net.ParseIP("2600:1f13:a0d:a700::5"))
+	assert.Equal(t, "02:06:00:00:00:05", mac.String())
+	assert.True(t, IsUnicastMACAddress(mac))
+	assert.Equal(t, mac, GenerateMACAddress(net.ParseIP("2600:1f13:a0d:a700::5")))
+
+	assert.Nil(t, GenerateMACAddress(nil))
+}
@@ -20,6 +20,9 @@ import (
 var (
 	// Well-known VPC default gateway host ID.
 	defaultGatewayHostID = []byte{0, 0, 0, 1}
+
+	// Well-known VPC default gateway host ID for IPv6 subnets.
+	defaultIPv6GatewayHostID = net.ParseIP("::1")
 )
 
 // Subnet represents a VPC subnet.
@@ -31,7 +34,11 @@ type Subnet struct {
 // NewSubnet creates a new VPC subnet object given its prefix.
 func NewSubnet(prefix *net.IPNet) (*Subnet, error) {
 	// Compute default gateway address.
-	gateway := ComputeIPAddress(prefix, defaultGatewayHostID)
+	hostID := net.IP(defaultGatewayHostID)
+	if prefix.IP.To4() == nil {
+		hostID = defaultIPv6GatewayHostID
+	}
+	gateway := ComputeIPAddress(prefix, hostID)
 
 	subnet := &Subnet{
 		Prefix:   *prefix,
@@ -63,7 +70,8 @@ func GetSubnetPrefix(ipAddress *net.IPNet) *net.IPNet {
 func ComputeIPAddress(prefix *net.IPNet, hostID net.IP) net.IP {
 	// Always treat as IPv6 address to ensure compatibility with both IPv4 and IPv6.
 	prefixIP := prefix.IP.To16()
-	hostIP := hostID.To16()
+	hostIP := make(net.IP, net.IPv6len)
+	copy(hostIP, hostID.To16())
 
 	for i := 0; i < len(hostIP); i++ {
 		hostIP[i] |= prefixIP[i]
@@ -24,6 +24,8 @@ const (
 	anySubnetPrefixString        = "12.34.56.0/22"
 	anySubnetGateway             = "12.34.56.1"
 	anyInvalidSubnetPrefixString = "12.345.56.0/42"
+	anyIPv6SubnetPrefixString    = "2600:1f13:a0d:a700::/64"
+	anyIPv6SubnetGateway         = "2600:1f13:a0d:a700::1"
 )
 
 // TestNewSubnet tests subnet constructors.
@@ -49,3 +51,12 @@ func TestNewSubnet(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, subnet)
 }
+
+// TestNewIPv6Subnet tests subnet constructors with IPv6 prefixes.
+func TestNewIPv6Subnet(t *testing.T) {
+	subnet, err := NewSubnetFromString(anyIPv6SubnetPrefixString)
+	assert.NoError(t, err)
+	assert.Equal(t, anyIPv6SubnetPrefixString, subnet.Prefix.String(), "incorrect prefix")
+	assert.Equal(t, 1, len(subnet.Gateways), "incorrect number of gateways")
+	assert.Equal(t, anyIPv6SubnetGateway, subnet.Gateways[0].String(), "incorrect gateway")
+}
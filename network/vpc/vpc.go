@@ -17,6 +17,9 @@ const (
 	// InstanceMetadataEndpoint is EC2's instance metadata endpoint.
 	InstanceMetadataEndpoint = "169.254.169.254/32"
 
+	// InstanceMetadataIPv6Endpoint is EC2's instance metadata IPv6 endpoint.
+	InstanceMetadataIPv6Endpoint = "fd00:ec2::254/128"
+
 	// JumboFrameMTU is the VPC jumbo Ethernet frame Maximum Transmission Unit size in bytes.
 	JumboFrameMTU = 9001
 )
@@ -16,6 +16,7 @@
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -27,7 +28,7 @@ import (
 	"github.com/aws/amazon-vpc-cni-plugins/plugins/aws-appmesh/config"
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
-	"github.com/containernetworking/cni/pkg/types/current"
+	types040 "github.com/containernetworking/cni/pkg/types/040"
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -148,9 +149,11 @@ func testInvalid(t *testing.T, meta testMeta) {
 	// Execute the "ADD" command for the plugin.
 	execInvokeArgs.Command = "ADD"
 	err = invoke.ExecPluginWithoutResult(
+		context.TODO(),
 		pluginPath,
 		netConfData,
-		execInvokeArgs)
+		execInvokeArgs,
+		nil)
 	assert.EqualError(t, err, meta.errorMsg)
 }
 
@@ -174,9 +177,11 @@ func testValid(t *testing.T, meta testMeta) {
 	// Execute the "ADD" command for the plugin.
 	execInvokeArgs.Command = "ADD"
 	res, err := invoke.ExecPluginWithResult(
+		context.TODO(),
 		pluginPath,
 		netConfData,
-		execInvokeArgs)
+		execInvokeArgs,
+		nil)
 	require.NoError(t, err, "Unable to execute ADD command for aws-appmesh cni plugin")
 
 	netConf, err := config.New(&skel.CmdArgs{
@@ -187,7 +192,7 @@ func testValid(t *testing.T, meta testMeta) {
 	// Test that the plugin passed previous CNI result unmodified.
 	res031, err := res.GetAsVersion("0.3.1")
 	assert.NoError(t, err, "Unable to parse result")
-	result := res031.(*current.Result)
+	result := res031.(*types040.Result)
 	assert.Equal(t, "4", result.IPs[0].Version)
 	assert.Equal(t, "10.1.2.3/16", result.IPs[0].Address.String())
 
@@ -200,9 +205,11 @@ func testValid(t *testing.T, meta testMeta) {
 	// Execute the "DEL" command for the plugin.
 	execInvokeArgs.Command = "DEL"
 	err = invoke.ExecPluginWithoutResult(
+		context.TODO(),
 		pluginPath,
 		netConfData,
-		execInvokeArgs)
+		execInvokeArgs,
+		nil)
 	require.NoError(t, err, "Unable to execute DEL command for aws-appmesh cni plugin")
 
 	targetNS.Run(func() error {
@@ -14,106 +14,534 @@
 package config
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 
+	"github.com/aws/amazon-vpc-cni-plugins/logger"
+	"github.com/aws/amazon-vpc-cni-plugins/network/firewall"
+	"github.com/aws/amazon-vpc-cni-plugins/network/imds"
 	"github.com/aws/amazon-vpc-cni-plugins/network/vpc"
 
 	log "github.com/cihub/seelog"
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniVersion "github.com/containernetworking/cni/pkg/version"
 )
 
 // NetConfig defines the network configuration for the vpc-branch-eni plugin.
 type NetConfig struct {
 	cniTypes.NetConf
-	TrunkName              string
-	TrunkMACAddress        net.HardwareAddr
-	BranchVlanID           int
-	BranchMACAddress       net.HardwareAddr
-	BranchIPAddress        *net.IPNet
-	BranchGatewayIPAddress net.IP
-	BlockIMDS              bool
-	InterfaceType          string
-	Tap                    *TAPConfig
+	TrunkName                string
+	TrunkMACAddress          net.HardwareAddr
+	TrunkNames               []string
+	TrunkMACAddresses        []net.HardwareAddr
+	TrunkPCIAddress          string
+	TrunkInterfaceIndex      *int
+	TrunkPromiscuous         bool
+	BringUpTrunk             bool
+	BranchVlanID             int
+	VlanProtocol             string
+	VlanEgressQoSMap         map[uint32]uint32
+	VlanIngressQoSMap        map[uint32]uint32
+	MACVLANMode              string
+	BranchMACAddress         net.HardwareAddr
+	BranchIPAddress          *net.IPNet
+	BranchIPAddresses        []net.IPNet
+	BranchGatewayIPAddress   net.IP
+	BranchGatewayIPAddresses []net.IP
+	BranchIPv6Address        *net.IPNet
+	BranchGatewayIPv6Address net.IP
+	BranchIPPrefix           *net.IPNet
+	ManagementIPAddress      *net.IPNet
+	ManagementIPScope        string
+	AddressScope             string
+	MTU                      int
+	DefaultRouteMetric       int
+	InstallDefaultRoute      bool
+	DefaultRouteSource       bool
+	DuplicateAddrDetection   bool
+	GratuitousARPCount       int
+	BringUpAfterConfig       bool
+	TCPMSSClamp              bool
+	EgressOnly               bool
+	NoPrefixRoute            bool
+	ConnMark                 uint32
+	DNSStub                  bool
+	RouteTableID             int
+	RouteProtocol            int
+	Routes                   []cniTypes.Route
+	StaticNeighbors          []StaticNeighbor
+	IngressBandwidthLimit    uint64
+	EgressBandwidthLimit     uint64
+	TCBPFProgram             string
+	BlockIMDS                bool
+	BlockIMDSMethod          string
+	FirewallBackend          string
+	ProxyARP                 bool
+	VerifyGatewayReachable   bool
+	DisableRPFilter          bool
+	DisableIPv6Autoconf      bool
+	DisableICMPRedirects     bool
+	Hairpin                  bool
+	ConfigureLoopback        bool
+	Sysctls                  map[string]string
+	Offloads                 map[string]bool
+	InterfaceType            string
+	InterfaceName            string
+	InterfaceMACAddress      net.HardwareAddr
+	HostInterfaceName        string
+	StateFilePath            string
+	Tap                      *TAPConfig
+	AdoptInterfaceName       string
+	AdoptInterfaceMAC        net.HardwareAddr
+}
+
+// StaticNeighbor defines a permanent neighbor entry on the branch interface.
+type StaticNeighbor struct {
+	IPAddress  net.IP
+	MACAddress net.HardwareAddr
 }
 
 // TAPConfig defines a TAP interface configuration.
 type TAPConfig struct {
-	Uid    int
-	Gid    int
-	Queues int
+	Uid               int
+	Gid               int
+	Queues            int
+	VhostNet          bool
+	ExternallyManaged bool
 }
 
 // netConfigJSON defines the network configuration JSON file format for the vpc-branch-eni plugin.
 type netConfigJSON struct {
 	cniTypes.NetConf
-	TrunkName              string `json:"trunkName"`
-	TrunkMACAddress        string `json:"trunkMACAddress"`
-	BranchVlanID           string `json:"branchVlanID"`
-	BranchMACAddress       string `json:"branchMACAddress"`
-	BranchIPAddress        string `json:"branchIPAddress"`
-	BranchGatewayIPAddress string `json:"branchGatewayIPAddress"`
-	BlockIMDS              bool   `json:"blockInstanceMetadata"`
-	InterfaceType          string `json:"interfaceType"`
-	Uid                    string `json:"uid"`
-	Gid                    string `json:"gid"`
+	TrunkName                stringList        `json:"trunkName"`
+	TrunkMACAddress          stringList        `json:"trunkMACAddress"`
+	TrunkPCIAddress          string            `json:"trunkPCIAddress"`
+	TrunkInterfaceIndex      *int              `json:"trunkInterfaceIndex"`
+	TrunkPromiscuous         bool              `json:"trunkPromiscuous"`
+	BringUpTrunk             bool              `json:"bringUpTrunk"`
+	BranchVlanID             stringOrNumber    `json:"branchVlanID"`
+	VlanProtocol             string            `json:"vlanProtocol"`
+	VlanEgressQoSMap         map[string]uint32 `json:"vlanEgressQoSMap"`
+	VlanIngressQoSMap        map[string]uint32 `json:"vlanIngressQoSMap"`
+	MACVLANMode              string            `json:"macvlanMode"`
+	BranchMACAddress         string            `json:"branchMACAddress"`
+	GenerateMACFromIP        bool              `json:"generateMACFromIP"`
+	BranchIPAddress          string            `json:"branchIPAddress"`
+	BranchIPAddresses        []string          `json:"branchIPAddresses"`
+	BranchGatewayIPAddress   stringList        `json:"branchGatewayIPAddress"`
+	BranchIPv6Address        string            `json:"branchIPv6Address"`
+	BranchGatewayIPv6Address string            `json:"branchGatewayIPv6Address"`
+	BranchIPPrefix           string            `json:"branchIPPrefix"`
+	ManagementIPAddress      string            `json:"managementIPAddress"`
+	ManagementIPScope        string            `json:"managementIPAddressScope"`
+	AddressScope             string            `json:"addressScope"`
+	GatewayPosition          stringOrNumber    `json:"gatewayPosition"`
+	MTU                      intOrString       `json:"mtu"`
+	DefaultRouteMetric       int               `json:"defaultRouteMetric"`
+	InstallDefaultRoute      *bool             `json:"installDefaultRoute"`
+	DefaultRouteSource       *bool             `json:"defaultRouteSource"`
+	DuplicateAddrDetection   bool              `json:"duplicateAddressDetection"`
+	GratuitousARPCount       *int              `json:"gratuitousARPCount"`
+	BringUpAfterConfig       bool              `json:"bringUpAfterConfig"`
+	TCPMSSClamp              bool              `json:"tcpMSSClamp"`
+	EgressOnly               bool              `json:"egressOnly"`
+	NoPrefixRoute            bool              `json:"noPrefixRoute"`
+	ConnMark                 int64             `json:"connmark"`
+	DNSStub                  bool              `json:"dnsStub"`
+	RouteTableID             int               `json:"routeTableID"`
+	RouteProtocol            int               `json:"routeProtocol"`
+	Routes                   []routeJSON       `json:"routes"`
+	StaticNeighbors          []neighborJSON    `json:"staticNeighbors"`
+	IngressBandwidthLimit    string            `json:"ingressBandwidthLimit"`
+	EgressBandwidthLimit     string            `json:"egressBandwidthLimit"`
+	TCBPFProgram             string            `json:"tcBPFProgram"`
+	BlockIMDS                bool              `json:"blockInstanceMetadata"`
+	BlockIMDSMethod          string            `json:"blockInstanceMetadataMethod"`
+	FirewallBackend          string            `json:"firewallBackend"`
+	ProxyARP                 bool              `json:"proxyARP"`
+	VerifyGatewayReachable   bool              `json:"verifyGatewayReachable"`
+	DisableRPFilter          bool              `json:"disableRPFilter"`
+	DisableIPv6Autoconf      bool              `json:"disableIPv6Autoconf"`
+	DisableICMPRedirects     bool              `json:"disableICMPRedirects"`
+	Hairpin                  bool              `json:"hairpin"`
+	ConfigureLoopback        *bool             `json:"configureLoopback"`
+	Sysctls                  map[string]string `json:"sysctls"`
+	Offloads                 map[string]bool   `json:"offloads"`
+	InterfaceType            string            `json:"interfaceType"`
+	InterfaceName            string            `json:"interfaceName"`
+	InterfaceMACAddress      string            `json:"interfaceMACAddress"`
+	HostIfNameTemplate       string            `json:"hostInterfaceNameTemplate"`
+	Uid                      stringOrNumber    `json:"uid"`
+	Gid                      stringOrNumber    `json:"gid"`
+	TapQueues                int               `json:"tapQueues"`
+	VhostNet                 bool              `json:"vhostNet"`
+	TAPExternallyManaged     bool              `json:"tapDeviceExternallyManaged"`
+	StrictConfig             bool              `json:"strictConfig"`
+	StateFileTemplate        string            `json:"stateFile"`
+	AdoptInterface           string            `json:"adoptInterface"`
+}
+
+// strictNetConfigJSON defines the network configuration keys accepted in strict mode. Keys of
+// the IPAM plugin configuration and those added by the runtime are passed through unchecked.
+type strictNetConfigJSON struct {
+	netConfigJSON
+	IPAM          json.RawMessage `json:"ipam"`
+	RuntimeConfig json.RawMessage `json:"runtimeConfig"`
+	Args          json.RawMessage `json:"args"`
+}
+
+// stringList is a JSON value that is either a single string or an array of strings.
+type stringList []string
+
+// UnmarshalJSON unmarshals a single string or an array of strings.
+func (list *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*list = nil
+		if s != "" {
+			*list = stringList{s}
+		}
+		return nil
+	}
+
+	var strs []string
+	if err := json.Unmarshal(data, &strs); err != nil {
+		return fmt.Errorf("must be a string or an array of strings")
+	}
+	*list = strs
+	return nil
+}
+
+// stringOrNumber is a JSON value that is either a string or a number, kept in its string form.
+type stringOrNumber string
+
+// UnmarshalJSON unmarshals a string or a number.
+func (s *stringOrNumber) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = stringOrNumber(str)
+		return nil
+	}
+
+	var num json.Number
+	if err := json.Unmarshal(data, &num); err != nil {
+		return fmt.Errorf("must be a string or a number")
+	}
+	*s = stringOrNumber(num)
+	return nil
+}
+
+// intOrString is a JSON value that is either an integer or a string containing one.
+type intOrString int
+
+// UnmarshalJSON unmarshals an integer or a string containing one.
+func (i *intOrString) UnmarshalJSON(data []byte) error {
+	var num int
+	if err := json.Unmarshal(data, &num); err == nil {
+		*i = intOrString(num)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("must be an integer or a string containing one")
+	}
+	num, err := strconv.Atoi(str)
+	if err != nil {
+		return fmt.Errorf("must be an integer or a string containing one")
+	}
+	*i = intOrString(num)
+	return nil
+}
+
+// routeJSON defines the JSON format of a static route.
+type routeJSON struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw"`
+}
+
+// neighborJSON defines the JSON format of a static neighbor entry.
+type neighborJSON struct {
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
 }
 
 // pcArgs defines the per-container arguments passed in CNI_ARGS environment variable.
+// The accepted keys are the field names below, e.g. BranchVlanID=100;BranchMACAddress=...
+// Keys are matched case-insensitively, so branchvlanid and BRANCHVLANID are also accepted.
+// If the same key is passed with different cases, the one matching the field name exactly wins.
 type pcArgs struct {
 	cniTypes.CommonArgs
-	BranchVlanID           cniTypes.UnmarshallableString
-	BranchMACAddress       cniTypes.UnmarshallableString
-	BranchIPAddress        cniTypes.UnmarshallableString
-	BranchGatewayIPAddress cniTypes.UnmarshallableString
+	BranchVlanID             cniTypes.UnmarshallableString
+	BranchMACAddress         cniTypes.UnmarshallableString
+	BranchIPAddress          cniTypes.UnmarshallableString
+	BranchIPAddresses        cniTypes.UnmarshallableString
+	BranchGatewayIPAddress   cniTypes.UnmarshallableString
+	BranchIPv6Address        cniTypes.UnmarshallableString
+	BranchGatewayIPv6Address cniTypes.UnmarshallableString
+	MTU                      cniTypes.UnmarshallableString
+	IngressBandwidthLimit    cniTypes.UnmarshallableString
+	EgressBandwidthLimit     cniTypes.UnmarshallableString
+	Routes                   cniTypes.UnmarshallableString
 }
 
 const (
+	// envNetConfFile is the environment variable that specifies a file to read the network
+	// configuration from instead of stdin, e.g. to reproduce an issue from a captured config.
+	envNetConfFile = "VPC_CNI_NETCONF_FILE"
+
+	// envStrict is the environment variable that enables strict mode for all network
+	// configurations, like the strictConfig key does for a single one. Strict mode rejects
+	// unknown keys, and parameters that have no effect with the interface type, which are
+	// otherwise ignored.
+	envStrict = "VPC_CNI_STRICT"
+
+	// envCNICommand is the environment variable that specifies the CNI command being executed.
+	envCNICommand = "CNI_COMMAND"
+
 	// Interface type values.
 	IfTypeVLAN    = "vlan"
 	IfTypeTAP     = "tap"
 	IfTypeMACVTAP = "macvtap"
+	IfTypeMACVLAN = "macvlan"
 
-	// Default number of queues to use with TAP interfaces.
+	// Template in sysctl keys substituted with the name of the interface in the target netns.
+	SysctlIfNameTemplate = "{ifname}"
+
+	// Templates in host interface names substituted with the branch VLAN ID and with the first
+	// characters of the container ID.
+	hostIfNameVlanTemplate        = "{vlan}"
+	hostIfNameContainerIDTemplate = "{cid8}"
+	hostIfNameContainerIDLength   = 8
+
+	// Templates in state file paths substituted with the container ID and with the name of the
+	// interface in the target netns.
+	stateFileContainerIDTemplate = "{containerID}"
+	stateFileIfNameTemplate      = "{ifname}"
+
+	// Offload feature names, as used by "ethtool -K".
+	OffloadRXChecksum = "rx"
+	OffloadTXChecksum = "tx"
+	OffloadSG         = "sg"
+	OffloadTSO        = "tso"
+	OffloadGSO        = "gso"
+	OffloadGRO        = "gro"
+
+	// Positions in the branch subnet of the gateway derived when not specified.
+	GatewayPositionFirst = "first"
+	GatewayPositionLast  = "last"
+
+	// Host offsets of the first and last usable hosts of a subnet. The last one depends on
+	// the subnet size and is resolved when the gateway is derived.
+	firstGatewayOffset = 1
+	lastGatewayOffset  = -1
+
+	// Prefix of the sysctls allowed to be set in the target netns.
+	allowedSysctlPrefix = "net."
+
+	// VLAN protocol values.
+	VlanProtocol8021Q  = "802.1q"
+	VlanProtocol8021AD = "802.1ad"
+
+	// MACVLAN mode values.
+	MACVLANModeBridge  = "bridge"
+	MACVLANModePrivate = "private"
+	MACVLANModeVEPA    = "vepa"
+
+	// Type of the IPAM plugin leasing the branch IP addresses from a DHCP server.
+	IPAMTypeDHCP = "dhcp"
+
+	// Default route protocol (RTPROT) value tagging the routes installed by this plugin.
+	DefaultRouteProtocol = 86
+
+	// Management IP address scope values.
+	ManagementIPScopeHost = "host"
+	ManagementIPScopeLink = "link"
+
+	// Branch IP address scope values.
+	AddressScopeGlobal = "global"
+	AddressScopeLink   = "link"
+	AddressScopeHost   = "host"
+
+	// Default name of the interface in the target network namespace.
+	defaultInterfaceName = "eth0"
+
+	// Default number of gratuitous ARPs and unsolicited neighbor advertisements sent for the
+	// branch IP addresses of branch interfaces.
+	defaultGratuitousARPCount = 1
+
+	// Maximum length of a Linux interface name, excluding the terminating null (IFNAMSIZ - 1).
+	maxInterfaceNameLength = 15
+
+	// Default and maximum number of queues to use with TAP interfaces.
 	defaultTapQueues = 1
+	maxTapQueues     = 256
+
+	// Range of valid IEEE 802.1Q VLAN IDs.
+	minVlanID = 1
+	maxVlanID = 4094
+
+	// Maximum IEEE 802.1p VLAN priority.
+	maxVlanPriority = 7
+
+	// Range of valid interface MTU values.
+	minMTU = 576
+	maxMTU = 9216
+
+	// Lengths of the IPv4 and IPv6 prefixes delegated to ENIs by AWS prefix delegation.
+	ipv4PrefixLength = 28
+	ipv6PrefixLength = 80
+
+	// Range of valid route table IDs for the branch routes.
+	minRouteTableID = 1
+	maxRouteTableID = 252
+
+	// Range of valid route protocols. Lower values are reserved for routes installed by the kernel.
+	minRouteProtocol = 3
+	maxRouteProtocol = 255
+
+	// Decimal suffixes accepted in bandwidth limits, and the multiplier between successive suffixes.
+	bandwidthSuffixes   = "kmgt"
+	bandwidthMultiplier = 1000
+
+	// Separator for list values in per-container arguments.
+	pcArgsListSeparator = ","
+
+	// Parameters identifying the trunk interface, exactly one of which must be specified.
+	trunkIDParams = "trunkName, trunkMACAddress, trunkPCIAddress or trunkInterfaceIndex"
 
 	// Whether the plugin ignores unknown per-container arguments.
 	ignoreUnknown = true
 )
 
+var (
+	// pciAddressRegex matches a PCI address in domain:bus:device.function format.
+	pciAddressRegex = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-1][0-9a-fA-F]\.[0-7]$`)
+)
+
+// ReadData returns the network configuration passed in the given CNI arguments, which is read
+// from a file instead of stdin if requested.
+func ReadData(args *cniSkel.CmdArgs) ([]byte, error) {
+	path := os.Getenv(envNetConfFile)
+	if path == "" {
+		return args.StdinData, nil
+	}
+
+	log.Infof("Reading network config from file %s.", path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network config file %s: %v", path, err)
+	}
+
+	return data, nil
+}
+
+// checkUnknownKeys returns an error naming the first key in the given network configuration
+// that is not recognized.
+func checkUnknownKeys(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var config strictNetConfigJSON
+	return decoder.Decode(&config)
+}
+
 // New creates a new NetConfig object by parsing the given CNI arguments.
 func New(args *cniSkel.CmdArgs) (*NetConfig, error) {
+	stdinData, err := ReadData(args)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse network configuration.
 	var config netConfigJSON
-	err := json.Unmarshal(args.StdinData, &config)
+	err = json.Unmarshal(stdinData, &config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse network config: %v", err)
 	}
 
+	// Reject unknown keys, which are usually misspelled ones, in strict mode.
+	if os.Getenv(envStrict) == "1" {
+		config.StrictConfig = true
+	}
+	if config.StrictConfig {
+		err = checkUnknownKeys(stdinData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse network config: %v", err)
+		}
+	}
+
+	// Parse the optional result of the previous invocation.
+	err = cniVersion.ParsePrevResult(&config.NetConf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult: %v", err)
+	}
+
+	// Validate the remaining fields, collecting all problems so that they can be fixed at once.
+	var errs validationErrors
+
 	// Parse optional per-container arguments.
 	if args.Args != "" {
 		var pca pcArgs
 		pca.IgnoreUnknown = ignoreUnknown
 
-		if err := cniTypes.LoadArgs(args.Args, &pca); err != nil {
+		if err := cniTypes.LoadArgs(normalizePCArgs(args.Args), &pca); err != nil {
 			return nil, fmt.Errorf("failed to parse per-container args: %v", err)
 		}
 
 		// Per-container arguments override the ones from network configuration.
 		if pca.BranchVlanID != "" {
-			config.BranchVlanID = string(pca.BranchVlanID)
+			config.BranchVlanID = stringOrNumber(pca.BranchVlanID)
 		}
 		if pca.BranchMACAddress != "" {
 			config.BranchMACAddress = string(pca.BranchMACAddress)
 		}
-		if pca.BranchIPAddress != "" {
+		if pca.BranchIPAddress != "" || pca.BranchIPAddresses != "" {
+			// Per-container branch IP addresses replace the whole set from network configuration.
 			config.BranchIPAddress = string(pca.BranchIPAddress)
+			config.BranchIPAddresses = nil
+			if pca.BranchIPAddresses != "" {
+				config.BranchIPAddresses = strings.Split(string(pca.BranchIPAddresses), pcArgsListSeparator)
+			}
 		}
 		if pca.BranchGatewayIPAddress != "" {
-			config.BranchGatewayIPAddress = string(pca.BranchGatewayIPAddress)
+			config.BranchGatewayIPAddress = strings.Split(string(pca.BranchGatewayIPAddress), pcArgsListSeparator)
+		}
+		if pca.BranchIPv6Address != "" {
+			config.BranchIPv6Address = string(pca.BranchIPv6Address)
+		}
+		if pca.BranchGatewayIPv6Address != "" {
+			config.BranchGatewayIPv6Address = string(pca.BranchGatewayIPv6Address)
+		}
+		if pca.MTU != "" {
+			mtu, err := strconv.Atoi(string(pca.MTU))
+			if err != nil {
+				errs.add(fmt.Errorf("invalid MTU %s", pca.MTU))
+			}
+			config.MTU = intOrString(mtu)
+		}
+		if pca.IngressBandwidthLimit != "" {
+			config.IngressBandwidthLimit = string(pca.IngressBandwidthLimit)
+		}
+		if pca.EgressBandwidthLimit != "" {
+			config.EgressBandwidthLimit = string(pca.EgressBandwidthLimit)
+		}
+		if pca.Routes != "" {
+			// Per-container routes are merged with, and override, the ones from network configuration.
+			config.Routes = mergeRoutes(config.Routes, strings.Split(string(pca.Routes), pcArgsListSeparator))
 		}
 	}
 
@@ -122,119 +550,1557 @@ func New(args *cniSkel.CmdArgs) (*NetConfig, error) {
 		config.InterfaceType = IfTypeTAP
 	}
 
+	if config.BlockIMDSMethod == "" {
+		config.BlockIMDSMethod = imds.BlockMethodRoute
+	}
+
+	// Rules are installed with iptables unless another backend is requested, so that they do not
+	// move to nftables on hosts whose own rules are in the legacy iptables.
+	if config.FirewallBackend == "" {
+		config.FirewallBackend = firewall.BackendIPTables
+	}
+
+	// Default routes are installed unless explicitly disabled.
+	installDefaultRoute := true
+	if config.InstallDefaultRoute != nil {
+		installDefaultRoute = *config.InstallDefaultRoute
+	}
+
+	// Default routes prefer the branch IP address as source unless explicitly disabled.
+	defaultRouteSource := true
+	if config.DefaultRouteSource != nil {
+		defaultRouteSource = *config.DefaultRouteSource
+	}
+
+	// The loopback link is configured unless it is managed elsewhere.
+	configureLoopback := true
+	if config.ConfigureLoopback != nil {
+		configureLoopback = *config.ConfigureLoopback
+	}
+
+	config.VlanProtocol = strings.ToLower(config.VlanProtocol)
+	if config.VlanProtocol == "" {
+		config.VlanProtocol = VlanProtocol8021Q
+	}
+
+	// The interface name from network configuration names the interface when CNI_IFNAME is not
+	// set. Otherwise it must match CNI_IFNAME, which the runtime expects to find in the result.
+	if config.InterfaceName == "" {
+		config.InterfaceName = args.IfName
+	} else if args.IfName != "" && args.IfName != config.InterfaceName {
+		errs.add(fmt.Errorf("interfaceName %s does not match CNI_IFNAME %s", config.InterfaceName, args.IfName))
+	}
+	if config.InterfaceName == "" {
+		config.InterfaceName = defaultInterfaceName
+	}
+
+	// Validate the interface type.
+	switch config.InterfaceType {
+	case IfTypeVLAN, IfTypeTAP, IfTypeMACVTAP, IfTypeMACVLAN:
+	default:
+		errs.add(fmt.Errorf("invalid interfaceType %s", config.InterfaceType))
+	}
+
+	// Validate the MACVLAN mode, which defaults to bridge.
+	config.MACVLANMode = strings.ToLower(config.MACVLANMode)
+	if config.InterfaceType == IfTypeMACVLAN {
+		if config.MACVLANMode == "" {
+			config.MACVLANMode = MACVLANModeBridge
+		}
+		switch config.MACVLANMode {
+		case MACVLANModeBridge, MACVLANModePrivate, MACVLANModeVEPA:
+		default:
+			errs.add(fmt.Errorf("invalid macvlanMode %s, must be %s, %s or %s",
+				config.MACVLANMode, MACVLANModeBridge, MACVLANModePrivate, MACVLANModeVEPA))
+		}
+	} else if config.MACVLANMode != "" {
+		errs.add(fmt.Errorf("macvlanMode is supported only with interfaceType %s", IfTypeMACVLAN))
+	}
+
+	// Validate the VLAN protocol.
+	switch config.VlanProtocol {
+	case VlanProtocol8021Q, VlanProtocol8021AD:
+	default:
+		errs.add(fmt.Errorf("invalid vlanProtocol %s", config.VlanProtocol))
+	}
+
+	// Validate the sysctls. Only network sysctls are namespaced, so nothing else is allowed.
+	for key := range config.Sysctls {
+		if !isAllowedSysctl(key) {
+			errs.add(fmt.Errorf("invalid sysctl %s, must be a %s* sysctl", key, allowedSysctlPrefix))
+		}
+	}
+
+	// Validate the offload feature names.
+	for name := range config.Offloads {
+		if !isKnownOffload(name) {
+			errs.add(fmt.Errorf("invalid offload %s, must be one of %s", name, strings.Join(offloads, ", ")))
+		}
+	}
+
+	// Validate the interface name.
+	if len(config.InterfaceName) > maxInterfaceNameLength {
+		errs.add(fmt.Errorf("invalid interfaceName %s, must be at most %d characters",
+			config.InterfaceName, maxInterfaceNameLength))
+	}
+
+	// Validate the instance metadata blocking method.
+	switch config.BlockIMDSMethod {
+	case imds.BlockMethodRoute, imds.BlockMethodIPTables, imds.BlockMethodAuto:
+	default:
+		errs.add(fmt.Errorf("invalid blockInstanceMetadataMethod %s", config.BlockIMDSMethod))
+	}
+
+	// Validate the backend installing the iptables rules.
+	switch config.FirewallBackend {
+	case firewall.BackendAuto, firewall.BackendIPTables, firewall.BackendNFTables:
+	default:
+		errs.add(fmt.Errorf("invalid firewallBackend %s, must be %s, %s or %s", config.FirewallBackend,
+			firewall.BackendAuto, firewall.BackendIPTables, firewall.BackendNFTables))
+	}
+
 	// Validate if all the required fields are present.
-	if config.TrunkName == "" && config.TrunkMACAddress == "" {
-		return nil, fmt.Errorf("missing required parameter trunkName or trunkMACAddress")
+	// Exactly one of the trunk identifiers must be specified.
+	// Multiple trunk names or MAC addresses can be specified for failover.
+	trunkIDCount := 0
+	for _, trunkIDs := range [][]string{config.TrunkName, config.TrunkMACAddress, {config.TrunkPCIAddress}} {
+		if len(trunkIDs) != 0 && trunkIDs[0] != "" {
+			trunkIDCount++
+		}
+	}
+	if config.TrunkInterfaceIndex != nil {
+		trunkIDCount++
 	}
-	if config.BranchVlanID == "" {
-		return nil, fmt.Errorf("missing required parameter branchVlanID")
+
+	// An adopted interface is an existing device moved into the container's netns in place of
+	// a VLAN link created on a trunk, so none of the VLAN parameters apply to it.
+	if config.AdoptInterface != "" {
+		if config.InterfaceType != IfTypeVLAN {
+			errs.add(fmt.Errorf("adoptInterface is supported only with interfaceType %s", IfTypeVLAN))
+		}
+		for _, param := range getAdoptInterfaceConflicts(&config, trunkIDCount) {
+			errs.add(fmt.Errorf("adoptInterface cannot be combined with %s", param))
+		}
+	} else {
+		if trunkIDCount == 0 {
+			errs.add(fmt.Errorf("missing required parameter " + trunkIDParams))
+		}
+		if trunkIDCount > 1 {
+			errs.add(fmt.Errorf("only one of " + trunkIDParams + " can be specified"))
+		}
+		// MACVLAN branches are untagged, so they have no VLAN ID.
+		if config.InterfaceType == IfTypeMACVLAN {
+			if config.BranchVlanID != "" {
+				errs.ignore(config.StrictConfig, "branchVlanID", string(config.BranchVlanID), config.InterfaceType)
+				config.BranchVlanID = ""
+			}
+		} else if config.BranchVlanID == "" {
+			errs.add(fmt.Errorf("missing required parameter branchVlanID"))
+		}
+		// The branch MAC address can be derived from the branch IP address instead.
+		if config.BranchMACAddress == "" && !config.GenerateMACFromIP {
+			errs.add(fmt.Errorf("missing required parameter branchMACAddress"))
+		}
+	}
+
+	// Branch IP addresses are either specified statically or allocated by an IPAM plugin.
+	if config.IPAM.Type != "" && (config.BranchIPAddress != "" || len(config.BranchIPAddresses) != 0 ||
+		config.BranchIPv6Address != "" || config.BranchIPPrefix != "") {
+		errs.add(fmt.Errorf("ipam cannot be specified along with " +
+			"branchIPAddress, branchIPAddresses, branchIPv6Address or branchIPPrefix"))
+	}
+
+	// DHCP leases are acquired by a client running on the branch link in the container's netns,
+	// so the link must be a branch interface that is already up.
+	if config.IPAM.Type == IPAMTypeDHCP {
+		if config.InterfaceType != IfTypeVLAN && config.InterfaceType != IfTypeMACVLAN {
+			errs.add(fmt.Errorf("ipam type %s is supported only with interfaceType %s or %s",
+				IPAMTypeDHCP, IfTypeVLAN, IfTypeMACVLAN))
+		}
+		if config.BringUpAfterConfig {
+			errs.add(fmt.Errorf("ipam type %s cannot be combined with bringUpAfterConfig", IPAMTypeDHCP))
+		}
+	}
+
+	// Validate the optional MTU. Zero means inherit the trunk's MTU.
+	if config.MTU != 0 && (config.MTU < minMTU || config.MTU > maxMTU) {
+		errs.add(fmt.Errorf("invalid mtu %d, must be between %d and %d", config.MTU, minMTU, maxMTU))
+	}
+
+	// Validate the optional default route metric. Zero means the kernel default.
+	if config.DefaultRouteMetric < 0 {
+		errs.add(fmt.Errorf("invalid defaultRouteMetric %d, must not be negative", config.DefaultRouteMetric))
+	}
+
+	// Validate the optional route table ID. Zero means the main table is used. Table IDs above
+	// the range are reserved for the well-known default, main and local tables.
+	if config.RouteTableID != 0 && (config.RouteTableID < minRouteTableID || config.RouteTableID > maxRouteTableID) {
+		errs.add(fmt.Errorf("invalid routeTableID %d, must be between %d and %d",
+			config.RouteTableID, minRouteTableID, maxRouteTableID))
+	}
+
+	// Validate the optional route protocol, which identifies the routes owned by this plugin.
+	if config.RouteProtocol == 0 {
+		config.RouteProtocol = DefaultRouteProtocol
+	} else if config.RouteProtocol < minRouteProtocol || config.RouteProtocol > maxRouteProtocol {
+		errs.add(fmt.Errorf("invalid routeProtocol %d, must be between %d and %d",
+			config.RouteProtocol, minRouteProtocol, maxRouteProtocol))
+	}
+
+	// Branch IP addresses are assigned by the plugin, and thus probed for duplicates, only on
+	// branch interfaces.
+	if config.DuplicateAddrDetection && config.InterfaceType != IfTypeVLAN && config.InterfaceType != IfTypeMACVLAN {
+		errs.add(fmt.Errorf("duplicateAddressDetection is supported only with interfaceType %s or %s",
+			IfTypeVLAN, IfTypeMACVLAN))
+	}
+
+	// Branch IP addresses are announced only from branch interfaces, which are assigned them.
+	gratuitousARPCount := 0
+	if config.InterfaceType == IfTypeVLAN || config.InterfaceType == IfTypeMACVLAN {
+		gratuitousARPCount = defaultGratuitousARPCount
+	}
+	if config.GratuitousARPCount != nil {
+		gratuitousARPCount = *config.GratuitousARPCount
+		if gratuitousARPCount < 0 {
+			errs.add(fmt.Errorf("invalid gratuitousARPCount %d, must not be negative", gratuitousARPCount))
+		} else if gratuitousARPCount != 0 &&
+			config.InterfaceType != IfTypeVLAN && config.InterfaceType != IfTypeMACVLAN {
+			errs.add(fmt.Errorf("gratuitousARPCount is supported only with interfaceType %s or %s",
+				IfTypeVLAN, IfTypeMACVLAN))
+		}
+	}
+
+	// Only branch interfaces are configured in the container's netns. Duplicate address detection
+	// probes on the link, so it must be up before the addresses are assigned.
+	if config.BringUpAfterConfig {
+		if config.InterfaceType != IfTypeVLAN && config.InterfaceType != IfTypeMACVLAN {
+			errs.add(fmt.Errorf("bringUpAfterConfig is supported only with interfaceType %s or %s",
+				IfTypeVLAN, IfTypeMACVLAN))
+		}
+		if config.DuplicateAddrDetection {
+			errs.add(fmt.Errorf("bringUpAfterConfig cannot be combined with duplicateAddressDetection"))
+		}
 	}
-	if config.BranchMACAddress == "" {
-		return nil, fmt.Errorf("missing required parameter branchMACAddress")
+
+	// The TCP MSS is clamped to the MTU of the interface in the container's netns, so the MTU
+	// must be specified and there must be such an interface.
+	if config.TCPMSSClamp {
+		if config.InterfaceType != IfTypeVLAN {
+			errs.add(fmt.Errorf("tcpMSSClamp is supported only with interfaceType %s", IfTypeVLAN))
+		}
+		if config.MTU == 0 {
+			errs.add(fmt.Errorf("tcpMSSClamp requires mtu to be specified"))
+		}
+	}
+
+	// Inbound connections are blocked on the interface in the container's netns.
+	if config.EgressOnly && config.InterfaceType != IfTypeVLAN {
+		errs.add(fmt.Errorf("egressOnly is supported only with interfaceType %s", IfTypeVLAN))
+	}
+
+	// Outbound packets are marked on the interface in the container's netns.
+	if config.ConnMark != 0 {
+		if config.InterfaceType != IfTypeVLAN {
+			errs.add(fmt.Errorf("connmark is supported only with interfaceType %s", IfTypeVLAN))
+		}
+		if config.ConnMark < 0 || config.ConnMark > math.MaxUint32 {
+			errs.add(fmt.Errorf("invalid connmark %d, must be between 0 and %d", config.ConnMark, uint32(math.MaxUint32)))
+		}
+	}
+
+	// DNS queries are forwarded from the interface in the container's netns to an IPv4 nameserver.
+	// Routes to the nameservers are installed in the main table, which the translated queries
+	// are routed with, as their source is only rewritten to the branch IP address afterwards.
+	if config.DNSStub {
+		if config.InterfaceType != IfTypeVLAN {
+			errs.add(fmt.Errorf("dnsStub is supported only with interfaceType %s", IfTypeVLAN))
+		}
+		if !hasIPv4Nameserver(config.DNS.Nameservers) {
+			errs.add(fmt.Errorf("dnsStub requires an IPv4 address in dns nameservers"))
+		}
+		if config.RouteTableID != 0 {
+			errs.add(fmt.Errorf("dnsStub cannot be combined with routeTableID"))
+		}
+	}
+
+	// Static neighbor entries are programmed on the interface in the container's netns.
+	if len(config.StaticNeighbors) != 0 && config.InterfaceType != IfTypeVLAN {
+		errs.add(fmt.Errorf("staticNeighbors is supported only with interfaceType %s", IfTypeVLAN))
 	}
 
-	// Under TAP mode, UID and GID are required to set TAP ownership.
-	if config.InterfaceType == IfTypeTAP {
+	// Gateways are pinged from the interface in the container's netns.
+	if config.VerifyGatewayReachable && config.InterfaceType != IfTypeVLAN {
+		errs.add(fmt.Errorf("verifyGatewayReachable is supported only with interfaceType %s", IfTypeVLAN))
+	}
+
+	// Hairpinning loops traffic back on the interface in the container's netns, or on the TAP
+	// link connected to the bridge, which externally managed TAP devices are not yet.
+	if config.Hairpin {
+		if config.InterfaceType == IfTypeMACVTAP {
+			errs.add(fmt.Errorf("hairpin is supported only with interfaceType %s, %s or %s",
+				IfTypeVLAN, IfTypeMACVLAN, IfTypeTAP))
+		}
+		if config.TAPExternallyManaged {
+			errs.add(fmt.Errorf("hairpin cannot be combined with tapDeviceExternallyManaged"))
+		}
+		// Branch interfaces hairpin via the loopback link, which must be brought up.
+		if !configureLoopback && config.InterfaceType != IfTypeTAP {
+			errs.add(fmt.Errorf("hairpin requires configureLoopback with interfaceType %s", config.InterfaceType))
+		}
+	}
+
+	// vhost-net accelerates only the queues of TAP interfaces.
+	if config.VhostNet && config.InterfaceType != IfTypeTAP {
+		errs.add(fmt.Errorf("vhostNet is supported only with interfaceType %s", IfTypeTAP))
+	}
+
+	// Externally managed TAP devices are created by the VMM, which still needs the owner UID
+	// and GID, so they are required as usual.
+	if config.TAPExternallyManaged && config.InterfaceType != IfTypeTAP {
+		errs.add(fmt.Errorf("tapDeviceExternallyManaged is supported only with interfaceType %s", IfTypeTAP))
+	}
+
+	// Validate the optional DNS nameservers.
+	for _, nameserver := range config.DNS.Nameservers {
+		if net.ParseIP(nameserver) == nil {
+			errs.add(fmt.Errorf("invalid dns nameserver %s", nameserver))
+		}
+	}
+
+	// Under TAP and MACVTAP modes, UID and GID are required to set TAP ownership. Other modes
+	// have no TAP interface to own, so they ignore them.
+	if config.InterfaceType == IfTypeTAP || config.InterfaceType == IfTypeMACVTAP {
 		if config.Uid == "" {
-			return nil, fmt.Errorf("missing required parameter uid")
+			errs.add(fmt.Errorf("missing required parameter uid"))
 		}
 		if config.Gid == "" {
-			return nil, fmt.Errorf("missing required parameter gid")
+			errs.add(fmt.Errorf("missing required parameter gid"))
+		}
+	} else {
+		if config.Uid != "" {
+			errs.ignore(config.StrictConfig, "uid", string(config.Uid), config.InterfaceType)
+		}
+		if config.Gid != "" {
+			errs.ignore(config.StrictConfig, "gid", string(config.Gid), config.InterfaceType)
 		}
 	}
 
 	// Populate NetConfig.
 	netConfig := NetConfig{
-		NetConf:       config.NetConf,
-		TrunkName:     config.TrunkName,
-		BlockIMDS:     config.BlockIMDS,
-		InterfaceType: config.InterfaceType,
+		NetConf:                config.NetConf,
+		TrunkNames:             config.TrunkName,
+		TrunkPromiscuous:       config.TrunkPromiscuous,
+		BringUpTrunk:           config.BringUpTrunk,
+		VlanProtocol:           config.VlanProtocol,
+		MACVLANMode:            config.MACVLANMode,
+		MTU:                    int(config.MTU),
+		DefaultRouteMetric:     config.DefaultRouteMetric,
+		InstallDefaultRoute:    installDefaultRoute,
+		DefaultRouteSource:     defaultRouteSource,
+		DuplicateAddrDetection: config.DuplicateAddrDetection,
+		GratuitousARPCount:     gratuitousARPCount,
+		BringUpAfterConfig:     config.BringUpAfterConfig,
+		TCPMSSClamp:            config.TCPMSSClamp,
+		EgressOnly:             config.EgressOnly,
+		NoPrefixRoute:          config.NoPrefixRoute,
+		ConnMark:               uint32(config.ConnMark),
+		DNSStub:                config.DNSStub,
+		RouteTableID:           config.RouteTableID,
+		RouteProtocol:          config.RouteProtocol,
+		BlockIMDS:              config.BlockIMDS,
+		BlockIMDSMethod:        config.BlockIMDSMethod,
+		FirewallBackend:        config.FirewallBackend,
+		ProxyARP:               config.ProxyARP,
+		VerifyGatewayReachable: config.VerifyGatewayReachable,
+		DisableRPFilter:        config.DisableRPFilter,
+		DisableIPv6Autoconf:    config.DisableIPv6Autoconf,
+		DisableICMPRedirects:   config.DisableICMPRedirects,
+		Hairpin:                config.Hairpin,
+		ConfigureLoopback:      configureLoopback,
+		Sysctls:                config.Sysctls,
+		Offloads:               config.Offloads,
+		InterfaceType:          config.InterfaceType,
+		InterfaceName:          config.InterfaceName,
 	}
 
-	// Parse the trunk MAC address.
-	if config.TrunkMACAddress != "" {
-		netConfig.TrunkMACAddress, err = net.ParseMAC(config.TrunkMACAddress)
-		if err != nil {
-			return nil, fmt.Errorf("invalid trunkMACAddress %s", config.TrunkMACAddress)
+	// The first trunk name is the primary one.
+	for _, trunkName := range config.TrunkName {
+		if trunkName == "" {
+			errs.add(fmt.Errorf("invalid trunkName, must not be empty"))
+		}
+	}
+	if len(netConfig.TrunkNames) != 0 {
+		netConfig.TrunkName = netConfig.TrunkNames[0]
+	}
+
+	// Parse the adopted interface, which is identified either by its MAC address or by its name.
+	// The branch MAC address is that of the adopted interface.
+	if config.AdoptInterface != "" {
+		macAddress, err := net.ParseMAC(config.AdoptInterface)
+		if err == nil {
+			if !vpc.IsUnicastMACAddress(macAddress) {
+				errs.add(fmt.Errorf("invalid adoptInterface %s, must be a non-zero unicast address",
+					config.AdoptInterface))
+			}
+			netConfig.AdoptInterfaceMAC = macAddress
+			netConfig.BranchMACAddress = macAddress
+		} else {
+			if len(config.AdoptInterface) > maxInterfaceNameLength {
+				errs.add(fmt.Errorf("invalid adoptInterface %s, must be at most %d characters",
+					config.AdoptInterface, maxInterfaceNameLength))
+			}
+			netConfig.AdoptInterfaceName = config.AdoptInterface
+		}
+	}
+
+	// Parse the trunk MAC addresses. The first one is the primary one.
+	for _, trunkMACAddress := range config.TrunkMACAddress {
+		macAddress, err := net.ParseMAC(trunkMACAddress)
+		if err != nil || !vpc.IsUnicastMACAddress(macAddress) {
+			errs.add(fmt.Errorf("invalid trunkMACAddress %s, must be a non-zero unicast address",
+				trunkMACAddress))
+		}
+		netConfig.TrunkMACAddresses = append(netConfig.TrunkMACAddresses, macAddress)
+	}
+	if len(netConfig.TrunkMACAddresses) != 0 {
+		netConfig.TrunkMACAddress = netConfig.TrunkMACAddresses[0]
+	}
+
+	// Validate the trunk device index, under which IMDS reports the MAC address of the trunk ENI.
+	if config.TrunkInterfaceIndex != nil {
+		if *config.TrunkInterfaceIndex < 0 {
+			errs.add(fmt.Errorf("invalid trunkInterfaceIndex %d, must not be negative", *config.TrunkInterfaceIndex))
 		}
+		netConfig.TrunkInterfaceIndex = config.TrunkInterfaceIndex
+	}
+
+	// Validate the trunk PCI address.
+	if config.TrunkPCIAddress != "" {
+		if !pciAddressRegex.MatchString(config.TrunkPCIAddress) {
+			errs.add(fmt.Errorf("invalid trunkPCIAddress %s, must be in domain:bus:device.function format",
+				config.TrunkPCIAddress))
+		}
+		netConfig.TrunkPCIAddress = strings.ToLower(config.TrunkPCIAddress)
 	}
 
 	// Parse the branch VLAN ID.
-	netConfig.BranchVlanID, err = strconv.Atoi(config.BranchVlanID)
-	if err != nil {
-		return nil, fmt.Errorf("invalid branchVlanID %s", config.BranchVlanID)
+	if config.BranchVlanID != "" {
+		netConfig.BranchVlanID, err = strconv.Atoi(string(config.BranchVlanID))
+		if err != nil {
+			errs.add(fmt.Errorf("invalid branchVlanID %s", config.BranchVlanID))
+		} else if netConfig.BranchVlanID < minVlanID || netConfig.BranchVlanID > maxVlanID {
+			errs.add(fmt.Errorf("invalid branchVlanID %d, must be between %d and %d",
+				netConfig.BranchVlanID, minVlanID, maxVlanID))
+		}
 	}
 
-	// Parse the branch MAC address.
-	netConfig.BranchMACAddress, err = net.ParseMAC(config.BranchMACAddress)
+	// Parse the VLAN QoS maps. Egress maps go from skb priority to VLAN priority, and ingress
+	// maps the other way around. MACVLAN branches are untagged, so they have no VLAN priority.
+	if len(config.VlanEgressQoSMap)+len(config.VlanIngressQoSMap) != 0 && config.InterfaceType == IfTypeMACVLAN {
+		errs.add(fmt.Errorf("vlanEgressQoSMap and vlanIngressQoSMap are not supported with interfaceType %s",
+			IfTypeMACVLAN))
+	}
+	netConfig.VlanEgressQoSMap, err = parseVlanQoSMap("vlanEgressQoSMap", config.VlanEgressQoSMap, false)
 	if err != nil {
-		return nil, fmt.Errorf("invalid branchMACAddress %s", config.BranchMACAddress)
+		errs.add(err)
+	}
+	netConfig.VlanIngressQoSMap, err = parseVlanQoSMap("vlanIngressQoSMap", config.VlanIngressQoSMap, true)
+	if err != nil {
+		errs.add(err)
 	}
 
-	// Parse the optional branch IP address.
-	if config.BranchIPAddress != "" {
-		netConfig.BranchIPAddress, err = vpc.GetIPAddressFromString(config.BranchIPAddress)
+	// Expand the optional host interface name template. MACVLAN branches have no VLAN ID.
+	if config.HostIfNameTemplate != "" && config.InterfaceType == IfTypeMACVLAN &&
+		strings.Contains(config.HostIfNameTemplate, hostIfNameVlanTemplate) {
+		errs.add(fmt.Errorf("invalid hostInterfaceNameTemplate %s, %s is not supported with interfaceType %s",
+			config.HostIfNameTemplate, hostIfNameVlanTemplate, IfTypeMACVLAN))
+	} else if config.HostIfNameTemplate != "" &&
+		(netConfig.BranchVlanID != 0 || config.InterfaceType == IfTypeMACVLAN) {
+		netConfig.HostInterfaceName, err = expandHostInterfaceName(
+			config.HostIfNameTemplate, netConfig.BranchVlanID, args.ContainerID)
 		if err != nil {
-			return nil, fmt.Errorf("invalid branchIPAddress %s", config.BranchIPAddress)
+			errs.add(err)
 		}
 	}
 
-	// Parse the TAP interface owner UID and GID.
-	if config.InterfaceType == IfTypeTAP {
-		netConfig.Tap = &TAPConfig{
-			Queues: defaultTapQueues,
+	// Expand the optional state file path template.
+	if config.StateFileTemplate != "" {
+		netConfig.StateFilePath, err = expandStateFilePath(
+			config.StateFileTemplate, args.ContainerID, config.InterfaceName)
+		if err != nil {
+			errs.add(err)
 		}
+	}
 
-		if config.Uid != "" {
-			netConfig.Tap.Uid, err = strconv.Atoi(config.Uid)
-			if err != nil {
-				return nil, fmt.Errorf("invalid uid %s", config.Uid)
-			}
+	// Parse the branch MAC address.
+	if config.BranchMACAddress != "" {
+		netConfig.BranchMACAddress, err = net.ParseMAC(config.BranchMACAddress)
+		if err != nil || !vpc.IsUnicastMACAddress(netConfig.BranchMACAddress) {
+			errs.add(fmt.Errorf("invalid branchMACAddress %s, must be a non-zero unicast address",
+				config.BranchMACAddress))
 		}
+	}
 
-		if config.Gid != "" {
-			netConfig.Tap.Gid, err = strconv.Atoi(config.Gid)
-			if err != nil {
-				return nil, fmt.Errorf("invalid gid %s", config.Gid)
-			}
+	// Parse the optional in-container interface MAC address. It defaults to the branch MAC address.
+	if config.InterfaceMACAddress != "" {
+		netConfig.InterfaceMACAddress, err = net.ParseMAC(config.InterfaceMACAddress)
+		if err != nil || !vpc.IsUnicastMACAddress(netConfig.InterfaceMACAddress) {
+			errs.add(fmt.Errorf("invalid interfaceMACAddress %s, must be a non-zero unicast address",
+				config.InterfaceMACAddress))
 		}
 	}
 
-	// Compute the optional gateway IP address.
-	netConfig.BranchGatewayIPAddress, err =
-		getGatewayIPAddress(netConfig.BranchIPAddress, config.BranchGatewayIPAddress)
+	// Parse the optional branch IP addresses. The fields derived from them are only validated
+	// if they are valid.
+	ipAddressesValid := true
+	netConfig.BranchIPAddress, netConfig.BranchIPAddresses, err =
+		getBranchIPAddresses(config.BranchIPAddress, config.BranchIPAddresses)
 	if err != nil {
-		return nil, err
+		errs.add(err)
+		ipAddressesValid = false
 	}
 
-	// Validation complete. Return the parsed NetConfig object.
-	log.Debugf("Created NetConfig: %+v", netConfig)
-	return &netConfig, nil
-}
-
-func getGatewayIPAddress(ipAddress *net.IPNet, gatewayIPAddressString string) (net.IP, error) {
-	var gatewayIPAddress net.IP
-
-	// If an explicit gateway IP address is provided, use it.
-	if gatewayIPAddressString != "" {
-		gatewayIPAddress = net.ParseIP(gatewayIPAddressString)
-		if gatewayIPAddress == nil {
-			return nil, fmt.Errorf("invalid branchGatewayIPAddress %s", gatewayIPAddressString)
+	// Parse the optional branch IPv6 address.
+	if config.BranchIPv6Address != "" {
+		netConfig.BranchIPv6Address, err = parseBranchIPAddress(config.BranchIPv6Address, nil)
+		if err != nil || netConfig.BranchIPv6Address.IP.To4() != nil {
+			errs.add(fmt.Errorf("invalid branchIPv6Address %s", config.BranchIPv6Address))
+			netConfig.BranchIPv6Address = nil
+			ipAddressesValid = false
 		}
+	}
 
-		return gatewayIPAddress, nil
+	// Parse the optional delegated prefix. The branch is assigned the first usable address in the
+	// prefix, in place of a branch IP address of the same family.
+	if config.BranchIPPrefix != "" {
+		netConfig.BranchIPPrefix, err = parseBranchIPPrefix(config.BranchIPPrefix)
+		if err != nil {
+			errs.add(err)
+			ipAddressesValid = false
+		} else {
+			address := getPrefixAddress(netConfig.BranchIPPrefix)
+			if address.IP.To4() != nil {
+				if len(netConfig.BranchIPAddresses) != 0 {
+					errs.add(fmt.Errorf("branchIPPrefix %s cannot be combined with branchIPAddress or branchIPAddresses",
+						config.BranchIPPrefix))
+					ipAddressesValid = false
+				}
+				netConfig.BranchIPAddress = address
+				netConfig.BranchIPAddresses = []net.IPNet{*address}
+			} else {
+				if netConfig.BranchIPv6Address != nil {
+					errs.add(fmt.Errorf("branchIPPrefix %s cannot be combined with branchIPv6Address",
+						config.BranchIPPrefix))
+					ipAddressesValid = false
+				}
+				netConfig.BranchIPv6Address = address
+			}
+		}
 	}
 
-	// If neither gateway IP address nor container IP address is provided, cannot compute a gateway
-	// IP address, so just leave it as nil.
-	if ipAddress == nil {
-		return nil, nil
+	// Parse the optional scope of the branch IP addresses, which is global by default.
+	switch scope := strings.ToLower(config.AddressScope); scope {
+	case "":
+		netConfig.AddressScope = AddressScopeGlobal
+	case AddressScopeGlobal, AddressScopeLink, AddressScopeHost:
+		netConfig.AddressScope = scope
+	default:
+		errs.add(fmt.Errorf("invalid addressScope %s, must be %s, %s or %s",
+			config.AddressScope, AddressScopeGlobal, AddressScopeLink, AddressScopeHost))
 	}
 
-	// Otherwise, infer the gateway IP address from the subnet that the container IP address is in.
-	subnet, err := vpc.NewSubnet(vpc.GetSubnetPrefix(ipAddress))
-	if err != nil {
-		log.Errorf("Failed to parse VPC subnet for %s: %v.", ipAddress, err)
+	// Parse the optional management IP address. It is assigned alongside the branch IP addresses,
+	// so it is supported only on branch interfaces and must be distinct from them.
+	config.ManagementIPScope = strings.ToLower(config.ManagementIPScope)
+	if config.ManagementIPAddress != "" {
+		if config.InterfaceType != IfTypeVLAN && config.InterfaceType != IfTypeMACVLAN {
+			errs.add(fmt.Errorf("managementIPAddress is supported only with interfaceType %s or %s",
+				IfTypeVLAN, IfTypeMACVLAN))
+		}
+
+		netConfig.ManagementIPAddress, err = parseManagementIPAddress(config.ManagementIPAddress)
+		if err != nil {
+			errs.add(err)
+		} else if ipAddressesValid {
+			branchIPAddresses := append([]net.IPNet{}, netConfig.BranchIPAddresses...)
+			if netConfig.BranchIPv6Address != nil {
+				branchIPAddresses = append(branchIPAddresses, *netConfig.BranchIPv6Address)
+			}
+			for _, ipAddress := range branchIPAddresses {
+				if ipAddress.IP.Equal(netConfig.ManagementIPAddress.IP) {
+					errs.add(fmt.Errorf("managementIPAddress %s must be distinct from branch IP address %s",
+						config.ManagementIPAddress, ipAddress.String()))
+				}
+			}
+		}
+
+		switch config.ManagementIPScope {
+		case "":
+			netConfig.ManagementIPScope = ManagementIPScopeLink
+		case ManagementIPScopeHost, ManagementIPScopeLink:
+			netConfig.ManagementIPScope = config.ManagementIPScope
+		default:
+			errs.add(fmt.Errorf("invalid managementIPAddressScope %s, must be %s or %s",
+				config.ManagementIPScope, ManagementIPScopeHost, ManagementIPScopeLink))
+		}
+	} else if config.ManagementIPScope != "" {
+		errs.add(fmt.Errorf("managementIPAddressScope requires managementIPAddress"))
+	}
+
+	// Derive the branch MAC address from the branch IP address if no explicit one is specified,
+	// so that it stays the same across reboots instead of being randomized by the kernel.
+	if config.BranchMACAddress == "" && config.GenerateMACFromIP && ipAddressesValid {
+		netConfig.BranchMACAddress, err = getGeneratedMACAddress(&netConfig)
+		if err != nil {
+			errs.add(err)
+		}
+	}
+
+	// Parse the optional static routes.
+	if ipAddressesValid {
+		netConfig.Routes, err = getRoutes(config.Routes, netConfig.BranchIPAddresses, netConfig.BranchIPv6Address)
+		if err != nil {
+			errs.add(err)
+		}
+
+		// The rest of the delegated prefix is reachable on-link.
+		if netConfig.BranchIPPrefix != nil {
+			netConfig.Routes = append(netConfig.Routes, cniTypes.Route{Dst: *netConfig.BranchIPPrefix})
+		}
+	}
+
+	// Parse the optional static neighbor entries.
+	netConfig.StaticNeighbors, err = getStaticNeighbors(config.StaticNeighbors)
+	if err != nil {
+		errs.add(err)
+	}
+
+	// Parse the optional bandwidth limits.
+	if config.IngressBandwidthLimit != "" {
+		netConfig.IngressBandwidthLimit, err = parseBandwidth(config.IngressBandwidthLimit)
+		if err != nil {
+			errs.add(fmt.Errorf("invalid ingressBandwidthLimit %s, must be a positive rate in bits/sec",
+				config.IngressBandwidthLimit))
+		}
+	}
+
+	if config.EgressBandwidthLimit != "" {
+		netConfig.EgressBandwidthLimit, err = parseBandwidth(config.EgressBandwidthLimit)
+		if err != nil {
+			errs.add(fmt.Errorf("invalid egressBandwidthLimit %s, must be a positive rate in bits/sec",
+				config.EgressBandwidthLimit))
+		}
+	}
+
+	// Validate the optional eBPF tc program, which is either pinned or in an object file. It is
+	// attached to the clsact qdisc, which takes the place of the ingress qdisc shaping ingress traffic.
+	// The program is only loaded, and required to exist, on ADD, so that DEL can detach it after
+	// the file is removed.
+	if config.TCBPFProgram != "" {
+		if netConfig.IngressBandwidthLimit != 0 {
+			errs.add(fmt.Errorf("tcBPFProgram cannot be combined with ingressBandwidthLimit"))
+		}
+		netConfig.TCBPFProgram = config.TCBPFProgram
+	}
+
+	// Parse the TAP interface owner UID and GID.
+	if config.InterfaceType == IfTypeTAP || config.InterfaceType == IfTypeMACVTAP {
+		netConfig.Tap = &TAPConfig{
+			Queues:            defaultTapQueues,
+			VhostNet:          config.VhostNet,
+			ExternallyManaged: config.TAPExternallyManaged,
+		}
+
+		// The owner is needed only to create and check TAP links. DEL ignores names that no
+		// longer resolve, e.g. because the user was removed after ADD.
+		ignoreOwnerErrors := os.Getenv(envCNICommand) == "DEL"
+
+		if config.Uid != "" {
+			netConfig.Tap.Uid, err = lookupUID(string(config.Uid))
+			if err != nil {
+				if ignoreOwnerErrors {
+					log.Infof("Ignoring invalid uid %s on DEL: %v.", config.Uid, err)
+				} else {
+					errs.add(fmt.Errorf("invalid uid %s: %v", config.Uid, err))
+				}
+			}
+		}
+
+		if config.Gid != "" {
+			netConfig.Tap.Gid, err = lookupGID(string(config.Gid))
+			if err != nil {
+				if ignoreOwnerErrors {
+					log.Infof("Ignoring invalid gid %s on DEL: %v.", config.Gid, err)
+				} else {
+					errs.add(fmt.Errorf("invalid gid %s: %v", config.Gid, err))
+				}
+			}
+		}
+
+		// Multi-queue devices are supported only with TAP interfaces.
+		if config.InterfaceType == IfTypeTAP && config.TapQueues != 0 {
+			if config.TapQueues < 1 || config.TapQueues > maxTapQueues {
+				errs.add(fmt.Errorf("invalid tapQueues %d, must be between 1 and %d",
+					config.TapQueues, maxTapQueues))
+			}
+			netConfig.Tap.Queues = config.TapQueues
+		}
+	}
+
+	if ipAddressesValid {
+		// Compute the optional gateways of each address family independently.
+		gatewaysValid := true
+		err = setIPv4Gateways(&config, &netConfig)
+		if err != nil {
+			errs.add(err)
+			gatewaysValid = false
+		}
+
+		err = setIPv6Gateway(&config, &netConfig)
+		if err != nil {
+			errs.add(err)
+			gatewaysValid = false
+		}
+
+		// Proxy ARP and NDP are only meaningful for the gateways of the branch.
+		if gatewaysValid && netConfig.ProxyARP &&
+			netConfig.BranchGatewayIPAddress == nil && netConfig.BranchGatewayIPv6Address == nil {
+			errs.add(fmt.Errorf("proxyARP requires a branch gateway IP address"))
+		}
+
+		// The gateways allocated by IPAM are known only once the plugin runs.
+		if gatewaysValid && netConfig.VerifyGatewayReachable && config.IPAM.Type == "" &&
+			netConfig.BranchGatewayIPAddress == nil && netConfig.BranchGatewayIPv6Address == nil {
+			errs.add(fmt.Errorf("verifyGatewayReachable requires a branch gateway IP address"))
+		}
+	}
+
+	if err = errs.err(); err != nil {
+		return nil, err
+	}
+
+	// Validation complete. Return the parsed NetConfig object.
+	log.Debugf("Created NetConfig: %+v", netConfig)
+	return &netConfig, nil
+}
+
+// validationErrors accumulates the problems found while validating a network configuration.
+type validationErrors []error
+
+// add records a problem. Each problem names the offending field.
+func (errs *validationErrors) add(err error) {
+	*errs = append(*errs, err)
+}
+
+// ignore records a parameter that has no effect with the given interface type. In strict mode,
+// this is a problem. Otherwise, the parameter is ignored with a warning.
+func (errs *validationErrors) ignore(strict bool, name string, value string, interfaceType string) {
+	if strict {
+		errs.add(fmt.Errorf("%s is not supported with interfaceType %s", name, interfaceType))
+		return
+	}
+
+	logger.Warnf("Ignoring %s %s with interfaceType %s.", name, value, interfaceType)
+}
+
+// err returns a single error listing all recorded problems, or nil if there are none.
+// A single problem is returned as is.
+func (errs validationErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%d problems in network config: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// hasIPv4Nameserver returns whether any of the given nameservers is an IPv4 address.
+func hasIPv4Nameserver(nameservers []string) bool {
+	for _, nameserver := range nameservers {
+		if ipAddress := net.ParseIP(nameserver); ipAddress != nil && ipAddress.To4() != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getAdoptInterfaceConflicts returns the parameters set in the given network configuration that
+// apply only to VLAN links created on a trunk, and thus conflict with adoptInterface.
+func getAdoptInterfaceConflicts(config *netConfigJSON, trunkIDCount int) []string {
+	var params []string
+	if trunkIDCount != 0 {
+		params = append(params, trunkIDParams)
+	}
+	if config.BranchVlanID != "" {
+		params = append(params, "branchVlanID")
+	}
+	if config.BranchMACAddress != "" || config.GenerateMACFromIP {
+		params = append(params, "branchMACAddress or generateMACFromIP")
+	}
+	if len(config.VlanEgressQoSMap)+len(config.VlanIngressQoSMap) != 0 {
+		params = append(params, "vlanEgressQoSMap or vlanIngressQoSMap")
+	}
+	if config.HostIfNameTemplate != "" {
+		params = append(params, "hostInterfaceNameTemplate")
+	}
+	if config.ProxyARP {
+		params = append(params, "proxyARP")
+	}
+	if config.TrunkPromiscuous {
+		params = append(params, "trunkPromiscuous")
+	}
+	if config.BringUpTrunk {
+		params = append(params, "bringUpTrunk")
+	}
+
+	return params
+}
+
+// expandHostInterfaceName returns the name of the branch link in the host netns generated from
+// the given template, which must fit in a Linux interface name.
+func expandHostInterfaceName(template string, vlanID int, containerID string) (string, error) {
+	name := strings.Replace(template, hostIfNameVlanTemplate, strconv.Itoa(vlanID), -1)
+
+	if strings.Contains(name, hostIfNameContainerIDTemplate) {
+		if containerID == "" {
+			return "", fmt.Errorf("invalid hostInterfaceNameTemplate %s, container ID is not known", template)
+		}
+		if len(containerID) > hostIfNameContainerIDLength {
+			containerID = containerID[:hostIfNameContainerIDLength]
+		}
+		name = strings.Replace(name, hostIfNameContainerIDTemplate, containerID, -1)
+	}
+
+	if strings.ContainsAny(name, "{}/ \t\n") {
+		return "", fmt.Errorf("invalid hostInterfaceNameTemplate %s, must contain only %s and %s templates",
+			template, hostIfNameVlanTemplate, hostIfNameContainerIDTemplate)
+	}
+
+	if len(name) > maxInterfaceNameLength {
+		return "", fmt.Errorf("invalid hostInterfaceNameTemplate %s, expands to %s longer than %d characters",
+			template, name, maxInterfaceNameLength)
+	}
+
+	return name, nil
+}
+
+// offloads lists the offload features that can be toggled on the branch interface.
+var offloads = []string{
+	OffloadRXChecksum,
+	OffloadTXChecksum,
+	OffloadSG,
+	OffloadTSO,
+	OffloadGSO,
+	OffloadGRO,
+}
+
+// isKnownOffload returns whether the offload feature with the given name can be toggled.
+func isKnownOffload(name string) bool {
+	for _, offload := range offloads {
+		if name == offload {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedSysctl returns whether the sysctl with the given key can be set in the target netns.
+// Keys are rejected if they could resolve to a path outside of the allowed sysctl tree.
+func isAllowedSysctl(key string) bool {
+	if !strings.HasPrefix(key, allowedSysctlPrefix) || strings.Contains(key, "/") {
+		return false
+	}
+
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizePCArgs rewrites the keys in per-container arguments to their canonical pcArgs field
+// names by matching them case-insensitively. Keys that already match a field name exactly take
+// precedence over keys that differ only in case. Unknown keys are passed through unchanged.
+func normalizePCArgs(args string) string {
+	// Build the set of canonical key names, including those of embedded structs.
+	canonicalKeys := make(map[string]string)
+	pcArgsType := reflect.TypeOf(pcArgs{})
+	for i := 0; i < pcArgsType.NumField(); i++ {
+		field := pcArgsType.Field(i)
+		if field.Anonymous {
+			for j := 0; j < field.Type.NumField(); j++ {
+				name := field.Type.Field(j).Name
+				canonicalKeys[strings.ToLower(name)] = name
+			}
+			continue
+		}
+		canonicalKeys[strings.ToLower(field.Name)] = field.Name
+	}
+
+	pairs := strings.Split(args, ";")
+
+	// Find the keys that are specified with their exact canonical names.
+	exactKeys := make(map[string]bool)
+	for _, pair := range pairs {
+		key := strings.SplitN(pair, "=", 2)[0]
+		if canonicalKeys[strings.ToLower(key)] == key {
+			exactKeys[key] = true
+		}
+	}
+
+	var normalizedPairs []string
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if key, ok := canonicalKeys[strings.ToLower(kv[0])]; ok && key != kv[0] {
+			if exactKeys[key] {
+				// Prefer the exact match.
+				continue
+			}
+			kv[0] = key
+		}
+		normalizedPairs = append(normalizedPairs, strings.Join(kv, "="))
+	}
+
+	return strings.Join(normalizedPairs, ";")
+}
+
+// getBranchIPAddresses parses the branch IP addresses and returns the primary address along with
+// the full set of addresses. The singular branchIPAddress is an alias for a single-element list.
+// If both are specified, the singular address must be one of the listed addresses.
+// Addresses without a prefix length are completed as described in parseBranchIPAddress, using
+// the subnets of the listed addresses that have one.
+func getBranchIPAddresses(ipAddressString string, ipAddressStrings []string) (*net.IPNet, []net.IPNet, error) {
+	var primary *net.IPNet
+	var addresses []net.IPNet
+
+	var subnets []net.IPNet
+	for _, s := range ipAddressStrings {
+		if address, err := vpc.GetIPAddressFromString(strings.TrimSpace(s)); err == nil {
+			subnets = append(subnets, *address)
+		}
+	}
+
+	for _, s := range ipAddressStrings {
+		address, err := parseBranchIPAddress(strings.TrimSpace(s), subnets)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid branchIPAddresses entry %s", s)
+		}
+		addresses = append(addresses, *address)
+	}
+
+	if ipAddressString != "" {
+		address, err := parseBranchIPAddress(ipAddressString, subnets)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid branchIPAddress %s", ipAddressString)
+		}
+
+		if len(addresses) == 0 {
+			addresses = []net.IPNet{*address}
+		} else if !containsIPAddress(addresses, address) {
+			return nil, nil, fmt.Errorf(
+				"branchIPAddress %s does not match any of branchIPAddresses %v",
+				ipAddressString, ipAddressStrings)
+		}
+
+		primary = address
+	} else if len(addresses) != 0 {
+		primary = &net.IPNet{IP: addresses[0].IP, Mask: addresses[0].Mask}
+	}
+
+	return primary, addresses, nil
+}
+
+// parseBranchIPAddress parses a branch IP address in CIDR notation. For callers that omit the
+// prefix length, a bare IP address is also accepted and given the mask of the first of the given
+// subnets that contains it. If none does, it is given a host prefix (/32 or /128), in which case
+// the gateway cannot be derived and must be specified explicitly.
+func parseBranchIPAddress(ipAddressString string, subnets []net.IPNet) (*net.IPNet, error) {
+	if strings.Contains(ipAddressString, "/") {
+		return vpc.GetIPAddressFromString(ipAddressString)
+	}
+
+	ip := net.ParseIP(ipAddressString)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %s", ipAddressString)
+	}
+
+	address := &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+	if ip.To4() != nil {
+		address.Mask = net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)
+	}
+
+	for _, subnet := range subnets {
+		if len(subnet.Mask) == len(address.Mask) && subnet.Contains(ip) {
+			address.Mask = subnet.Mask
+			break
+		}
+	}
+
+	logger.Warnf("Branch IP address %s has no prefix length, assuming %s.", ipAddressString, address)
+	return address, nil
+}
+
+// parseVlanQoSMap parses the VLAN QoS map with the given name. The VLAN priorities are the keys
+// of ingress maps and the values of egress maps, and must be valid 802.1p priorities.
+func parseVlanQoSMap(name string, qosMap map[string]uint32, ingress bool) (map[uint32]uint32, error) {
+	if len(qosMap) == 0 {
+		return nil, nil
+	}
+
+	parsed := make(map[uint32]uint32, len(qosMap))
+	for fromString, to := range qosMap {
+		from, err := strconv.ParseUint(fromString, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s priority %s", name, fromString)
+		}
+
+		vlanPriority := to
+		if ingress {
+			vlanPriority = uint32(from)
+		}
+		if vlanPriority > maxVlanPriority {
+			return nil, fmt.Errorf("invalid %s mapping %s:%d, VLAN priority must be between 0 and %d",
+				name, fromString, to, maxVlanPriority)
+		}
+
+		parsed[uint32(from)] = to
+	}
+
+	return parsed, nil
+}
+
+// expandStateFilePath returns the path of the state file of the given container interface
+// generated from the given template. Each container must have its own state file.
+func expandStateFilePath(template string, containerID string, ifName string) (string, error) {
+	if !filepath.IsAbs(template) || !strings.Contains(template, stateFileContainerIDTemplate) {
+		return "", fmt.Errorf("invalid stateFile %s, must be an absolute path containing %s",
+			template, stateFileContainerIDTemplate)
+	}
+
+	if containerID == "" || strings.Contains(containerID, "/") {
+		return "", fmt.Errorf("invalid stateFile %s, container ID %s is not valid in a path",
+			template, containerID)
+	}
+
+	path := strings.Replace(template, stateFileContainerIDTemplate, containerID, -1)
+	path = strings.Replace(path, stateFileIfNameTemplate, ifName, -1)
+
+	return filepath.Clean(path), nil
+}
+
+// parseManagementIPAddress parses a management IP address in CIDR notation. A bare IP address
+// is given a host prefix (/32 or /128).
+func parseManagementIPAddress(ipAddressString string) (*net.IPNet, error) {
+	if strings.Contains(ipAddressString, "/") {
+		address, err := vpc.GetIPAddressFromString(ipAddressString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid managementIPAddress %s", ipAddressString)
+		}
+		return address, nil
+	}
+
+	ip := net.ParseIP(ipAddressString)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid managementIPAddress %s", ipAddressString)
+	}
+
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// getGeneratedMACAddress returns the branch MAC address derived from the primary branch IPv4
+// address, or from the branch IPv6 address on IPv6-only branches.
+func getGeneratedMACAddress(netConfig *NetConfig) (net.HardwareAddr, error) {
+	var ipAddress net.IP
+	if netConfig.BranchIPAddress != nil {
+		ipAddress = netConfig.BranchIPAddress.IP
+	} else if netConfig.BranchIPv6Address != nil {
+		ipAddress = netConfig.BranchIPv6Address.IP
+	} else {
+		return nil, fmt.Errorf("generateMACFromIP requires branchIPAddress, branchIPv6Address or branchIPPrefix")
+	}
+
+	macAddress := vpc.GenerateMACAddress(ipAddress)
+	if !vpc.IsUnicastMACAddress(macAddress) {
+		return nil, fmt.Errorf("invalid MAC address %s generated from IP address %s", macAddress, ipAddress)
+	}
+
+	log.Infof("Generated branch MAC address %s from IP address %s.", macAddress, ipAddress)
+	return macAddress, nil
+}
+
+// parseBranchIPPrefix parses a prefix delegated to the branch ENI. AWS delegates IPv4 /28 and
+// IPv6 /80 prefixes.
+func parseBranchIPPrefix(prefixString string) (*net.IPNet, error) {
+	ip, prefix, err := net.ParseCIDR(prefixString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid branchIPPrefix %s", prefixString)
+	}
+
+	prefixLength, _ := prefix.Mask.Size()
+	if (ip.To4() != nil && prefixLength != ipv4PrefixLength) || (ip.To4() == nil && prefixLength != ipv6PrefixLength) {
+		return nil, fmt.Errorf("invalid branchIPPrefix %s, must be an IPv4 /%d or IPv6 /%d prefix",
+			prefixString, ipv4PrefixLength, ipv6PrefixLength)
+	}
+	if !ip.Equal(prefix.IP) {
+		return nil, fmt.Errorf("invalid branchIPPrefix %s, must not have host bits set", prefixString)
+	}
+
+	return prefix, nil
+}
+
+// getPrefixAddress returns the first usable address in the given prefix, as a host address.
+func getPrefixAddress(prefix *net.IPNet) *net.IPNet {
+	ip := make(net.IP, len(prefix.IP))
+	copy(ip, prefix.IP)
+	ip[len(ip)-1]++
+
+	bits := 8 * len(prefix.Mask)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// containsIPAddress returns whether the list of addresses contains the given address.
+func containsIPAddress(addresses []net.IPNet, address *net.IPNet) bool {
+	for _, a := range addresses {
+		if a.IP.Equal(address.IP) && a.Mask.String() == address.Mask.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseBandwidth parses a bandwidth rate in bits/sec. The rate can have an optional decimal
+// suffix k, m, g or t, e.g. "100m" for 100 megabits/sec.
+func parseBandwidth(rate string) (uint64, error) {
+	s := strings.ToLower(strings.TrimSpace(rate))
+	multiplier := uint64(1)
+
+	if len(s) > 0 {
+		if i := strings.IndexByte(bandwidthSuffixes, s[len(s)-1]); i >= 0 {
+			for ; i >= 0; i-- {
+				multiplier *= bandwidthMultiplier
+			}
+			s = s[:len(s)-1]
+		}
+	}
+
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == 0 {
+		return 0, fmt.Errorf("bandwidth must be positive")
+	}
+
+	if value > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("bandwidth is out of range")
+	}
+
+	return value * multiplier, nil
+}
+
+// mergeRoutes merges on-link routes to the given destinations into the list of routes.
+// A destination that is already in the list replaces the existing route.
+func mergeRoutes(routes []routeJSON, dsts []string) []routeJSON {
+	for _, dst := range dsts {
+		route := routeJSON{Dst: strings.TrimSpace(dst)}
+
+		replaced := false
+		for i := range routes {
+			if isSameRouteDst(routes[i].Dst, route.Dst) {
+				routes[i] = route
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			routes = append(routes, route)
+		}
+	}
+
+	return routes
+}
+
+// isSameRouteDst returns whether two route destination strings specify the same network.
+func isSameRouteDst(dst1 string, dst2 string) bool {
+	_, network1, err1 := net.ParseCIDR(dst1)
+	_, network2, err2 := net.ParseCIDR(dst2)
+	if err1 != nil || err2 != nil {
+		return dst1 == dst2
+	}
+
+	return network1.String() == network2.String()
+}
+
+// getRoutes parses the static routes and validates that each gateway is in a branch subnet.
+func getRoutes(routes []routeJSON, ipAddresses []net.IPNet, ipv6Address *net.IPNet) ([]cniTypes.Route, error) {
+	var result []cniTypes.Route
+
+	for _, route := range routes {
+		_, dst, err := net.ParseCIDR(route.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route dst %s", route.Dst)
+		}
+
+		// Routes can only be added for the address families assigned to the branch.
+		if dst.IP.To4() != nil && len(ipAddresses) == 0 {
+			return nil, fmt.Errorf("route dst %s requires a branch IPv4 address", route.Dst)
+		}
+
+		var gw net.IP
+		if route.GW != "" {
+			gw = net.ParseIP(route.GW)
+			if gw == nil {
+				return nil, fmt.Errorf("invalid route gw %s", route.GW)
+			}
+
+			if (gw.To4() == nil) != (dst.IP.To4() == nil) {
+				return nil, fmt.Errorf("route gw %s and dst %s have different address families", route.GW, route.Dst)
+			}
+
+			if !isInBranchSubnet(gw, ipAddresses, ipv6Address) {
+				return nil, fmt.Errorf("route gw %s is not in the branch subnet", route.GW)
+			}
+		}
+
+		result = append(result, cniTypes.Route{Dst: *dst, GW: gw})
+	}
+
+	return result, nil
+}
+
+// getStaticNeighbors parses the static neighbor entries. Each IP address can have only one entry.
+func getStaticNeighbors(neighbors []neighborJSON) ([]StaticNeighbor, error) {
+	var result []StaticNeighbor
+	ipAddresses := make(map[string]bool)
+
+	for _, neighbor := range neighbors {
+		ipAddress := net.ParseIP(neighbor.IP)
+		if ipAddress == nil {
+			return nil, fmt.Errorf("invalid static neighbor ip %s", neighbor.IP)
+		}
+
+		if ipAddresses[ipAddress.String()] {
+			return nil, fmt.Errorf("duplicate static neighbor ip %s", neighbor.IP)
+		}
+		ipAddresses[ipAddress.String()] = true
+
+		if neighbor.MAC == "" {
+			return nil, fmt.Errorf("missing static neighbor mac for ip %s", neighbor.IP)
+		}
+
+		macAddress, err := net.ParseMAC(neighbor.MAC)
+		if err != nil || len(macAddress) != 6 {
+			return nil, fmt.Errorf("invalid static neighbor mac %s for ip %s", neighbor.MAC, neighbor.IP)
+		}
+
+		result = append(result, StaticNeighbor{IPAddress: ipAddress, MACAddress: macAddress})
+	}
+
+	return result, nil
+}
+
+// isInBranchSubnet returns whether the given IP address is in one of the branch subnets.
+func isInBranchSubnet(ip net.IP, ipAddresses []net.IPNet, ipv6Address *net.IPNet) bool {
+	if ip.To4() == nil {
+		return ipv6Address != nil && ipv6Address.Contains(ip)
+	}
+
+	for _, ipAddress := range ipAddresses {
+		if ipAddress.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupUID returns the numeric user ID for the given user ID or user name.
+// User names are resolved via the host's user database.
+func lookupUID(uid string) (int, error) {
+	id, err := strconv.Atoi(uid)
+	if err == nil {
+		return id, nil
+	}
+
+	u, err := user.Lookup(uid)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGID returns the numeric group ID for the given group ID or group name.
+// Group names are resolved via the host's group database.
+func lookupGID(gid string) (int, error) {
+	id, err := strconv.Atoi(gid)
+	if err == nil {
+		return id, nil
+	}
+
+	g, err := user.LookupGroup(gid)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(g.Gid)
+}
+
+// getGatewayOffset returns the host offset in the branch subnet of the gateway derived for
+// the given gatewayPosition, which is either first, last or a positive offset.
+func getGatewayOffset(position string) (int, error) {
+	switch position {
+	case "", GatewayPositionFirst:
+		return firstGatewayOffset, nil
+	case GatewayPositionLast:
+		return lastGatewayOffset, nil
+	}
+
+	offset, err := strconv.Atoi(position)
+	if err != nil || offset < firstGatewayOffset {
+		return 0, fmt.Errorf("invalid gatewayPosition %s, must be %s, %s or a positive offset",
+			position, GatewayPositionFirst, GatewayPositionLast)
+	}
+
+	return offset, nil
+}
+
+// isDualStack returns whether the branch has both IPv4 and IPv6 addresses.
+func isDualStack(netConfig *NetConfig) bool {
+	return netConfig.BranchIPAddress != nil && netConfig.BranchIPv6Address != nil
+}
+
+// setIPv4Gateways sets the IPv4 gateways of the branch to their explicit values, or to the one
+// derived from the branch subnet. IPv6-only branches skip all IPv4 setup. If no gateway can be
+// derived for a dual-stack branch, only its IPv4 default route is skipped.
+func setIPv4Gateways(config *netConfigJSON, netConfig *NetConfig) error {
+	gatewayOffset, err := getGatewayOffset(string(config.GatewayPosition))
+	if err != nil {
+		return err
+	}
+
+	if netConfig.BranchIPAddress == nil {
+		return nil
+	}
+
+	derived := len(config.BranchGatewayIPAddress) == 0
+	gateways, err := getGatewayIPAddresses(netConfig.BranchIPAddress, config.BranchGatewayIPAddress, gatewayOffset)
+	if err != nil {
+		if derived && config.GatewayPosition == "" && isDualStack(netConfig) {
+			logger.Warnf("Skipping the IPv4 default route: %v.", err)
+			return nil
+		}
+		return err
+	}
+
+	netConfig.BranchGatewayIPAddresses = gateways
+	netConfig.BranchGatewayIPAddress = gateways[0]
+	if derived {
+		logger.Warnf("Branch gateway IP address not specified, assuming %s.", netConfig.BranchGatewayIPAddress)
+	}
+
+	return nil
+}
+
+// setIPv6Gateway sets the IPv6 gateway of the branch to its explicit value, or to the one derived
+// from the branch IPv6 subnet. If no gateway can be derived for a dual-stack branch, only its IPv6
+// default route is skipped.
+func setIPv6Gateway(config *netConfigJSON, netConfig *NetConfig) error {
+	derived := config.BranchGatewayIPv6Address == ""
+	gateway, err := getGatewayIPv6Address(netConfig.BranchIPv6Address, config.BranchGatewayIPv6Address)
+	if err != nil {
+		if derived && isDualStack(netConfig) {
+			logger.Warnf("Skipping the IPv6 default route: %v.", err)
+			return nil
+		}
+		return err
+	}
+
+	netConfig.BranchGatewayIPv6Address = gateway
+	if derived && gateway != nil {
+		logger.Warnf("Branch gateway IPv6 address not specified, assuming %s.", gateway)
+	}
+
+	return nil
+}
+
+// getGatewayIPAddresses computes the IPv4 gateways for the given branch IP address. Multiple
+// explicit gateways form an ECMP default route, and each must be in the branch subnet.
+func getGatewayIPAddresses(ipAddress *net.IPNet, gatewayIPAddressStrings []string, gatewayOffset int) ([]net.IP, error) {
+	// Without explicit gateways, a single one is derived from the branch subnet.
+	if len(gatewayIPAddressStrings) == 0 {
+		gatewayIPAddressStrings = []string{""}
+	}
+
+	var gatewayIPAddresses []net.IP
+	for _, gatewayIPAddressString := range gatewayIPAddressStrings {
+		gatewayIPAddress, err := getGatewayIPAddress(ipAddress, gatewayIPAddressString, gatewayOffset)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, other := range gatewayIPAddresses {
+			if other.Equal(gatewayIPAddress) {
+				return nil, fmt.Errorf("duplicate branchGatewayIPAddress %s", gatewayIPAddressString)
+			}
+		}
+		gatewayIPAddresses = append(gatewayIPAddresses, gatewayIPAddress)
+	}
+
+	return gatewayIPAddresses, nil
+}
+
+func getGatewayIPAddress(ipAddress *net.IPNet, gatewayIPAddressString string, gatewayOffset int) (net.IP, error) {
+	var gatewayIPAddress net.IP
+
+	// If an explicit gateway IP address is provided, use it.
+	if gatewayIPAddressString != "" {
+		gatewayIPAddress = net.ParseIP(gatewayIPAddressString)
+		if gatewayIPAddress == nil || gatewayIPAddress.To4() == nil {
+			return nil, fmt.Errorf("invalid branchGatewayIPAddress %s", gatewayIPAddressString)
+		}
+
+		// The gateway must be reachable in the branch subnet. Host routes (/32) are exempt, as
+		// their gateway is necessarily outside of the subnet.
+		if ipAddress != nil && !isHostPrefix(ipAddress) && !ipAddress.Contains(gatewayIPAddress) {
+			return nil, fmt.Errorf("branchGatewayIPAddress %s is not in the subnet %s of branchIPAddress %s",
+				gatewayIPAddressString, vpc.GetSubnetPrefix(ipAddress), ipAddress)
+		}
+
+		return gatewayIPAddress, nil
+	}
+
+	// If neither gateway IP address nor container IP address is provided, cannot compute a gateway
+	// IP address, so just leave it as nil.
+	if ipAddress == nil {
+		return nil, nil
+	}
+
+	// A host prefix has no subnet to infer the gateway from.
+	if isHostPrefix(ipAddress) {
+		return nil, fmt.Errorf("unable to derive a gateway for branchIPAddress %s, "+
+			"branchGatewayIPAddress must be specified", ipAddress)
+	}
+
+	// Point-to-point subnets have no conventional gateway, the other address of the pair is used.
+	if isPointToPointPrefix(ipAddress) {
+		return getPointToPointPeer(ipAddress.IP), nil
+	}
+
+	// Otherwise, infer the gateway IP address from the subnet that the container IP address is in.
+	subnet, err := vpc.NewSubnet(vpc.GetSubnetPrefix(ipAddress))
+	if err != nil {
+		log.Errorf("Failed to parse VPC subnet for %s: %v.", ipAddress, err)
+		return nil, err
+	}
+
+	gatewayIPAddress = subnet.Gateways[0]
+	if gatewayOffset != firstGatewayOffset {
+		gatewayIPAddress, err = getSubnetHost(&subnet.Prefix, gatewayOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Small subnets may not have a usable host address left for the gateway.
+	if gatewayIPAddress.Equal(ipAddress.IP) || !subnet.Prefix.Contains(gatewayIPAddress) {
+		return nil, fmt.Errorf("unable to derive a gateway for branchIPAddress %s, "+
+			"branchGatewayIPAddress must be specified", ipAddress)
+	}
+
+	return gatewayIPAddress, nil
+}
+
+// getSubnetHost returns the host at the given offset in the given IPv4 subnet, or its last
+// usable host, before the broadcast address, for lastGatewayOffset.
+func getSubnetHost(prefix *net.IPNet, offset int) (net.IP, error) {
+	ones, bits := prefix.Mask.Size()
+	lastOffset := uint64(1)<<uint(bits-ones) - 2
+	if offset == lastGatewayOffset {
+		offset = int(lastOffset)
+	}
+
+	if offset < firstGatewayOffset || uint64(offset) > lastOffset {
+		return nil, fmt.Errorf("gatewayPosition %d is outside of the usable hosts of subnet %s",
+			offset, prefix)
+	}
+
+	hostID := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(hostID, uint32(offset))
+	return vpc.ComputeIPAddress(prefix, hostID), nil
+}
+
+// isHostPrefix returns whether the given address has a full-length prefix.
+func isHostPrefix(ipAddress *net.IPNet) bool {
+	ones, bits := ipAddress.Mask.Size()
+	return ones == bits
+}
+
+// isPointToPointPrefix returns whether the given address is in a point-to-point subnet of two
+// addresses (/31 or /127), as defined by RFC 3021.
+func isPointToPointPrefix(ipAddress *net.IPNet) bool {
+	ones, bits := ipAddress.Mask.Size()
+	return ones == bits-1
+}
+
+// getPointToPointPeer returns the other address of a point-to-point subnet.
+func getPointToPointPeer(ipAddress net.IP) net.IP {
+	peer := make(net.IP, net.IPv6len)
+	copy(peer, ipAddress.To16())
+	peer[net.IPv6len-1] ^= 1
+	return peer
+}
+
+func getGatewayIPv6Address(ipAddress *net.IPNet, gatewayIPAddressString string) (net.IP, error) {
+	var gatewayIPAddress net.IP
+
+	// If an explicit gateway IPv6 address is provided, use it.
+	if gatewayIPAddressString != "" {
+		gatewayIPAddress = net.ParseIP(gatewayIPAddressString)
+		if gatewayIPAddress == nil || gatewayIPAddress.To4() != nil {
+			return nil, fmt.Errorf("invalid branchGatewayIPv6Address %s", gatewayIPAddressString)
+		}
+
+		return gatewayIPAddress, nil
+	}
+
+	// If neither gateway IPv6 address nor container IPv6 address is provided, leave it as nil.
+	if ipAddress == nil {
+		return nil, nil
+	}
+
+	// A host prefix has no subnet to infer the gateway from.
+	if isHostPrefix(ipAddress) {
+		return nil, fmt.Errorf("unable to derive a gateway for branchIPv6Address %s, "+
+			"branchGatewayIPv6Address must be specified", ipAddress)
+	}
+
+	// Point-to-point subnets have no conventional gateway, the other address of the pair is used.
+	if isPointToPointPrefix(ipAddress) {
+		return getPointToPointPeer(ipAddress.IP), nil
+	}
+
+	// Otherwise, infer the gateway IPv6 address from the first address in the subnet.
+	subnet, err := vpc.NewSubnet(vpc.GetSubnetPrefix(ipAddress))
+	if err != nil {
+		log.Errorf("Failed to parse VPC IPv6 subnet for %s: %v.", ipAddress, err)
 		return nil, err
 	}
 
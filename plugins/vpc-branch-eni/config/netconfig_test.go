@@ -16,11 +16,22 @@
 package config
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math"
 	"net"
+	"os"
+	"os/user"
+	"strconv"
 	"testing"
 
+	"github.com/aws/amazon-vpc-cni-plugins/network/firewall"
+	"github.com/aws/amazon-vpc-cni-plugins/network/imds"
+	"github.com/aws/amazon-vpc-cni-plugins/network/vpc"
+
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type config struct {
@@ -30,8 +41,26 @@ type config struct {
 
 var (
 	validConfigs = []config{
+		config{ // Interface MAC address distinct from the branch MAC address.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceMACAddress":"02:42:42:42:42:42", "interfaceType":"vlan"}`,
+		},
+		config{ // Dedicated route table.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "routeTableID":252, "interfaceType":"vlan"}`,
+		},
+		config{ // Custom route protocol.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "routeProtocol":200, "interfaceType":"vlan"}`,
+		},
+		config{ // Default route metric.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "defaultRouteMetric":100, "interfaceType":"vlan"}`,
+		},
+		config{ // List of trunk names for failover.
+			netConfig: `{"trunkName":["eth1", "eth2"], "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		},
+		config{ // List of trunk MAC addresses for failover.
+			netConfig: `{"trunkMACAddress":["42:42:42:42:42:42", "42:42:42:42:42:43"], "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		},
 		config{ // All required fields in netconfig.
-			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"01:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "uid":"42", "gid":"42"}`,
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "uid":"42", "gid":"42"}`,
 			pcArgs:    "",
 		},
 		config{ // All required network fields in netconfig and branch fields in per-container args.
@@ -39,7 +68,7 @@ var (
 			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;BranchIPAddress=192.168.1.2/16",
 		},
 		config{ // TrunkMACAddress instead of TrunkName.
-			netConfig: `{"trunkMACAddress":"42:42:42:42:42:42", "branchVlanID":"100", "branchMACAddress":"01:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/14", "uid":"42", "gid":"42"}`,
+			netConfig: `{"trunkMACAddress":"42:42:42:42:42:42", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/14", "uid":"42", "gid":"42"}`,
 			pcArgs:    "",
 		},
 		config{ // With optional fields.
@@ -50,9 +79,100 @@ var (
 			netConfig: `{"trunkName":"eth1", "interfaceType": "vlan"}`,
 			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;BranchIPAddress=192.168.1.2/16",
 		},
+		config{ // Multiple branch IP addresses.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddresses":["10.11.12.13/16", "10.11.12.14/16"], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // Multiple branch IP addresses including the singular one.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.14/16", "branchIPAddresses":["10.11.12.13/16", "10.11.12.14/16"], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // IPv6 branch address only.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // Proxy ARP with a derived gateway.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "proxyARP":true, "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // Dual-stack branch addresses.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "branchGatewayIPv6Address":"2600:1f13:a0d:a700::1", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // Dual-stack branch addresses in per-container args.
+			netConfig: `{"trunkName":"eth1", "interfaceType": "vlan"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;BranchIPAddress=192.168.1.2/16;BranchIPv6Address=2600:1f13:a0d:a700::5/64",
+		},
+		config{ // With jumbo frame MTU.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "mtu":9001, "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // With MTU in per-container args.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;MTU=1500",
+		},
+		config{ // With DNS settings.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "dns":{"nameservers":["10.0.0.2"], "search":["us-west-2.compute.internal"], "options":["ndots:2"]}, "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // With static routes.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "routes":[{"dst":"10.20.0.0/16", "gw":"10.11.0.5"}, {"dst":"10.30.0.0/16"}], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // Universally administered unicast MAC addresses.
+			netConfig: `{"trunkMACAddress":"0e:42:42:42:42:42", "branchVlanID":"100", "branchMACAddress":"00:1b:21:3a:4f:5e", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // Locally administered unicast MAC addresses.
+			netConfig: `{"trunkMACAddress":"06:42:42:42:42:42", "branchVlanID":"100", "branchMACAddress":"fe:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // TrunkPCIAddress instead of TrunkName.
+			netConfig: `{"trunkPCIAddress":"0000:00:06.0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // MACVTAP interface with UID and GID.
+			netConfig: `{"trunkName":"eth1", "interfaceType":"macvtap", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60",
+		},
+		config{ // Boundary VLAN IDs.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=4094;BranchMACAddress=10:20:30:40:50:60",
+		},
+		config{ // Multiple branch IP addresses in per-container args.
+			netConfig: `{"trunkName":"eth1", "interfaceType": "vlan"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;BranchIPAddresses=192.168.1.2/16,192.168.1.3/16",
+		},
 	}
 
 	invalidConfigs = []config{
+		config{ // multicast interface MAC address.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceMACAddress":"01:00:5e:00:00:01", "interfaceType":"vlan"}`,
+		},
+		config{ // invalid interface MAC address.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceMACAddress":"02:23:45", "interfaceType":"vlan"}`,
+		},
+		config{ // route table ID out of range.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "routeTableID":253, "interfaceType":"vlan"}`,
+		},
+		config{ // negative route table ID.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "routeTableID":-1, "interfaceType":"vlan"}`,
+		},
+		config{ // route protocol reserved for the kernel.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "routeProtocol":2, "interfaceType":"vlan"}`,
+		},
+		config{ // route protocol out of range.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "routeProtocol":256, "interfaceType":"vlan"}`,
+		},
+		config{ // negative default route metric.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "defaultRouteMetric":-1, "interfaceType":"vlan"}`,
+		},
+		config{ // invalid trunk MAC address in a list of trunks.
+			netConfig: `{"trunkMACAddress":["42:42:42:42:42:42", "not-a-mac"], "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		},
+		config{ // empty trunk name in a list of trunks.
+			netConfig: `{"trunkName":["eth1", ""], "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		},
 		config{ // invalid branch IP address.
 			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
 			pcArgs:    "BranchVlanID=100;BranchMACAddress=10:20:30:40:50:60;BranchIPAddress=192.168.1/16",
@@ -65,6 +185,149 @@ var (
 			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "interfaceType":"tap"}`,
 			pcArgs:    "BranchMACAddress=10:20:30:40:50:60;BranchIPAddress=192.168.1.2/16",
 		},
+		config{ // interface name longer than IFNAMSIZ.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "interfaceName":"container-eth0-1"}`,
+		},
+		config{ // sysctl outside of the net tree.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "sysctls":{"kernel.hostname":"foo"}}`,
+		},
+		config{ // sysctl escaping the net tree.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "sysctls":{"net.ipv4/../../kernel.hostname":"foo"}}`,
+		},
+		config{ // unknown VLAN protocol.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "vlanProtocol":"802.1x"}`,
+		},
+		config{ // proxy ARP without a gateway.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "proxyARP":true}`,
+		},
+		config{ // too few TAP queues.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"tap", "uid":"42", "gid":"42", "tapQueues":-1}`,
+		},
+		config{ // too many TAP queues.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"tap", "uid":"42", "gid":"42", "tapQueues":257}`,
+		},
+		config{ // branch gateway outside of the branch subnet.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"172.31.19.6/20", "branchGatewayIPAddress":"172.31.32.1", "interfaceType":"vlan"}`,
+		},
+		config{ // IPv4 route on an IPv6-only branch.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "routes":[{"dst":"10.20.0.0/16"}], "interfaceType":"vlan"}`,
+		},
+		config{ // invalid branch IPv6 address.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPv6Address":"10.11.12.13/16", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // invalid branch gateway IPv6 address.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "branchGatewayIPv6Address":"10.11.0.1", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // zero branch MAC address.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"00:00:00:00:00:00", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // multicast branch MAC address.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=01:00:5e:00:00:01",
+		},
+		config{ // broadcast branch MAC address.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=ff:ff:ff:ff:ff:ff",
+		},
+		config{ // zero trunk MAC address.
+			netConfig: `{"trunkMACAddress":"00:00:00:00:00:00", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // multicast trunk MAC address.
+			netConfig: `{"trunkMACAddress":"33:33:00:00:00:01", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // missing MACVTAP UID and GID.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "interfaceType":"macvtap"}`,
+			pcArgs:    "BranchMACAddress=10:20:30:40:50:60",
+		},
+		config{ // unknown interface type.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "interfaceType":"veth", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchMACAddress=10:20:30:40:50:60",
+		},
+		config{ // unknown instance metadata blocking method.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "blockInstanceMetadata":true, "blockInstanceMetadataMethod":"nftables"}`,
+			pcArgs:    "",
+		},
+		config{ // zero bandwidth limit.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "egressBandwidthLimit":"0"}`,
+			pcArgs:    "",
+		},
+		config{ // negative bandwidth limit.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "IngressBandwidthLimit=-10m",
+		},
+		config{ // invalid bandwidth limit suffix.
+			netConfig: `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "ingressBandwidthLimit":"10x"}`,
+			pcArgs:    "",
+		},
+		config{ // missing trunk.
+			netConfig: `{"branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // both trunk name and MAC address.
+			netConfig: `{"trunkName":"eth1", "trunkMACAddress":"42:42:42:42:42:42", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // both trunk name and PCI address.
+			netConfig: `{"trunkName":"eth1", "trunkPCIAddress":"0000:00:06.0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // invalid trunk PCI address.
+			netConfig: `{"trunkPCIAddress":"00:06.0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // branch VLAN ID zero.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"0", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // branch VLAN ID too large.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"9999", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // branch VLAN ID out of range in per-container args.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=4095;BranchMACAddress=10:20:30:40:50:60",
+		},
+		config{ // negative branch VLAN ID in per-container args.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=-1;BranchMACAddress=10:20:30:40:50:60",
+		},
+		config{ // MTU too small.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "mtu":575, "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // MTU too large.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;MTU=9217",
+		},
+		config{ // invalid MTU in per-container args.
+			netConfig: `{"trunkName":"eth1", "uid":"42", "gid":"42"}`,
+			pcArgs:    "BranchVlanID=10;BranchMACAddress=10:20:30:40:50:60;MTU=jumbo",
+		},
+		config{ // invalid DNS nameserver.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "dns":{"nameservers":["10.0.0"]}, "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // invalid static route dst.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "routes":[{"dst":"10.20.0.0"}], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // static route gw outside of the branch subnet.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "routes":[{"dst":"10.20.0.0/16", "gw":"10.12.0.1"}], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // invalid entry in branch IP addresses.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddresses":["10.11.12.13/16", "10.11.12/16"], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
+		config{ // branch IP address disagrees with branch IP addresses.
+			netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.15/16", "branchIPAddresses":["10.11.12.13/16", "10.11.12.14/16"], "interfaceType":"vlan"}`,
+			pcArgs:    "",
+		},
 	}
 )
 
@@ -95,7 +358,7 @@ func TestInvalidConfigs(t *testing.T) {
 // TestPerContainerArgsOverrideNetConfig tests that per-container args override per-network args.
 func TestPerContainerArgsOverrideNetConfig(t *testing.T) {
 	c := config{
-		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"01:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/14", "uid":"42", "gid":"42"}`,
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/14", "uid":"42", "gid":"42"}`,
 		pcArgs:    "BranchVlanID=42;BranchMACAddress=44:44:44:55:55:55;BranchIPAddress=192.168.1.2/16",
 	}
 
@@ -111,24 +374,1799 @@ func TestPerContainerArgsOverrideNetConfig(t *testing.T) {
 	assert.Equal(t, "192.168.1.2/16", nc.BranchIPAddress.String(), "invalid ipaddress")
 }
 
-func TestGetGatewayIPAddress(t *testing.T) {
-	_, ipv4Net, err := net.ParseCIDR("172.31.16.3/20")
-	assert.NoError(t, err)
+// TestPerContainerArgsCaseInsensitive tests that per-container args keys are matched case-insensitively.
+func TestPerContainerArgsCaseInsensitive(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "interfaceType":"vlan"}`,
+		pcArgs:    "branchvlanid=100;BRANCHMACADDRESS=02:23:45:67:89:ab;branchIPAddress=10.11.12.13/16;Mtu=1500",
+	}
 
-	expectedGatewayIPAddress := net.ParseIP("172.31.16.2")
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, 100, nc.BranchVlanID, "invalid branchVlanID")
+	assert.Equal(t, "02:23:45:67:89:ab", nc.BranchMACAddress.String(), "invalid branchMACAddress")
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String(), "invalid branchIPAddress")
+	assert.Equal(t, 1500, nc.MTU, "invalid mtu")
+}
+
+// TestPerContainerArgsPreferExactMatch tests that exactly matching per-container args keys take
+// precedence over keys that differ only in case.
+func TestPerContainerArgsPreferExactMatch(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		pcArgs:    "branchvlanid=200;BranchVlanID=100;BRANCHVLANID=300",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, 100, nc.BranchVlanID, "invalid branchVlanID")
+}
+
+// TestNormalizePCArgs tests rewriting per-container args keys to their canonical names.
+func TestNormalizePCArgs(t *testing.T) {
+	assert.Equal(t, "BranchVlanID=1;K8S_POD_NAME=x;IgnoreUnknown=1",
+		normalizePCArgs("branchVLANid=1;K8S_POD_NAME=x;ignoreunknown=1"))
+	assert.Equal(t, "BranchMACAddress=02:23:45:67:89:ab", normalizePCArgs("BranchMACAddress=02:23:45:67:89:ab"))
+	assert.Equal(t, "invalid", normalizePCArgs("invalid"))
+}
+
+// TestUIDAndGIDNames tests that TAP owner user and group names are resolved to numeric IDs.
+func TestUIDAndGIDNames(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("User nobody not found: %v", err)
+	}
+	expectedUID, err := strconv.Atoi(nobody.Uid)
+	require.NoError(t, err)
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "uid":"nobody", "gid":"root"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, expectedUID, nc.Tap.Uid, "invalid uid")
+	assert.Equal(t, 0, nc.Tap.Gid, "invalid gid")
+}
 
-	outputGatewayIPAddress, err := getGatewayIPAddress(ipv4Net, "172.31.16.2")
+// TestUnknownUIDAndGIDNames tests that unresolvable TAP owner user and group names are rejected,
+// except on DEL.
+func TestUnknownUIDAndGIDNames(t *testing.T) {
+	defer os.Unsetenv(envCNICommand)
+
+	for _, owner := range []string{
+		`"uid":"vpc-branch-eni-nonexistent-user", "gid":"42"`,
+		`"uid":"42", "gid":"vpc-branch-eni-nonexistent-group"`,
+	} {
+		args := &skel.CmdArgs{
+			StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", ` + owner + `}`),
+		}
+		for _, command := range []string{"ADD", "CHECK"} {
+			os.Setenv(envCNICommand, command)
+			_, err := New(args)
+			assert.Error(t, err, owner)
+		}
+
+		os.Setenv(envCNICommand, "DEL")
+		_, err := New(args)
+		assert.NoError(t, err, owner)
+	}
+}
+
+// TestDNSConfig tests that DNS settings are parsed from netconfig.
+func TestDNSConfig(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "dns":{"nameservers":["10.0.0.2"], "search":["us-west-2.compute.internal"], "options":["ndots:2"]}, "interfaceType":"vlan"}`,
+		pcArgs:    "",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
 	assert.NoError(t, err)
-	assert.Equal(t, expectedGatewayIPAddress, outputGatewayIPAddress)
+
+	assert.Equal(t, []string{"10.0.0.2"}, nc.DNS.Nameservers, "invalid nameservers")
+	assert.Equal(t, []string{"us-west-2.compute.internal"}, nc.DNS.Search, "invalid search domains")
+	assert.Equal(t, []string{"ndots:2"}, nc.DNS.Options, "invalid options")
 }
 
-func TestGetGatewayIPAddressFromSubnet(t *testing.T) {
-	_, ipv4Net, err := net.ParseCIDR("172.31.16.3/20")
+// TestStaticRoutes tests that static routes are parsed from netconfig.
+func TestStaticRoutes(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "routes":[{"dst":"10.20.0.0/16", "gw":"10.11.0.5"}, {"dst":"2600:1f13:a0d:a800::/56", "gw":"2600:1f13:a0d:a700::9"}], "interfaceType":"vlan"}`,
+		pcArgs:    "",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
 	assert.NoError(t, err)
 
-	expectedGatewayIPAddress := net.ParseIP("172.31.16.1")
+	assert.Equal(t, 2, len(nc.Routes), "invalid number of routes")
+	assert.Equal(t, "10.20.0.0/16", nc.Routes[0].Dst.String(), "invalid route dst")
+	assert.Equal(t, "10.11.0.5", nc.Routes[0].GW.String(), "invalid route gw")
+	assert.Equal(t, "2600:1f13:a0d:a800::/56", nc.Routes[1].Dst.String(), "invalid route dst")
+	assert.Equal(t, "2600:1f13:a0d:a700::9", nc.Routes[1].GW.String(), "invalid route gw")
+}
+
+// TestPerContainerArgsRoutes tests that per-container routes are merged with the network routes.
+func TestPerContainerArgsRoutes(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "routes":[{"dst":"10.20.0.0/16", "gw":"10.11.0.5"}, {"dst":"192.168.0.0/16", "gw":"10.11.0.6"}], "interfaceType":"vlan"}`,
+		pcArgs:    "Routes=10.0.0.0/8,192.168.0.0/16",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, len(nc.Routes), "invalid number of routes")
+	assert.Equal(t, "10.20.0.0/16", nc.Routes[0].Dst.String(), "invalid route dst")
+	assert.Equal(t, "10.11.0.5", nc.Routes[0].GW.String(), "invalid route gw")
+	// The per-container route overrides the network route to the same destination.
+	assert.Equal(t, "192.168.0.0/16", nc.Routes[1].Dst.String(), "invalid route dst")
+	assert.Nil(t, nc.Routes[1].GW, "invalid route gw")
+	assert.Equal(t, "10.0.0.0/8", nc.Routes[2].Dst.String(), "invalid route dst")
+	assert.Nil(t, nc.Routes[2].GW, "invalid route gw")
+}
+
+// TestPerContainerArgsInvalidRoutes tests that invalid per-container routes are rejected.
+func TestPerContainerArgsInvalidRoutes(t *testing.T) {
+	for _, routes := range []string{"10.0.0.0", "10.0.0.0/8,", "10.0.0.0/33", "10.0.0.0/8,foo"} {
+		args := &skel.CmdArgs{
+			StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`),
+			Args:      "Routes=" + routes,
+		}
+		_, err := New(args)
+		assert.Error(t, err, routes)
+	}
+}
 
-	outputGatewayIPAddress, err := getGatewayIPAddress(ipv4Net, "")
+// TestPerContainerArgsOverrideMTU tests that the per-container MTU overrides the network MTU.
+func TestPerContainerArgsOverrideMTU(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "mtu":1500, "uid":"42", "gid":"42"}`,
+		pcArgs:    "MTU=9001",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
 	assert.NoError(t, err)
-	assert.Equal(t, expectedGatewayIPAddress, outputGatewayIPAddress)
+
+	assert.Equal(t, 9001, nc.MTU, "invalid mtu")
+}
+
+// TestInterfaceName tests that the interface name is taken from network configuration or from
+// CNI_IFNAME, falling back to the default, and that the two cannot disagree.
+func TestInterfaceName(t *testing.T) {
+	for _, c := range []struct {
+		interfaceName string
+		ifName        string
+		expected      string
+	}{
+		{interfaceName: "", ifName: "", expected: "eth0"},
+		{interfaceName: "", ifName: "eth3", expected: "eth3"},
+		{interfaceName: "eth3", ifName: "eth3", expected: "eth3"},
+		{interfaceName: "container-eth0", ifName: "", expected: "container-eth0"},
+	} {
+		args := &skel.CmdArgs{
+			StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "interfaceName":"` + c.interfaceName + `"}`),
+			IfName:    c.ifName,
+		}
+		nc, err := New(args)
+		require.NoError(t, err)
+
+		assert.Equal(t, c.expected, nc.InterfaceName, "invalid interfaceName")
+	}
+
+	_, err := New(&skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "interfaceName":"ctr0"}`),
+		IfName:    "eth3",
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "interfaceName ctr0 does not match CNI_IFNAME eth3")
+}
+
+// TestSysctls tests that network sysctls are accepted.
+func TestSysctls(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "sysctls":{"net.ipv4.tcp_keepalive_time":"600", "net.ipv4.conf.{ifname}.rp_filter":"2"}}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"net.ipv4.tcp_keepalive_time":      "600",
+		"net.ipv4.conf.{ifname}.rp_filter": "2",
+	}, nc.Sysctls)
+}
+
+// TestIsAllowedSysctl tests the sysctl allowlist.
+func TestIsAllowedSysctl(t *testing.T) {
+	for key, expected := range map[string]bool{
+		"net.ipv4.tcp_keepalive_time":      true,
+		"net.ipv6.conf.all.disable_ipv6":   true,
+		"net.ipv4.conf.{ifname}.rp_filter": true,
+		"net":                              false,
+		"net.":                             false,
+		"net..ipv4":                        false,
+		"network.foo":                      false,
+		"kernel.hostname":                  false,
+		"vm.swappiness":                    false,
+		"net.ipv4/../../kernel/hostname":   false,
+		"net/ipv4/ip_forward":              false,
+	} {
+		assert.Equal(t, expected, isAllowedSysctl(key), key)
+	}
+}
+
+// TestVlanProtocol tests that the VLAN protocol is parsed case-insensitively and defaulted.
+func TestVlanProtocol(t *testing.T) {
+	for protocol, expected := range map[string]string{
+		``:                           VlanProtocol8021Q,
+		`, "vlanProtocol":"802.1q"`:  VlanProtocol8021Q,
+		`, "vlanProtocol":"802.1Q"`:  VlanProtocol8021Q,
+		`, "vlanProtocol":"802.1ad"`: VlanProtocol8021AD,
+		`, "vlanProtocol":"802.1AD"`: VlanProtocol8021AD,
+	} {
+		args := &skel.CmdArgs{
+			StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"` + protocol + `}`),
+		}
+		nc, err := New(args)
+		require.NoError(t, err, protocol)
+
+		assert.Equal(t, expected, nc.VlanProtocol, "invalid vlanProtocol")
+	}
+}
+
+// TestTapQueues tests that the TAP queue count is parsed and defaulted.
+func TestTapQueues(t *testing.T) {
+	for queues, expected := range map[string]int{
+		``:                  1,
+		`, "tapQueues":1`:   1,
+		`, "tapQueues":8`:   8,
+		`, "tapQueues":256`: 256,
+	} {
+		args := &skel.CmdArgs{
+			StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"tap", "uid":"42", "gid":"42"` + queues + `}`),
+		}
+		nc, err := New(args)
+		require.NoError(t, err, queues)
+
+		assert.Equal(t, expected, nc.Tap.Queues, "invalid tapQueues")
+	}
+}
+
+// TestOffloads tests that offload feature names are validated.
+func TestOffloads(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"offloads":{"rx":true, "tx":true, "sg":true, "tso":false, "gso":false, "gro":true}, "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"rx": true, "tx": true, "sg": true, "tso": false, "gso": false, "gro": true},
+		nc.Offloads)
+
+	args.StdinData = []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"offloads":{"lro":false}, "interfaceType":"vlan"}`)
+	_, err = New(args)
+	require.Error(t, err)
+	assert.Equal(t, "invalid offload lro, must be one of rx, tx, sg, tso, gso, gro", err.Error())
+}
+
+// TestVhostNet tests that the vhost-net check is supported only in TAP mode.
+func TestVhostNet(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"interfaceType":"tap", "uid":"42", "gid":"42", "vhostNet":true}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.True(t, nc.Tap.VhostNet)
+
+	for _, ifType := range []string{IfTypeVLAN, IfTypeMACVTAP} {
+		args.StdinData = []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"interfaceType":"` + ifType + `", "uid":"42", "gid":"42", "vhostNet":true}`)
+		_, err = New(args)
+		assert.Error(t, err, ifType)
+	}
+}
+
+// TestBlockIMDSMethod tests that the instance metadata blocking method is parsed and defaulted.
+func TestBlockIMDSMethod(t *testing.T) {
+	for method, expected := range map[string]string{
+		`, "blockInstanceMetadataMethod":""`:         imds.BlockMethodRoute,
+		`, "blockInstanceMetadataMethod":"route"`:    imds.BlockMethodRoute,
+		`, "blockInstanceMetadataMethod":"iptables"`: imds.BlockMethodIPTables,
+		`, "blockInstanceMetadataMethod":"auto"`:     imds.BlockMethodAuto,
+	} {
+		args := &skel.CmdArgs{
+			StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "blockInstanceMetadata":true` + method + `}`),
+		}
+		nc, err := New(args)
+		require.NoError(t, err, method)
+
+		assert.Equal(t, expected, nc.BlockIMDSMethod, "invalid blockInstanceMetadataMethod")
+	}
+}
+
+// TestBandwidthLimits tests that bandwidth limits are parsed with optional suffixes.
+func TestBandwidthLimits(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan", "ingressBandwidthLimit":"100m", "egressBandwidthLimit":"500000"}`,
+		pcArgs:    "EgressBandwidthLimit=2G",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(100000000), nc.IngressBandwidthLimit, "invalid ingress bandwidth limit")
+	assert.Equal(t, uint64(2000000000), nc.EgressBandwidthLimit, "invalid egress bandwidth limit")
+}
+
+// TestParseBandwidth tests parsing bandwidth rates with suffixes.
+func TestParseBandwidth(t *testing.T) {
+	for rate, expected := range map[string]uint64{
+		"1":    1,
+		"100k": 100000,
+		"100m": 100000000,
+		"10g":  10000000000,
+		"1t":   1000000000000,
+	} {
+		value, err := parseBandwidth(rate)
+		assert.NoError(t, err, rate)
+		assert.Equal(t, expected, value, rate)
+	}
+
+	for _, rate := range []string{"", "m", "0", "0k", "-1", "1.5m", "100mbit", "20000000t"} {
+		_, err := parseBandwidth(rate)
+		assert.Error(t, err, rate)
+	}
+}
+
+// TestIPv6OnlyBranch tests that a branch with only an IPv6 address has no IPv4 configuration.
+func TestIPv6OnlyBranch(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "branchGatewayIPAddress":"10.11.0.1", "routes":[{"dst":"2600:1f13:a0d:a800::/56"}], "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+
+	assert.Nil(t, nc.BranchIPAddress, "unexpected branch IPv4 address")
+	assert.Empty(t, nc.BranchIPAddresses, "unexpected branch IPv4 addresses")
+	assert.Nil(t, nc.BranchGatewayIPAddress, "unexpected branch gateway IPv4 address")
+	assert.Equal(t, "2600:1f13:a0d:a700::5/64", nc.BranchIPv6Address.String(), "invalid branchIPv6Address")
+	assert.Equal(t, "2600:1f13:a0d:a700::1", nc.BranchGatewayIPv6Address.String(), "invalid branchGatewayIPv6Address")
+	require.Equal(t, 1, len(nc.Routes))
+	assert.Equal(t, "2600:1f13:a0d:a800::/56", nc.Routes[0].Dst.String(), "invalid route dst")
+}
+
+// TestMultipleBranchIPAddresses tests that all branch IP addresses are parsed.
+func TestMultipleBranchIPAddresses(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddresses":["10.11.12.13/16", "10.11.12.14/16"], "interfaceType":"vlan"}`,
+		pcArgs:    "",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String(), "invalid primary ipaddress")
+	assert.Equal(t, 2, len(nc.BranchIPAddresses), "invalid number of ipaddresses")
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddresses[0].String(), "invalid ipaddress")
+	assert.Equal(t, "10.11.12.14/16", nc.BranchIPAddresses[1].String(), "invalid ipaddress")
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String(), "invalid gateway")
+}
+
+// TestSingleBranchIPAddressAlias tests that branchIPAddress is an alias for a single-element list.
+func TestSingleBranchIPAddressAlias(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`,
+		pcArgs:    "",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String(), "invalid primary ipaddress")
+	assert.Equal(t, 1, len(nc.BranchIPAddresses), "invalid number of ipaddresses")
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddresses[0].String(), "invalid ipaddress")
+}
+
+func TestGetGatewayIPAddress(t *testing.T) {
+	_, ipv4Net, err := net.ParseCIDR("172.31.16.3/20")
+	assert.NoError(t, err)
+
+	expectedGatewayIPAddress := net.ParseIP("172.31.16.2")
+
+	outputGatewayIPAddress, err := getGatewayIPAddress(ipv4Net, "172.31.16.2", firstGatewayOffset)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedGatewayIPAddress, outputGatewayIPAddress)
+}
+
+// TestGetGatewayIPAddressOutsideSubnet tests that an explicit gateway outside of the branch subnet is rejected.
+func TestGetGatewayIPAddressOutsideSubnet(t *testing.T) {
+	ipAddress, err := vpc.GetIPAddressFromString("172.31.19.6/20")
+	require.NoError(t, err)
+
+	_, err = getGatewayIPAddress(ipAddress, "172.31.32.1", firstGatewayOffset)
+	require.Error(t, err)
+	assert.Equal(t, "branchGatewayIPAddress 172.31.32.1 is not in the subnet 172.31.16.0/20 of branchIPAddress 172.31.19.6/20", err.Error())
+}
+
+func TestGetGatewayIPAddressFromSubnet(t *testing.T) {
+	_, ipv4Net, err := net.ParseCIDR("172.31.16.3/20")
+	assert.NoError(t, err)
+
+	expectedGatewayIPAddress := net.ParseIP("172.31.16.1")
+
+	outputGatewayIPAddress, err := getGatewayIPAddress(ipv4Net, "", firstGatewayOffset)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedGatewayIPAddress, outputGatewayIPAddress)
+}
+
+// TestGetGatewayIPAddressFromSubnetPosition tests deriving the gateway at the last usable host
+// and at an explicit offset of the subnet.
+func TestGetGatewayIPAddressFromSubnetPosition(t *testing.T) {
+	testCases := []struct {
+		position        string
+		ipAddress       string
+		expectedGateway string
+	}{
+		{"last", "172.31.16.3/20", "172.31.31.254"},
+		{"last", "172.31.16.1/30", "172.31.16.2"},
+		{"5", "172.31.16.3/20", "172.31.16.5"},
+		{"256", "172.31.16.3/20", "172.31.17.0"},
+		{"4094", "172.31.16.3/20", "172.31.31.254"},
+		{"4095", "172.31.16.3/20", ""},
+		{"3", "172.31.16.3/20", ""},
+		{"last", "172.31.16.2/30", ""},
+	}
+
+	for _, tc := range testCases {
+		ipAddress, err := vpc.GetIPAddressFromString(tc.ipAddress)
+		require.NoError(t, err)
+		gatewayOffset, err := getGatewayOffset(tc.position)
+		require.NoError(t, err)
+
+		outputGatewayIPAddress, err := getGatewayIPAddress(ipAddress, "", gatewayOffset)
+		if tc.expectedGateway == "" {
+			assert.Error(t, err, tc.position, tc.ipAddress)
+		} else {
+			assert.NoError(t, err, tc.position, tc.ipAddress)
+			assert.Equal(t, tc.expectedGateway, outputGatewayIPAddress.String(), tc.position, tc.ipAddress)
+		}
+	}
+}
+
+// TestGatewayPosition tests the parsing of the gatewayPosition option.
+func TestGatewayPosition(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "gatewayPosition":%s, "interfaceType":"vlan"}`
+
+	for position, expected := range map[string]string{
+		`"first"`: "10.11.0.1",
+		`"last"`:  "10.11.255.254",
+		`"10"`:    "10.11.0.10",
+		`10`:      "10.11.0.10",
+	} {
+		nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, position))})
+		require.NoError(t, err, position)
+		assert.Equal(t, expected, nc.BranchGatewayIPAddress.String(), position)
+	}
+
+	for _, position := range []string{`"middle"`, `0`, `-1`, `65535`} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, position))})
+		assert.Error(t, err, position)
+	}
+}
+
+// TestMultipleGateways tests the parsing of multiple branch gateways.
+func TestMultipleGateways(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "branchGatewayIPAddress":%s, "interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `["10.11.0.1", "10.11.0.2"]`))})
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+	require.Len(t, nc.BranchGatewayIPAddresses, 2)
+	assert.Equal(t, "10.11.0.2", nc.BranchGatewayIPAddresses[1].String())
+
+	// Per-container gateways are separated by commas.
+	nc, err = New(&skel.CmdArgs{
+		StdinData: []byte(fmt.Sprintf(netConfigFmt, `"10.11.0.1"`)),
+		Args:      "BranchGatewayIPAddress=10.11.0.3,10.11.0.4",
+	})
+	require.NoError(t, err)
+	require.Len(t, nc.BranchGatewayIPAddresses, 2)
+	assert.Equal(t, "10.11.0.3", nc.BranchGatewayIPAddress.String())
+	assert.Equal(t, "10.11.0.4", nc.BranchGatewayIPAddresses[1].String())
+
+	// Each gateway must be in the branch subnet, and gateways must be unique.
+	for _, gateways := range []string{`["10.11.0.1", "10.12.0.1"]`, `["10.11.0.1", "10.11.0.1"]`} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, gateways))})
+		assert.Error(t, err, gateways)
+	}
+}
+
+func TestGetGatewayIPv6Address(t *testing.T) {
+	ipv6Address, err := vpc.GetIPAddressFromString("2600:1f13:a0d:a700::5/64")
+	assert.NoError(t, err)
+
+	expectedGatewayIPAddress := net.ParseIP("2600:1f13:a0d:a700::2")
+
+	outputGatewayIPAddress, err := getGatewayIPv6Address(ipv6Address, "2600:1f13:a0d:a700::2")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedGatewayIPAddress, outputGatewayIPAddress)
+}
+
+func TestGetGatewayIPv6AddressFromSubnet(t *testing.T) {
+	ipv6Address, err := vpc.GetIPAddressFromString("2600:1f13:a0d:a700::5/64")
+	assert.NoError(t, err)
+
+	expectedGatewayIPAddress := net.ParseIP("2600:1f13:a0d:a700::1")
+
+	outputGatewayIPAddress, err := getGatewayIPv6Address(ipv6Address, "")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedGatewayIPAddress, outputGatewayIPAddress)
+}
+
+func TestGetGatewayIPAddressFromSmallSubnets(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ipAddress       string
+		expectedGateway string
+	}{
+		{"/30 subnet", "172.31.16.2/30", "172.31.16.1"},
+		{"/31 subnet", "172.31.16.0/31", "172.31.16.1"},
+		{"/31 subnet with gateway at the start of the pair", "172.31.16.1/31", "172.31.16.0"},
+		{"/32 subnet", "172.31.16.3/32", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ipAddress, err := vpc.GetIPAddressFromString(tc.ipAddress)
+			assert.NoError(t, err)
+
+			outputGatewayIPAddress, err := getGatewayIPAddress(ipAddress, "", firstGatewayOffset)
+			if tc.expectedGateway == "" {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, net.ParseIP(tc.expectedGateway), outputGatewayIPAddress)
+			}
+
+			// An explicit gateway in the subnet, or for a host prefix, is always accepted.
+			_, err = getGatewayIPAddress(ipAddress, "172.31.16.1", firstGatewayOffset)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestGetGatewayIPv6AddressFromSmallSubnets tests that /127 subnets use the other address of the
+// pair as the gateway, and that /128 subnets require an explicit gateway.
+func TestGetGatewayIPv6AddressFromSmallSubnets(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ipAddress       string
+		expectedGateway string
+	}{
+		{"/127 subnet", "2600:1f13:a0d:a700::4/127", "2600:1f13:a0d:a700::5"},
+		{"/127 subnet with gateway at the start of the pair", "2600:1f13:a0d:a700::5/127", "2600:1f13:a0d:a700::4"},
+		{"/128 subnet", "2600:1f13:a0d:a700::5/128", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ipAddress, err := vpc.GetIPAddressFromString(tc.ipAddress)
+			assert.NoError(t, err)
+
+			outputGatewayIPAddress, err := getGatewayIPv6Address(ipAddress, "")
+			if tc.expectedGateway == "" {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, net.ParseIP(tc.expectedGateway), outputGatewayIPAddress)
+			}
+
+			_, err = getGatewayIPv6Address(ipAddress, "2600:1f13:a0d:a700::1")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestDerivedGatewayIPAddress tests that the gateway is derived when branchGatewayIPAddress is absent.
+func TestDerivedGatewayIPAddress(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.14/30", "interfaceType":"vlan"}`,
+		pcArgs:    "",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.12.13", nc.BranchGatewayIPAddress.String(), "invalid gateway")
+}
+
+// TestDerivedGatewayIPAddressWarning tests that a derived gateway is surfaced as a warning on
+// stderr when enabled.
+func TestDerivedGatewayIPAddressWarning(t *testing.T) {
+	stderr, err := ioutil.TempFile("", "stderr")
+	require.NoError(t, err)
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	realStderr := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = realStderr }()
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"branchIPAddress":"10.11.12.14/30", "interfaceType":"vlan"}`),
+	}
+
+	// Warnings are written to stderr only if enabled.
+	_, err = New(args)
+	require.NoError(t, err)
+
+	os.Setenv("VPC_CNI_WARN_STDERR", "1")
+	defer os.Unsetenv("VPC_CNI_WARN_STDERR")
+	_, err = New(args)
+	require.NoError(t, err)
+
+	// An explicit gateway is not warned about.
+	args.StdinData = []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.14/30", "branchGatewayIPAddress":"10.11.12.13", "interfaceType":"vlan"}`)
+	_, err = New(args)
+	require.NoError(t, err)
+
+	output, err := ioutil.ReadFile(stderr.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "WARNING: Branch gateway IP address not specified, assuming 10.11.12.13.\n", string(output))
+}
+
+// TestPerContainerArgsDerivedGatewayIPAddress tests that the gateway IP addresses are derived from
+// the subnet when the branch IP addresses are passed only in per-container args.
+func TestPerContainerArgsDerivedGatewayIPAddress(t *testing.T) {
+	c := config{
+		netConfig: `{"trunkName":"eth0", "interfaceType":"vlan"}`,
+		pcArgs:    "BranchVlanID=100;BranchMACAddress=02:23:45:67:89:ab;BranchIPAddress=172.31.19.6/20;BranchIPv6Address=2600:1f13:a0d:a700::5/64",
+	}
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(c.netConfig),
+		Args:      c.pcArgs,
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "172.31.16.1", nc.BranchGatewayIPAddress.String(), "invalid gateway")
+	assert.Equal(t, "2600:1f13:a0d:a700::1", nc.BranchGatewayIPv6Address.String(), "invalid IPv6 gateway")
+}
+
+// TestTrunkList tests that a list of trunk interfaces is parsed in order.
+func TestTrunkList(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":["eth1", "eth2"], "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eth1", "eth2"}, nc.TrunkNames)
+	assert.Equal(t, "eth1", nc.TrunkName)
+
+	args.StdinData = []byte(`{"trunkMACAddress":"42:42:42:42:42:42", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	require.Len(t, nc.TrunkMACAddresses, 1)
+	assert.Equal(t, "42:42:42:42:42:42", nc.TrunkMACAddress.String())
+}
+
+// TestBareBranchIPAddress tests that branch IP addresses without a prefix length are accepted.
+func TestBareBranchIPAddress(t *testing.T) {
+	// Without subnet context, a host prefix is assumed and the gateway must be specified.
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"branchIPAddress":"10.11.12.13", "branchGatewayIPAddress":"10.11.0.1", "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.12.13/32", nc.BranchIPAddress.String())
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13", "interfaceType":"vlan"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+
+	// With subnet context from the listed addresses, the subnet's mask is used.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.14", "branchIPAddresses":["10.11.12.13/16", "10.11.12.14"], "interfaceType":"vlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.12.14/16", nc.BranchIPAddress.String())
+	require.Len(t, nc.BranchIPAddresses, 2)
+	assert.Equal(t, "10.11.12.14/16", nc.BranchIPAddresses[1].String())
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+
+	// IPv6 addresses are given a /128 host prefix.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPv6Address":"2600:1f13:a0d:a700::5", "branchGatewayIPv6Address":"fe80::1", "interfaceType":"vlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "2600:1f13:a0d:a700::5/128", nc.BranchIPv6Address.String())
+
+	// Invalid bare addresses are still rejected.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12", "interfaceType":"vlan"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+}
+
+// TestValidationErrorsAccumulate tests that all validation problems are reported at once.
+func TestValidationErrorsAccumulate(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"01:00:5e:00:00:01",
+			"mtu":42, "vlanProtocol":"802.1x", "interfaceType":"vlan"}`),
+	}
+	_, err := New(args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 problems")
+	assert.Contains(t, err.Error(), "invalid vlanProtocol 802.1x")
+	assert.Contains(t, err.Error(), "invalid mtu 42")
+	assert.Contains(t, err.Error(), "invalid branchMACAddress 01:00:5e:00:00:01")
+
+	// A single problem is reported as is.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"mtu":42, "interfaceType":"vlan"}`)
+	_, err = New(args)
+	require.Error(t, err)
+	assert.Equal(t, "invalid mtu 42, must be between 576 and 9216", err.Error())
+
+	// Parse errors still fail fast.
+	args.StdinData = []byte(`{"trunkName":`)
+	_, err = New(args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse network config")
+}
+
+// TestBranchIPPrefix tests the address and route derived from a delegated prefix.
+func TestBranchIPPrefix(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"branchIPPrefix":"10.11.12.16/28", "branchGatewayIPAddress":"10.11.0.1",
+			"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.12.16/28", nc.BranchIPPrefix.String())
+	assert.Equal(t, "10.11.12.17/32", nc.BranchIPAddress.String())
+	require.Len(t, nc.BranchIPAddresses, 1)
+	assert.Equal(t, "10.11.12.17/32", nc.BranchIPAddresses[0].String())
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+	require.Len(t, nc.Routes, 1)
+	assert.Equal(t, "10.11.12.16/28", nc.Routes[0].Dst.String())
+	assert.Nil(t, nc.Routes[0].GW)
+
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPPrefix":"2600:1f13:a0d:a700:42::/80", "branchGatewayIPv6Address":"fe80::1", "interfaceType":"vlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	assert.Nil(t, nc.BranchIPAddress)
+	assert.Equal(t, "2600:1f13:a0d:a700:42::1/128", nc.BranchIPv6Address.String())
+	require.Len(t, nc.Routes, 1)
+	assert.Equal(t, "2600:1f13:a0d:a700:42::/80", nc.Routes[0].Dst.String())
+}
+
+// TestInvalidBranchIPPrefix tests the validation of delegated prefixes.
+func TestInvalidBranchIPPrefix(t *testing.T) {
+	for _, netConfig := range []string{
+		// Not a delegated prefix length.
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPPrefix":"10.11.12.0/24", "branchGatewayIPAddress":"10.11.0.1", "interfaceType":"vlan"}`,
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPPrefix":"2600:1f13:a0d:a700::/64", "interfaceType":"vlan"}`,
+		// Host bits set.
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPPrefix":"10.11.12.17/28", "branchGatewayIPAddress":"10.11.0.1", "interfaceType":"vlan"}`,
+		// Combined with a branch IP address of the same family.
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPPrefix":"10.11.12.16/28", "branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`,
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPPrefix":"2600:1f13:a0d:a700:42::/80", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "interfaceType":"vlan"}`,
+		// No gateway can be derived from a host address.
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "branchIPPrefix":"10.11.12.16/28", "interfaceType":"vlan"}`,
+	} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(netConfig)})
+		assert.Error(t, err, netConfig)
+	}
+}
+
+// TestGenerateMACFromIP tests that the branch MAC address is derived deterministically from the
+// branch IP address when no explicit one is specified.
+func TestGenerateMACFromIP(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "generateMACFromIP":true,
+			"branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "02:00:0a:0b:0c:0d", nc.BranchMACAddress.String())
+
+	// The same IP address yields the same MAC address.
+	nc2, err := New(args)
+	require.NoError(t, err)
+	assert.Equal(t, nc.BranchMACAddress, nc2.BranchMACAddress)
+
+	// IPv6-only branches use the IPv6 address.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "generateMACFromIP":true,
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "interfaceType":"vlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "02:06:00:00:00:05", nc.BranchMACAddress.String())
+
+	// An explicit MAC address takes precedence.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "generateMACFromIP":true,
+		"branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	assert.Equal(t, "02:23:45:67:89:ab", nc.BranchMACAddress.String())
+
+	// There must be an IP address to derive the MAC address from.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "generateMACFromIP":true,
+		"interfaceType":"vlan"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+}
+
+// TestDuplicateAddressDetection tests that duplicate address detection is supported only in VLAN
+// and MACVLAN modes.
+func TestDuplicateAddressDetection(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"branchIPAddress":"10.11.12.13/16", "duplicateAddressDetection":true, "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.True(t, nc.DuplicateAddrDetection)
+
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "duplicateAddressDetection":true, "interfaceType":"macvlan"}`)
+	nc, err = New(args)
+	require.NoError(t, err)
+	assert.True(t, nc.DuplicateAddrDetection)
+
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "duplicateAddressDetection":true, "interfaceType":"tap",
+		"uid":"0", "gid":"0"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+}
+
+// TestNetConfigFile tests that the network config read from a file parses identically to stdin.
+func TestNetConfigFile(t *testing.T) {
+	netConfig := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "routes":[{"dst":"10.20.0.0/16"}], "interfaceType":"vlan"}`
+	args := &skel.CmdArgs{StdinData: []byte(netConfig), IfName: "eth0"}
+	expected, err := New(args)
+	require.NoError(t, err)
+
+	file, err := ioutil.TempFile("", "vpc-branch-eni-netconf")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(netConfig)
+	require.NoError(t, err)
+	file.Close()
+
+	os.Setenv(envNetConfFile, file.Name())
+	defer os.Unsetenv(envNetConfFile)
+
+	// The file takes precedence over stdin.
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(`{}`), IfName: "eth0"})
+	require.NoError(t, err)
+	assert.Equal(t, expected, nc)
+
+	// A missing file is an error.
+	os.Setenv(envNetConfFile, file.Name()+"-nonexistent")
+	_, err = New(&skel.CmdArgs{StdinData: []byte(netConfig)})
+	assert.Error(t, err)
+}
+
+// TestTCPMSSClamp tests that TCP MSS clamping requires a known MTU in VLAN mode.
+func TestTCPMSSClamp(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"mtu":9001, "tcpMSSClamp":true, "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.True(t, nc.TCPMSSClamp)
+
+	// The MTU must be specified.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"tcpMSSClamp":true, "interfaceType":"vlan"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+
+	// There must be a container-facing interface in the netns.
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"mtu":9001, "tcpMSSClamp":true, "interfaceType":"macvtap", "uid":"0", "gid":"0"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+}
+
+// TestEgressOnly tests that blocking inbound connections is supported only in VLAN mode.
+func TestEgressOnly(t *testing.T) {
+	args := &skel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"egressOnly":true, "interfaceType":"vlan"}`),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	assert.True(t, nc.EgressOnly)
+
+	args.StdinData = []byte(`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"egressOnly":true, "interfaceType":"tap", "uid":"0", "gid":"0"}`)
+	_, err = New(args)
+	assert.Error(t, err)
+}
+
+// TestNumericFields tests that the numeric fields accept both JSON string and number forms.
+func TestNumericFields(t *testing.T) {
+	for _, netConfig := range []string{
+		`{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"mtu":"9001", "uid":"42", "gid":"43", "interfaceType":"tap"}`,
+		`{"trunkName":"eth1", "branchVlanID":100, "branchMACAddress":"02:23:45:67:89:ab",
+			"mtu":9001, "uid":42, "gid":43, "interfaceType":"tap"}`,
+	} {
+		nc, err := New(&skel.CmdArgs{StdinData: []byte(netConfig)})
+		require.NoError(t, err, netConfig)
+		assert.Equal(t, 100, nc.BranchVlanID)
+		assert.Equal(t, 9001, nc.MTU)
+		assert.Equal(t, 42, nc.Tap.Uid)
+		assert.Equal(t, 43, nc.Tap.Gid)
+	}
+
+	for _, netConfig := range []string{
+		`{"trunkName":"eth1", "branchVlanID":true, "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		`{"trunkName":"eth1", "branchVlanID":100.5, "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`,
+		`{"trunkName":"eth1", "branchVlanID":100, "branchMACAddress":"02:23:45:67:89:ab", "mtu":"jumbo", "interfaceType":"vlan"}`,
+		`{"trunkName":"eth1", "branchVlanID":100, "branchMACAddress":"02:23:45:67:89:ab", "mtu":9001.5, "interfaceType":"vlan"}`,
+		`{"trunkName":"eth1", "branchVlanID":100, "branchMACAddress":"02:23:45:67:89:ab", "uid":[42], "gid":43, "interfaceType":"tap"}`,
+	} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(netConfig)})
+		assert.Error(t, err, netConfig)
+	}
+}
+
+// TestConnMark tests that the firewall mark must fit in 32 bits and is supported only in VLAN mode.
+func TestConnMark(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"connmark":%d, "interfaceType":"%s", "uid":"0", "gid":"0"}`
+
+	for connMark, expected := range map[int64]uint32{
+		0:          0,
+		1:          1,
+		4294967295: math.MaxUint32,
+	} {
+		nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, connMark, "vlan"))})
+		require.NoError(t, err, connMark)
+		assert.Equal(t, expected, nc.ConnMark)
+	}
+
+	for _, connMark := range []int64{-1, 4294967296} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, connMark, "vlan"))})
+		assert.Error(t, err, connMark)
+	}
+
+	_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, 1, "tap"))})
+	assert.Error(t, err)
+}
+
+// TestIPAM tests that an IPAM plugin cannot be combined with static branch IP addresses.
+func TestIPAM(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"ipam":{"type":"host-local"}%s, "interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ""))})
+	require.NoError(t, err)
+	assert.Equal(t, "host-local", nc.IPAM.Type)
+
+	for _, static := range []string{
+		`, "branchIPAddress":"10.11.12.13/16"`,
+		`, "branchIPAddresses":["10.11.12.13/16"]`,
+		`, "branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+		`, "branchIPPrefix":"10.11.12.16/28"`,
+	} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, static))})
+		assert.Error(t, err, static)
+	}
+}
+
+// TestVerifyGatewayReachable tests that gateway pings require a VLAN interface with a gateway.
+func TestVerifyGatewayReachable(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		%s"verifyGatewayReachable":true, "interfaceType":"%s", "uid":"0", "gid":"0"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"branchIPAddress":"10.11.12.13/16", `, "vlan"))})
+	require.NoError(t, err)
+	assert.True(t, nc.VerifyGatewayReachable)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"ipam":{"type":"host-local"}, `, "vlan"))})
+	assert.NoError(t, err)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "vlan"))})
+	assert.EqualError(t, err, "verifyGatewayReachable requires a branch gateway IP address")
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"branchIPAddress":"10.11.12.13/16", `, "tap"))})
+	assert.Error(t, err)
+}
+
+// TestDisableRPFilter tests that reverse path filtering is left enabled by default.
+func TestDisableRPFilter(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ""))})
+	require.NoError(t, err)
+	assert.False(t, nc.DisableRPFilter)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"disableRPFilter":true, `))})
+	require.NoError(t, err)
+	assert.True(t, nc.DisableRPFilter)
+}
+
+// TestDisableIPv6Autoconf tests that IPv6 autoconfiguration is left enabled by default.
+func TestDisableIPv6Autoconf(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", %s"interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ""))})
+	require.NoError(t, err)
+	assert.False(t, nc.DisableIPv6Autoconf)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"disableIPv6Autoconf":true, `))})
+	require.NoError(t, err)
+	assert.True(t, nc.DisableIPv6Autoconf)
+}
+
+// TestDisableICMPRedirects tests that ICMP redirects are left enabled by default.
+func TestDisableICMPRedirects(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ""))})
+	require.NoError(t, err)
+	assert.False(t, nc.DisableICMPRedirects)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"disableICMPRedirects":true, `))})
+	require.NoError(t, err)
+	assert.True(t, nc.DisableICMPRedirects)
+}
+
+// TestUidGidInterfaceType tests that UID and GID are required for TAP interfaces, and ignored
+// for other interface types unless the configuration is strict.
+func TestUidGidInterfaceType(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		%s"interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"uid":"42", "gid":"42", `, "vlan"))})
+	require.NoError(t, err)
+	assert.Nil(t, nc.Tap)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"uid":"42", "strictConfig":true, `, "vlan"))})
+	assert.EqualError(t, err, "uid is not supported with interfaceType vlan")
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"uid":"42", "gid":"42", "strictConfig":true, `, "macvlan"))})
+	assert.Error(t, err)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"gid":"42", `, "tap"))})
+	assert.EqualError(t, err, "missing required parameter uid")
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"uid":"42", "gid":"42", "strictConfig":true, `, "tap"))})
+	require.NoError(t, err)
+	assert.Equal(t, 42, nc.Tap.Uid)
+}
+
+// TestGratuitousARPCount tests that branch interfaces announce their addresses once by default,
+// and that other interface types do not announce them.
+func TestGratuitousARPCount(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "uid":"0", "gid":"0", %s"interfaceType":"%s"}`
+
+	for interfaceType, expected := range map[string]int{"vlan": 1, "macvlan": 1, "tap": 0, "macvtap": 0} {
+		nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", interfaceType))})
+		require.NoError(t, err, interfaceType)
+		assert.Equal(t, expected, nc.GratuitousARPCount, interfaceType)
+
+		nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"gratuitousARPCount":0, `, interfaceType))})
+		require.NoError(t, err, interfaceType)
+		assert.Zero(t, nc.GratuitousARPCount, interfaceType)
+	}
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"gratuitousARPCount":3, `, "vlan"))})
+	require.NoError(t, err)
+	assert.Equal(t, 3, nc.GratuitousARPCount)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"gratuitousARPCount":-1, `, "vlan"))})
+	assert.Error(t, err)
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"gratuitousARPCount":2, `, "tap"))})
+	assert.Error(t, err)
+}
+
+// TestFirewallBackend tests that the firewall backend is iptables by default, and that only known
+// backends are accepted.
+func TestFirewallBackend(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ""))})
+	require.NoError(t, err)
+	assert.Equal(t, firewall.BackendIPTables, nc.FirewallBackend)
+
+	for _, backend := range []string{firewall.BackendAuto, firewall.BackendIPTables, firewall.BackendNFTables} {
+		nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+			fmt.Sprintf(`"firewallBackend":"%s", `, backend)))})
+		require.NoError(t, err, backend)
+		assert.Equal(t, backend, nc.FirewallBackend)
+	}
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"firewallBackend":"ebtables", `))})
+	assert.Error(t, err)
+}
+
+// TestAddressScope tests that branch IP addresses have global scope by default, and that only
+// known scopes are accepted.
+func TestAddressScope(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"169.254.10.1/16", %s"interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ""))})
+	require.NoError(t, err)
+	assert.Equal(t, AddressScopeGlobal, nc.AddressScope)
+
+	for scope, expected := range map[string]string{
+		"global": AddressScopeGlobal,
+		"Link":   AddressScopeLink,
+		"host":   AddressScopeHost,
+	} {
+		nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+			fmt.Sprintf(`"addressScope":"%s", `, scope)))})
+		require.NoError(t, err, scope)
+		assert.Equal(t, expected, nc.AddressScope)
+	}
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"addressScope":"site", `))})
+	assert.Error(t, err)
+}
+
+// TestManagementIPAddress tests the parsing of the management IP address and its scope.
+func TestManagementIPAddress(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"managementIPAddress":"169.254.100.1", `, "vlan"))})
+	require.NoError(t, err)
+	assert.Equal(t, "169.254.100.1/32", nc.ManagementIPAddress.String())
+	assert.Equal(t, ManagementIPScopeLink, nc.ManagementIPScope)
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String())
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"managementIPAddress":"10.11.200.1/16", "managementIPAddressScope":"Host", `, "vlan"))})
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.200.1/16", nc.ManagementIPAddress.String())
+	assert.Equal(t, ManagementIPScopeHost, nc.ManagementIPScope)
+
+	for _, invalid := range []string{
+		`"managementIPAddress":"10.11.12.13", `,
+		`"managementIPAddress":"10.11.12.13/32", `,
+		`"managementIPAddress":"10.11.12", `,
+		`"managementIPAddress":"169.254.100.1", "managementIPAddressScope":"global", `,
+		`"managementIPAddressScope":"host", `,
+	} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, invalid, "vlan"))})
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"managementIPAddress":"169.254.100.1", "uid":"0", "gid":"0", `, "tap"))})
+	assert.Error(t, err)
+}
+
+// TestStateFile tests the expansion of the state file path template.
+func TestStateFile(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"stateFile":"%s", "interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{
+		ContainerID: "7b6ec2a3d5f4",
+		IfName:      "eth2",
+		StdinData:   []byte(fmt.Sprintf(netConfigFmt, "/var/lib/vpc-branch-eni/{containerID}-{ifname}.json")),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/vpc-branch-eni/7b6ec2a3d5f4-eth2.json", nc.StateFilePath)
+
+	for _, template := range []string{"/var/lib/vpc-branch-eni/state.json", "state/{containerID}.json"} {
+		_, err = New(&skel.CmdArgs{
+			ContainerID: "7b6ec2a3d5f4",
+			StdinData:   []byte(fmt.Sprintf(netConfigFmt, template)),
+		})
+		assert.Error(t, err, template)
+	}
+
+	// The container ID must be known and usable in a path.
+	for _, containerID := range []string{"", "../7b6ec2a3d5f4"} {
+		_, err = New(&skel.CmdArgs{
+			ContainerID: containerID,
+			StdinData:   []byte(fmt.Sprintf(netConfigFmt, "/var/lib/vpc-branch-eni/{containerID}.json")),
+		})
+		assert.Error(t, err, containerID)
+	}
+}
+
+// TestVlanQoSMaps tests the parsing and validation of the VLAN QoS maps.
+func TestVlanQoSMaps(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		%s, "interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"vlanEgressQoSMap":{"0":1, "1000":7}, "vlanIngressQoSMap":{"7":1000}`, "vlan"))})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]uint32{0: 1, 1000: 7}, nc.VlanEgressQoSMap)
+	assert.Equal(t, map[uint32]uint32{7: 1000}, nc.VlanIngressQoSMap)
+
+	for _, invalid := range []string{
+		`"vlanEgressQoSMap":{"0":8}`,
+		`"vlanEgressQoSMap":{"high":1}`,
+		`"vlanEgressQoSMap":{"0":-1}`,
+		`"vlanIngressQoSMap":{"8":0}`,
+	} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, invalid, "vlan"))})
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"vlanEgressQoSMap":{"0":1}`, "macvlan"))})
+	assert.Error(t, err)
+}
+
+// TestTAPExternallyManaged tests that externally managed TAP devices still require UID and GID.
+func TestTAPExternallyManaged(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		%s"tapDeviceExternallyManaged":true, "interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"uid":"42", "gid":"43", `, "tap"))})
+	require.NoError(t, err)
+	assert.True(t, nc.Tap.ExternallyManaged)
+	assert.Equal(t, 42, nc.Tap.Uid)
+	assert.Equal(t, 43, nc.Tap.Gid)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "tap"))})
+	assert.Error(t, err)
+
+	for _, interfaceType := range []string{"macvtap", "vlan"} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"uid":"42", "gid":"43", `, interfaceType))})
+		assert.Error(t, err, interfaceType)
+	}
+}
+
+// TestMACVLAN tests that MACVLAN interfaces default to bridge mode and do not require a VLAN ID.
+func TestMACVLAN(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"%s"}`
+
+	for mode, expected := range map[string]string{
+		``:                          MACVLANModeBridge,
+		`"macvlanMode":"bridge", `:  MACVLANModeBridge,
+		`"macvlanMode":"Private", `: MACVLANModePrivate,
+		`"macvlanMode":"vepa", `:    MACVLANModeVEPA,
+		`"branchVlanID":"100", `:    MACVLANModeBridge,
+	} {
+		nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, mode, "macvlan"))})
+		require.NoError(t, err, mode)
+		assert.Equal(t, IfTypeMACVLAN, nc.InterfaceType)
+		assert.Equal(t, expected, nc.MACVLANMode, mode)
+		assert.Equal(t, 0, nc.BranchVlanID, mode)
+	}
+
+	_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"macvlanMode":"passthru", `, "macvlan"))})
+	assert.Error(t, err)
+
+	// VLAN interfaces still require a VLAN ID, and do not accept a MACVLAN mode.
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, ``, "vlan"))})
+	assert.EqualError(t, err, "missing required parameter branchVlanID")
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"branchVlanID":"100", "macvlanMode":"bridge", `, "vlan"))})
+	assert.Error(t, err)
+}
+
+// TestStaticNeighbors tests the parsing and validation of static neighbor entries.
+func TestStaticNeighbors(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"staticNeighbors":%s, "interfaceType":"%s", "uid":"0", "gid":"0"}`
+
+	args := &skel.CmdArgs{
+		StdinData: []byte(fmt.Sprintf(netConfigFmt, `[{"ip":"10.11.0.1", "mac":"02:00:00:00:00:01"}]`, "vlan")),
+	}
+	nc, err := New(args)
+	require.NoError(t, err)
+	require.Len(t, nc.StaticNeighbors, 1)
+	assert.Equal(t, "10.11.0.1", nc.StaticNeighbors[0].IPAddress.String())
+	assert.Equal(t, "02:00:00:00:00:01", nc.StaticNeighbors[0].MACAddress.String())
+
+	for neighbors, expectedErr := range map[string]string{
+		`[{"ip":"10.11.0", "mac":"02:00:00:00:00:01"}]`: "invalid static neighbor ip 10.11.0",
+		`[{"ip":"10.11.0.1", "mac":"02:00:00:00:01"}]`:  "invalid static neighbor mac 02:00:00:00:01 for ip 10.11.0.1",
+		`[{"ip":"10.11.0.1"}]`:                          "missing static neighbor mac for ip 10.11.0.1",
+		`[{"ip":"10.11.0.1", "mac":"02:00:00:00:00:01"}, {"ip":"10.11.0.1", "mac":"02:00:00:00:00:02"}]`: "duplicate static neighbor ip 10.11.0.1",
+	} {
+		args.StdinData = []byte(fmt.Sprintf(netConfigFmt, neighbors, "vlan"))
+		_, err = New(args)
+		require.Error(t, err, neighbors)
+		assert.Equal(t, expectedErr, err.Error(), neighbors)
+	}
+
+	args.StdinData = []byte(fmt.Sprintf(netConfigFmt, `[{"ip":"10.11.0.1", "mac":"02:00:00:00:00:01"}]`, "tap"))
+	_, err = New(args)
+	assert.Error(t, err)
+}
+
+// TestHostInterfaceNameTemplate tests the expansion of host interface name templates.
+func TestHostInterfaceNameTemplate(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:23:45:67:89:ab",
+		"hostInterfaceNameTemplate":"%s", "interfaceType":"vlan"}`
+	containerID := "0123456789abcdef0123456789abcdef"
+
+	for template, expected := range map[string]string{
+		"br-{vlan}":       "br-101",
+		"br-{cid8}":       "br-01234567",
+		"{cid8}.{vlan}":   "01234567.101",
+		"branch{vlan}":    "branch101",
+		"br{vlan}-{cid8}": "br101-01234567",
+		"static-name":     "static-name",
+	} {
+		args := &skel.CmdArgs{
+			ContainerID: containerID,
+			StdinData:   []byte(fmt.Sprintf(netConfigFmt, template)),
+		}
+		nc, err := New(args)
+		require.NoError(t, err, template)
+		assert.Equal(t, expected, nc.HostInterfaceName, template)
+	}
+
+	for _, template := range []string{
+		// Too long once expanded.
+		"branch-{vlan}-{cid8}",
+		// Unknown template.
+		"br-{trunk}",
+	} {
+		args := &skel.CmdArgs{
+			ContainerID: containerID,
+			StdinData:   []byte(fmt.Sprintf(netConfigFmt, template)),
+		}
+		_, err := New(args)
+		assert.Error(t, err, template)
+	}
+
+	// The container ID must be known.
+	_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "br-{cid8}"))})
+	assert.Error(t, err)
+
+	// MACVLAN branches have no VLAN ID to expand.
+	macvlanFmt := `{"trunkName":"eth1", "branchMACAddress":"02:23:45:67:89:ab",
+		"hostInterfaceNameTemplate":"%s", "interfaceType":"macvlan"}`
+	nc, err := New(&skel.CmdArgs{ContainerID: containerID, StdinData: []byte(fmt.Sprintf(macvlanFmt, "mv-{cid8}"))})
+	require.NoError(t, err)
+	assert.Equal(t, "mv-01234567", nc.HostInterfaceName)
+
+	_, err = New(&skel.CmdArgs{ContainerID: containerID, StdinData: []byte(fmt.Sprintf(macvlanFmt, "mv-{vlan}"))})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "{vlan} is not supported with interfaceType macvlan")
+}
+
+// TestBringUpAfterConfig tests that bringUpAfterConfig is parsed, and rejected where unsupported.
+func TestBringUpAfterConfig(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "vlan"))})
+	require.NoError(t, err)
+	assert.False(t, nc.BringUpAfterConfig)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"bringUpAfterConfig":true, `, "vlan"))})
+	require.NoError(t, err)
+	assert.True(t, nc.BringUpAfterConfig)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"bringUpAfterConfig":true, "uid":"0", "gid":"0", `, "tap"))})
+	assert.Error(t, err)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"bringUpAfterConfig":true, "duplicateAddressDetection":true, `, "vlan"))})
+	assert.Error(t, err)
+}
+
+// TestDHCPIPAM tests that DHCP leases are accepted only with branch interfaces, and not along
+// with static branch IP addresses.
+func TestDHCPIPAM(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		%s"ipam":{"type":"dhcp"}, "interfaceType":"%s"}`
+
+	for _, interfaceType := range []string{"vlan", "macvlan"} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", interfaceType))})
+		assert.NoError(t, err, interfaceType)
+	}
+
+	for _, params := range []string{
+		`"branchIPAddress":"10.11.12.13/16", `, `"branchIPv6Address":"2600:1f13:a0d:a700::5/64", `,
+		`"bringUpAfterConfig":true, `,
+	} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, params, "vlan"))})
+		assert.Error(t, err, params)
+	}
+
+	_, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"uid":"0", "gid":"0", `, "tap"))})
+	assert.Error(t, err)
+}
+
+// TestDualStackGateways tests that the IPv4 and IPv6 gateways are each specified or derived
+// independently, and that a gateway that cannot be derived for one address family does not
+// prevent the setup of the other.
+func TestDualStackGateways(t *testing.T) {
+	testCases := []struct {
+		name            string
+		addresses       string
+		expectedGateway string
+		expectedIPv6GW  string
+	}{
+		{
+			name:            "both derived",
+			addresses:       `"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+			expectedGateway: "10.11.0.1",
+			expectedIPv6GW:  "2600:1f13:a0d:a700::1",
+		},
+		{
+			name: "explicit IPv4, derived IPv6",
+			addresses: `"branchIPAddress":"10.11.12.13/16", "branchGatewayIPAddress":"10.11.0.2",
+				"branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+			expectedGateway: "10.11.0.2",
+			expectedIPv6GW:  "2600:1f13:a0d:a700::1",
+		},
+		{
+			name: "derived IPv4, explicit IPv6",
+			addresses: `"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64",
+				"branchGatewayIPv6Address":"fe80::1"`,
+			expectedGateway: "10.11.0.1",
+			expectedIPv6GW:  "fe80::1",
+		},
+		{
+			name:            "IPv6 gateway not derivable",
+			addresses:       `"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/128"`,
+			expectedGateway: "10.11.0.1",
+		},
+		{
+			name:           "IPv4 gateway not derivable",
+			addresses:      `"branchIPAddress":"10.11.12.13/32", "branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+			expectedIPv6GW: "2600:1f13:a0d:a700::1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nc, err := New(&skel.CmdArgs{StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100",
+				"branchMACAddress":"02:23:45:67:89:ab", ` + tc.addresses + `, "interfaceType":"vlan"}`)})
+			require.NoError(t, err)
+
+			if tc.expectedGateway == "" {
+				assert.Nil(t, nc.BranchGatewayIPAddress)
+				assert.Empty(t, nc.BranchGatewayIPAddresses)
+			} else {
+				assert.Equal(t, tc.expectedGateway, nc.BranchGatewayIPAddress.String())
+			}
+			if tc.expectedIPv6GW == "" {
+				assert.Nil(t, nc.BranchGatewayIPv6Address)
+			} else {
+				assert.Equal(t, tc.expectedIPv6GW, nc.BranchGatewayIPv6Address.String())
+			}
+		})
+	}
+}
+
+// TestDualStackGatewayErrors tests that invalid explicit gateways are rejected even when the
+// gateway of the other address family is valid.
+func TestDualStackGatewayErrors(t *testing.T) {
+	for _, addresses := range []string{
+		// IPv6 gateway given as the IPv4 gateway, and vice versa.
+		`"branchIPAddress":"10.11.12.13/16", "branchGatewayIPAddress":"fe80::1", "branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+		`"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "branchGatewayIPv6Address":"10.11.0.1"`,
+		// IPv4 gateway outside of the branch subnet.
+		`"branchIPAddress":"10.11.12.13/16", "branchGatewayIPAddress":"10.12.0.1", "branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+		// Explicit gateway position outside of the branch subnet.
+		`"branchIPAddress":"10.11.12.13/30", "gatewayPosition":"10", "branchIPv6Address":"2600:1f13:a0d:a700::5/64"`,
+		// Single-stack branches still require a gateway.
+		`"branchIPv6Address":"2600:1f13:a0d:a700::5/128"`,
+		`"branchIPAddress":"10.11.12.13/32"`,
+	} {
+		_, err := New(&skel.CmdArgs{StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100",
+			"branchMACAddress":"02:23:45:67:89:ab", ` + addresses + `, "interfaceType":"vlan"}`)})
+		assert.Error(t, err, addresses)
+	}
+}
+
+// TestAdoptInterface tests that an interface to adopt is identified by name or MAC address, and
+// that it cannot be combined with the parameters of a VLAN link created on a trunk.
+func TestAdoptInterface(t *testing.T) {
+	netConfigFmt := `{%s"adoptInterface":"%s", "branchIPAddress":"10.11.12.13/16", "interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "eth5", "vlan"))})
+	require.NoError(t, err)
+	assert.Equal(t, "eth5", nc.AdoptInterfaceName)
+	assert.Nil(t, nc.AdoptInterfaceMAC)
+	assert.Nil(t, nc.BranchMACAddress)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "02:23:45:67:89:ab", "vlan"))})
+	require.NoError(t, err)
+	assert.Empty(t, nc.AdoptInterfaceName)
+	assert.Equal(t, "02:23:45:67:89:ab", nc.AdoptInterfaceMAC.String())
+	assert.Equal(t, "02:23:45:67:89:ab", nc.BranchMACAddress.String())
+
+	for _, adoptInterface := range []string{"eth5-adopted-interface", "01:23:45:67:89:ab", "00:00:00:00:00:00"} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", adoptInterface, "vlan"))})
+		assert.Error(t, err, adoptInterface)
+	}
+
+	for _, params := range []string{
+		`"trunkName":"eth1", `, `"trunkMACAddress":"02:23:45:67:89:ac", `, `"trunkPCIAddress":"0000:00:05.0", `,
+		`"trunkInterfaceIndex":1, `, `"branchVlanID":"100", `, `"branchMACAddress":"02:23:45:67:89:ab", `, `"generateMACFromIP":true, `,
+		`"vlanEgressQoSMap":{"1":2}, `, `"hostInterfaceNameTemplate":"vlan{vlan}", `, `"proxyARP":true, `,
+	} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, params, "eth5", "vlan"))})
+		assert.Error(t, err, params)
+	}
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "eth5", "macvlan"))})
+	assert.Error(t, err)
+}
+
+// TestHairpin tests that hairpin mode is supported with all interface types but MACVTAP, and not
+// with externally managed TAP devices.
+func TestHairpin(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "uid":"0", "gid":"0", %s"interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "vlan"))})
+	require.NoError(t, err)
+	assert.False(t, nc.Hairpin)
+
+	for _, interfaceType := range []string{"vlan", "macvlan", "tap"} {
+		nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"hairpin":true, `, interfaceType))})
+		require.NoError(t, err, interfaceType)
+		assert.True(t, nc.Hairpin, interfaceType)
+	}
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"hairpin":true, `, "macvtap"))})
+	assert.Error(t, err)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"hairpin":true, "tapDeviceExternallyManaged":true, `, "tap"))})
+	assert.Error(t, err)
+
+	// Branch interfaces hairpin via the loopback link, unlike TAP links.
+	for _, interfaceType := range []string{"vlan", "macvlan"} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+			`"hairpin":true, "configureLoopback":false, `, interfaceType))})
+		require.Error(t, err, interfaceType)
+		assert.Contains(t, err.Error(), "hairpin requires configureLoopback", interfaceType)
+	}
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"hairpin":true, "configureLoopback":false, `, "tap"))})
+	assert.NoError(t, err)
+}
+
+// TestTCBPFProgram tests that the eBPF tc program cannot be combined with an ingress bandwidth
+// limit, and that it is not required to exist until it is loaded on ADD.
+func TestTCBPFProgram(t *testing.T) {
+	prog, err := ioutil.TempFile("", "vpc-branch-eni-tcbpf")
+	require.NoError(t, err)
+	prog.Close()
+	defer os.Remove(prog.Name())
+
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"tcBPFProgram":"%s", "interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", prog.Name()))})
+	require.NoError(t, err)
+	assert.Equal(t, prog.Name(), nc.TCBPFProgram)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", prog.Name()+".nonexistent"))})
+	assert.NoError(t, err)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"ingressBandwidthLimit":"10m", `,
+		prog.Name()))})
+	assert.Error(t, err)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"egressBandwidthLimit":"10m", `,
+		prog.Name()))})
+	assert.NoError(t, err)
+}
+
+// TestTrunkInterfaceIndex tests that the trunk can be identified by its device index, and only
+// by a single trunk identifier.
+func TestTrunkInterfaceIndex(t *testing.T) {
+	netConfigFmt := `{%s"branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"trunkInterfaceIndex":2, `))})
+	require.NoError(t, err)
+	require.NotNil(t, nc.TrunkInterfaceIndex)
+	assert.Equal(t, 2, *nc.TrunkInterfaceIndex)
+	assert.Empty(t, nc.TrunkName)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `"trunkInterfaceIndex":0, `))})
+	require.NoError(t, err)
+	require.NotNil(t, nc.TrunkInterfaceIndex)
+	assert.Equal(t, 0, *nc.TrunkInterfaceIndex)
+
+	for _, params := range []string{
+		`"trunkInterfaceIndex":-1, `,
+		`"trunkInterfaceIndex":1, "trunkName":"eth1", `,
+		`"trunkInterfaceIndex":1, "trunkMACAddress":"02:23:45:67:89:ac", `,
+	} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, params))})
+		assert.Error(t, err, params)
+	}
+}
+
+// TestStrictMode tests that unknown keys are ignored by default, and rejected in strict mode, which
+// is enabled by either the strictConfig key or the environment.
+func TestStrictMode(t *testing.T) {
+	netConfigFmt := `{"cniVersion":"1.0.0", "name":"test", "type":"vpc-branch-eni", "trunkName":"eth1",
+		"%s":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"ipam":{"type":"host-local", "subnet":"10.11.0.0/16"}, "runtimeConfig":{"portMappings":[]},
+		"interfaceType":"vlan"}`
+	typo := &skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "branchVlandID"))}
+	valid := &skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "branchVlanID"))}
+
+	// The misspelled key is ignored, and the missing one reported instead.
+	_, err := New(typo)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "branchVlandID")
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`strictConfig":true, "branchVlandID`))})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown field "branchVlandID"`)
+
+	os.Setenv(envStrict, "1")
+	defer os.Unsetenv(envStrict)
+
+	_, err = New(typo)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown field "branchVlandID"`)
+
+	nc, err := New(valid)
+	require.NoError(t, err)
+	assert.Equal(t, 100, nc.BranchVlanID)
+
+	// Parameters that have no effect with the interface type are rejected too.
+	_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, `uid":"42", "branchVlanID`))})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "uid is not supported with interfaceType vlan")
+}
+
+// TestTrunkPromiscuous tests that trunkPromiscuous is disabled by default, and rejected for
+// adopted interfaces, which have no trunk.
+func TestTrunkPromiscuous(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", %s"interfaceType":"vlan"}`
+	newArgs := func(params string) *skel.CmdArgs {
+		return &skel.CmdArgs{ContainerID: "7b6ec2a3d5f4", StdinData: []byte(fmt.Sprintf(netConfigFmt, params))}
+	}
+
+	nc, err := New(newArgs(""))
+	require.NoError(t, err)
+	assert.False(t, nc.TrunkPromiscuous)
+
+	nc, err = New(newArgs(`"trunkPromiscuous":true, `))
+	require.NoError(t, err)
+	assert.True(t, nc.TrunkPromiscuous)
+
+	// Adopted interfaces have no trunk.
+	_, err = New(&skel.CmdArgs{ContainerID: "7b6ec2a3d5f4", StdinData: []byte(`{"adoptInterface":"eth5",
+		"branchIPAddress":"10.11.12.13/16", "trunkPromiscuous":true, "interfaceType":"vlan"}`)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "adoptInterface cannot be combined with trunkPromiscuous")
+}
+
+// TestBringUpTrunk tests that bringUpTrunk is disabled by default, and rejected for adopted
+// interfaces, which have no trunk.
+func TestBringUpTrunk(t *testing.T) {
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`)})
+	require.NoError(t, err)
+	assert.False(t, nc.BringUpTrunk)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "bringUpTrunk":true,
+		"interfaceType":"vlan"}`)})
+	require.NoError(t, err)
+	assert.True(t, nc.BringUpTrunk)
+
+	_, err = New(&skel.CmdArgs{StdinData: []byte(`{"adoptInterface":"eth5", "branchIPAddress":"10.11.12.13/16",
+		"bringUpTrunk":true, "interfaceType":"vlan"}`)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "adoptInterface cannot be combined with bringUpTrunk")
+}
+
+// TestDNSStub tests that dnsStub requires an IPv4 nameserver, and is rejected where unsupported.
+func TestDNSStub(t *testing.T) {
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "uid":"0", "gid":"0", %s"interfaceType":"%s"}`
+
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, "", "vlan"))})
+	require.NoError(t, err)
+	assert.False(t, nc.DNSStub)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt,
+		`"dnsStub":true, "dns":{"nameservers":["169.254.169.253"]}, `, "vlan"))})
+	require.NoError(t, err)
+	assert.True(t, nc.DNSStub)
+
+	for _, tc := range []struct {
+		params        string
+		interfaceType string
+	}{
+		{`"dnsStub":true, `, "vlan"},
+		{`"dnsStub":true, "dns":{"nameservers":["2600:1f13:a0d:a700::2"]}, `, "vlan"},
+		{`"dnsStub":true, "dns":{"nameservers":["169.254.169.253"]}, "routeTableID":100, `, "vlan"},
+		{`"dnsStub":true, "dns":{"nameservers":["169.254.169.253"]}, `, "tap"},
+	} {
+		_, err = New(&skel.CmdArgs{StdinData: []byte(fmt.Sprintf(netConfigFmt, tc.params, tc.interfaceType))})
+		assert.Error(t, err, tc.params)
+	}
+}
+
+// TestConfigureLoopback tests that the loopback link is configured unless explicitly disabled.
+func TestConfigureLoopback(t *testing.T) {
+	nc, err := New(&skel.CmdArgs{StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`)})
+	require.NoError(t, err)
+	assert.True(t, nc.ConfigureLoopback)
+
+	nc, err = New(&skel.CmdArgs{StdinData: []byte(`{"trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16", "configureLoopback":false,
+		"interfaceType":"vlan"}`)})
+	require.NoError(t, err)
+	assert.False(t, nc.ConfigureLoopback)
 }
@@ -16,6 +16,7 @@
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -25,6 +26,8 @@ import (
 	"time"
 
 	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/plugin"
 	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -51,7 +54,8 @@ const (
 	"branchMACAddress": "%s",
 	"branchIPAddress": "%s",
 	"branchGatewayIPAddress": "%s",
-	"interfaceType": "vlan"
+	"interfaceType": "vlan",
+	"bringUpTrunk": true
 }
 `
 	netConfJsonFmtBlockIMDS = `
@@ -76,8 +80,8 @@ func TestAddDelBlockIMDS(t *testing.T) {
 func TestAddDel(t *testing.T) {
 	var err error
 
-	// Bring down the trunk interface so that we can ensure the plugin is not assuming the trunk interface
-	// is already brought up.
+	// Bring down the trunk interface so that we can ensure the plugin brings it up with bringUpTrunk,
+	// instead of assuming the trunk interface is already brought up.
 	la := netlink.NewLinkAttrs()
 	la.Name = trunkName
 	link := &netlink.Dummy{LinkAttrs: la}
@@ -139,9 +143,11 @@ func testAddDel(t *testing.T, netConfJsonFmt string, validateAfterAddFunc, valid
 	// Execute the "ADD" command for the plugin.
 	execInvokeArgs.Command = "ADD"
 	err = invoke.ExecPluginWithoutResult(
+		context.TODO(),
 		pluginPath,
 		netConf,
-		execInvokeArgs)
+		execInvokeArgs,
+		nil)
 	require.NoError(t, err, "Unable to execute ADD command for vpc-branch-eni cni plugin")
 
 	targetNS.Run(func() error {
@@ -152,9 +158,11 @@ func testAddDel(t *testing.T, netConfJsonFmt string, validateAfterAddFunc, valid
 	// Execute the "DEL" command for the plugin.
 	execInvokeArgs.Command = "DEL"
 	err = invoke.ExecPluginWithoutResult(
+		context.TODO(),
 		pluginPath,
 		netConf,
-		execInvokeArgs)
+		execInvokeArgs,
+		nil)
 	require.NoError(t, err, "Unable to execute DEL command for vpc-branch-eni cni plugin")
 
 	targetNS.Run(func() error {
@@ -163,6 +171,53 @@ func testAddDel(t *testing.T, netConfJsonFmt string, validateAfterAddFunc, valid
 	})
 }
 
+// TestLibraryAddDel tests the library entry points with a constructed NetConfig.
+func TestLibraryAddDel(t *testing.T) {
+	targetNS, err := netns.NewNetNS(nsName)
+	require.NoError(t, err,
+		"Unable to create the network namespace that represents the network namespace of the container")
+	defer targetNS.Close()
+
+	trunkMAC, _ := net.ParseMAC(trunkMACAddress)
+	branchMAC, _ := net.ParseMAC(branchMACAddress)
+	branchIP, branchPrefix, _ := net.ParseCIDR(branchIPAddress)
+	branchPrefix.IP = branchIP
+	vlanID, _ := strconv.Atoi(branchVlanID)
+
+	netConfig := &config.NetConfig{
+		TrunkMACAddress:        trunkMAC,
+		BranchVlanID:           vlanID,
+		VlanProtocol:           config.VlanProtocol8021Q,
+		BranchMACAddress:       branchMAC,
+		BranchIPAddress:        branchPrefix,
+		BranchIPAddresses:      []net.IPNet{*branchPrefix},
+		BranchGatewayIPAddress: net.ParseIP(branchGatewayIPAddress),
+		InstallDefaultRoute:    true,
+		InterfaceType:          config.IfTypeVLAN,
+		InterfaceName:          ifName,
+	}
+	netConfig.CNIVersion = "1.0.0"
+
+	result, err := plugin.Add(context.TODO(), containerID, targetNS.GetPath(), netConfig)
+	require.NoError(t, err, "Unable to execute library Add")
+	require.NotEmpty(t, result.Interfaces)
+	assert.Equal(t, ifName, result.Interfaces[0].Name)
+	assert.Equal(t, branchMACAddress, result.Interfaces[0].Mac)
+
+	targetNS.Run(func() error {
+		validateAfterAdd(t)
+		return nil
+	})
+
+	err = plugin.Del(context.TODO(), containerID, targetNS.GetPath(), netConfig)
+	require.NoError(t, err, "Unable to execute library Del")
+
+	targetNS.Run(func() error {
+		validateAfterDel(t)
+		return nil
+	})
+}
+
 func validateAfterAdd(t *testing.T) {
 	// When the branch link is just brought up and brought down by another test, there will be some
 	// delay before the same branch link is up again, even though the plugin brings it up.
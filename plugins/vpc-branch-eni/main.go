@@ -14,13 +14,56 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/plugin"
 )
 
 // main is the entry point for vpc-branch-eni plugin executable.
 func main() {
+	// List the branch links created by the plugin, optionally in the given netns.
+	if len(os.Args) > 1 && os.Args[1] == plugin.ListCommand {
+		err := plugin.List(os.Stdout, os.Args[2:])
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("Failed to list branch links: %v\n", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Print the plugin build metadata.
+	if len(os.Args) > 1 && isVersionCommand(os.Args[1]) {
+		err := runVersion(os.Args[2:])
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("Failed to print version: %v\n", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Delete the branch links of containers that are not live.
+	if len(os.Args) > 1 && os.Args[1] == plugin.GCCommand {
+		err := runGC(os.Args[2:])
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("Failed to delete orphaned branch links: %v\n", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Check that the host supports the links created by the plugin.
+	if len(os.Args) > 1 && os.Args[1] == plugin.SelfTestCommand {
+		err := plugin.SelfTest(os.Stdout)
+		if err != nil {
+			os.Stderr.WriteString(fmt.Sprintf("Self-test failed: %v\n", err))
+			os.Exit(1)
+		}
+		return
+	}
+
 	plugin, err := plugin.NewPlugin()
 	if err != nil {
 		os.Exit(1)
@@ -37,3 +80,44 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// isVersionCommand returns whether the given argument is the version subcommand, or the
+// equivalent -version or --version flag.
+func isVersionCommand(arg string) bool {
+	return strings.TrimLeft(arg, "-") == plugin.VersionCommand
+}
+
+// runVersion runs the version subcommand with the given arguments. The build metadata is
+// printed as JSON if the -json flag is set, and as text otherwise.
+func runVersion(args []string) error {
+	flags := flag.NewFlagSet(plugin.VersionCommand, flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the version as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	return plugin.PrintVersion(os.Stdout, *asJSON)
+}
+
+// runGC runs the gc subcommand with the given arguments. The live container IDs are read from
+// the file given by the -live flag, or from stdin by default. The remaining arguments are netns.
+func runGC(args []string) error {
+	flags := flag.NewFlagSet(plugin.GCCommand, flag.ContinueOnError)
+	livePath := flags.String("live", "-", "file listing the live container IDs, or - for stdin")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	live := os.Stdin
+	if *livePath != "-" {
+		live, err = os.Open(*livePath)
+		if err != nil {
+			return err
+		}
+		defer live.Close()
+	}
+
+	return plugin.GC(os.Stdout, live, flags.Args())
+}
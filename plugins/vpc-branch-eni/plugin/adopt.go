@@ -0,0 +1,259 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+	"github.com/aws/amazon-vpc-cni-plugins/network/eni"
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// Name given to an interface adopted by MAC address when it is moved back to the host
+	// network namespace and its original name is not known, from the last bytes of its MAC address.
+	adoptedLinkNameFormat = "eni%x"
+	adoptedLinkNameMACLen = 4
+)
+
+// isAdoptedInterface returns whether the branch link is an existing interface adopted into the
+// target network namespace, instead of a link created on a trunk.
+func isAdoptedInterface(netConfig *config.NetConfig) bool {
+	return netConfig.AdoptInterfaceName != "" || netConfig.AdoptInterfaceMAC != nil
+}
+
+// adoptInterface moves the interface to adopt from the host network namespace to the target
+// network namespace, where it is renamed and configured like a VLAN branch link. The interface
+// is moved back to the host network namespace if any of the remaining steps fail.
+func adoptInterface(
+	ctx context.Context,
+	containerID string,
+	netnsPath string,
+	ns netns.NetNS,
+	netConfig *config.NetConfig,
+	lease leaseFunc) (*cniTypesCurrent.Result, error) {
+
+	// Serialize the invocations adopting the same interface, so that only one of them moves it
+	// and the others find it.
+	lock, err := lockLink(ctx, getAdoptLockName(netConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+
+	// Find the interface to adopt in the host network namespace.
+	link, err := eni.NewENI(netConfig.AdoptInterfaceName, netConfig.AdoptInterfaceMAC)
+	if err != nil {
+		return nil, cni.NewError(cni.ErrCodeInvalidConfig, err)
+	}
+
+	err = link.AttachToLink()
+	if err != nil {
+		// The interface may have been adopted by a previous invocation of this plugin.
+		var exists bool
+		nsErr := runInNetNS(ns, func() error {
+			var err error
+			exists, err = findExistingVLANLink(netConfig.InterfaceName, netConfig)
+			return err
+		})
+		if nsErr != nil || !exists {
+			log.Errorf("Failed to find interface to adopt %v: %v.", link, err)
+			return nil, cni.NewError(cni.ErrCodeLinkCreation, err)
+		}
+
+		log.Infof("Adopted interface %s already exists with the requested configuration.", netConfig.InterfaceName)
+		if lease != nil {
+			err = runInNetNS(ns, lease)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newResult(netConfig.InterfaceName, netnsPath, netConfig), nil
+	}
+
+	// Record the interface, so that its original name and MAC address are in the result.
+	netConfig.AdoptInterfaceName = link.GetLinkName()
+	netConfig.AdoptInterfaceMAC = link.GetMACAddress()
+	if netConfig.BranchMACAddress == nil {
+		netConfig.BranchMACAddress = link.GetMACAddress()
+	}
+
+	// In dry-run mode, stop after validation without making any changes.
+	if isDryRun() {
+		log.Infof("Dry-run mode is enabled, skipping network setup.")
+		return newResult(netConfig.InterfaceName, netnsPath, netConfig), nil
+	}
+
+	hostNS, err := getHostNetNS()
+	if err != nil {
+		return nil, cni.NewError(cni.ErrCodeNetNS, err)
+	}
+	defer hostNS.Close()
+
+	// Roll back the changes made by this invocation if any of the remaining steps fail.
+	var rb rollback
+	defer rb.run()
+
+	// Move the interface to the network namespace.
+	hostName := link.GetLinkName()
+	log.Infof("Moving adopted interface %v to netns %s.", link, netnsPath)
+	err = trace(traceOpMoveLink, hostName+" "+netnsPath, func() error {
+		return link.SetNetNS(ns)
+	})
+	if err != nil {
+		log.Errorf("Failed to move adopted interface: %v.", err)
+		return nil, cni.NewError(cni.ErrCodeLinkCreation, err)
+	}
+	rb.add("adopted interface", func() error {
+		return ns.Run(func() error {
+			return releaseInterface(link.GetLinkName(), hostName, hostNS)
+		})
+	})
+
+	ifbName := fmt.Sprintf(ifbLinkNameFormat, netConfig.BranchVlanID)
+	rb.add("links in netns "+netnsPath, func() error {
+		return ns.Run(func() error {
+			return deleteLink(ifbName)
+		})
+	})
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Complete the remaining setup in target network namespace.
+	err = runInNetNS(ns, func() error {
+		// The interface index may have changed when the interface was moved.
+		err := link.AttachToLink()
+		if err != nil {
+			return err
+		}
+
+		err = prepareBranchLink(link, containerID, netConfig)
+		if err != nil {
+			return err
+		}
+
+		err = createVLANLink(link, netConfig.InterfaceName, netConfig, lease)
+		if err != nil {
+			return err
+		}
+
+		return configureContainerLink(link, ifbName, netConfig)
+	})
+	if err != nil {
+		log.Errorf("Failed to setup the adopted interface: %v.", err)
+		return nil, cni.NewError(cni.ErrCodeLinkSetup, err)
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Verify that the gateways reply to pings from the target netns if requested.
+	if netConfig.VerifyGatewayReachable {
+		err = ns.Run(func() error {
+			return verifyGatewaysReachable(netConfig)
+		})
+		if err != nil {
+			return nil, cni.NewError(cni.ErrCodeGatewayCheck, err)
+		}
+	}
+
+	// Keep the changes now that the setup is complete.
+	rb.disarm()
+
+	return newResult(netConfig.InterfaceName, netnsPath, netConfig), nil
+}
+
+// releaseInterface moves the adopted interface with the given name in the current network
+// namespace back to the host network namespace, under its original name. Interfaces that are
+// not found are considered already released.
+func releaseInterface(linkName string, hostName string, hostNS netns.NetNS) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			log.Debugf("Adopted interface %s does not exist, ignoring.", linkName)
+			return nil
+		}
+		return err
+	}
+
+	// Links can only be renamed while they are down.
+	err = netlink.LinkSetDown(link)
+	if err != nil {
+		return err
+	}
+
+	if linkName != hostName {
+		log.Infof("Renaming adopted interface %s to %s.", linkName, hostName)
+		err = trace(traceOpRenameLink, linkName+" "+hostName, func() error {
+			return netlink.LinkSetName(link, hostName)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Moving adopted interface %s to the host netns.", hostName)
+	return trace(traceOpMoveLink, hostName+" "+hostNS.GetPath(), func() error {
+		return netlink.LinkSetNsFd(link, int(hostNS.GetFd()))
+	})
+}
+
+// getAdoptedInterfaceName returns the original name of the adopted interface in the host network
+// namespace. The result of ADD records it as its only host interface, like it does trunks.
+func getAdoptedInterfaceName(netConfig *config.NetConfig) string {
+	if netConfig.AdoptInterfaceName != "" {
+		return netConfig.AdoptInterfaceName
+	}
+
+	name := getTrunkNameFromResult(netConfig.PrevResult)
+	if name != "" {
+		return name
+	}
+
+	mac := netConfig.AdoptInterfaceMAC
+	return fmt.Sprintf(adoptedLinkNameFormat, []byte(mac[len(mac)-adoptedLinkNameMACLen:]))
+}
+
+// getAdoptLockName returns the name keying the lock of the interface to adopt. It is the host
+// interface name if one is configured, and the MAC address otherwise. It depends on the config
+// alone, as the interface cannot be found in the host network namespace once adopted.
+func getAdoptLockName(netConfig *config.NetConfig) string {
+	if netConfig.AdoptInterfaceName != "" {
+		return netConfig.AdoptInterfaceName
+	}
+	return netConfig.AdoptInterfaceMAC.String()
+}
+
+// getHostNetNS returns the network namespace of the plugin process, which is the host network
+// namespace that adopted interfaces are moved from and back to.
+func getHostNetNS() (netns.NetNS, error) {
+	ns, err := netns.GetNetNSByPid(os.Getpid())
+	if err != nil {
+		log.Errorf("Failed to find host netns: %v.", err)
+		return nil, err
+	}
+
+	return ns, nil
+}
@@ -0,0 +1,117 @@
+// +build e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	testAdoptLinkName = "testAdopt0"
+	testAdoptPeerName = "testAdopt1"
+	testAdoptIfName   = "testIf"
+)
+
+// TestAdoptInterface tests that ADD moves the adopted interface into the target netns, renames
+// and configures it, and that DEL moves it back to the host netns under its original name.
+func TestAdoptInterface(t *testing.T) {
+	// Adopt one end of a veth pair, whose other end stays in the host netns.
+	la := netlink.NewLinkAttrs()
+	la.Name = testAdoptLinkName
+	err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: la, PeerName: testAdoptPeerName})
+	require.NoError(t, err)
+	defer deleteLink(testAdoptLinkName)
+
+	hostLink, err := netlink.LinkByName(testAdoptLinkName)
+	require.NoError(t, err)
+	hostMAC := hostLink.Attrs().HardwareAddr
+
+	targetNS, err := netns.NewNetNS("testAdoptNS")
+	require.NoError(t, err)
+	defer targetNS.Close()
+
+	branchIP, branchPrefix, _ := net.ParseCIDR("172.31.19.6/20")
+	branchPrefix.IP = branchIP
+	netConfig := &config.NetConfig{
+		AdoptInterfaceMAC:   hostMAC,
+		BranchIPAddress:     branchPrefix,
+		BranchIPAddresses:   []net.IPNet{*branchPrefix},
+		InstallDefaultRoute: false,
+		InterfaceType:       config.IfTypeVLAN,
+		InterfaceName:       testAdoptIfName,
+	}
+	netConfig.CNIVersion = "1.0.0"
+
+	result, err := Add(context.TODO(), "container_1", targetNS.GetPath(), netConfig)
+	require.NoError(t, err)
+	require.Len(t, result.Interfaces, 2)
+	assert.Equal(t, testAdoptIfName, result.Interfaces[0].Name)
+	assert.Equal(t, testAdoptLinkName, result.Interfaces[1].Name)
+	assert.Empty(t, result.Interfaces[1].Sandbox)
+
+	// The interface is gone from the host netns, and configured in the target netns.
+	_, err = netlink.LinkByName(testAdoptLinkName)
+	assert.Error(t, err)
+	err = targetNS.Run(func() error {
+		link, err := netlink.LinkByName(testAdoptIfName)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, hostMAC, link.Attrs().HardwareAddr)
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return err
+		}
+		require.Len(t, addrs, 1)
+		assert.Equal(t, branchPrefix.String(), addrs[0].IPNet.String())
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A repeated ADD finds the interface already adopted.
+	_, err = Add(context.TODO(), "container_1", targetNS.GetPath(), netConfig)
+	assert.NoError(t, err)
+
+	// DEL restores the original name from the previous result.
+	netConfig.AdoptInterfaceName = ""
+	netConfig.PrevResult = result
+	err = Del(context.TODO(), "container_1", targetNS.GetPath(), netConfig)
+	require.NoError(t, err)
+
+	hostLink, err = netlink.LinkByName(testAdoptLinkName)
+	require.NoError(t, err)
+	assert.Equal(t, hostMAC, hostLink.Attrs().HardwareAddr)
+	err = targetNS.Run(func() error {
+		_, err := netlink.LinkByName(testAdoptIfName)
+		assert.Error(t, err)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// DEL is idempotent.
+	err = Del(context.TODO(), "container_1", targetNS.GetPath(), netConfig)
+	assert.NoError(t, err)
+}
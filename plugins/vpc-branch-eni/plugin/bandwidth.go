@@ -0,0 +1,178 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"math"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Name template for the IFB link used to shape ingress traffic.
+	ifbLinkNameFormat = "ifb%d"
+
+	// Token bucket filter parameters.
+	tbfLatencyUsec   = 25000
+	tbfMinBurstBytes = 64 * 1024
+	tbfBurstDivisor  = 100
+)
+
+// setBandwidthLimits applies the configured bandwidth limits to the branch link.
+// Egress traffic is shaped by a token bucket filter on the branch link. Ingress traffic is
+// redirected to an IFB link and shaped by a token bucket filter there.
+func setBandwidthLimits(branchIndex int, ifbName string, netConfig *config.NetConfig) error {
+	if netConfig.EgressBandwidthLimit == 0 && netConfig.IngressBandwidthLimit == 0 {
+		return nil
+	}
+
+	branchLink, err := netlink.LinkByIndex(branchIndex)
+	if err != nil {
+		log.Errorf("Failed to find branch link: %v.", err)
+		return err
+	}
+
+	if netConfig.EgressBandwidthLimit != 0 {
+		log.Infof("Limiting egress bandwidth on link %s to %d bits/sec.",
+			branchLink.Attrs().Name, netConfig.EgressBandwidthLimit)
+		err = addTBFQdisc(branchLink.Attrs().Index, netConfig.EgressBandwidthLimit)
+		if err != nil {
+			log.Errorf("Failed to add egress qdisc: %v.", err)
+			return err
+		}
+	}
+
+	if netConfig.IngressBandwidthLimit != 0 {
+		log.Infof("Limiting ingress bandwidth on link %s to %d bits/sec.",
+			branchLink.Attrs().Name, netConfig.IngressBandwidthLimit)
+
+		// Create the IFB link.
+		la := netlink.NewLinkAttrs()
+		la.Name = ifbName
+		la.MTU = branchLink.Attrs().MTU
+		ifbLink := &netlink.Ifb{LinkAttrs: la}
+		err = netlink.LinkAdd(ifbLink)
+		if err != nil {
+			log.Errorf("Failed to add IFB link %s: %v.", ifbName, err)
+			return err
+		}
+
+		err = netlink.LinkSetUp(ifbLink)
+		if err != nil {
+			log.Errorf("Failed to set IFB link state: %v.", err)
+			return err
+		}
+
+		// Redirect all ingress traffic on the branch link to the IFB link.
+		ingressQdisc := &netlink.Ingress{
+			QdiscAttrs: netlink.QdiscAttrs{
+				LinkIndex: branchLink.Attrs().Index,
+				Parent:    netlink.HANDLE_INGRESS,
+			},
+		}
+		err = netlink.QdiscAdd(ingressQdisc)
+		if err != nil {
+			log.Errorf("Failed to add ingress qdisc: %v.", err)
+			return err
+		}
+
+		filter := &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: branchLink.Attrs().Index,
+				Parent:    netlink.MakeHandle(0xffff, 0),
+				Priority:  1,
+				Protocol:  unix.ETH_P_ALL,
+			},
+			ClassId:    netlink.MakeHandle(1, 1),
+			RedirIndex: ifbLink.Attrs().Index,
+			Actions:    []netlink.Action{netlink.NewMirredAction(ifbLink.Attrs().Index)},
+		}
+		err = netlink.FilterAdd(filter)
+		if err != nil {
+			log.Errorf("Failed to add ingress redirect filter: %v.", err)
+			return err
+		}
+
+		err = addTBFQdisc(ifbLink.Attrs().Index, netConfig.IngressBandwidthLimit)
+		if err != nil {
+			log.Errorf("Failed to add IFB link qdisc: %v.", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteBandwidthLimits removes the bandwidth limits applied to the branch link.
+func deleteBandwidthLimits(branchName string, ifbName string, netConfig *config.NetConfig) error {
+	if netConfig.EgressBandwidthLimit == 0 && netConfig.IngressBandwidthLimit == 0 {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(branchName)
+	if err == nil {
+		qdiscs, err := netlink.QdiscList(link)
+		if err != nil {
+			return err
+		}
+
+		for _, qdisc := range qdiscs {
+			switch qdisc.(type) {
+			case *netlink.Tbf, *netlink.Ingress:
+				log.Infof("Deleting qdisc %+v.", qdisc)
+				err = netlink.QdiscDel(qdisc)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	} else if _, ok := err.(netlink.LinkNotFoundError); !ok {
+		return err
+	}
+
+	return deleteLink(ifbName)
+}
+
+// addTBFQdisc adds a root token bucket filter qdisc limiting the link to the given rate in bits/sec.
+func addTBFQdisc(linkIndex int, rate uint64) error {
+	rateBytes := rate / 8
+	burst := rateBytes / tbfBurstDivisor
+	if burst < tbfMinBurstBytes {
+		burst = tbfMinBurstBytes
+	} else if burst > math.MaxUint32 {
+		burst = math.MaxUint32
+	}
+	burstBytes := uint32(burst)
+
+	buffer := uint32(netlink.Xmittime(rateBytes, burstBytes))
+	limit := uint32(float64(rateBytes)*tbfLatencyUsec/netlink.TIME_UNITS_PER_SEC) + burstBytes
+
+	qdisc := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rateBytes,
+		Limit:  limit,
+		Buffer: buffer,
+	}
+
+	log.Infof("Adding qdisc %+v.", qdisc)
+	return netlink.QdiscAdd(qdisc)
+}
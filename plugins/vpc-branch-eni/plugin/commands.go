@@ -14,10 +14,16 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+	"github.com/aws/amazon-vpc-cni-plugins/logger"
 	"github.com/aws/amazon-vpc-cni-plugins/network/eni"
 	"github.com/aws/amazon-vpc-cni-plugins/network/imds"
 	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
@@ -27,314 +33,1867 @@ import (
 	log "github.com/cihub/seelog"
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
-	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/current"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
 
 const (
 	// Name templates used for objects created by this plugin.
-	branchLinkNameFormat = "%s.%d"
-	bridgeNameFormat     = "tapbr%d"
+	branchLinkNameFormat  = "%s.%d"
+	macvlanLinkNameFormat = "mv%x"
+	bridgeNameFormat      = "tapbr%d"
+	branchLinkAliasPrefix = "container:"
+	branchLinkAliasFormat = branchLinkAliasPrefix + "%.12s"
+
+	// Path format of the character device node of a MACVTAP link.
+	macvtapDevicePathFormat = "/dev/tap%d"
 )
 
 // Add is the internal implementation of CNI ADD command.
 func (plugin *Plugin) Add(args *cniSkel.CmdArgs) error {
-	// Parse network configuration.
-	netConfig, err := config.New(args)
+	logger.SetContainerID(args.ContainerID)
+
+	return runWithTimeout("ADD", os.Stdout, func(ctx context.Context, stdout io.Writer) error {
+		// Parse network configuration.
+		netConfig, err := config.New(args)
+		if err != nil {
+			log.Errorf("Failed to parse netconfig from args: %v.", err)
+			return cni.NewError(cni.ErrCodeInvalidConfig, err)
+		}
+
+		// In explain mode, print the resolved network configuration without making any changes.
+		if isExplain() {
+			return printExplainOutput(stdout, netConfig)
+		}
+
+		// Allocate the branch IP addresses from the IPAM plugin if configured. DHCP leases can only
+		// be acquired once the branch link is up in the target netns, and are thus deferred.
+		useIPAM := netConfig.IPAM.Type != "" && !isDryRun()
+		var lease leaseFunc
+		if useIPAM && isDHCP(netConfig) {
+			lease = func() error {
+				return addIPAM(ctx, args, netConfig)
+			}
+		} else if useIPAM {
+			err = addIPAM(ctx, args, netConfig)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := add(ctx, args.ContainerID, args.Netns, netConfig, lease)
+		if err != nil {
+			// Release the IP addresses allocated for the failed setup.
+			if useIPAM {
+				if ipamErr := deleteIPAM(ctx, args, netConfig); ipamErr != nil {
+					log.Errorf("Failed to release IP addresses after failed ADD: %v.", ipamErr)
+				}
+			}
+			return err
+		}
+
+		// In dry-run mode, print the parsed network configuration along with the would-be result.
+		if isDryRun() {
+			return printDryRunOutput(stdout, netConfig.InterfaceName, args.Netns, netConfig)
+		}
+
+		// Persist the result, so that DEL knows precisely what to tear down.
+		if netConfig.StateFilePath != "" {
+			log.Infof("Writing CNI result to state file %s.", netConfig.StateFilePath)
+			err = writeState(netConfig.StateFilePath, result)
+			if err != nil {
+				log.Errorf("Failed to write state file %s: %v.", netConfig.StateFilePath, err)
+				return cni.NewError(cni.ErrCodeInternal, err)
+			}
+		}
+
+		log.Infof("Writing CNI result to stdout: %+v", result)
+		versionedResult, err := result.GetAsVersion(netConfig.CNIVersion)
+		if err != nil {
+			return err
+		}
+		return versionedResult.PrintTo(stdout)
+	})
+}
+
+// Add sets up the branch link for the given container in the given netns and returns the CNI
+// result. It is the entry point for embedding the plugin as a library, with netConfig typically
+// created by config.New. In dry-run mode, Add only validates the configuration and returns the
+// would-be result. If ctx is cancelled, Add stops and rolls back its changes.
+func Add(
+	ctx context.Context,
+	containerID string,
+	netnsPath string,
+	netConfig *config.NetConfig) (*cniTypesCurrent.Result, error) {
+
+	return add(ctx, containerID, netnsPath, netConfig, nil)
+}
+
+// add implements Add. If lease is not nil, it is called in the target netns to acquire the
+// branch IP addresses once the branch link is up.
+func add(
+	ctx context.Context,
+	containerID string,
+	netnsPath string,
+	netConfig *config.NetConfig,
+	lease leaseFunc) (*cniTypesCurrent.Result, error) {
+
+	log.Infof("Executing ADD with netconfig: %+v.", netConfig)
+
+	// Find the network namespace.
+	log.Infof("Searching for netns %s.", netnsPath)
+	ns, err := getNetNS(netnsPath)
+	if err != nil {
+		log.Errorf("Failed to find netns %s: %v.", netnsPath, err)
+		return nil, cni.NewError(cni.ErrCodeNetNS, err)
+	}
+
+	// Adopted interfaces are moved into the target netns in place of a branch link on a trunk.
+	if isAdoptedInterface(netConfig) {
+		return adoptInterface(ctx, containerID, netnsPath, ns, netConfig, lease)
+	}
+
+	// Resolve the trunk interface name from its PCI address if specified.
+	err = resolveTrunkName(netConfig)
+	if err != nil {
+		return nil, cni.NewError(cni.ErrCodeTrunkNotFound, err)
+	}
+
+	// Create the trunk ENI.
+	trunk, err := findTrunk(netConfig)
+	if err != nil {
+		log.Errorf("Failed to find trunk interface: %v.", err)
+		return nil, cni.NewError(cni.ErrCodeTrunkNotFound, err)
+	}
+
+	// In dry-run mode, stop after validation without making any changes.
+	if isDryRun() {
+		log.Infof("Dry-run mode is enabled, skipping network setup.")
+		return newResult(netConfig.InterfaceName, netnsPath, netConfig), nil
+	}
+
+	// Check that the trunk ENI is up, or bring it up if configured to.
+	err = checkTrunkUp(trunk.GetLinkName(), netConfig)
+	if err != nil {
+		return nil, cni.NewError(cni.ErrCodeTrunkNotFound, err)
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Serialize the invocations setting up the same branch link on the host, so that only one
+	// of them creates it and the others find it.
+	branchName := getBranchLinkName(trunk.GetLinkName(), netConfig)
+	lock, err := lockLink(ctx, branchName)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+
+	// Check whether the branch link was already set up by a previous invocation of this plugin.
+	if isBranchInterface(netConfig) {
+		var exists bool
+		err = runInNetNS(ns, func() error {
+			var err error
+			exists, err = findExistingVLANLink(netConfig.InterfaceName, netConfig)
+			return err
+		})
+		if err != nil {
+			log.Errorf("Failed to reuse existing branch link %s: %v.", netConfig.InterfaceName, err)
+			return nil, cni.NewError(cni.ErrCodeLinkCreation, err)
+		}
+
+		if exists {
+			log.Infof("Branch link %s already exists with the requested configuration.", netConfig.InterfaceName)
+			// The lease is renewed on the existing branch link, whose addresses come from it.
+			if lease != nil {
+				err = runInNetNS(ns, lease)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return newResult(netConfig.InterfaceName, netnsPath, netConfig), nil
+		}
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Create the branch ENI.
+	var branch *eni.Branch
+	if netConfig.InterfaceType == config.IfTypeMACVLAN {
+		branch, err = eni.NewMACVLANBranch(trunk, branchName, netConfig.BranchMACAddress,
+			getMACVLANMode(netConfig.MACVLANMode))
+	} else {
+		branch, err = eni.NewBranch(trunk, branchName, netConfig.BranchMACAddress, netConfig.BranchVlanID)
+	}
+	if err != nil {
+		log.Errorf("Failed to create branch interface %s: %v.", branchName, err)
+		return nil, cni.NewError(cni.ErrCodeLinkCreation, err)
+	}
+
+	// Branch links are stacked on trunks that are themselves VLAN links, e.g. for QinQ.
+	trunkIsVLAN, err := trunk.IsVLAN()
+	if err != nil {
+		log.Errorf("Failed to query trunk interface %s: %v.", trunk.GetLinkName(), err)
+		return nil, cni.NewError(cni.ErrCodeTrunkNotFound, err)
+	}
+	if trunkIsVLAN && netConfig.InterfaceType != config.IfTypeMACVLAN {
+		log.Infof("Trunk %s is a VLAN link, stacking branch link %s with protocol %s.",
+			trunk.GetLinkName(), branchName, netConfig.VlanProtocol)
+	}
+	branch.SetVLANProtocol(getVLANProtocol(netConfig.VlanProtocol))
+	branch.SetVLANQoSMaps(netConfig.VlanEgressQoSMap, netConfig.VlanIngressQoSMap)
+
+	// Roll back the resources created by this invocation if any of the remaining steps fail.
+	var rb rollback
+	defer rb.run()
+
+	// Enable promiscuous mode on the trunk ENI, and restore its original mode on failure. The
+	// rollback step is registered first, as a failed attempt may have recorded the branch.
+	if netConfig.TrunkPromiscuous {
+		promiscKey := getTrunkPromiscuousKey(containerID, netConfig)
+		rb.add("trunk promiscuous mode", func() error {
+			return restoreTrunkPromiscuous(ctx, trunk.GetLinkName(), promiscKey)
+		})
+		err = enableTrunkPromiscuous(ctx, trunk.GetLinkName(), promiscKey)
+		if err != nil {
+			return nil, cni.NewError(cni.ErrCodeTrunkNotFound, err)
+		}
+	}
+
+	// Create a link for the branch ENI.
+	log.Infof("Creating branch link %s.", branchName)
+	overrideMAC := isBranchInterface(netConfig)
+	branchCreated := false
+	err = defaultRetryPolicy.run("branch link creation", func() error {
+		return trace(traceOpCreateLink, branchName, func() error {
+			return branch.AttachToLink(overrideMAC)
+		})
+	})
+	if err != nil {
+		if os.IsExist(err) {
+			// If the branch link already exists, it may have been created in a previous invocation
+			// of this plugin. Look for it in the target network namespace and reset it.
+			err = runInNetNS(ns, func() error {
+				err := branch.ENI.AttachToLink()
+				if err != nil {
+					return err
+				}
+
+				for _, ipAddress := range getBranchIPAddresses(netConfig) {
+					err = branch.DeleteIPAddress(&ipAddress)
+					if os.IsNotExist(err) {
+						err = nil
+					} else if err != nil {
+						log.Errorf("Failed to reset branch link: %v", err)
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			log.Errorf("Failed to attach branch interface %s: %v.", branchName, err)
+			return nil, cni.NewError(cni.ErrCodeLinkCreation, err)
+		}
+	} else {
+		// Delete the new branch link, from whichever network namespace it is in, on failure.
+		branchInNetNS := false
+		rb.add("branch link", func() error {
+			if branchInNetNS {
+				return ns.Run(branch.DetachFromLink)
+			}
+			return branch.DetachFromLink()
+		})
+
+		// Move branch ENI to the network namespace.
+		log.Infof("Moving branch link %s to netns %s.", branch, netnsPath)
+		err = trace(traceOpMoveLink, branchName+" "+netnsPath, func() error {
+			return branch.SetNetNS(ns)
+		})
+		if err != nil {
+			log.Errorf("Failed to move branch link: %v.", err)
+			return nil, cni.NewError(cni.ErrCodeLinkCreation, err)
+		}
+		branchInNetNS = true
+		branchCreated = true
+	}
+
+	// Delete the links created in the target network namespace on failure. Links left behind
+	// by a previous invocation that reused the existing branch link are not touched.
+	bridgeName := fmt.Sprintf(bridgeNameFormat, netConfig.BranchVlanID)
+	ifbName := fmt.Sprintf(ifbLinkNameFormat, netConfig.BranchVlanID)
+	if branchCreated {
+		rb.add("links in netns "+netnsPath, func() error {
+			return ns.Run(func() error {
+				linkNames := []string{ifbName}
+				if !isBranchInterface(netConfig) {
+					linkNames = append(linkNames, netConfig.InterfaceName, bridgeName)
+				}
+				for _, linkName := range linkNames {
+					err := deleteLink(linkName)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Complete the remaining setup in target network namespace.
+	err = runInNetNS(ns, func() error {
+		err := prepareBranchLink(&branch.ENI, containerID, netConfig)
+		if err != nil {
+			return err
+		}
+
+		// Create the container-facing link based on the requested interface type.
+		switch netConfig.InterfaceType {
+		case config.IfTypeVLAN, config.IfTypeMACVLAN:
+			// Container is running in a network namespace on this host.
+			err = createVLANLink(&branch.ENI, netConfig.InterfaceName, netConfig, lease)
+		case config.IfTypeTAP:
+			// Container is running in a VM.
+			// Connect the branch ENI to a TAP link in the target network namespace.
+			err = checkVhostNet(netConfig.Tap)
+			if err != nil {
+				return err
+			}
+			err = createTAPLink(branch, bridgeName, netConfig.InterfaceName, netConfig.Tap, netConfig.MTU)
+		case config.IfTypeMACVTAP:
+			// Container is running in a VM.
+			// Connect the branch ENI to a MACVTAP link in the target network namespace.
+			err = createMACVTAPLink(netConfig.InterfaceName, branch.GetLinkIndex(), netConfig.Tap)
+		}
+		if err != nil {
+			return err
+		}
+
+		return configureContainerLink(&branch.ENI, ifbName, netConfig)
+	})
+
+	if err != nil {
+		log.Errorf("Failed to setup the link: %v.", err)
+		return nil, cni.NewError(cni.ErrCodeLinkSetup, err)
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Enable proxy ARP on the trunk for the branch gateways if requested.
+	if netConfig.ProxyARP {
+		rb.add("proxy neighbor entries", func() error {
+			return disableProxyARP(trunk.GetLinkIndex(), netConfig)
+		})
+
+		err = enableProxyARP(trunk.GetLinkName(), trunk.GetLinkIndex(), netConfig)
+		if err != nil {
+			return nil, cni.NewError(cni.ErrCodeLinkSetup, err)
+		}
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return nil, err
+	}
+
+	// Verify that the gateways reply to pings from the target netns if requested.
+	if netConfig.VerifyGatewayReachable {
+		err = ns.Run(func() error {
+			return verifyGatewaysReachable(netConfig)
+		})
+		if err != nil {
+			return nil, cni.NewError(cni.ErrCodeGatewayCheck, err)
+		}
+	}
+
+	// Keep the resources now that the setup is complete.
+	rb.disarm()
+
+	// Generate CNI result.
+	return newResult(netConfig.InterfaceName, netnsPath, netConfig), nil
+}
+
+// prepareBranchLink sets the MTU, offload features and alias of the branch link in the target
+// network namespace, before the container-facing link is set up on it.
+func prepareBranchLink(branch *eni.ENI, containerID string, netConfig *config.NetConfig) error {
+	var err error
+
+	// Set branch link MTU if specified. Otherwise the branch link inherits the trunk's MTU.
+	if netConfig.MTU != 0 {
+		log.Infof("Setting branch link MTU to %d.", netConfig.MTU)
+		err = trace(traceOpSetMTU, fmt.Sprintf("%s %d", branch.GetLinkName(), netConfig.MTU), func() error {
+			return branch.SetLinkMTU(uint(netConfig.MTU))
+		})
+		if err != nil {
+			log.Errorf("Failed to set branch link %v MTU: %v.", branch, err)
+			return err
+		}
+	}
+
+	// Set the offload features of the branch link if specified.
+	err = setOffloads(branch.GetLinkName(), netConfig.Offloads)
 	if err != nil {
-		log.Errorf("Failed to parse netconfig from args: %v.", err)
 		return err
 	}
 
-	log.Infof("Executing ADD with netconfig: %+v.", netConfig)
+	// Tag the branch link with the ID of the container that owns it.
+	if containerID != "" {
+		alias := fmt.Sprintf(branchLinkAliasFormat, containerID)
+		err = branch.SetLinkAlias(alias)
+		if err != nil {
+			// Log and ignore the failure, as the alias is only informational.
+			log.Errorf("Failed to set branch link %v alias to %s: %v.", branch, alias, err)
+		}
+	}
 
-	// Find the network namespace.
-	log.Infof("Searching for netns %s.", args.Netns)
-	ns, err := netns.GetNetNS(args.Netns)
+	return nil
+}
+
+// configureContainerLink completes the setup of the container-facing link in the target network
+// namespace once it is created.
+func configureContainerLink(branch *eni.ENI, ifbName string, netConfig *config.NetConfig) error {
+	// Override the MAC address of the container-facing link if requested.
+	err := setInterfaceMACAddress(netConfig)
+	if err != nil {
+		return err
+	}
+
+	// Clamp the TCP MSS to the MTU of the container-facing link if requested.
+	if netConfig.TCPMSSClamp {
+		err = addIPTablesRules(newMSSClampRules(netConfig))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Block inbound connections on the container-facing link if requested.
+	if netConfig.EgressOnly {
+		err = addIPTablesRules(newEgressOnlyRules(netConfig))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Mark outbound packets on the container-facing link if requested.
+	if netConfig.ConnMark != 0 {
+		err = addIPTablesRules(newConnMarkRules(netConfig))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Forward DNS queries sent to the stub resolver address to the nameservers if requested.
+	if netConfig.DNSStub {
+		err = enableDNSStub(netConfig.InterfaceName, netConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Apply the bandwidth limits if specified.
+	err = setBandwidthLimits(branch.GetLinkIndex(), ifbName, netConfig)
 	if err != nil {
-		log.Errorf("Failed to find netns %s: %v.", args.Netns, err)
+		log.Errorf("Failed to set bandwidth limits on branch link %v: %v.", branch, err)
 		return err
 	}
 
-	// Create the trunk ENI.
-	trunk, err := eni.NewTrunk(netConfig.TrunkName, netConfig.TrunkMACAddress, eni.TrunkIsolationModeVLAN)
-	if err != nil {
-		log.Errorf("Failed to find trunk interface %s: %v.", netConfig.TrunkName, err)
-		return err
+	// Attach the eBPF tc program to the branch link if specified.
+	if netConfig.TCBPFProgram != "" {
+		err = addTCBPFProgram(branch.GetLinkIndex(), netConfig.TCBPFProgram)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Add a blackhole route for IMDS endpoint if required.
+	if netConfig.BlockIMDS {
+		err = imds.BlockInstanceMetadataEndpoint(
+			netConfig.BlockIMDSMethod, netConfig.BranchIPv6Address != nil, netConfig.FirewallBackend)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Set branch link operational state up. VLAN and MACVLAN interfaces were already brought up above.
+	if !isBranchInterface(netConfig) {
+		log.Infof("Setting branch link state up.")
+		err = trace(traceOpSetUp, branch.GetLinkName(), func() error {
+			return branch.SetOpState(true)
+		})
+		if err != nil {
+			log.Errorf("Failed to set branch link %v state: %v.", branch, err)
+			return err
+		}
+	}
+
+	// Announce the branch IP addresses, which may have moved from another host.
+	if isBranchInterface(netConfig) {
+		announceAddresses(branch.GetLinkIndex(), getBranchIPAddresses(netConfig), netConfig.GratuitousARPCount)
+	}
+
+	// Let the container reach itself via its branch IP addresses if requested.
+	if netConfig.Hairpin {
+		err = enableHairpin(netConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Disable reverse path filtering on the interface if asymmetric routing is expected.
+	if netConfig.DisableRPFilter {
+		err = disableRPFilter(netConfig.InterfaceName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Disable IPv6 autoconfiguration on the interface so that its addresses stay deterministic.
+	if netConfig.DisableIPv6Autoconf {
+		err = disableIPv6Autoconf(netConfig.InterfaceName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Disable ICMP redirects on the interface so that gateways cannot alter its routing.
+	if netConfig.DisableICMPRedirects {
+		err = disableICMPRedirects(netConfig.InterfaceName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Apply the requested sysctls now that the interface is up.
+	return setSysctls(netConfig.InterfaceName, netConfig.Sysctls)
+}
+
+// Del is the internal implementation of CNI DEL command.
+// CNI DEL command can be called by the orchestrator agent multiple times for the same interface,
+// and thus must be best-effort and idempotent.
+func (plugin *Plugin) Del(args *cniSkel.CmdArgs) error {
+	logger.SetContainerID(args.ContainerID)
+
+	return runWithTimeout("DEL", os.Stdout, func(ctx context.Context, stdout io.Writer) error {
+		// Parse network configuration.
+		netConfig, err := config.New(args)
+		if err != nil {
+			log.Errorf("Failed to parse netconfig from args: %v.", err)
+			return cni.NewError(cni.ErrCodeInvalidConfig, err)
+		}
+
+		// In explain mode, print the resolved network configuration without making any changes.
+		if isExplain() {
+			return printExplainOutput(stdout, netConfig)
+		}
+
+		// Recover the result of ADD from the state file if the runtime did not pass it.
+		err = loadState(netConfig)
+		if err != nil {
+			return cni.NewError(cni.ErrCodeInternal, err)
+		}
+
+		// Recover the IP addresses allocated from the IPAM plugin, so that the configuration
+		// depending on them is deleted, and release them once the branch is torn down.
+		useIPAM := netConfig.IPAM.Type != ""
+		if useIPAM {
+			err = applyPrevResult(netConfig)
+			if err != nil {
+				return err
+			}
+		}
+
+		// DHCP leases are released on the branch link, and thus before it is deleted.
+		if useIPAM && isDHCP(netConfig) {
+			err = deleteIPAM(ctx, args, netConfig)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = Del(ctx, args.ContainerID, args.Netns, netConfig)
+		if err != nil {
+			return err
+		}
+
+		if useIPAM && !isDHCP(netConfig) {
+			err = deleteIPAM(ctx, args, netConfig)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The state file is no longer needed once everything is torn down.
+		if netConfig.StateFilePath != "" {
+			log.Infof("Deleting state file %s.", netConfig.StateFilePath)
+			return deleteState(netConfig.StateFilePath)
+		}
+
+		return nil
+	})
+}
+
+// Del tears down the branch link set up for the given container in the given netns. It is the
+// library counterpart of Add and, like CNI DEL, is best-effort and idempotent.
+func Del(ctx context.Context, containerID string, netnsPath string, netConfig *config.NetConfig) error {
+	var err error
+
+	log.Infof("Executing DEL with netconfig: %+v.", netConfig)
+
+	// Target the trunk that ADD recorded in its result, if any. Adopted interfaces have no trunk,
+	// and their result records the host interface instead.
+	trunkName := ""
+	if !isAdoptedInterface(netConfig) {
+		trunkName = getTrunkNameFromResult(netConfig.PrevResult)
+	}
+	if trunkName != "" {
+		log.Infof("Using trunk interface %s from previous result.", trunkName)
+		netConfig.TrunkName = trunkName
+		netConfig.TrunkNames = []string{trunkName}
+		netConfig.TrunkMACAddresses = nil
+	}
+
+	// Find the trunk link name, which names the branch link and keys the lock of the branch.
+	// Branch interfaces are deleted from the target netns even if the trunk is gone.
+	if !isAdoptedInterface(netConfig) {
+		err = findDelTrunk(netConfig)
+		if err != nil && !isBranchInterface(netConfig) {
+			// Log and ignore the failure.
+			return nil
+		}
+	}
+
+	// Derive names from CNI network config.
+	var branchName string
+	if isBranchInterface(netConfig) {
+		branchName = netConfig.InterfaceName
+	} else {
+		branchName = getBranchLinkName(netConfig.TrunkName, netConfig)
+	}
+	tapBridgeName := fmt.Sprintf(bridgeNameFormat, netConfig.BranchVlanID)
+	tapLinkName := netConfig.InterfaceName
+	ifbName := fmt.Sprintf(ifbLinkNameFormat, netConfig.BranchVlanID)
+
+	// Serialize the invocations tearing down the same host link with those setting it up. The
+	// trunk name is unknown only if the trunk was not found, so no branch can be set up on it.
+	var lockName string
+	if isAdoptedInterface(netConfig) {
+		lockName = getAdoptLockName(netConfig)
+	} else if netConfig.TrunkName != "" {
+		lockName = getBranchLinkName(netConfig.TrunkName, netConfig)
+	}
+	if lockName != "" {
+		lock, err := lockLink(ctx, lockName)
+		if err != nil {
+			return err
+		}
+		defer lock.unlock()
+	}
+
+	// Delete the proxy neighbor entries from the trunk in the host network namespace.
+	if netConfig.ProxyARP {
+		deleteProxyNeighbors(netConfig)
+	}
+
+	// Restore the original promiscuous mode of the trunk once no other branch needs it.
+	if netConfig.TrunkPromiscuous && netConfig.TrunkName != "" {
+		// Log and ignore the failure.
+		restoreTrunkPromiscuous(ctx, netConfig.TrunkName, getTrunkPromiscuousKey(containerID, netConfig))
+	}
+
+	if err = checkTimeout(ctx); err != nil {
+		return err
+	}
+
+	// The runtime passes no netns once the container is gone, along with the links in it. Only
+	// the host-side state above is left to clean up.
+	if netnsPath == "" {
+		log.Infof("No netns specified, skipping teardown in the netns.")
+		return nil
+	}
+
+	// Adopted interfaces are moved back to the host network namespace instead of being deleted.
+	var hostNS netns.NetNS
+	if isAdoptedInterface(netConfig) {
+		hostNS, err = getHostNetNS()
+		if err != nil {
+			return cni.NewError(cni.ErrCodeNetNS, err)
+		}
+		defer hostNS.Close()
+	}
+
+	// Search for the target network namespace.
+	netns, err := getNetNS(netnsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The netns was already deleted along with the links in it.
+			// DEL can be called multiple times and thus must be idempotent.
+			log.Debugf("Netns %s does not exist, ignoring.", netnsPath)
+			return nil
+		}
+		log.Errorf("Failed to find netns %s: %v.", netnsPath, err)
+		return cni.NewError(cni.ErrCodeNetNS, err)
+	}
+
+	// In target network namespace...
+	err = netns.Run(func() error {
+		if netConfig.InterfaceType == config.IfTypeMACVTAP ||
+			netConfig.InterfaceType == config.IfTypeTAP {
+			// Delete the tap link.
+			err := deleteLink(tapLinkName)
+			if err != nil {
+				log.Errorf("Failed to delete tap link: %v.", err)
+				return err
+			}
+		}
+
+		// Delete the static routes added via the branch link.
+		if isBranchInterface(netConfig) {
+			err := deleteStaticRoutes(branchName, netConfig)
+			if err != nil {
+				log.Errorf("Failed to delete static routes: %v.", err)
+				return err
+			}
+
+			// Delete the static neighbor entries on the branch link.
+			err = deleteStaticNeighbors(branchName, netConfig.StaticNeighbors)
+			if err != nil {
+				log.Errorf("Failed to delete static neighbor entries: %v.", err)
+				return err
+			}
+
+			// Delete the ip rules pointing at the branch route table.
+			err = deleteBranchRules(netConfig)
+			if err != nil {
+				log.Errorf("Failed to delete ip rules: %v.", err)
+				return err
+			}
+
+			// Delete the iptables rules clamping the TCP MSS.
+			if netConfig.TCPMSSClamp {
+				err = deleteIPTablesRules(newMSSClampRules(netConfig))
+				if err != nil {
+					log.Errorf("Failed to delete TCP MSS clamping rules: %v.", err)
+					return err
+				}
+			}
+
+			// Delete the iptables rules blocking inbound connections.
+			if netConfig.EgressOnly {
+				err = deleteIPTablesRules(newEgressOnlyRules(netConfig))
+				if err != nil {
+					log.Errorf("Failed to delete egress-only rules: %v.", err)
+					return err
+				}
+			}
+
+			// Delete the iptables rules marking outbound packets.
+			if netConfig.ConnMark != 0 {
+				err = deleteIPTablesRules(newConnMarkRules(netConfig))
+				if err != nil {
+					log.Errorf("Failed to delete connmark rules: %v.", err)
+					return err
+				}
+			}
+
+			// Delete the iptables rules forwarding DNS queries to the stub resolver.
+			if netConfig.DNSStub {
+				err = deleteIPTablesRules(newDNSStubRules(netConfig))
+				if err != nil {
+					log.Errorf("Failed to delete DNS stub rules: %v.", err)
+					return err
+				}
+			}
+		}
+
+		// Delete the bandwidth limits.
+		err := deleteBandwidthLimits(branchName, ifbName, netConfig)
+		if err != nil {
+			log.Errorf("Failed to delete bandwidth limits: %v.", err)
+			return err
+		}
+
+		// Detach the eBPF tc program, which adopted interfaces would otherwise keep running.
+		if netConfig.TCBPFProgram != "" {
+			err = detachTCBPFProgram(branchName)
+			if err != nil {
+				log.Errorf("Failed to detach eBPF tc program: %v.", err)
+				return err
+			}
+		}
+
+		// Release the adopted interface, or delete the branch link.
+		if isAdoptedInterface(netConfig) {
+			err = releaseInterface(branchName, getAdoptedInterfaceName(netConfig), hostNS)
+			if err != nil {
+				log.Errorf("Failed to release adopted interface: %v.", err)
+				return err
+			}
+		} else {
+			err = deleteLink(branchName)
+			if err != nil {
+				log.Errorf("Failed to delete branch link: %v.", err)
+				return err
+			}
+		}
+
+		if netConfig.InterfaceType == config.IfTypeTAP {
+			// Delete the tap bridge.
+			err = deleteLink(tapBridgeName)
+			if err != nil {
+				log.Errorf("Failed to delete tap bridge: %v.", err)
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Check is the internal implementation of CNI CHECK command.
+// CNI CHECK command verifies that the interface created by ADD is still correctly configured.
+func (plugin *Plugin) Check(args *cniSkel.CmdArgs) error {
+	logger.SetContainerID(args.ContainerID)
+
+	return runWithTimeout("CHECK", os.Stdout, func(ctx context.Context, stdout io.Writer) error {
+		// Parse network configuration.
+		netConfig, err := config.New(args)
+		if err != nil {
+			log.Errorf("Failed to parse netconfig from args: %v.", err)
+			return cni.NewError(cni.ErrCodeInvalidConfig, err)
+		}
+
+		// In explain mode, print the resolved network configuration without making any changes.
+		if isExplain() {
+			return printExplainOutput(stdout, netConfig)
+		}
+
+		if netConfig.IPAM.Type == "" {
+			return Check(ctx, args.Netns, netConfig)
+		}
+
+		// Verify the branch against the IP addresses allocated from the IPAM plugin.
+		err = applyPrevResult(netConfig)
+		if err != nil {
+			return err
+		}
+
+		err = Check(ctx, args.Netns, netConfig)
+		if err != nil {
+			return err
+		}
+
+		return checkIPAM(ctx, args, netConfig)
+	})
+}
+
+// Check verifies that the branch link set up by Add in the given netns is still correctly
+// configured. It is the library counterpart of CNI CHECK.
+func Check(ctx context.Context, netnsPath string, netConfig *config.NetConfig) error {
+	log.Infof("Executing CHECK with netconfig: %+v.", netConfig)
+
+	if err := checkTimeout(ctx); err != nil {
+		return err
+	}
+
+	// Search for the target network namespace.
+	ns, err := getNetNS(netnsPath)
+	if err != nil {
+		log.Errorf("Failed to find netns %s: %v.", netnsPath, err)
+		return cni.NewError(cni.ErrCodeNetNS, err)
+	}
+
+	// Verify the link in the target network namespace. Externally managed TAP links are
+	// connected to the bridge by the VMM, so only the bridge is expected to exist.
+	linkName := netConfig.InterfaceName
+	if isExternalTAP(netConfig) {
+		linkName = fmt.Sprintf(bridgeNameFormat, netConfig.BranchVlanID)
+	}
+	err = ns.Run(func() error {
+		link, err := netlink.LinkByName(linkName)
+		if err != nil {
+			return fmt.Errorf("failed to find link %s: %v", linkName, err)
+		}
+
+		if !isBranchInterface(netConfig) {
+			return validateVMLink(link, netConfig)
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("failed to query addresses of link %s: %v", netConfig.InterfaceName, err)
+		}
+
+		routes, err := listBranchRoutes(link, netConfig.RouteTableID)
+		if err != nil {
+			return fmt.Errorf("failed to query routes of link %s: %v", netConfig.InterfaceName, err)
+		}
+
+		return validateVLANLink(link, addrs, routes, netConfig)
+	})
+
+	if err != nil {
+		log.Errorf("Failed to check link %s: %v.", netConfig.InterfaceName, err)
+		return cni.NewError(cni.ErrCodeLinkCheck, err)
+	}
+
+	return nil
+}
+
+// deleteProxyNeighbors deletes the proxy neighbor entries for the branch gateways from the trunk.
+// Failures are logged and ignored, as DEL is best-effort.
+func deleteProxyNeighbors(netConfig *config.NetConfig) {
+	err := resolveTrunkName(netConfig)
+	if err != nil {
+		return
+	}
+
+	trunk, err := findTrunk(netConfig)
+	if err != nil {
+		log.Errorf("Failed to find trunk interface: %v.", err)
+		return
+	}
+
+	err = disableProxyARP(trunk.GetLinkIndex(), netConfig)
+	if err != nil {
+		log.Errorf("Failed to delete proxy neighbor entries: %v.", err)
+	}
+}
+
+// getMACVLANMode returns the mode of branch MACVLAN links for the given macvlanMode value.
+func getMACVLANMode(macvlanMode string) netlink.MacvlanMode {
+	switch macvlanMode {
+	case config.MACVLANModePrivate:
+		return netlink.MACVLAN_MODE_PRIVATE
+	case config.MACVLANModeVEPA:
+		return netlink.MACVLAN_MODE_VEPA
+	default:
+		return netlink.MACVLAN_MODE_BRIDGE
+	}
+}
+
+// getManagementIPScope returns the scope of the management IP address for the given
+// managementIPAddressScope value.
+func getManagementIPScope(managementIPScope string) netlink.Scope {
+	if managementIPScope == config.ManagementIPScopeHost {
+		return netlink.SCOPE_HOST
+	}
+
+	return netlink.SCOPE_LINK
+}
+
+// getAddressScope returns the scope of the branch IP addresses for the given addressScope value.
+// The kernel derives the scope of IPv6 addresses from the addresses themselves.
+func getAddressScope(addressScope string) netlink.Scope {
+	switch addressScope {
+	case config.AddressScopeLink:
+		return netlink.SCOPE_LINK
+	case config.AddressScopeHost:
+		return netlink.SCOPE_HOST
+	default:
+		return netlink.SCOPE_UNIVERSE
+	}
+}
+
+// getVLANProtocol returns the protocol of branch VLAN links for the given vlanProtocol value.
+func getVLANProtocol(vlanProtocol string) eni.VLANProtocol {
+	if vlanProtocol == config.VlanProtocol8021AD {
+		return eni.VLANProtocol8021AD
+	}
+
+	return eni.VLANProtocol8021Q
+}
+
+// findTrunk returns the first of the configured trunk interfaces that is present, trying the
+// trunk names or MAC addresses in order, and records the one found in netConfig.
+func findTrunk(netConfig *config.NetConfig) (*eni.Trunk, error) {
+	// A trunk identified by its PCI address has a single, already resolved, name. NetConfigs
+	// constructed by library users may also set only the primary trunk name or MAC address.
+	trunkNames := netConfig.TrunkNames
+	if len(trunkNames) == 0 && netConfig.TrunkName != "" {
+		trunkNames = []string{netConfig.TrunkName}
+	}
+	trunkMACAddresses := netConfig.TrunkMACAddresses
+	if len(trunkMACAddresses) == 0 && netConfig.TrunkMACAddress != nil {
+		trunkMACAddresses = []net.HardwareAddr{netConfig.TrunkMACAddress}
+	}
+
+	var errs []string
+	for _, trunkName := range trunkNames {
+		trunk, err := eni.NewTrunk(trunkName, nil, eni.TrunkIsolationModeVLAN)
+		if err == nil {
+			recordTrunk(trunk, netConfig)
+			return trunk, nil
+		}
+		log.Infof("Trunk interface %s is not available: %v.", trunkName, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", trunkName, err))
+	}
+
+	for _, trunkMACAddress := range trunkMACAddresses {
+		trunk, err := eni.NewTrunk("", trunkMACAddress, eni.TrunkIsolationModeVLAN)
+		if err == nil {
+			recordTrunk(trunk, netConfig)
+			return trunk, nil
+		}
+		log.Infof("Trunk interface with MAC address %s is not available: %v.", trunkMACAddress, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", trunkMACAddress, err))
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no trunk interface specified")
+	}
+
+	return nil, fmt.Errorf("none of the trunk interfaces is available: %s", strings.Join(errs, "; "))
+}
+
+// recordTrunk records the trunk interface in use in netConfig.
+func recordTrunk(trunk *eni.Trunk, netConfig *config.NetConfig) {
+	log.Infof("Using trunk interface %s.", trunk)
+	netConfig.TrunkName = trunk.GetLinkName()
+	netConfig.TrunkMACAddress = trunk.GetMACAddress()
+}
+
+// linkByName finds a link by name. It is a variable so that it can be replaced in unit tests.
+var linkByName = netlink.LinkByName
+
+// checkTrunkUp returns an error if the given trunk link is administratively down, as VLAN links
+// can be created on it but do not pass traffic. With bringUpTrunk, the trunk is brought up instead.
+func checkTrunkUp(trunkName string, netConfig *config.NetConfig) error {
+	link, err := linkByName(trunkName)
+	if err != nil {
+		log.Errorf("Failed to find trunk interface %s: %v.", trunkName, err)
+		return err
+	}
+
+	if link.Attrs().Flags&net.FlagUp != 0 {
+		return nil
+	}
+
+	if !netConfig.BringUpTrunk {
+		log.Errorf("Trunk interface %s is down.", trunkName)
+		return fmt.Errorf("trunk interface %s is administratively down, "+
+			"bring it up or set bringUpTrunk", trunkName)
+	}
+
+	log.Infof("Bringing up trunk interface %s.", trunkName)
+	err = trace(traceOpSetUp, trunkName, func() error {
+		return linkSetUp(link)
+	})
+	if err != nil {
+		log.Errorf("Failed to bring up trunk interface %s: %v.", trunkName, err)
+		return err
+	}
+
+	return nil
+}
+
+// findDelTrunk finds the trunk link name for DEL if it is not known, resolving the trunk from its
+// PCI address or device index if specified.
+func findDelTrunk(netConfig *config.NetConfig) error {
+	err := resolveTrunkName(netConfig)
+	if err != nil {
+		return err
+	}
+
+	if netConfig.TrunkName == "" || len(netConfig.TrunkNames) > 1 {
+		_, err = findTrunk(netConfig)
+		if err != nil {
+			log.Errorf("Failed to find trunk interface: %v.", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveTrunkName resolves the trunk interface name if the trunk is identified by its PCI address.
+// A trunk identified by its device index is resolved to its MAC address, which identifies the
+// local interface instead.
+func resolveTrunkName(netConfig *config.NetConfig) error {
+	if netConfig.TrunkInterfaceIndex != nil {
+		macAddress, err := getInterfaceMACAddressFromIMDS(*netConfig.TrunkInterfaceIndex)
+		if err != nil {
+			log.Errorf("Failed to find trunk interface at device index %d: %v.", *netConfig.TrunkInterfaceIndex, err)
+			return err
+		}
+
+		log.Infof("Found trunk interface %s at device index %d.", macAddress, *netConfig.TrunkInterfaceIndex)
+		netConfig.TrunkMACAddress = macAddress
+		netConfig.TrunkMACAddresses = []net.HardwareAddr{macAddress}
+		return nil
+	}
+
+	if netConfig.TrunkPCIAddress == "" {
+		return nil
+	}
+
+	linkName, err := eni.GetLinkNameByPCIAddress(netConfig.TrunkPCIAddress)
+	if err != nil {
+		log.Errorf("Failed to find trunk interface at PCI address %s: %v.", netConfig.TrunkPCIAddress, err)
+		return err
+	}
+
+	log.Infof("Found trunk interface %s at PCI address %s.", linkName, netConfig.TrunkPCIAddress)
+	netConfig.TrunkName = linkName
+	return nil
+}
+
+// getNetNS returns the target network namespace, which is given by name, by path, or by the pid
+// of a process in it, as some runtimes pass a pid instead of a netns path.
+func getNetNS(netnsPath string) (netns.NetNS, error) {
+	if pid, err := strconv.Atoi(netnsPath); err == nil {
+		return netns.GetNetNSByPid(pid)
+	}
+
+	return netns.GetNetNS(netnsPath)
+}
+
+// deleteLink deletes the link with the given name in the current network namespace.
+// Links that do not exist are considered already deleted.
+func deleteLink(linkName string) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			log.Debugf("Link %s does not exist, ignoring.", linkName)
+			return nil
+		}
+		return err
+	}
+
+	log.Infof("Deleting link: %v.", linkName)
+	return netlink.LinkDel(link)
+}
+
+// findExistingVLANLink looks for a VLAN link with the given name in the current network namespace.
+// It returns whether the link exists, and an error if an existing link does not match netConfig.
+func findExistingVLANLink(linkName string, netConfig *config.NetConfig) (bool, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return false, nil
+		}
+		log.Errorf("Failed to query link %s: %v.", linkName, err)
+		return false, err
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		log.Errorf("Failed to query addresses of link %s: %v.", linkName, err)
+		return false, err
+	}
+
+	err = validateExistingVLANLink(link, addrs, netConfig)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// validateExistingVLANLink validates that an existing link and its addresses match netConfig.
+func validateExistingVLANLink(link netlink.Link, addrs []netlink.Addr, netConfig *config.NetConfig) error {
+	linkName := link.Attrs().Name
+
+	switch {
+	case isAdoptedInterface(netConfig):
+		// Adopted interfaces keep their own link type.
+	case netConfig.InterfaceType == config.IfTypeMACVLAN:
+		macvlanLink, ok := link.(*netlink.Macvlan)
+		if !ok {
+			return fmt.Errorf("existing link %s has type %s, expected macvlan", linkName, link.Type())
+		}
+
+		if macvlanLink.Mode != getMACVLANMode(netConfig.MACVLANMode) {
+			return fmt.Errorf("existing link %s has MACVLAN mode %d, expected %s",
+				linkName, macvlanLink.Mode, netConfig.MACVLANMode)
+		}
+	default:
+		vlanLink, ok := link.(*netlink.Vlan)
+		if !ok {
+			return fmt.Errorf("existing link %s has type %s, expected vlan", linkName, link.Type())
+		}
+
+		if vlanLink.VlanId != netConfig.BranchVlanID {
+			return fmt.Errorf("existing link %s has VLAN ID %d, expected %d",
+				linkName, vlanLink.VlanId, netConfig.BranchVlanID)
+		}
+	}
+
+	// Interfaces adopted by name have whichever MAC address they came with.
+	expectedMAC := getInterfaceMACAddress(netConfig)
+	if expectedMAC != nil && !vpc.CompareMACAddress(link.Attrs().HardwareAddr, expectedMAC) {
+		return fmt.Errorf("existing link %s has MAC address %s, expected %s",
+			linkName, link.Attrs().HardwareAddr, expectedMAC)
+	}
+
+	// Every requested address must be assigned.
+	expectedAddrs := getBranchIPAddresses(netConfig)
+	for _, expected := range expectedAddrs {
+		found := false
+		for _, addr := range addrs {
+			if addr.IPNet != nil && addr.IP.Equal(expected.IP) &&
+				addr.Mask.String() == expected.Mask.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("existing link %s is missing IP address %s", linkName, expected.String())
+		}
+	}
+
+	// The management IP address must be assigned with its configured scope.
+	if expected := netConfig.ManagementIPAddress; expected != nil {
+		found := false
+		for _, addr := range addrs {
+			if addr.IPNet != nil && addr.IP.Equal(expected.IP) &&
+				addr.Scope == int(getManagementIPScope(netConfig.ManagementIPScope)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("existing link %s is missing management IP address %s", linkName, expected.String())
+		}
+	}
+
+	// No other global addresses must be assigned. Link-local addresses are managed by the kernel.
+	for _, addr := range addrs {
+		if addr.IPNet == nil || addr.Scope != unix.RT_SCOPE_UNIVERSE {
+			continue
+		}
+		found := false
+		for _, expected := range expectedAddrs {
+			if addr.IP.Equal(expected.IP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("existing link %s has unexpected IP address %s", linkName, addr.IPNet.String())
+		}
+	}
+
+	return nil
+}
+
+// validateVLANLink validates that a VLAN link, its addresses and its routes match netConfig.
+func validateVLANLink(
+	link netlink.Link,
+	addrs []netlink.Addr,
+	routes []netlink.Route,
+	netConfig *config.NetConfig) error {
+
+	err := validateExistingVLANLink(link, addrs, netConfig)
+	if err != nil {
+		return err
+	}
+
+	linkName := link.Attrs().Name
+
+	// Default routes are expected for each configured address family with a gateway. An ECMP
+	// default route is expected to have a nexthop via each IPv4 gateway.
+	var expectedRoutes []cniTypes.Route
+	for _, r := range getDefaultRoutes(netConfig) {
+		if r.GW.To4() == nil {
+			expectedRoutes = append(expectedRoutes, r)
+			continue
+		}
+		for _, gateway := range getIPv4Gateways(netConfig) {
+			expectedRoutes = append(expectedRoutes, cniTypes.Route{Dst: r.Dst, GW: gateway})
+		}
+	}
+	expectedRoutes = append(expectedRoutes, netConfig.Routes...)
+
+	for _, expected := range expectedRoutes {
+		found := false
+		for _, route := range routes {
+			if isSameRouteDst(route.Dst, &expected.Dst) && isRouteViaGateway(route, expected.GW) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("link %s is missing route to %s via %s", linkName, expected.Dst.String(), expected.GW)
+		}
+	}
+
+	return nil
+}
+
+// isRouteViaGateway returns whether the route, or any of its nexthops, is via the given gateway.
+func isRouteViaGateway(route netlink.Route, gatewayIPAddress net.IP) bool {
+	if route.Gw.Equal(gatewayIPAddress) {
+		return true
+	}
+
+	for _, nexthop := range route.MultiPath {
+		if nexthop.Gw.Equal(gatewayIPAddress) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSameRouteDst returns whether a route destination matches the expected destination.
+// Netlink reports default routes with a nil destination.
+func isSameRouteDst(dst *net.IPNet, expected *net.IPNet) bool {
+	if dst == nil {
+		ones, _ := expected.Mask.Size()
+		return ones == 0
+	}
+
+	return dst.IP.Equal(expected.IP) && dst.Mask.String() == expected.Mask.String()
+}
+
+// validateVMLink validates that the link connecting a VM to the branch has the expected type.
+func validateVMLink(link netlink.Link, netConfig *config.NetConfig) error {
+	expectedType := "tuntap"
+	if netConfig.InterfaceType == config.IfTypeMACVTAP {
+		expectedType = "macvtap"
+	} else if isExternalTAP(netConfig) {
+		expectedType = "bridge"
+	}
+
+	if link.Type() != expectedType {
+		return fmt.Errorf("link %s has type %s, expected %s", link.Attrs().Name, link.Type(), expectedType)
+	}
+
+	return nil
+}
+
+// createVLANLink creates a VLAN link in the target network namespace. If lease is not nil, it is
+// called to acquire the branch IP addresses once the link is up.
+func createVLANLink(
+	branch *eni.ENI,
+	linkName string,
+	netConfig *config.NetConfig,
+	lease leaseFunc) error {
+
+	// Rename the branch link to the requested interface name.
+	if branch.GetLinkName() != linkName {
+		log.Infof("Renaming branch link %v to %s.", branch, linkName)
+		err := trace(traceOpRenameLink, branch.GetLinkName()+" "+linkName, func() error {
+			return branch.SetLinkName(linkName)
+		})
+		if err != nil {
+			log.Errorf("Failed to rename branch link %v: %v.", branch, err)
+			return err
+		}
+	}
+
+	// Set branch link operational state up, unless it is brought up once its addresses are
+	// assigned, to avoid a burst of neighbor discovery for each of them.
+	var err error
+	if !netConfig.BringUpAfterConfig {
+		err = setBranchLinkUp(branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Acquire the DHCP lease on the link, which provides the addresses and gateways set below.
+	if lease != nil {
+		log.Infof("Acquiring lease for branch link %v.", branch)
+		err = lease()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Set branch IP addresses.
+	for _, ipAddress := range getBranchIPAddresses(netConfig) {
+		// IPv4 addresses are probed for duplicates before they are assigned.
+		isIPv4 := ipAddress.IP.To4() != nil
+		if netConfig.DuplicateAddrDetection && isIPv4 {
+			err = checkAddressConflict(branch.GetLinkIndex(), &ipAddress)
+			if err != nil {
+				return cni.NewError(cni.ErrCodeAddressAssignment, err)
+			}
+		}
+
+		err = addBranchIPAddress(branch, &ipAddress, netConfig)
+		if err != nil {
+			log.Errorf("Failed to assign IP address to branch link %v: %v.", branch, err)
+			return cni.NewError(cni.ErrCodeAddressAssignment, err)
+		}
+
+		// IPv6 addresses are probed by the kernel once they are assigned. Remove the duplicate
+		// address, as the branch link may be reused from a previous invocation.
+		if netConfig.DuplicateAddrDetection && !isIPv4 {
+			err = checkAddressConflict(branch.GetLinkIndex(), &ipAddress)
+			if err != nil {
+				if delErr := branch.DeleteIPAddress(&ipAddress); delErr != nil {
+					log.Errorf("Failed to delete duplicate IP address %v: %v.", ipAddress, delErr)
+				}
+				return cni.NewError(cni.ErrCodeAddressAssignment, err)
+			}
+		}
+	}
+
+	// Assign the management IP address. No default route is added via it.
+	if netConfig.ManagementIPAddress != nil {
+		err = addManagementIPAddress(branch, netConfig)
+		if err != nil {
+			return cni.NewError(cni.ErrCodeAddressAssignment, err)
+		}
 	}
 
-	// Bring up the trunk ENI.
-	err = trunk.SetOpState(true)
+	// Add static neighbor entries, so that they are in place before any traffic is routed.
+	err = addStaticNeighbors(branch.GetLinkIndex(), netConfig.StaticNeighbors)
 	if err != nil {
-		log.Errorf("Failed to bring up trunk interface %s: %v", netConfig.TrunkName, err)
 		return err
 	}
 
-	// Create the branch ENI.
-	branchName := fmt.Sprintf(branchLinkNameFormat, trunk.GetLinkName(), netConfig.BranchVlanID)
-	branch, err := eni.NewBranch(trunk, branchName, netConfig.BranchMACAddress, netConfig.BranchVlanID)
-	if err != nil {
-		log.Errorf("Failed to create branch interface %s: %v.", branchName, err)
-		return err
+	// Routes can only be added via links that are up.
+	if netConfig.BringUpAfterConfig {
+		err = setBranchLinkUp(branch)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Create a link for the branch ENI.
-	log.Infof("Creating branch link %s.", branchName)
-	overrideMAC := netConfig.InterfaceType == config.IfTypeVLAN
-	err = branch.AttachToLink(overrideMAC)
-	if err != nil {
-		if os.IsExist(err) {
-			// If the branch link already exists, it may have been created in a previous invocation
-			// of this plugin. Look for it in the target network namespace and reset it.
-			err = ns.Run(func() error {
-				err := branch.ENI.AttachToLink()
-				if err != nil {
-					return err
-				}
-
-				if netConfig.BranchIPAddress != nil {
-					err = branch.DeleteIPAddress(netConfig.BranchIPAddress)
-					if os.IsNotExist(err) {
-						err = nil
-					} else if err != nil {
-						log.Errorf("Failed to reset branch link: %v", err)
-					}
-				}
-				return err
-			})
+	// Add default routes via branch link for each configured address family.
+	for _, r := range getDefaultRoutes(netConfig) {
+		err = addDefaultRoute(branch, r.GW, netConfig)
+		if err != nil {
+			return err
 		}
+	}
+
+	// Add static routes via branch link.
+	for _, r := range netConfig.Routes {
+		route := newStaticRoute(branch.GetLinkIndex(), r, netConfig)
+		log.Infof("Adding static IP route %+v.", route)
+		err = trace(traceOpAddRoute, route.String(), func() error {
+			return routeAdd(route)
+		})
 		if err != nil {
-			log.Errorf("Failed to attach branch interface %s: %v.", branchName, err)
+			log.Errorf("Failed to add IP route %+v via branch %v: %v.", route, branch, err)
 			return err
 		}
-	} else {
-		// Move branch ENI to the network namespace.
-		log.Infof("Moving branch link %s to netns %s.", branch, args.Netns)
-		err = branch.SetNetNS(ns)
+	}
+
+	// Add routes to the nameservers that the DNS stub forwards queries to.
+	if netConfig.DNSStub {
+		err = addDNSStubRoutes(branch.GetLinkIndex(), netConfig)
 		if err != nil {
-			log.Errorf("Failed to move branch link: %v.", err)
 			return err
 		}
 	}
 
-	// Complete the remaining setup in target network namespace.
-	err = ns.Run(func() error {
-		var err error
+	// Direct traffic from the branch IP addresses to the branch route table.
+	return addBranchRules(netConfig)
+}
 
-		// Create the container-facing link based on the requested interface type.
-		switch netConfig.InterfaceType {
-		case config.IfTypeVLAN:
-			// Container is running in a network namespace on this host.
-			err = plugin.createVLANLink(branch, args.IfName, netConfig.BranchIPAddress, netConfig.BranchGatewayIPAddress)
-		case config.IfTypeTAP:
-			// Container is running in a VM.
-			// Connect the branch ENI to a TAP link in the target network namespace.
-			bridgeName := fmt.Sprintf(bridgeNameFormat, netConfig.BranchVlanID)
-			err = plugin.createTAPLink(branch, bridgeName, args.IfName, netConfig.Tap)
-		case config.IfTypeMACVTAP:
-			// Container is running in a VM.
-			// Connect the branch ENI to a MACVTAP link in the target network namespace.
-			err = plugin.createMACVTAPLink(args.IfName, branch.GetLinkIndex())
-		}
+// addBranchIPAddress assigns the given branch IP address to the branch link with the configured
+// scope and flags.
+func addBranchIPAddress(branch *eni.ENI, ipAddress *net.IPNet, netConfig *config.NetConfig) error {
+	scope := getAddressScope(netConfig.AddressScope)
 
-		// Add a blackhole route for IMDS endpoint if required.
-		if netConfig.BlockIMDS {
-			err = imds.BlockInstanceMetadataEndpoint()
-			if err != nil {
-				return err
+	log.Infof("Assigning IP address %v with scope %s to branch link.", ipAddress, scope)
+	return defaultRetryPolicy.run("IP address assignment", func() error {
+		return trace(traceOpAddAddr, ipAddress.String(), func() error {
+			err := addScopedIPAddress(branch, ipAddress, scope, getAddressFlags(netConfig))
+			if err == unix.EEXIST {
+				// The address may be left over from a previous invocation.
+				return checkExistingIPAddress(branch.GetLinkIndex(), ipAddress)
 			}
-		}
+			return err
+		})
+	})
+}
 
-		// Set branch link operational state up. VLAN interfaces were already brought up above.
-		if netConfig.InterfaceType != config.IfTypeVLAN && err == nil {
-			log.Infof("Setting branch link state up.")
-			err = branch.SetOpState(true)
-			if err != nil {
-				log.Errorf("Failed to set branch link %v state: %v.", branch, err)
-				return err
-			}
-		}
+// addManagementIPAddress assigns the management IP address to the branch link with its
+// configured scope.
+func addManagementIPAddress(branch *eni.ENI, netConfig *config.NetConfig) error {
+	ipAddress := netConfig.ManagementIPAddress
+	scope := getManagementIPScope(netConfig.ManagementIPScope)
 
+	log.Infof("Assigning management IP address %v with scope %s to branch link.", ipAddress, scope)
+	err := trace(traceOpAddAddr, ipAddress.String(), func() error {
+		err := addScopedIPAddress(branch, ipAddress, scope, getAddressFlags(netConfig))
+		if err == unix.EEXIST {
+			// The address may be left over from a previous invocation.
+			return checkExistingIPAddress(branch.GetLinkIndex(), ipAddress)
+		}
 		return err
 	})
+	if err != nil {
+		log.Errorf("Failed to assign management IP address to branch link %v: %v.", branch, err)
+		return err
+	}
+
+	return nil
+}
 
+// setBranchLinkUp sets the operational state of the branch link up.
+func setBranchLinkUp(branch *eni.ENI) error {
+	err := trace(traceOpSetUp, branch.GetLinkName(), func() error {
+		return branch.SetOpState(true)
+	})
 	if err != nil {
-		log.Errorf("Failed to setup the link: %v.", err)
+		log.Errorf("Failed to set branch link %v state: %v.", branch, err)
 		return err
 	}
 
-	// Generate CNI result.
-	// IP addresses, routes and DNS are configured by VPC DHCP servers.
-	result := &cniTypesCurrent.Result{
-		Interfaces: []*cniTypesCurrent.Interface{
-			{
-				Name:    args.IfName,
-				Mac:     netConfig.BranchMACAddress.String(),
-				Sandbox: args.Netns,
-			},
-		},
+	return nil
+}
+
+// newStaticRoute returns the netlink route for a static route via the given link in the branch
+// route table, tagged with the route protocol of the plugin. Routes without a gateway are on-link.
+func newStaticRoute(linkIndex int, r cniTypes.Route, netConfig *config.NetConfig) *netlink.Route {
+	dst := r.Dst
+	route := &netlink.Route{
+		Dst:       &dst,
+		Gw:        r.GW,
+		LinkIndex: linkIndex,
+		Table:     netConfig.RouteTableID,
+		Protocol:  netConfig.RouteProtocol,
 	}
 
-	log.Infof("Writing CNI result to stdout: %+v", result)
+	if r.GW == nil {
+		route.Scope = netlink.SCOPE_LINK
+	}
 
-	return cniTypes.PrintResult(result, netConfig.CNIVersion)
+	return route
 }
 
-// Del is the internal implementation of CNI DEL command.
-// CNI DEL command can be called by the orchestrator agent multiple times for the same interface,
-// and thus must be best-effort and idempotent.
-func (plugin *Plugin) Del(args *cniSkel.CmdArgs) error {
-	// Parse network configuration.
-	netConfig, err := config.New(args)
+// deleteStaticRoutes deletes the static routes added by this plugin via the given link. The kernel
+// only deletes routes tagged with the route protocol of the plugin, leaving those of others alone.
+func deleteStaticRoutes(linkName string, netConfig *config.NetConfig) error {
+	if len(netConfig.Routes) == 0 {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(linkName)
 	if err != nil {
-		log.Errorf("Failed to parse netconfig from args: %v.", err)
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
 		return err
 	}
 
-	log.Infof("Executing DEL with netconfig: %+v.", netConfig)
+	for _, r := range netConfig.Routes {
+		route := newStaticRoute(link.Attrs().Index, r, netConfig)
+		log.Infof("Deleting static IP route %+v.", route)
+		err = routeDel(route)
+		if err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
 
-	// Derive names from CNI network config.
-	var branchName string
-	if netConfig.InterfaceType == config.IfTypeVLAN {
-		branchName = args.IfName
-	} else {
-		// Find the trunk link name if not known.
-		if netConfig.TrunkName == "" {
-			trunk, err := eni.NewTrunk("", netConfig.TrunkMACAddress, eni.TrunkIsolationModeVLAN)
-			if err != nil {
-				// Log and ignore the failure.
-				log.Errorf("Failed to find trunk with MAC address %v: %v.", netConfig.TrunkMACAddress, err)
-				return nil
-			}
-			netConfig.TrunkName = trunk.GetLinkName()
+	return nil
+}
+
+// addDefaultRoute adds a default route via the given gateway on the branch link.
+func addDefaultRoute(branch *eni.ENI, gatewayIPAddress net.IP, netConfig *config.NetConfig) error {
+	route := newBranchDefaultRoute(branch.GetLinkIndex(), gatewayIPAddress, netConfig)
+	log.Infof("Adding default IP route %+v.", route)
+	err := trace(traceOpAddRoute, route.String(), func() error {
+		return routeAdd(route)
+	})
+	if err != nil {
+		log.Errorf("Failed to add IP route %+v via branch %v: %v.", route, branch, err)
+		return err
+	}
+
+	return nil
+}
+
+// newBranchDefaultRoute returns the netlink route for the default route via the given gateway
+// on the branch link. With multiple IPv4 gateways, the IPv4 default route has a nexthop via
+// each of them instead of a single gateway.
+func newBranchDefaultRoute(linkIndex int, gatewayIPAddress net.IP, netConfig *config.NetConfig) *netlink.Route {
+	route := newDefaultRoute(linkIndex, gatewayIPAddress, netConfig.DefaultRouteMetric)
+	route.Table = netConfig.RouteTableID
+	route.Protocol = netConfig.RouteProtocol
+	route.Src = getDefaultRouteSource(gatewayIPAddress, netConfig)
+
+	gateways := []net.IP{gatewayIPAddress}
+	if gatewayIPAddress.To4() != nil {
+		gateways = getIPv4Gateways(netConfig)
+	}
+
+	if len(gateways) == 1 {
+		if isOffSubnetGateway(gatewayIPAddress, netConfig) {
+			route.Flags = int(netlink.FLAG_ONLINK)
 		}
-		branchName = fmt.Sprintf(branchLinkNameFormat, netConfig.TrunkName, netConfig.BranchVlanID)
+		return route
 	}
-	tapBridgeName := fmt.Sprintf(bridgeNameFormat, netConfig.BranchVlanID)
-	tapLinkName := args.IfName
 
-	// Search for the target network namespace.
-	netns, err := netns.GetNetNS(args.Netns)
-	if err == nil {
-		// In target network namespace...
-		err = netns.Run(func() error {
-			if netConfig.InterfaceType == config.IfTypeMACVTAP ||
-				netConfig.InterfaceType == config.IfTypeTAP {
-				// Delete the tap link.
-				la := netlink.NewLinkAttrs()
-				la.Name = tapLinkName
-				tapLink := &netlink.Tuntap{LinkAttrs: la}
-				log.Infof("Deleting tap link: %v.", tapLinkName)
-				err = netlink.LinkDel(tapLink)
-				if err != nil {
-					log.Errorf("Failed to delete tap link: %v.", err)
-				}
-			}
+	route.Gw = nil
+	for _, gateway := range gateways {
+		nexthop := &netlink.NexthopInfo{
+			LinkIndex: linkIndex,
+			Gw:        gateway,
+		}
+		if isOffSubnetGateway(gateway, netConfig) {
+			nexthop.Flags = int(netlink.FLAG_ONLINK)
+		}
+		route.MultiPath = append(route.MultiPath, nexthop)
+	}
 
-			// Delete the branch link.
-			la := netlink.NewLinkAttrs()
-			la.Name = branchName
-			branchLink := &netlink.Vlan{LinkAttrs: la}
-			log.Infof("Deleting branch link: %v.", branchName)
-			err = netlink.LinkDel(branchLink)
-			if err != nil {
-				log.Errorf("Failed to delete branch link: %v.", err)
-			}
+	return route
+}
 
-			if netConfig.InterfaceType == config.IfTypeTAP {
-				// Delete the tap bridge.
-				la = netlink.NewLinkAttrs()
-				la.Name = tapBridgeName
-				tapBridge := &netlink.Bridge{LinkAttrs: la}
-				log.Infof("Deleting tap bridge: %v.", tapBridgeName)
-				err = netlink.LinkDel(tapBridge)
-				if err != nil {
-					log.Errorf("Failed to delete tap bridge: %v.", err)
-				}
-			}
+// isOffSubnetGateway returns whether the given IPv4 gateway is outside of all branch subnets, as
+// is the case for host addresses such as those assigned from a delegated prefix. Such gateways
+// must be explicitly marked as on-link. IPv6 gateways are typically link-local and thus on-link.
+// Without prefix routes, no subnet is on-link.
+func isOffSubnetGateway(gatewayIPAddress net.IP, netConfig *config.NetConfig) bool {
+	if gatewayIPAddress.To4() == nil {
+		return false
+	}
 
-			return nil
-		})
-	} else {
-		// Log and ignore the failure. DEL can be called multiple times and thus must be idempotent.
-		log.Errorf("Failed to find netns %s, ignoring: %v.", args.Netns, err)
+	if netConfig.NoPrefixRoute {
+		return true
+	}
+
+	for _, ipAddress := range netConfig.BranchIPAddresses {
+		if ipAddress.Contains(gatewayIPAddress) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getDefaultRouteSource returns the preferred source IP address of the default route via the
+// given gateway, so that outbound traffic uses the branch IP address even when the netns has
+// other addresses. Only IPv4 default routes carry a source, as IPv6 addresses that are still
+// tentative during duplicate address detection cannot be used as a route source.
+func getDefaultRouteSource(gatewayIPAddress net.IP, netConfig *config.NetConfig) net.IP {
+	if !netConfig.DefaultRouteSource || gatewayIPAddress.To4() == nil || netConfig.BranchIPAddress == nil {
+		return nil
+	}
+	return netConfig.BranchIPAddress.IP
+}
+
+// getAddressFlags returns the flags with which the branch IP addresses are assigned.
+func getAddressFlags(netConfig *config.NetConfig) int {
+	if netConfig.NoPrefixRoute {
+		return unix.IFA_F_NOPREFIXROUTE
+	}
+	return 0
+}
+
+// newDefaultRoute returns the netlink route for a default route via the given gateway and link.
+// A zero metric leaves the route priority to the kernel default.
+func newDefaultRoute(linkIndex int, gatewayIPAddress net.IP, metric int) *netlink.Route {
+	return &netlink.Route{
+		Gw:        gatewayIPAddress,
+		LinkIndex: linkIndex,
+		Priority:  metric,
 	}
+}
 
+// getIPv4Gateways returns the IPv4 gateways of the branch. Multiple gateways share a single
+// ECMP default route.
+func getIPv4Gateways(netConfig *config.NetConfig) []net.IP {
+	if len(netConfig.BranchGatewayIPAddresses) > 1 {
+		return netConfig.BranchGatewayIPAddresses
+	}
+	if netConfig.BranchGatewayIPAddress != nil {
+		return []net.IP{netConfig.BranchGatewayIPAddress}
+	}
 	return nil
 }
 
-// createVLANLink creates a VLAN link in the target network namespace.
-func (plugin *Plugin) createVLANLink(
-	branch *eni.Branch,
-	linkName string,
-	ipAddress *net.IPNet,
-	gatewayIPAddress net.IP) error {
+// getBranchGateways returns all IPv4 and IPv6 gateways of the branch.
+func getBranchGateways(netConfig *config.NetConfig) []net.IP {
+	gateways := getIPv4Gateways(netConfig)
+	if netConfig.BranchGatewayIPv6Address != nil {
+		gateways = append(gateways, netConfig.BranchGatewayIPv6Address)
+	}
+	return gateways
+}
 
-	// Rename the branch link to the requested interface name.
-	if branch.GetLinkName() != linkName {
-		log.Infof("Renaming branch link %v to %s.", branch, linkName)
-		err := branch.SetLinkName(linkName)
-		if err != nil {
-			log.Errorf("Failed to rename branch link %v: %v.", branch, err)
-			return err
-		}
+// getInterfaceMACAddress returns the MAC address of the container-facing link.
+func getInterfaceMACAddress(netConfig *config.NetConfig) net.HardwareAddr {
+	if netConfig.InterfaceMACAddress != nil {
+		return netConfig.InterfaceMACAddress
+	}
+
+	return netConfig.BranchMACAddress
+}
+
+// setInterfaceMACAddress sets the MAC address of the container-facing link, if one distinct from
+// the branch MAC address is specified.
+func setInterfaceMACAddress(netConfig *config.NetConfig) error {
+	if netConfig.InterfaceMACAddress == nil {
+		return nil
+	}
+
+	log.Infof("Setting link %s MAC address to %s.", netConfig.InterfaceName, netConfig.InterfaceMACAddress)
+	err := trace(traceOpSetMAC, netConfig.InterfaceName+" "+netConfig.InterfaceMACAddress.String(), func() error {
+		return setLinkMACAddress(netConfig.InterfaceName, netConfig.InterfaceMACAddress)
+	})
+	if err != nil {
+		log.Errorf("Failed to set link %s MAC address: %v.", netConfig.InterfaceName, err)
+		return err
 	}
 
-	// Set branch link operational state up.
-	err := branch.SetOpState(true)
+	return nil
+}
+
+// setLinkMACAddress sets the MAC address of the link with the given name. It is a variable so
+// that it can be replaced in unit tests.
+var setLinkMACAddress = func(linkName string, macAddress net.HardwareAddr) error {
+	link, err := netlink.LinkByName(linkName)
 	if err != nil {
-		log.Errorf("Failed to set branch link %v state: %v.", branch, err)
 		return err
 	}
 
-	// Set branch IP address and default gateway if specified.
-	if ipAddress != nil {
-		// Assign the IP address.
-		log.Infof("Assigning IP address %v to branch link.", ipAddress)
-		err = branch.AddIPAddress(ipAddress)
+	return netlink.LinkSetHardwareAddr(link, macAddress)
+}
+
+// Link and address list operations. They are variables so that they can be replaced in unit tests.
+var (
+	linkList = netlink.LinkList
+	addrList = netlink.AddrList
+)
+
+// checkExistingIPAddress checks an IP address that could not be assigned to the given link because
+// it already exists. It returns nil if the link already has the exact same address, and an error
+// if the address is assigned with a different prefix length or to a different link.
+func checkExistingIPAddress(linkIndex int, address *net.IPNet) error {
+	links, err := linkList()
+	if err != nil {
+		return err
+	}
+
+	assigned := false
+	for _, link := range links {
+		addrs, err := addrList(link, netlink.FAMILY_ALL)
 		if err != nil {
-			log.Errorf("Failed to assign IP address to branch link %v: %v.", branch, err)
 			return err
 		}
 
-		// Add default route via branch link.
-		route := &netlink.Route{
-			Gw:        gatewayIPAddress,
-			LinkIndex: branch.GetLinkIndex(),
-		}
-		log.Infof("Adding default IP route %+v.", route)
-		err = netlink.RouteAdd(route)
-		if err != nil {
-			log.Errorf("Failed to add IP route %+v via branch %v: %v.", route, branch, err)
-			return err
+		for _, addr := range addrs {
+			if !addr.IP.Equal(address.IP) {
+				continue
+			}
+
+			if link.Attrs().Index != linkIndex {
+				return fmt.Errorf("IP address %s is already assigned to link %s", address.IP, link.Attrs().Name)
+			}
+			if addr.Mask.String() != address.Mask.String() {
+				return fmt.Errorf("IP address %s is already assigned to the branch link as %s", address.IP, addr.IPNet)
+			}
+
+			assigned = true
 		}
 	}
 
+	if !assigned {
+		return unix.EEXIST
+	}
+
+	log.Infof("IP address %v is already assigned to the branch link.", address)
 	return nil
 }
 
+// getBranchLinkName returns the name of the branch link on the given trunk. It is the host
+// interface name if one is configured. Otherwise it is derived from the trunk name and VLAN ID,
+// or from the branch MAC address for MACVLAN links.
+func getBranchLinkName(trunkName string, netConfig *config.NetConfig) string {
+	if netConfig.HostInterfaceName != "" {
+		return netConfig.HostInterfaceName
+	}
+	if netConfig.InterfaceType == config.IfTypeMACVLAN {
+		return fmt.Sprintf(macvlanLinkNameFormat, []byte(netConfig.BranchMACAddress[2:]))
+	}
+	return fmt.Sprintf(branchLinkNameFormat, trunkName, netConfig.BranchVlanID)
+}
+
+// isBranchInterface returns whether the container-facing interface is the branch link itself,
+// as with VLAN and MACVLAN interfaces, rather than a TAP or MACVTAP link connected to it.
+func isBranchInterface(netConfig *config.NetConfig) bool {
+	return netConfig.InterfaceType == config.IfTypeVLAN || netConfig.InterfaceType == config.IfTypeMACVLAN
+}
+
+// isExternalTAP returns whether the TAP link is created by the VMM instead of this plugin.
+func isExternalTAP(netConfig *config.NetConfig) bool {
+	return netConfig.Tap != nil && netConfig.Tap.ExternallyManaged
+}
+
+// getBranchIPAddresses returns all IPv4 and IPv6 addresses to be assigned to the branch link.
+func getBranchIPAddresses(netConfig *config.NetConfig) []net.IPNet {
+	ipAddresses := append([]net.IPNet{}, netConfig.BranchIPAddresses...)
+	if netConfig.BranchIPv6Address != nil {
+		ipAddresses = append(ipAddresses, *netConfig.BranchIPv6Address)
+	}
+
+	return ipAddresses
+}
+
+// newTAPLink returns the attributes of a TAP link with the given name, master and MTU.
+// The link is created as a multi-queue device if more than one queue is requested.
+func newTAPLink(linkName string, masterIndex int, mtu int, tapCfg *config.TAPConfig) *netlink.Tuntap {
+	la := netlink.NewLinkAttrs()
+	la.Name = linkName
+	la.MasterIndex = masterIndex
+	la.MTU = mtu
+
+	// Parse headers added by virtio_net implementation.
+	tapLink := &netlink.Tuntap{
+		LinkAttrs: la,
+		Mode:      netlink.TUNTAP_MODE_TAP,
+		Flags:     netlink.TUNTAP_VNET_HDR,
+		Queues:    tapCfg.Queues,
+	}
+
+	if tapCfg.Queues == 1 {
+		tapLink.Flags |= netlink.TUNTAP_ONE_QUEUE
+	} else {
+		tapLink.Flags |= netlink.TUNTAP_MULTI_QUEUE
+	}
+
+	return tapLink
+}
+
 // createTAPLink creates a TAP link in the target network namespace.
-func (plugin *Plugin) createTAPLink(
+func createTAPLink(
 	branch *eni.Branch,
 	bridgeName string,
 	tapLinkName string,
-	tapCfg *config.TAPConfig) error {
+	tapCfg *config.TAPConfig,
+	mtu int) error {
+
+	// TAP interfaces default to jumbo frames unless an explicit MTU is specified.
+	if mtu == 0 {
+		mtu = vpc.JumboFrameMTU
+	}
 
 	// Create the bridge link.
 	la := netlink.NewLinkAttrs()
 	la.Name = bridgeName
-	la.MTU = vpc.JumboFrameMTU
+	la.MTU = mtu
 	bridge := &netlink.Bridge{LinkAttrs: la}
 	log.Infof("Creating bridge link %+v.", bridge)
-	err := netlink.LinkAdd(bridge)
+	err := trace(traceOpCreateLink, bridgeName, func() error {
+		return netlink.LinkAdd(bridge)
+	})
 	if err != nil {
 		log.Errorf("Failed to create bridge link: %v", err)
 		return err
 	}
 
 	// Set bridge link MTU.
-	err = netlink.LinkSetMTU(bridge, vpc.JumboFrameMTU)
+	err = netlink.LinkSetMTU(bridge, mtu)
 	if err != nil {
 		log.Errorf("Failed to set bridge link MTU: %v", err)
 		return err
@@ -350,14 +1909,18 @@ func (plugin *Plugin) createTAPLink(
 		return err
 	}
 
-	err = branch.SetMACAddress(bridgeLink.Attrs().HardwareAddr)
+	err = trace(traceOpSetMAC, branch.GetLinkName()+" "+bridgeLink.Attrs().HardwareAddr.String(), func() error {
+		return branch.SetMACAddress(bridgeLink.Attrs().HardwareAddr)
+	})
 	if err != nil {
 		log.Errorf("Failed to set branch link MAC address: %v.", err)
 		return err
 	}
 
 	// Set bridge link operational state up.
-	err = netlink.LinkSetUp(bridge)
+	err = trace(traceOpSetUp, bridgeName, func() error {
+		return netlink.LinkSetUp(bridge)
+	})
 	if err != nil {
 		log.Errorf("Failed to set bridge link state: %v", err)
 		return err
@@ -373,32 +1936,44 @@ func (plugin *Plugin) createTAPLink(
 		return err
 	}
 
-	// Create the TAP link.
-	// Parse headers added by virtio_net implementation.
-	la = netlink.NewLinkAttrs()
-	la.Name = tapLinkName
-	la.MasterIndex = bridge.Index
-	la.MTU = vpc.JumboFrameMTU
-	tapLink := &netlink.Tuntap{
-		LinkAttrs: la,
-		Mode:      netlink.TUNTAP_MODE_TAP,
-		Flags:     netlink.TUNTAP_VNET_HDR,
-		Queues:    tapCfg.Queues,
-	}
+	return addTAPDevice(tapLinkName, bridge.Index, mtu, tapCfg)
+}
 
-	if tapCfg.Queues == 1 {
-		tapLink.Flags |= netlink.TUNTAP_ONE_QUEUE
+// getInterfaceMACAddressFromIMDS returns the MAC address of the ENI at the given device index. It
+// is a variable so that it can be replaced in unit tests.
+var getInterfaceMACAddressFromIMDS = imds.GetInterfaceMACAddress
+
+// addScopedIPAddress assigns an IP address to a branch link. It is a variable so that it can be
+// replaced in unit tests.
+var addScopedIPAddress = (*eni.ENI).AddScopedIPAddress
+
+// tapLinkAdd creates a TAP link. It is a variable so that it can be replaced in unit tests.
+var tapLinkAdd = func(tapLink *netlink.Tuntap) error {
+	return netlink.LinkAdd(tapLink)
+}
+
+// addTAPDevice creates the TAP link connected to the given bridge and sets its owner, unless the
+// TAP device is externally managed, in which case the VMM creates it and connects it to the bridge.
+func addTAPDevice(tapLinkName string, bridgeIndex int, mtu int, tapCfg *config.TAPConfig) error {
+	if tapCfg.ExternallyManaged {
+		log.Infof("TAP link %s is externally managed, skipping its creation for UID %d and GID %d.",
+			tapLinkName, tapCfg.Uid, tapCfg.Gid)
+		return nil
 	}
 
+	// Create the TAP link.
+	tapLink := newTAPLink(tapLinkName, bridgeIndex, mtu, tapCfg)
 	log.Infof("Creating TAP link %+v.", tapLink)
-	err = netlink.LinkAdd(tapLink)
+	err := trace(traceOpCreateLink, tapLinkName, func() error {
+		return tapLinkAdd(tapLink)
+	})
 	if err != nil {
 		log.Errorf("Failed to add TAP link: %v", err)
 		return err
 	}
 
 	// Set TAP link MTU.
-	err = netlink.LinkSetMTU(tapLink, vpc.JumboFrameMTU)
+	err = netlink.LinkSetMTU(tapLink, mtu)
 	if err != nil {
 		log.Errorf("Failed to set TAP link MTU: %v", err)
 		return err
@@ -424,7 +1999,9 @@ func (plugin *Plugin) createTAPLink(
 	}
 
 	// Set TAP link operational state up.
-	err = netlink.LinkSetUp(tapLink)
+	err = trace(traceOpSetUp, tapLinkName, func() error {
+		return netlink.LinkSetUp(tapLink)
+	})
 	if err != nil {
 		log.Errorf("Failed to set TAP link state: %v", err)
 		return err
@@ -434,27 +2011,40 @@ func (plugin *Plugin) createTAPLink(
 }
 
 // createMACVTAPLink creates a MACVTAP link in the target network namespace.
-func (plugin *Plugin) createMACVTAPLink(linkName string, parentIndex int) error {
+func createMACVTAPLink(linkName string, parentIndex int, tapCfg *config.TAPConfig) error {
 	// Create a MACVTAP link attached to the parent link.
 	la := netlink.NewLinkAttrs()
 	la.Name = linkName
 	la.ParentIndex = parentIndex
 	macvtapLink := &netlink.Macvtap{
-		netlink.Macvlan{
+		Macvlan: netlink.Macvlan{
 			LinkAttrs: la,
 			Mode:      netlink.MACVLAN_MODE_PASSTHRU,
 		},
 	}
 
 	log.Infof("Creating MACVTAP link %+v.", macvtapLink)
-	err := netlink.LinkAdd(macvtapLink)
+	err := trace(traceOpCreateLink, linkName, func() error {
+		return netlink.LinkAdd(macvtapLink)
+	})
 	if err != nil {
 		log.Errorf("Failed to add MACVTAP link: %v.", err)
 		return err
 	}
 
+	// Set MACVTAP device ownership.
+	devicePath := fmt.Sprintf(macvtapDevicePathFormat, macvtapLink.Index)
+	log.Infof("Setting MACVTAP device %s owner to UID %d and GID %d.", devicePath, tapCfg.Uid, tapCfg.Gid)
+	err = os.Chown(devicePath, tapCfg.Uid, tapCfg.Gid)
+	if err != nil {
+		log.Errorf("Failed to set MACVTAP device ownership: %v.", err)
+		return err
+	}
+
 	// Set MACVTAP link operational state up.
-	err = netlink.LinkSetUp(macvtapLink)
+	err = trace(traceOpSetUp, linkName, func() error {
+		return netlink.LinkSetUp(macvtapLink)
+	})
 	if err != nil {
 		log.Errorf("Failed to set MACVTAP link state: %v.", err)
 		return err
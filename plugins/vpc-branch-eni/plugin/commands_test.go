@@ -0,0 +1,795 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+	"github.com/aws/amazon-vpc-cni-plugins/logger"
+	"github.com/aws/amazon-vpc-cni-plugins/network/eni"
+	"github.com/aws/amazon-vpc-cni-plugins/network/imds"
+	"github.com/aws/amazon-vpc-cni-plugins/network/vpc"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	testBranchNetConfig = `{"cniVersion":"0.3.1", "trunkName":"eth1", "branchVlanID":"101",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20", "interfaceType":"vlan"}`
+)
+
+// realSetLinkMACAddress is the link MAC address operation used outside of unit tests.
+var realSetLinkMACAddress = setLinkMACAddress
+
+// newTestVLANLink returns a VLAN link object for tests.
+func newTestVLANLink(vlanID int, macAddress string) *netlink.Vlan {
+	la := netlink.NewLinkAttrs()
+	la.Name = testIfName
+	la.HardwareAddr, _ = net.ParseMAC(macAddress)
+	return &netlink.Vlan{LinkAttrs: la, VlanId: vlanID}
+}
+
+// newTestAddr returns an address object for tests.
+func newTestAddr(address string, scope int) netlink.Addr {
+	ip, ipNet, _ := net.ParseCIDR(address)
+	ipNet.IP = ip
+	return netlink.Addr{IPNet: ipNet, Scope: scope}
+}
+
+// TestExistingVLANLinkMatches tests that an existing link matching the config is accepted.
+func TestExistingVLANLinkMatches(t *testing.T) {
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	link := newTestVLANLink(101, "02:e1:48:75:86:a4")
+	addrs := []netlink.Addr{
+		newTestAddr("172.31.19.6/20", unix.RT_SCOPE_UNIVERSE),
+		newTestAddr("fe80::e1:48ff:fe75:86a4/64", unix.RT_SCOPE_LINK),
+	}
+
+	err := validateExistingVLANLink(link, addrs, nc)
+	assert.NoError(t, err)
+}
+
+// TestExistingVLANLinkDiffers tests that an existing link not matching the config is rejected.
+func TestExistingVLANLinkDiffers(t *testing.T) {
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	matchingAddrs := []netlink.Addr{newTestAddr("172.31.19.6/20", unix.RT_SCOPE_UNIVERSE)}
+
+	testCases := []struct {
+		name  string
+		link  netlink.Link
+		addrs []netlink.Addr
+	}{
+		{
+			name:  "different VLAN ID",
+			link:  newTestVLANLink(102, "02:e1:48:75:86:a4"),
+			addrs: matchingAddrs,
+		},
+		{
+			name:  "different MAC address",
+			link:  newTestVLANLink(101, "02:e1:48:75:86:a5"),
+			addrs: matchingAddrs,
+		},
+		{
+			name:  "missing IP address",
+			link:  newTestVLANLink(101, "02:e1:48:75:86:a4"),
+			addrs: nil,
+		},
+		{
+			name: "extra IP address",
+			link: newTestVLANLink(101, "02:e1:48:75:86:a4"),
+			addrs: []netlink.Addr{
+				newTestAddr("172.31.19.6/20", unix.RT_SCOPE_UNIVERSE),
+				newTestAddr("172.31.19.7/20", unix.RT_SCOPE_UNIVERSE),
+			},
+		},
+		{
+			name:  "not a VLAN link",
+			link:  &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: testIfName}},
+			addrs: matchingAddrs,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateExistingVLANLink(tc.link, tc.addrs, nc)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestDelWithMissingNetNS tests that DEL succeeds if the netns no longer exists, or if the runtime
+// does not specify one.
+func TestDelWithMissingNetNS(t *testing.T) {
+	plugin := &Plugin{}
+	args := &cniSkel.CmdArgs{
+		ContainerID: "container_1",
+		Netns:       "/var/run/netns/vpc-branch-eni-nonexistent-netns",
+		IfName:      testIfName,
+		StdinData:   []byte(testBranchNetConfig),
+	}
+
+	err := plugin.Del(args)
+	assert.NoError(t, err)
+
+	args.Netns = ""
+	err = plugin.Del(args)
+	assert.NoError(t, err)
+}
+
+// TestGetNetNS tests that the target netns can be given by the pid of a process in it.
+func TestGetNetNS(t *testing.T) {
+	pidPath := fmt.Sprintf("/proc/%d/ns/net", os.Getpid())
+
+	for _, netnsPath := range []string{strconv.Itoa(os.Getpid()), pidPath} {
+		ns, err := getNetNS(netnsPath)
+		require.NoError(t, err, netnsPath)
+		assert.Equal(t, pidPath, ns.GetPath(), netnsPath)
+		assert.NoError(t, ns.Close(), netnsPath)
+	}
+}
+
+// TestLibraryAddWithMissingNetNS tests that the library entry points accept a constructed NetConfig.
+func TestLibraryAddWithMissingNetNS(t *testing.T) {
+	macAddress, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	netConfig := &config.NetConfig{
+		TrunkName:        "eth1",
+		BranchVlanID:     101,
+		BranchMACAddress: macAddress,
+		InterfaceType:    config.IfTypeVLAN,
+		InterfaceName:    testIfName,
+	}
+	netnsPath := "/var/run/netns/vpc-branch-eni-nonexistent-netns"
+
+	result, err := Add(context.Background(), "container_1", netnsPath, netConfig)
+	assert.Nil(t, result)
+	require.Error(t, err)
+	cniErr, ok := err.(*cniTypes.Error)
+	require.True(t, ok)
+	assert.Equal(t, cni.ErrCodeNetNS, cniErr.Code)
+
+	// DEL is idempotent when the netns no longer exists.
+	err = Del(context.Background(), "container_1", netnsPath, netConfig)
+	assert.NoError(t, err)
+}
+
+// TestNewStaticRoute tests that static routes are programmed via the branch link.
+func TestNewStaticRoute(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.20.0.0/16")
+
+	route := newStaticRoute(42, cniTypes.Route{Dst: *dst, GW: net.ParseIP("172.31.16.5")}, &config.NetConfig{})
+	assert.Equal(t, 42, route.LinkIndex)
+	assert.Equal(t, "10.20.0.0/16", route.Dst.String())
+	assert.Equal(t, "172.31.16.5", route.Gw.String())
+	assert.Equal(t, netlink.SCOPE_UNIVERSE, route.Scope)
+
+	route = newStaticRoute(42, cniTypes.Route{Dst: *dst}, &config.NetConfig{})
+	assert.Nil(t, route.Gw)
+	assert.Equal(t, netlink.SCOPE_LINK, route.Scope)
+}
+
+// TestAddErrorCodes tests that ADD failures return CNI errors with the code of their failure class.
+func TestAddErrorCodes(t *testing.T) {
+	testCases := []struct {
+		name         string
+		netns        string
+		netConfig    string
+		expectedCode uint
+	}{
+		{
+			name:         "invalid config",
+			netns:        "/proc/self/ns/net",
+			netConfig:    `{"cniVersion":"0.3.1", "trunkName":"eth1", "interfaceType":"vlan"}`,
+			expectedCode: cni.ErrCodeInvalidConfig,
+		},
+		{
+			name:         "missing netns",
+			netns:        "/var/run/netns/vpc-branch-eni-nonexistent-netns",
+			netConfig:    testBranchNetConfig,
+			expectedCode: cni.ErrCodeNetNS,
+		},
+		{
+			name:  "missing trunk",
+			netns: "/proc/self/ns/net",
+			netConfig: `{"cniVersion":"0.3.1", "trunkName":"nonexistent0", "branchVlanID":"101",
+				"branchMACAddress":"02:e1:48:75:86:a4", "interfaceType":"vlan"}`,
+			expectedCode: cni.ErrCodeTrunkNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plugin := &Plugin{}
+			args := &cniSkel.CmdArgs{
+				ContainerID: "container_1",
+				Netns:       tc.netns,
+				IfName:      testIfName,
+				StdinData:   []byte(tc.netConfig),
+			}
+
+			err := plugin.Add(args)
+			cniErr, ok := err.(*cniTypes.Error)
+			require.True(t, ok, "expected a CNI error object")
+			assert.Equal(t, tc.expectedCode, cniErr.Code)
+			assert.NotEmpty(t, cniErr.Msg)
+			assert.NotEmpty(t, cniErr.Details)
+		})
+	}
+}
+
+// TestAddLogsContainerID tests that log lines written during ADD carry the container ID.
+func TestAddLogsContainerID(t *testing.T) {
+	var buf bytes.Buffer
+	testLogger, err := log.LoggerFromWriterWithMinLevelAndFormat(&buf, log.InfoLvl, "%ContainerID%Msg%n")
+	require.NoError(t, err)
+	log.ReplaceLogger(testLogger)
+	defer log.ReplaceLogger(log.Disabled)
+	defer logger.SetContainerID("")
+
+	plugin := &Plugin{}
+	args := &cniSkel.CmdArgs{
+		ContainerID: "container_1",
+		Netns:       "/proc/self/ns/net",
+		IfName:      testIfName,
+		StdinData:   []byte(`{"cniVersion":"0.3.1", "trunkName":"eth1", "interfaceType":"vlan"}`),
+	}
+
+	err = plugin.Add(args)
+	require.Error(t, err)
+	log.Flush()
+
+	assert.Contains(t, buf.String(), "[container_1] Failed to parse netconfig from args")
+}
+
+// newTestRoute returns a route object for tests. A default destination is reported as nil.
+func newTestRoute(dst string, gw string) netlink.Route {
+	route := netlink.Route{Gw: net.ParseIP(gw)}
+	_, route.Dst, _ = net.ParseCIDR(dst)
+	if ones, _ := route.Dst.Mask.Size(); ones == 0 {
+		route.Dst = nil
+	}
+	return route
+}
+
+// TestCheckVLANLink tests that CHECK accepts a VLAN link matching the config.
+func TestCheckVLANLink(t *testing.T) {
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	link := newTestVLANLink(101, "02:e1:48:75:86:a4")
+	addrs := []netlink.Addr{newTestAddr("172.31.19.6/20", unix.RT_SCOPE_UNIVERSE)}
+	routes := []netlink.Route{
+		newTestRoute("172.31.16.0/20", ""),
+		newTestRoute("0.0.0.0/0", "172.31.16.1"),
+	}
+
+	err := validateVLANLink(link, addrs, routes, nc)
+	assert.NoError(t, err)
+}
+
+// TestCheckVLANLinkDrifted tests that CHECK reports VLAN links that drifted from the config.
+func TestCheckVLANLinkDrifted(t *testing.T) {
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	link := newTestVLANLink(101, "02:e1:48:75:86:a4")
+	defaultRoutes := []netlink.Route{newTestRoute("0.0.0.0/0", "172.31.16.1")}
+
+	// The IP address was changed.
+	addrs := []netlink.Addr{newTestAddr("172.31.19.7/20", unix.RT_SCOPE_UNIVERSE)}
+	err := validateVLANLink(link, addrs, defaultRoutes, nc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing IP address 172.31.19.6/20")
+
+	// The default route was removed.
+	addrs = []netlink.Addr{newTestAddr("172.31.19.6/20", unix.RT_SCOPE_UNIVERSE)}
+	err = validateVLANLink(link, addrs, nil, nc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing route to 0.0.0.0/0 via 172.31.16.1")
+}
+
+// TestGetVLANProtocol tests the selection of the branch VLAN link protocol.
+func TestGetVLANProtocol(t *testing.T) {
+	assert.Equal(t, eni.VLANProtocol8021Q, getVLANProtocol(config.VlanProtocol8021Q))
+	assert.Equal(t, eni.VLANProtocol8021AD, getVLANProtocol(config.VlanProtocol8021AD))
+
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	assert.Equal(t, eni.VLANProtocol8021Q, getVLANProtocol(nc.VlanProtocol))
+}
+
+// TestGetMACVLANMode tests the selection of the branch MACVLAN link mode.
+func TestGetMACVLANMode(t *testing.T) {
+	assert.Equal(t, netlink.MACVLAN_MODE_BRIDGE, getMACVLANMode(config.MACVLANModeBridge))
+	assert.Equal(t, netlink.MACVLAN_MODE_PRIVATE, getMACVLANMode(config.MACVLANModePrivate))
+	assert.Equal(t, netlink.MACVLAN_MODE_VEPA, getMACVLANMode(config.MACVLANModeVEPA))
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchMACAddress":"02:e1:48:75:86:a4", "interfaceType":"macvlan"}`)
+	assert.Equal(t, netlink.MACVLAN_MODE_BRIDGE, getMACVLANMode(nc.MACVLANMode))
+}
+
+// TestExistingMACVLANLink tests that an existing link is validated against the MACVLAN config.
+func TestExistingMACVLANLink(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchMACAddress":"02:e1:48:75:86:a4",
+		"macvlanMode":"vepa", "interfaceType":"macvlan"}`)
+	vlanLink := newTestVLANLink(101, "02:e1:48:75:86:a4")
+	macvlanLink := &netlink.Macvlan{LinkAttrs: vlanLink.LinkAttrs, Mode: netlink.MACVLAN_MODE_VEPA}
+
+	assert.NoError(t, validateExistingVLANLink(macvlanLink, nil, nc))
+	assert.Error(t, validateExistingVLANLink(vlanLink, nil, nc))
+
+	macvlanLink.Mode = netlink.MACVLAN_MODE_BRIDGE
+	assert.Error(t, validateExistingVLANLink(macvlanLink, nil, nc))
+}
+
+// TestExistingLinkManagementIPAddress tests that an existing link must have both the branch and
+// the management IP address assigned, the latter with its configured scope.
+func TestExistingLinkManagementIPAddress(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "managementIPAddress":"169.254.100.1",
+		"managementIPAddressScope":"host", "interfaceType":"vlan"}`)
+	assert.Equal(t, netlink.SCOPE_HOST, getManagementIPScope(nc.ManagementIPScope))
+	link := newTestVLANLink(101, "02:e1:48:75:86:a4")
+
+	addrs := []netlink.Addr{
+		newTestAddr("172.31.19.6/20", unix.RT_SCOPE_UNIVERSE),
+		newTestAddr("169.254.100.1/32", unix.RT_SCOPE_HOST),
+	}
+	assert.NoError(t, validateExistingVLANLink(link, addrs, nc))
+
+	// The management IP address is missing or has the wrong scope.
+	assert.Error(t, validateExistingVLANLink(link, addrs[:1], nc))
+	addrs[1].Scope = unix.RT_SCOPE_LINK
+	assert.Error(t, validateExistingVLANLink(link, addrs, nc))
+
+	// The scope defaults to link.
+	nc.ManagementIPScope = config.ManagementIPScopeLink
+	assert.Equal(t, netlink.SCOPE_LINK, getManagementIPScope(nc.ManagementIPScope))
+	assert.NoError(t, validateExistingVLANLink(link, addrs, nc))
+}
+
+// TestAddBranchIPAddressScope tests that branch IP addresses are assigned with the configured
+// scope and flags.
+func TestAddBranchIPAddressScope(t *testing.T) {
+	type addrRequest struct {
+		address string
+		scope   netlink.Scope
+		flags   int
+	}
+	var requests []addrRequest
+	addScopedIPAddress = func(branch *eni.ENI, address *net.IPNet, scope netlink.Scope, flags int) error {
+		requests = append(requests, addrRequest{address.String(), scope, flags})
+		return nil
+	}
+	defer func() { addScopedIPAddress = (*eni.ENI).AddScopedIPAddress }()
+
+	branch, err := eni.NewENI("eth0", nil)
+	require.NoError(t, err)
+	ip, address, _ := net.ParseCIDR("169.254.10.1/16")
+	address.IP = ip
+
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"169.254.10.1/16", %s"interfaceType":"vlan"}`
+	for _, tc := range []struct {
+		params string
+		scope  netlink.Scope
+		flags  int
+	}{
+		{``, netlink.SCOPE_UNIVERSE, 0},
+		{`"addressScope":"global", `, netlink.SCOPE_UNIVERSE, 0},
+		{`"addressScope":"link", `, netlink.SCOPE_LINK, 0},
+		{`"addressScope":"host", "noPrefixRoute":true, `, netlink.SCOPE_HOST, unix.IFA_F_NOPREFIXROUTE},
+	} {
+		requests = nil
+		nc := newTestNetConfig(t, fmt.Sprintf(netConfigFmt, tc.params))
+		require.NoError(t, addBranchIPAddress(branch, address, nc), tc.params)
+		assert.Equal(t, []addrRequest{{"169.254.10.1/16", tc.scope, tc.flags}}, requests, tc.params)
+	}
+}
+
+// TestAddExternalTAPDevice tests that no TAP link is created when the TAP device is externally
+// managed, while the CNI result still reports it along with the bridge it connects to.
+func TestAddExternalTAPDevice(t *testing.T) {
+	realTAPLinkAdd := tapLinkAdd
+	defer func() { tapLinkAdd = realTAPLinkAdd }()
+	var tapLinks []string
+	tapLinkAdd = func(tapLink *netlink.Tuntap) error {
+		tapLinks = append(tapLinks, tapLink.Name)
+		return errors.New("operation not permitted")
+	}
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "interfaceType":"tap", "uid":"0", "gid":"0",
+		"tapDeviceExternallyManaged":true}`)
+	require.True(t, nc.Tap.ExternallyManaged)
+	assert.NoError(t, addTAPDevice(testIfName, 3, 9001, nc.Tap))
+	assert.Empty(t, tapLinks)
+
+	result := newResult(testIfName, testNetnsPath, nc)
+	require.Len(t, result.Interfaces, 2)
+	assert.Equal(t, testIfName, result.Interfaces[0].Name)
+	assert.Equal(t, "02:e1:48:75:86:a4", result.Interfaces[0].Mac)
+	assert.Equal(t, "tapbr101", result.Interfaces[1].Name)
+	assert.Equal(t, testNetnsPath, result.Interfaces[1].Sandbox)
+	require.Len(t, result.IPs, 1)
+	assert.Equal(t, "172.31.16.1", result.IPs[0].Gateway.String())
+
+	// Otherwise, the TAP link is created.
+	nc.Tap.ExternallyManaged = false
+	assert.Error(t, addTAPDevice(testIfName, 3, 9001, nc.Tap))
+	assert.Equal(t, []string{testIfName}, tapLinks)
+}
+
+// TestNewTAPLinkQueues tests the tuntap flags requested for single and multi-queue TAP links.
+func TestNewTAPLinkQueues(t *testing.T) {
+	tapLink := newTAPLink(testIfName, 3, 9001, &config.TAPConfig{Queues: 1})
+	assert.Equal(t, netlink.TUNTAP_MODE_TAP, tapLink.Mode)
+	assert.Equal(t, netlink.TUNTAP_VNET_HDR|netlink.TUNTAP_ONE_QUEUE, tapLink.Flags)
+	assert.Equal(t, 1, tapLink.Queues)
+	assert.Equal(t, 3, tapLink.MasterIndex)
+	assert.Equal(t, 9001, tapLink.MTU)
+
+	tapLink = newTAPLink(testIfName, 3, 9001, &config.TAPConfig{Queues: 4})
+	assert.Equal(t, netlink.TUNTAP_VNET_HDR|netlink.TUNTAP_MULTI_QUEUE, tapLink.Flags)
+	assert.Equal(t, 4, tapLink.Queues)
+}
+
+// TestFindTrunkFailover tests that the first available trunk interface is used.
+func TestFindTrunkFailover(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":["vpc-branch-eni-nonexistent", "lo"], "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`)
+
+	trunk, err := findTrunk(nc)
+	require.NoError(t, err)
+	assert.Equal(t, "lo", trunk.GetLinkName())
+	assert.Equal(t, "lo", nc.TrunkName)
+
+	nc = newTestNetConfig(t, `{"trunkName":["vpc-branch-eni-nonexistent0", "vpc-branch-eni-nonexistent1"],
+		"branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`)
+	_, err = findTrunk(nc)
+	assert.Error(t, err)
+}
+
+// TestResolveTrunkInterfaceIndex tests that a trunk identified by its device index is resolved to
+// the local interface with the MAC address reported by IMDS.
+func TestResolveTrunkInterfaceIndex(t *testing.T) {
+	var testIface *net.Interface
+	interfaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for i := range interfaces {
+		if len(interfaces[i].HardwareAddr) != 0 && interfaces[i].Flags&net.FlagLoopback == 0 {
+			testIface = &interfaces[i]
+			break
+		}
+	}
+	if testIface == nil {
+		t.Skip("No interface with a MAC address to test with.")
+	}
+
+	var deviceIndexes []int
+	getInterfaceMACAddressFromIMDS = func(deviceIndex int) (net.HardwareAddr, error) {
+		deviceIndexes = append(deviceIndexes, deviceIndex)
+		if deviceIndex != 2 {
+			return nil, fmt.Errorf("no ENI attached at device index %d", deviceIndex)
+		}
+		return testIface.HardwareAddr, nil
+	}
+	defer func() { getInterfaceMACAddressFromIMDS = imds.GetInterfaceMACAddress }()
+
+	nc := newTestNetConfig(t, `{"trunkInterfaceIndex":2, "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`)
+	require.NoError(t, resolveTrunkName(nc))
+	assert.Equal(t, []int{2}, deviceIndexes)
+
+	trunk, err := findTrunk(nc)
+	require.NoError(t, err)
+	assert.Equal(t, testIface.Name, trunk.GetLinkName())
+	assert.Equal(t, testIface.Name, nc.TrunkName)
+
+	nc = newTestNetConfig(t, `{"trunkInterfaceIndex":3, "branchVlanID":"100",
+		"branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"vlan"}`)
+	assert.Error(t, resolveTrunkName(nc))
+}
+
+// TestNewDefaultRouteMetric tests that the configured metric is passed through to the default route.
+func TestNewDefaultRouteMetric(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "defaultRouteMetric":100, "interfaceType":"vlan"}`)
+	assert.Equal(t, 100, nc.DefaultRouteMetric)
+
+	route := newDefaultRoute(7, nc.BranchGatewayIPAddress, nc.DefaultRouteMetric)
+	assert.Equal(t, 100, route.Priority)
+	assert.Equal(t, 7, route.LinkIndex)
+	assert.Nil(t, route.Dst)
+	assert.True(t, route.Gw.Equal(net.ParseIP("10.11.0.1")))
+
+	// The metric defaults to the kernel default.
+	nc = newTestNetConfig(t, testBranchNetConfig)
+	assert.Equal(t, 0, newDefaultRoute(7, nc.BranchGatewayIPAddress, nc.DefaultRouteMetric).Priority)
+}
+
+// TestGetDefaultRouteSource tests that IPv4 default routes prefer the branch IP address as source
+// unless disabled.
+func TestGetDefaultRouteSource(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64", "interfaceType":"vlan"}`)
+	assert.True(t, nc.DefaultRouteSource)
+	src := getDefaultRouteSource(nc.BranchGatewayIPAddress, nc)
+	assert.True(t, src.Equal(nc.BranchIPAddress.IP), "default route src %v does not match the branch address", src)
+	assert.Nil(t, getDefaultRouteSource(nc.BranchGatewayIPv6Address, nc))
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "defaultRouteSource":false, "interfaceType":"vlan"}`)
+	assert.False(t, nc.DefaultRouteSource)
+	assert.Nil(t, getDefaultRouteSource(nc.BranchGatewayIPAddress, nc))
+}
+
+// TestValidateVLANLinkInterfaceMACAddress tests that an existing link must have the interface MAC address.
+func TestValidateVLANLinkInterfaceMACAddress(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:e1:48:75:86:a4",
+		"interfaceMACAddress":"02:42:42:42:42:42", "interfaceType":"vlan"}`)
+
+	link := newTestVLANLink(100, "02:e1:48:75:86:a4")
+	assert.Error(t, validateExistingVLANLink(link, nil, nc))
+
+	link = newTestVLANLink(100, "02:42:42:42:42:42")
+	assert.NoError(t, validateExistingVLANLink(link, nil, nc))
+}
+
+// TestSetInterfaceMACAddress tests that the interface MAC address is applied to the container-facing link.
+func TestSetInterfaceMACAddress(t *testing.T) {
+	var linkNames []string
+	var macAddresses []string
+	setLinkMACAddress = func(linkName string, macAddress net.HardwareAddr) error {
+		linkNames = append(linkNames, linkName)
+		macAddresses = append(macAddresses, macAddress.String())
+		return nil
+	}
+	defer func() { setLinkMACAddress = realSetLinkMACAddress }()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:e1:48:75:86:a4",
+		"interfaceMACAddress":"02:42:42:42:42:42", "interfaceName":"eth1", "uid":"42", "gid":"42"}`)
+	require.NoError(t, setInterfaceMACAddress(nc))
+	assert.Equal(t, []string{"eth1"}, linkNames)
+	assert.Equal(t, []string{"02:42:42:42:42:42"}, macAddresses)
+
+	// The link is not touched without an interface MAC address.
+	nc.InterfaceMACAddress = nil
+	require.NoError(t, setInterfaceMACAddress(nc))
+	assert.Len(t, linkNames, 1)
+}
+
+// TestNewBranchDefaultRouteMultipath tests that multiple IPv4 gateways result in a single ECMP
+// default route with a nexthop via each of them.
+func TestNewBranchDefaultRouteMultipath(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "branchGatewayIPAddress":["10.11.0.1", "10.11.0.2"],
+		"defaultRouteMetric":100, "interfaceType":"vlan"}`)
+
+	route := newBranchDefaultRoute(7, nc.BranchGatewayIPAddress, nc)
+	assert.Nil(t, route.Dst)
+	assert.Nil(t, route.Gw)
+	assert.Equal(t, 100, route.Priority)
+	require.Len(t, route.MultiPath, 2)
+	for i, gateway := range []string{"10.11.0.1", "10.11.0.2"} {
+		assert.Equal(t, 7, route.MultiPath[i].LinkIndex)
+		assert.Equal(t, gateway, route.MultiPath[i].Gw.String())
+	}
+
+	// Both nexthops are found when validating the route.
+	for _, gateway := range nc.BranchGatewayIPAddresses {
+		assert.True(t, isRouteViaGateway(*route, gateway))
+	}
+	assert.False(t, isRouteViaGateway(*route, net.ParseIP("10.11.0.3")))
+	assert.True(t, isRouteViaLink(*route, 7))
+	assert.False(t, isRouteViaLink(*route, 8))
+
+	// A single gateway results in a regular default route.
+	nc = newTestNetConfig(t, testBranchNetConfig)
+	route = newBranchDefaultRoute(7, nc.BranchGatewayIPAddress, nc)
+	assert.Empty(t, route.MultiPath)
+	assert.True(t, route.Gw.Equal(nc.BranchGatewayIPAddress))
+}
+
+// TestIsOffSubnetGateway tests that gateways of delegated prefix addresses are marked on-link.
+func TestIsOffSubnetGateway(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPPrefix":"10.11.12.16/28", "branchGatewayIPAddress":"10.11.0.1", "interfaceType":"vlan"}`)
+	assert.True(t, isOffSubnetGateway(nc.BranchGatewayIPAddress, nc))
+
+	nc = newTestNetConfig(t, testBranchNetConfig)
+	assert.False(t, isOffSubnetGateway(nc.BranchGatewayIPAddress, nc))
+	assert.False(t, isOffSubnetGateway(net.ParseIP("fe80::1"), nc))
+}
+
+// TestGetAddressFlags tests that branch IP addresses are assigned without prefix routes when
+// configured, in which case IPv4 gateways are marked on-link.
+func TestGetAddressFlags(t *testing.T) {
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	assert.False(t, nc.NoPrefixRoute)
+	assert.Zero(t, getAddressFlags(nc))
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/24", "branchGatewayIPAddress":"10.11.12.1", "noPrefixRoute":true,
+		"interfaceType":"vlan"}`)
+	assert.True(t, nc.NoPrefixRoute)
+	assert.Equal(t, unix.IFA_F_NOPREFIXROUTE, getAddressFlags(nc))
+	assert.True(t, isOffSubnetGateway(nc.BranchGatewayIPAddress, nc))
+}
+
+// mockAddressLists replaces the link and address list operations with ones returning the given
+// addresses for each link index.
+func mockAddressLists(t *testing.T, addrs map[int][]string) {
+	linkList = func() ([]netlink.Link, error) {
+		var links []netlink.Link
+		for index := range addrs {
+			la := netlink.NewLinkAttrs()
+			la.Index = index
+			la.Name = fmt.Sprintf("eth%d", index)
+			links = append(links, &netlink.Dummy{LinkAttrs: la})
+		}
+		return links, nil
+	}
+	addrList = func(link netlink.Link, family int) ([]netlink.Addr, error) {
+		var result []netlink.Addr
+		for _, addr := range addrs[link.Attrs().Index] {
+			ipAddress, err := vpc.GetIPAddressFromString(addr)
+			require.NoError(t, err)
+			result = append(result, netlink.Addr{IPNet: ipAddress})
+		}
+		return result, nil
+	}
+}
+
+// restoreAddressLists restores the real link and address list operations.
+func restoreAddressLists() {
+	linkList = netlink.LinkList
+	addrList = netlink.AddrList
+}
+
+// TestCheckExistingIPAddress tests that an already assigned address is accepted only if it
+// matches exactly on the branch link.
+func TestCheckExistingIPAddress(t *testing.T) {
+	defer restoreAddressLists()
+	address, err := vpc.GetIPAddressFromString("10.11.12.13/16")
+	require.NoError(t, err)
+
+	// The exact address is already on the branch link.
+	mockAddressLists(t, map[int][]string{1: {"127.0.0.1/8"}, 7: {"10.11.12.13/16"}})
+	assert.NoError(t, checkExistingIPAddress(7, address))
+
+	// The address is on the branch link with a different prefix length.
+	mockAddressLists(t, map[int][]string{7: {"10.11.12.13/24"}})
+	err = checkExistingIPAddress(7, address)
+	require.Error(t, err)
+	assert.Equal(t, "IP address 10.11.12.13 is already assigned to the branch link as 10.11.12.13/24", err.Error())
+
+	// The address is on another link, even if also on the branch link.
+	mockAddressLists(t, map[int][]string{3: {"10.11.12.13/16"}, 7: {"10.11.12.13/16"}})
+	err = checkExistingIPAddress(7, address)
+	require.Error(t, err)
+	assert.Equal(t, "IP address 10.11.12.13 is already assigned to link eth3", err.Error())
+
+	// The original error is returned if the address is not found.
+	mockAddressLists(t, map[int][]string{7: {"10.11.12.14/16"}})
+	assert.Equal(t, unix.EEXIST, checkExistingIPAddress(7, address))
+}
+
+// TestGetBranchLinkName tests that the host interface name overrides the default branch link name.
+func TestGetBranchLinkName(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:23:45:67:89:ab",
+		"interfaceType":"vlan"}`)
+	assert.Equal(t, "eth1.101", getBranchLinkName("eth1", nc))
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:23:45:67:89:ab",
+		"hostInterfaceNameTemplate":"br-{vlan}", "interfaceType":"vlan"}`)
+	assert.Equal(t, "br-101", getBranchLinkName("eth1", nc))
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchMACAddress":"02:23:45:67:89:ab", "interfaceType":"macvlan"}`)
+	assert.Equal(t, "mv456789ab", getBranchLinkName("eth1", nc))
+}
+
+// TestGetAdoptLockName tests that the lock of an adopted interface is keyed by its configured name,
+// or by its MAC address if it is identified by one.
+func TestGetAdoptLockName(t *testing.T) {
+	nc := newTestNetConfig(t, `{"adoptInterface":"eth5", "branchIPAddress":"10.11.12.13/16", "interfaceType":"vlan"}`)
+	assert.Equal(t, "eth5", getAdoptLockName(nc))
+
+	nc = newTestNetConfig(t, `{"adoptInterface":"02:23:45:67:89:ab", "branchIPAddress":"10.11.12.13/16",
+		"interfaceType":"vlan"}`)
+	assert.Equal(t, "02:23:45:67:89:ab", getAdoptLockName(nc))
+}
+
+// TestCheckTrunkUp tests that ADD fails on a trunk that is down, unless bringUpTrunk is set, in
+// which case the trunk is brought up.
+func TestCheckTrunkUp(t *testing.T) {
+	// The loopback link stands in for the trunk, and is reported down.
+	lo, err := netlink.LinkByName(loopbackLinkName)
+	require.NoError(t, err)
+	lo.Attrs().Flags &^= net.FlagUp
+	var upLinks []string
+	linkByName = func(name string) (netlink.Link, error) {
+		if name != loopbackLinkName {
+			return nil, errors.New("link not found")
+		}
+		return lo, nil
+	}
+	linkSetUp = func(link netlink.Link) error {
+		upLinks = append(upLinks, link.Attrs().Name)
+		return nil
+	}
+	defer func() {
+		linkByName = netlink.LinkByName
+		linkSetUp = netlink.LinkSetUp
+	}()
+
+	nc := newTestNetConfig(t, `{"trunkName":"lo", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "interfaceType":"vlan"}`)
+	assert.False(t, nc.BringUpTrunk)
+	err = checkTrunkUp(loopbackLinkName, nc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "trunk interface lo is administratively down")
+	assert.Empty(t, upLinks)
+
+	nc.BringUpTrunk = true
+	require.NoError(t, checkTrunkUp(loopbackLinkName, nc))
+	assert.Equal(t, []string{loopbackLinkName}, upLinks)
+
+	// Trunks that are up are left untouched.
+	upLinks = nil
+	lo.Attrs().Flags |= net.FlagUp
+	nc.BringUpTrunk = false
+	require.NoError(t, checkTrunkUp(loopbackLinkName, nc))
+	assert.Empty(t, upLinks)
+
+	assert.Error(t, checkTrunkUp("nonexistent0", nc))
+}
+
+// TestFindDelTrunk tests that DEL finds the trunk of a branch interface among the candidates,
+// so that it takes the lock of the branch, and leaves the name unknown if the trunk is gone.
+func TestFindDelTrunk(t *testing.T) {
+	// The loopback link stands in for the trunk.
+	nc := &config.NetConfig{
+		TrunkName:     "nonexistent0",
+		TrunkNames:    []string{"nonexistent0", loopbackLinkName},
+		BranchVlanID:  101,
+		InterfaceType: config.IfTypeVLAN,
+		InterfaceName: testIfName,
+	}
+	err := findDelTrunk(nc)
+	require.NoError(t, err)
+	assert.Equal(t, loopbackLinkName, nc.TrunkName)
+
+	macAddress, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	nc = &config.NetConfig{
+		TrunkMACAddresses: []net.HardwareAddr{macAddress},
+		BranchVlanID:      101,
+		InterfaceType:     config.IfTypeVLAN,
+		InterfaceName:     testIfName,
+	}
+	err = findDelTrunk(nc)
+	assert.Error(t, err)
+	assert.Empty(t, nc.TrunkName)
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+)
+
+const (
+	// Table and chain of iptables rules marking outbound packets.
+	connMarkTable = "mangle"
+	connMarkChain = "POSTROUTING"
+)
+
+// newConnMarkRules returns the iptables rules that set the configured firewall mark on the
+// packets sent on the branch interface, for each configured IP address family, so that host
+// firewall policies can match them.
+func newConnMarkRules(netConfig *config.NetConfig) []iptablesRule {
+	var rules []iptablesRule
+	for _, proto := range getIPTablesProtocols(netConfig) {
+		rules = append(rules, iptablesRule{
+			backend: netConfig.FirewallBackend,
+			proto:   proto,
+			table:   connMarkTable,
+			chain:   connMarkChain,
+			rulespec: []string{"-o", netConfig.InterfaceName,
+				"-j", "MARK", "--set-mark", fmt.Sprintf("0x%x", netConfig.ConnMark)},
+		})
+	}
+
+	return rules
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Number of ARP probes sent for an IPv4 address and how long to wait for replies to each.
+	arpProbeCount = 3
+	arpProbeWait  = 200 * time.Millisecond
+
+	// How long to wait for the kernel to complete duplicate address detection for an IPv6
+	// address, and how often to poll the address state.
+	ipv6DADTimeout      = 3 * time.Second
+	ipv6DADPollInterval = 100 * time.Millisecond
+
+	// ARP packet format for IPv4 over Ethernet.
+	arpPacketLength = 28
+	arpOpRequest    = 1
+)
+
+// probeAddress reports whether another host on the given link already uses the given IP address.
+// IPv4 addresses are probed with ARP before they are assigned. IPv6 addresses are probed by the
+// kernel's duplicate address detection once they are assigned. It is a variable so that it can be
+// replaced in unit tests.
+var probeAddress = func(linkIndex int, address *net.IPNet) (bool, error) {
+	if ipAddress := address.IP.To4(); ipAddress != nil {
+		return arpProbe(linkIndex, ipAddress)
+	}
+	return waitForIPv6DAD(linkIndex, address)
+}
+
+// checkAddressConflict returns an error if the given IP address is already in use on the link.
+func checkAddressConflict(linkIndex int, address *net.IPNet) error {
+	log.Infof("Probing for duplicates of IP address %v.", address)
+	conflict, err := probeAddress(linkIndex, address)
+	if err != nil {
+		log.Errorf("Failed to probe for duplicates of IP address %v: %v.", address, err)
+		return err
+	}
+
+	if conflict {
+		log.Errorf("IP address %v is already in use on the link.", address)
+		return fmt.Errorf("duplicate address detected: %s is already in use by another host", address.IP)
+	}
+
+	return nil
+}
+
+// arpProbe sends RFC 5227 ARP probes for the given IPv4 address on the given link and reports
+// whether any other host claims it.
+func arpProbe(linkIndex int, ipAddress net.IP) (bool, error) {
+	link, err := netlink.LinkByIndex(linkIndex)
+	if err != nil {
+		return false, err
+	}
+	macAddress := link.Attrs().HardwareAddr
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return false, err
+	}
+	defer unix.Close(fd)
+
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: linkIndex})
+	if err != nil {
+		return false, err
+	}
+
+	tv := unix.NsecToTimeval(int64(arpProbeWait))
+	err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return false, err
+	}
+
+	broadcast := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  linkIndex,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	probe := newARPProbe(macAddress, ipAddress)
+	buf := make([]byte, 1500)
+
+	for i := 0; i < arpProbeCount; i++ {
+		err = unix.Sendto(fd, probe, 0, broadcast)
+		if err != nil {
+			return false, err
+		}
+
+		deadline := time.Now().Add(arpProbeWait)
+		for time.Now().Before(deadline) {
+			n, from, err := unix.Recvfrom(fd, buf, 0)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				break
+			}
+			if err != nil {
+				return false, err
+			}
+			if sll, ok := from.(*unix.SockaddrLinklayer); ok && sll.Pkttype == unix.PACKET_OUTGOING {
+				continue
+			}
+			if isARPConflict(buf[:n], macAddress, ipAddress) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// newARPProbe returns an ARP probe for the given IPv4 address, which is an ARP request with
+// an unspecified sender IP address.
+func newARPProbe(macAddress net.HardwareAddr, ipAddress net.IP) []byte {
+	packet := make([]byte, arpPacketLength)
+	binary.BigEndian.PutUint16(packet[0:], 1) // Ethernet
+	binary.BigEndian.PutUint16(packet[2:], unix.ETH_P_IP)
+	packet[4] = 6 // Hardware address length
+	packet[5] = 4 // Protocol address length
+	binary.BigEndian.PutUint16(packet[6:], arpOpRequest)
+	copy(packet[8:14], macAddress)
+	copy(packet[24:28], ipAddress.To4())
+	return packet
+}
+
+// isARPConflict returns whether the given ARP packet from another host claims the given IPv4
+// address, either as its sender or, for probes from other hosts, as its target.
+func isARPConflict(packet []byte, macAddress net.HardwareAddr, ipAddress net.IP) bool {
+	if len(packet) < arpPacketLength {
+		return false
+	}
+
+	senderMACAddress := net.HardwareAddr(packet[8:14])
+	senderIPAddress := net.IP(packet[14:18])
+	targetIPAddress := net.IP(packet[24:28])
+
+	if bytes.Equal(senderMACAddress, macAddress) {
+		return false
+	}
+
+	if senderIPAddress.Equal(ipAddress) {
+		return true
+	}
+
+	isProbe := binary.BigEndian.Uint16(packet[6:]) == arpOpRequest && senderIPAddress.Equal(net.IPv4zero)
+	return isProbe && targetIPAddress.Equal(ipAddress)
+}
+
+// waitForIPv6DAD waits for the kernel to complete duplicate address detection for the given
+// IPv6 address on the given link and reports whether it failed.
+func waitForIPv6DAD(linkIndex int, address *net.IPNet) (bool, error) {
+	la := netlink.NewLinkAttrs()
+	la.Index = linkIndex
+	link := &netlink.Dummy{LinkAttrs: la}
+
+	deadline := time.Now().Add(ipv6DADTimeout)
+	for {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		for _, addr := range addrs {
+			if !addr.IP.Equal(address.IP) {
+				continue
+			}
+			found = true
+			if addr.Flags&unix.IFA_F_DADFAILED != 0 {
+				return true, nil
+			}
+			if addr.Flags&unix.IFA_F_TENTATIVE == 0 {
+				return false, nil
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("IP address %s is not assigned to the link", address.IP)
+		}
+
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("timed out waiting for duplicate address detection of %s", address.IP)
+		}
+		sleep(ipv6DADPollInterval)
+	}
+}
+
+// htons converts a 16-bit value from host to network byte order on little-endian hosts, which
+// all architectures supported by the plugin are.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
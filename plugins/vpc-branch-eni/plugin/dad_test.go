@@ -0,0 +1,116 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+	"github.com/aws/amazon-vpc-cni-plugins/network/eni"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// TestCheckAddressConflict tests that a conflict reported by the probe fails the address check.
+func TestCheckAddressConflict(t *testing.T) {
+	realProbeAddress := probeAddress
+	defer func() { probeAddress = realProbeAddress }()
+
+	_, address, _ := net.ParseCIDR("10.11.12.13/16")
+	address.IP = net.ParseIP("10.11.12.13")
+
+	var probedIndex int
+	var probedAddress *net.IPNet
+	probeAddress = func(linkIndex int, address *net.IPNet) (bool, error) {
+		probedIndex = linkIndex
+		probedAddress = address
+		return false, nil
+	}
+	assert.NoError(t, checkAddressConflict(42, address))
+	assert.Equal(t, 42, probedIndex)
+	assert.Equal(t, address, probedAddress)
+
+	probeAddress = func(int, *net.IPNet) (bool, error) { return true, nil }
+	err := checkAddressConflict(42, address)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "10.11.12.13 is already in use")
+
+	probeErr := errors.New("network is down")
+	probeAddress = func(int, *net.IPNet) (bool, error) { return false, probeErr }
+	assert.Equal(t, probeErr, checkAddressConflict(42, address))
+}
+
+// TestCreateMACVLANLinkDuplicateAddress tests that the addresses of a MACVLAN branch link are
+// probed for duplicates, and that a conflict fails before the address is assigned.
+func TestCreateMACVLANLinkDuplicateAddress(t *testing.T) {
+	realProbeAddress := probeAddress
+	defer func() { probeAddress = realProbeAddress }()
+	var probed []string
+	probeAddress = func(linkIndex int, address *net.IPNet) (bool, error) {
+		probed = append(probed, address.String())
+		return true, nil
+	}
+
+	var assigned []string
+	addScopedIPAddress = func(branch *eni.ENI, address *net.IPNet, scope netlink.Scope, flags int) error {
+		assigned = append(assigned, address.String())
+		return nil
+	}
+	defer func() { addScopedIPAddress = (*eni.ENI).AddScopedIPAddress }()
+
+	// The loopback link stands in for the branch link, and is already up.
+	branch, err := eni.NewENI(loopbackLinkName, nil)
+	require.NoError(t, err)
+	require.NoError(t, branch.AttachToLink())
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "duplicateAddressDetection":true, "interfaceType":"macvlan"}`)
+	err = createVLANLink(branch, loopbackLinkName, nc, nil)
+	require.Error(t, err)
+	cniErr, ok := err.(*cniTypes.Error)
+	require.True(t, ok)
+	assert.Equal(t, cni.ErrCodeAddressAssignment, cniErr.Code)
+	assert.Equal(t, []string{"10.11.12.13/16"}, probed)
+	assert.Empty(t, assigned)
+}
+
+// TestIsARPConflict tests the detection of ARP packets from other hosts claiming an address.
+func TestIsARPConflict(t *testing.T) {
+	ourMAC, _ := net.ParseMAC("02:23:45:67:89:ab")
+	otherMAC, _ := net.ParseMAC("02:23:45:67:89:ac")
+	ipAddress := net.ParseIP("10.11.12.13").To4()
+
+	// Our own probe is not a conflict.
+	probe := newARPProbe(ourMAC, ipAddress)
+	assert.False(t, isARPConflict(probe, ourMAC, ipAddress))
+
+	// A probe for the same address from another host is.
+	assert.True(t, isARPConflict(newARPProbe(otherMAC, ipAddress), ourMAC, ipAddress))
+	assert.False(t, isARPConflict(newARPProbe(otherMAC, net.ParseIP("10.11.12.14")), ourMAC, ipAddress))
+
+	// A packet from another host using the address is.
+	reply := newARPProbe(otherMAC, net.ParseIP("10.11.12.1"))
+	copy(reply[14:18], ipAddress)
+	assert.True(t, isARPConflict(reply, ourMAC, ipAddress))
+
+	// Truncated packets are ignored.
+	assert.False(t, isARPConflict(reply[:20], ourMAC, ipAddress))
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/vishvananda/netlink"
+)
+
+// The DNS stub lets containers that expect the systemd-resolved stub resolver at 127.0.0.53
+// reach the configured nameservers instead. There is no resolver listening in the netns: queries
+// to the stub address are translated to the first IPv4 nameserver, and their source to the
+// branch IP address, by iptables rules. Only IPv4 nameservers are supported, and each is
+// reached via a host route through the branch gateway unless it is on the branch subnet.
+const (
+	// Address and port of the systemd-resolved stub resolver.
+	dnsStubAddress = "127.0.0.53"
+	dnsPort        = "53"
+
+	// Table and chains of iptables rules forwarding DNS queries to the stub resolver.
+	dnsStubTable     = "nat"
+	dnsStubDNATChain = "OUTPUT"
+	dnsStubSNATChain = "POSTROUTING"
+
+	// Loopback source addresses of the queries sent to the stub resolver.
+	loopbackSubnet = "127.0.0.0/8"
+
+	// Path of the sysctl that lets packets with loopback addresses be routed out of an interface,
+	// as queries are once their destination is translated.
+	routeLocalnetSysctlFormat = "/proc/sys/net/ipv4/conf/%s/route_localnet"
+	routeLocalnetEnable       = "1"
+)
+
+// getDNSStubResolvers returns the IPv4 nameservers reached by the DNS stub.
+func getDNSStubResolvers(netConfig *config.NetConfig) []net.IP {
+	var resolvers []net.IP
+	for _, nameserver := range netConfig.DNS.Nameservers {
+		ipAddress := net.ParseIP(nameserver).To4()
+		if ipAddress != nil {
+			resolvers = append(resolvers, ipAddress)
+		}
+	}
+
+	return resolvers
+}
+
+// newDNSStubRules returns the iptables rules that forward the DNS queries sent to the stub
+// resolver address over UDP and TCP to the first IPv4 nameserver via the branch interface.
+func newDNSStubRules(netConfig *config.NetConfig) []iptablesRule {
+	resolvers := getDNSStubResolvers(netConfig)
+	if len(resolvers) == 0 {
+		return nil
+	}
+	destination := net.JoinHostPort(resolvers[0].String(), dnsPort)
+
+	var rules []iptablesRule
+	for _, proto := range []string{"udp", "tcp"} {
+		rules = append(rules, iptablesRule{
+			backend: netConfig.FirewallBackend,
+			proto:   iptables.ProtocolIPv4,
+			table:   dnsStubTable,
+			chain:   dnsStubDNATChain,
+			rulespec: []string{"-d", dnsStubAddress + "/32", "-p", proto, "--dport", dnsPort,
+				"-j", "DNAT", "--to-destination", destination},
+		})
+	}
+
+	return append(rules, iptablesRule{
+		backend:  netConfig.FirewallBackend,
+		proto:    iptables.ProtocolIPv4,
+		table:    dnsStubTable,
+		chain:    dnsStubSNATChain,
+		rulespec: []string{"-s", loopbackSubnet, "-o", netConfig.InterfaceName, "-j", "MASQUERADE"},
+	})
+}
+
+// newDNSStubRoutes returns the host routes to the IPv4 nameservers via the branch gateway on the
+// given link. Nameservers on the branch subnet are reached via its prefix route instead.
+func newDNSStubRoutes(linkIndex int, netConfig *config.NetConfig) []*netlink.Route {
+	gatewayIPAddress := netConfig.BranchGatewayIPAddress
+	if gatewayIPAddress == nil {
+		return nil
+	}
+
+	var routes []*netlink.Route
+	for _, resolver := range getDNSStubResolvers(netConfig) {
+		if !isOffSubnetGateway(resolver, netConfig) {
+			continue
+		}
+
+		route := newStaticRoute(linkIndex, cniTypes.Route{
+			Dst: net.IPNet{IP: resolver, Mask: net.CIDRMask(32, 32)},
+			GW:  gatewayIPAddress,
+		}, netConfig)
+		if isOffSubnetGateway(gatewayIPAddress, netConfig) {
+			route.Flags = int(netlink.FLAG_ONLINK)
+		}
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// addDNSStubRoutes adds the host routes to the IPv4 nameservers via the given link.
+func addDNSStubRoutes(linkIndex int, netConfig *config.NetConfig) error {
+	for _, route := range newDNSStubRoutes(linkIndex, netConfig) {
+		log.Infof("Adding DNS resolver IP route %+v.", route)
+		err := trace(traceOpAddRoute, route.String(), func() error {
+			return routeAdd(route)
+		})
+		if err != nil {
+			log.Errorf("Failed to add DNS resolver IP route %+v: %v.", route, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enableDNSStub forwards the DNS queries sent to the stub resolver address from the current
+// network namespace to the first IPv4 nameserver via the given interface.
+func enableDNSStub(ifName string, netConfig *config.NetConfig) error {
+	path := fmt.Sprintf(routeLocalnetSysctlFormat, ifName)
+	log.Infof("Enabling route_localnet sysctl %s.", path)
+	err := writeSysctl(path, routeLocalnetEnable)
+	if err != nil {
+		log.Errorf("Failed to enable route_localnet sysctl %s: %v.", path, err)
+		return err
+	}
+
+	return addIPTablesRules(newDNSStubRules(netConfig))
+}
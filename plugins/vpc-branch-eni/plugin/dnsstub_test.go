@@ -0,0 +1,89 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+
+package plugin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+const testDNSStubNetConfig = `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+	"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64",
+	"dns":{"nameservers":["2600:1f13:a0d:a700::2", "169.254.169.253", "10.11.0.2"]},
+	"dnsStub":true, "interfaceName":"eth0", "interfaceType":"vlan"}`
+
+// TestDNSStubRules tests that DNS queries to the stub resolver address are forwarded to the first
+// IPv4 nameserver over UDP and TCP, that the sysctl routing them out is set, and that the rules
+// are deleted on DEL.
+func TestDNSStubRules(t *testing.T) {
+	rules, deleted := mockIPTablesLayer()
+	defer restoreIPTablesLayer()
+	writes := mockWriteSysctl("")
+	defer func() { writeSysctl = realWriteSysctl }()
+
+	nc := newTestNetConfig(t, testDNSStubNetConfig)
+	require.NoError(t, enableDNSStub("eth0", nc))
+
+	assert.Equal(t, [][2]string{{"/proc/sys/net/ipv4/conf/eth0/route_localnet", "1"}}, *writes)
+	expected := []string{
+		fmt.Sprintf("%d nat OUTPUT -d 127.0.0.53/32 -p udp --dport 53 -j DNAT --to-destination 169.254.169.253:53",
+			iptables.ProtocolIPv4),
+		fmt.Sprintf("%d nat OUTPUT -d 127.0.0.53/32 -p tcp --dport 53 -j DNAT --to-destination 169.254.169.253:53",
+			iptables.ProtocolIPv4),
+		fmt.Sprintf("%d nat POSTROUTING -s 127.0.0.0/8 -o eth0 -j MASQUERADE", iptables.ProtocolIPv4),
+	}
+	assert.Equal(t, expected, *rules)
+
+	require.NoError(t, deleteIPTablesRules(newDNSStubRules(nc)))
+	assert.Equal(t, expected, *deleted)
+}
+
+// TestDNSStubRoutes tests that host routes are added via the branch gateway to the IPv4
+// nameservers that are not on the branch subnet.
+func TestDNSStubRoutes(t *testing.T) {
+	var added []*netlink.Route
+	routeAdd = func(route *netlink.Route) error {
+		added = append(added, route)
+		return nil
+	}
+	defer func() { routeAdd = netlink.RouteAdd }()
+
+	nc := newTestNetConfig(t, testDNSStubNetConfig)
+	require.NoError(t, addDNSStubRoutes(7, nc))
+	require.Len(t, added, 1)
+	assert.Equal(t, "169.254.169.253/32", added[0].Dst.String())
+	assert.Equal(t, "10.11.0.1", added[0].Gw.String())
+	assert.Equal(t, 7, added[0].LinkIndex)
+	assert.Equal(t, 0, added[0].Table)
+	assert.Equal(t, nc.RouteProtocol, added[0].Protocol)
+	assert.Zero(t, added[0].Flags)
+
+	// Gateways off the branch subnet are on-link.
+	added = nil
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/32", "branchGatewayIPAddress":"10.11.0.1",
+		"dns":{"nameservers":["10.11.0.2"]}, "dnsStub":true, "interfaceType":"vlan"}`)
+	require.NoError(t, addDNSStubRoutes(7, nc))
+	require.Len(t, added, 1)
+	assert.Equal(t, "10.11.0.2/32", added[0].Dst.String())
+	assert.Equal(t, int(netlink.FLAG_ONLINK), added[0].Flags)
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+)
+
+const (
+	// envDryRun is the environment variable that enables the dry-run mode.
+	// In dry-run mode, ADD validates the network configuration and prints the would-be result
+	// without making any changes to the host.
+	envDryRun = "VPC_CNI_DRYRUN"
+)
+
+// dryRunOutput defines the JSON format of the dry-run output.
+type dryRunOutput struct {
+	NetConfig *dryRunNetConfig `json:"netConfig"`
+	Result    cniTypes.Result  `json:"result"`
+}
+
+// dryRunNetConfig defines the JSON format of a parsed NetConfig in the dry-run output.
+type dryRunNetConfig struct {
+	CNIVersion               string           `json:"cniVersion"`
+	Name                     string           `json:"name"`
+	Type                     string           `json:"type"`
+	TrunkName                string           `json:"trunkName"`
+	TrunkMACAddress          string           `json:"trunkMACAddress,omitempty"`
+	TrunkPCIAddress          string           `json:"trunkPCIAddress,omitempty"`
+	TrunkInterfaceIndex      *int             `json:"trunkInterfaceIndex,omitempty"`
+	BranchVlanID             int              `json:"branchVlanID"`
+	BranchMACAddress         string           `json:"branchMACAddress"`
+	BranchIPAddresses        []string         `json:"branchIPAddresses,omitempty"`
+	BranchGatewayIPAddress   net.IP           `json:"branchGatewayIPAddress,omitempty"`
+	BranchIPv6Address        string           `json:"branchIPv6Address,omitempty"`
+	BranchGatewayIPv6Address net.IP           `json:"branchGatewayIPv6Address,omitempty"`
+	MTU                      int              `json:"mtu,omitempty"`
+	Routes                   []cniTypes.Route `json:"routes,omitempty"`
+	DNS                      cniTypes.DNS     `json:"dns"`
+	IngressBandwidthLimit    uint64           `json:"ingressBandwidthLimit,omitempty"`
+	EgressBandwidthLimit     uint64           `json:"egressBandwidthLimit,omitempty"`
+	BlockIMDS                bool             `json:"blockInstanceMetadata"`
+	BlockIMDSMethod          string           `json:"blockInstanceMetadataMethod"`
+	FirewallBackend          string           `json:"firewallBackend"`
+	ProxyARP                 bool             `json:"proxyARP"`
+	InterfaceType            string           `json:"interfaceType"`
+	Uid                      *int             `json:"uid,omitempty"`
+	Gid                      *int             `json:"gid,omitempty"`
+}
+
+// isDryRun returns whether the plugin is running in dry-run mode.
+func isDryRun() bool {
+	return os.Getenv(envDryRun) == "1"
+}
+
+// printDryRunOutput writes the parsed network configuration and the would-be CNI result.
+func printDryRunOutput(w io.Writer, ifName string, netnsPath string, netConfig *config.NetConfig) error {
+	result, err := newResult(ifName, netnsPath, netConfig).GetAsVersion(netConfig.CNIVersion)
+	if err != nil {
+		return err
+	}
+
+	output := &dryRunOutput{
+		NetConfig: newDryRunNetConfig(netConfig),
+		Result:    result,
+	}
+
+	data, err := json.MarshalIndent(output, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Writing dry-run output to stdout: %s", data)
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// newDryRunNetConfig returns the dry-run output representation of the given NetConfig.
+func newDryRunNetConfig(netConfig *config.NetConfig) *dryRunNetConfig {
+	nc := &dryRunNetConfig{
+		CNIVersion:               netConfig.CNIVersion,
+		Name:                     netConfig.Name,
+		Type:                     netConfig.Type,
+		TrunkName:                netConfig.TrunkName,
+		TrunkPCIAddress:          netConfig.TrunkPCIAddress,
+		TrunkInterfaceIndex:      netConfig.TrunkInterfaceIndex,
+		BranchVlanID:             netConfig.BranchVlanID,
+		BranchMACAddress:         netConfig.BranchMACAddress.String(),
+		BranchGatewayIPAddress:   netConfig.BranchGatewayIPAddress,
+		BranchGatewayIPv6Address: netConfig.BranchGatewayIPv6Address,
+		MTU:                      netConfig.MTU,
+		Routes:                   netConfig.Routes,
+		DNS:                      netConfig.DNS,
+		IngressBandwidthLimit:    netConfig.IngressBandwidthLimit,
+		EgressBandwidthLimit:     netConfig.EgressBandwidthLimit,
+		BlockIMDS:                netConfig.BlockIMDS,
+		BlockIMDSMethod:          netConfig.BlockIMDSMethod,
+		FirewallBackend:          netConfig.FirewallBackend,
+		ProxyARP:                 netConfig.ProxyARP,
+		InterfaceType:            netConfig.InterfaceType,
+	}
+
+	if netConfig.TrunkMACAddress != nil {
+		nc.TrunkMACAddress = netConfig.TrunkMACAddress.String()
+	}
+
+	for _, ipAddress := range netConfig.BranchIPAddresses {
+		nc.BranchIPAddresses = append(nc.BranchIPAddresses, ipAddress.String())
+	}
+
+	if netConfig.BranchIPv6Address != nil {
+		nc.BranchIPv6Address = netConfig.BranchIPv6Address.String()
+	}
+
+	if netConfig.Tap != nil {
+		nc.Uid = &netConfig.Tap.Uid
+		nc.Gid = &netConfig.Tap.Gid
+	}
+
+	return nc
+}
@@ -0,0 +1,74 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIsDryRun tests that the dry-run mode is enabled only by the environment variable.
+func TestIsDryRun(t *testing.T) {
+	defer os.Unsetenv(envDryRun)
+
+	os.Unsetenv(envDryRun)
+	assert.False(t, isDryRun())
+
+	os.Setenv(envDryRun, "0")
+	assert.False(t, isDryRun())
+
+	os.Setenv(envDryRun, "1")
+	assert.True(t, isDryRun())
+}
+
+// TestDryRunOutput tests that the dry-run output contains the parsed netconfig and the result.
+func TestDryRunOutput(t *testing.T) {
+	nc := newTestNetConfig(t, testBranchNetConfig)
+
+	var buf bytes.Buffer
+	err := printDryRunOutput(&buf, testIfName, testNetnsPath, nc)
+	require.NoError(t, err)
+
+	var output struct {
+		NetConfig map[string]interface{} `json:"netConfig"`
+		Result    struct {
+			CNIVersion string `json:"cniVersion"`
+			IPs        []struct {
+				Address string `json:"address"`
+				Gateway string `json:"gateway"`
+			} `json:"ips"`
+		} `json:"result"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &output)
+	require.NoError(t, err)
+
+	assert.Equal(t, "eth1", output.NetConfig["trunkName"])
+	assert.Equal(t, float64(101), output.NetConfig["branchVlanID"])
+	assert.Equal(t, "02:e1:48:75:86:a4", output.NetConfig["branchMACAddress"])
+	assert.Equal(t, []interface{}{"172.31.19.6/20"}, output.NetConfig["branchIPAddresses"])
+	assert.Equal(t, "172.31.16.1", output.NetConfig["branchGatewayIPAddress"])
+	assert.Equal(t, "vlan", output.NetConfig["interfaceType"])
+
+	assert.Equal(t, "0.3.1", output.Result.CNIVersion)
+	require.Len(t, output.Result.IPs, 1)
+	assert.Equal(t, "172.31.19.6/20", output.Result.IPs[0].Address)
+	assert.Equal(t, "172.31.16.1", output.Result.IPs[0].Gateway)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+)
+
+const (
+	// Table and chain of iptables rules blocking inbound connections.
+	egressOnlyTable = "filter"
+	egressOnlyChain = "INPUT"
+)
+
+// newEgressOnlyRules returns the iptables rules that drop new inbound connections on the branch
+// interface, for each configured IP address family. Packets of established connections, and
+// related ones such as ICMP errors, are accepted. Egress traffic is not filtered.
+func newEgressOnlyRules(netConfig *config.NetConfig) []iptablesRule {
+	var rules []iptablesRule
+	for _, proto := range getIPTablesProtocols(netConfig) {
+		rules = append(rules,
+			iptablesRule{
+				backend: netConfig.FirewallBackend,
+				proto:   proto,
+				table:   egressOnlyTable,
+				chain:   egressOnlyChain,
+				rulespec: []string{"-i", netConfig.InterfaceName,
+					"-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT"},
+			},
+			iptablesRule{
+				backend: netConfig.FirewallBackend,
+				proto:   proto,
+				table:   egressOnlyTable,
+				chain:   egressOnlyChain,
+				rulespec: []string{"-i", netConfig.InterfaceName,
+					"-m", "conntrack", "--ctstate", "NEW", "-j", "DROP"},
+			})
+	}
+
+	return rules
+}
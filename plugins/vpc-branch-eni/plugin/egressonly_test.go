@@ -0,0 +1,50 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEgressOnlyRules tests that established connections are accepted and new inbound connections
+// are dropped on the branch interface for each address family, and the rules are deleted on DEL.
+func TestEgressOnlyRules(t *testing.T) {
+	rules, deleted := mockIPTablesLayer()
+	defer restoreIPTablesLayer()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64",
+		"egressOnly":true, "interfaceName":"eth0", "interfaceType":"vlan"}`)
+
+	err := addIPTablesRules(newEgressOnlyRules(nc))
+	assert.NoError(t, err)
+
+	var expected []string
+	for _, proto := range []iptables.Protocol{iptables.ProtocolIPv4, iptables.ProtocolIPv6} {
+		expected = append(expected,
+			fmt.Sprintf("%d filter INPUT -i eth0 -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT", proto),
+			fmt.Sprintf("%d filter INPUT -i eth0 -m conntrack --ctstate NEW -j DROP", proto))
+	}
+	assert.Equal(t, expected, *rules)
+
+	err = deleteIPTablesRules(newEgressOnlyRules(nc))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, *deleted)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// envExplain is the environment variable that enables the explain mode.
+	// In explain mode, every command prints the fully-resolved network configuration, after the
+	// per-container args are merged and the gateways are derived, and exits without making any
+	// changes to the host.
+	envExplain = "VPC_CNI_EXPLAIN"
+)
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// isExplain returns whether the plugin is running in explain mode.
+func isExplain() bool {
+	return os.Getenv(envExplain) == "1"
+}
+
+// printExplainOutput writes the given NetConfig as indented JSON. Unlike the dry-run output,
+// every field is included, as it is applied by the plugin.
+func printExplainOutput(w io.Writer, netConfig *config.NetConfig) error {
+	data, err := json.MarshalIndent(explainValue(reflect.ValueOf(netConfig)), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Writing explain output to stdout: %s", data)
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// explainValue returns the JSON representation of the given value. Struct fields are named
+// after their JSON key if tagged, and after the field otherwise, with embedded structs
+// flattened. MAC addresses and IP prefixes are printed in their string form.
+func explainValue(v reflect.Value) interface{} {
+	switch value := v.Interface().(type) {
+	case net.HardwareAddr:
+		if value == nil {
+			return nil
+		}
+		return value.String()
+	case net.IPNet:
+		return value.String()
+	case *net.IPNet:
+		if value == nil {
+			return nil
+		}
+		return value.String()
+	}
+
+	if v.Type().Implements(textMarshalerType) || v.Type().Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return explainValue(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = explainValue(v.Index(i))
+		}
+		return values
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		explainFields(v, fields)
+		return fields
+	default:
+		return v.Interface()
+	}
+}
+
+// explainFields adds the exported fields of the given struct to fields.
+func explainFields(v reflect.Value, fields map[string]interface{}) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			explainFields(v.Field(i), fields)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = explainValue(v.Field(i))
+	}
+}
@@ -0,0 +1,88 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIsExplain tests that the explain mode is enabled only by the environment variable.
+func TestIsExplain(t *testing.T) {
+	defer os.Unsetenv(envExplain)
+
+	os.Unsetenv(envExplain)
+	assert.False(t, isExplain())
+
+	os.Setenv(envExplain, "0")
+	assert.False(t, isExplain())
+
+	os.Setenv(envExplain, "1")
+	assert.True(t, isExplain())
+}
+
+// TestExplainOutput tests that the explain output is the network configuration resolved from
+// the per-network and per-container args, including the derived gateway.
+func TestExplainOutput(t *testing.T) {
+	nc, err := config.New(&cniSkel.CmdArgs{
+		StdinData: []byte(`{"trunkName":"eth0", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+			"branchIPAddress":"10.11.12.13/14", "uid":"42", "gid":"42"}`),
+		Args: "BranchVlanID=42;BranchMACAddress=44:44:44:55:55:55;BranchIPAddress=192.168.1.2/16",
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, printExplainOutput(&buf, nc))
+
+	var output map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &output))
+
+	for key, value := range map[string]interface{}{
+		"TrunkName":                "eth0",
+		"TrunkNames":               []interface{}{"eth0"},
+		"BranchVlanID":             float64(42),
+		"BranchMACAddress":         "44:44:44:55:55:55",
+		"BranchIPAddress":          "192.168.1.2/16",
+		"BranchIPAddresses":        []interface{}{"192.168.1.2/16"},
+		"BranchGatewayIPAddress":   "192.168.0.1",
+		"BranchGatewayIPAddresses": []interface{}{"192.168.0.1"},
+		"BranchIPv6Address":        nil,
+		"InstallDefaultRoute":      true,
+		"InterfaceType":            config.IfTypeTAP,
+		"InterfaceName":            "eth0",
+		"Tap": map[string]interface{}{
+			"Uid": float64(42), "Gid": float64(42), "Queues": float64(1),
+			"VhostNet": false, "ExternallyManaged": false,
+		},
+		"VlanProtocol": "802.1q",
+		"ipam":         map[string]interface{}{"type": ""},
+	} {
+		assert.Equal(t, value, output[key], key)
+	}
+
+	// Every field is included, even if unset.
+	assert.Contains(t, output, "ManagementIPAddress")
+	assert.Contains(t, output, "cniVersion")
+	assert.NotContains(t, output, "NetConf")
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Interval between rounds of announcements of the same addresses.
+	announceInterval = 100 * time.Millisecond
+
+	// ICMPv6 neighbor advertisement format with a target link-layer address option.
+	ndpNAPacketLength    = 32
+	ndpNATypeAdvertise   = 136
+	ndpNAFlagOverride    = 0x20000000
+	ndpOptTargetLinkAddr = 2
+	ndpHopLimit          = 255
+)
+
+// announceAddress sends a gratuitous ARP for the given IPv4 address, or an unsolicited neighbor
+// advertisement for the given IPv6 address, from the given link. It is a variable so that it can
+// be replaced in unit tests.
+var announceAddress = func(linkIndex int, macAddress net.HardwareAddr, ipAddress net.IP) error {
+	if ipv4Address := ipAddress.To4(); ipv4Address != nil {
+		return sendGratuitousARP(linkIndex, macAddress, ipv4Address)
+	}
+	return sendUnsolicitedNA(linkIndex, macAddress, ipAddress)
+}
+
+// announceAddresses announces the given IP addresses on the given link the given number of times,
+// so that neighbors replace cache entries pointing to a previous owner of the addresses.
+// Announcements only speed up neighbor cache updates, so failures are logged and otherwise ignored.
+func announceAddresses(linkIndex int, addresses []net.IPNet, count int) {
+	if count == 0 || len(addresses) == 0 {
+		return
+	}
+
+	link, err := netlink.LinkByIndex(linkIndex)
+	if err != nil {
+		log.Errorf("Failed to find link %d to announce IP addresses: %v.", linkIndex, err)
+		return
+	}
+	macAddress := link.Attrs().HardwareAddr
+
+	log.Infof("Announcing IP addresses %v %d times.", addresses, count)
+	for i := 0; i < count; i++ {
+		if i != 0 {
+			sleep(announceInterval)
+		}
+		for _, address := range addresses {
+			err = announceAddress(linkIndex, macAddress, address.IP)
+			if err != nil {
+				log.Errorf("Failed to announce IP address %v: %v.", address.IP, err)
+			}
+		}
+	}
+}
+
+// sendGratuitousARP broadcasts a gratuitous ARP request for the given IPv4 address, which is an
+// ARP request with the address as both its sender and target.
+func sendGratuitousARP(linkIndex int, macAddress net.HardwareAddr, ipAddress net.IP) error {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	broadcast := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  linkIndex,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	return unix.Sendto(fd, newGratuitousARP(macAddress, ipAddress), 0, broadcast)
+}
+
+// newGratuitousARP returns a gratuitous ARP request for the given IPv4 address.
+func newGratuitousARP(macAddress net.HardwareAddr, ipAddress net.IP) []byte {
+	packet := newARPProbe(macAddress, ipAddress)
+	copy(packet[14:18], ipAddress.To4())
+	return packet
+}
+
+// sendUnsolicitedNA sends an unsolicited neighbor advertisement for the given IPv6 address to
+// the all-nodes multicast address. The kernel computes the ICMPv6 checksum.
+func sendUnsolicitedNA(linkIndex int, macAddress net.HardwareAddr, ipAddress net.IP) error {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_RAW, unix.IPPROTO_ICMPV6)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	// Neighbor discovery messages are only accepted with the maximum hop limit.
+	err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ndpHopLimit)
+	if err != nil {
+		return err
+	}
+
+	allNodes := &unix.SockaddrInet6{ZoneId: uint32(linkIndex)}
+	copy(allNodes.Addr[:], net.IPv6linklocalallnodes)
+
+	return unix.Sendto(fd, newUnsolicitedNA(macAddress, ipAddress), 0, allNodes)
+}
+
+// newUnsolicitedNA returns an unsolicited neighbor advertisement for the given IPv6 address,
+// which overrides existing cache entries with the given MAC address.
+func newUnsolicitedNA(macAddress net.HardwareAddr, ipAddress net.IP) []byte {
+	packet := make([]byte, ndpNAPacketLength)
+	packet[0] = ndpNATypeAdvertise
+	binary.BigEndian.PutUint32(packet[4:], ndpNAFlagOverride)
+	copy(packet[8:24], ipAddress.To16())
+	packet[24] = ndpOptTargetLinkAddr
+	packet[25] = 1 // Option length in units of 8 bytes
+	copy(packet[26:32], macAddress)
+	return packet
+}
@@ -0,0 +1,86 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// TestAnnounceAddresses tests that each address is announced the requested number of times, with
+// a pause between rounds, and that failures do not stop the announcements.
+func TestAnnounceAddresses(t *testing.T) {
+	realAnnounceAddress := announceAddress
+	var announced []string
+	announceAddress = func(linkIndex int, macAddress net.HardwareAddr, ipAddress net.IP) error {
+		announced = append(announced, ipAddress.String())
+		return errors.New("network is down")
+	}
+	var sleeps []time.Duration
+	sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	defer func() {
+		announceAddress = realAnnounceAddress
+		sleep = time.Sleep
+	}()
+
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(t, err)
+	_, ipv4Address, _ := net.ParseCIDR("10.11.12.13/32")
+	_, ipv6Address, _ := net.ParseCIDR("2600:1f13:a0d:a700::5/128")
+	addresses := []net.IPNet{*ipv4Address, *ipv6Address}
+
+	announceAddresses(lo.Attrs().Index, addresses, 3)
+	assert.Equal(t, []string{
+		"10.11.12.13", "2600:1f13:a0d:a700::5",
+		"10.11.12.13", "2600:1f13:a0d:a700::5",
+		"10.11.12.13", "2600:1f13:a0d:a700::5",
+	}, announced)
+	assert.Equal(t, []time.Duration{announceInterval, announceInterval}, sleeps)
+
+	announced = nil
+	announceAddresses(lo.Attrs().Index, addresses, 0)
+	assert.Empty(t, announced)
+}
+
+// TestNewGratuitousARP tests that the gratuitous ARP claims the address as its sender and target.
+func TestNewGratuitousARP(t *testing.T) {
+	macAddress, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	ipAddress := net.ParseIP("10.11.12.13")
+
+	assert.Equal(t, []byte{
+		0, 1, 8, 0, 6, 4, 0, 1,
+		0x02, 0xe1, 0x48, 0x75, 0x86, 0xa4, 10, 11, 12, 13,
+		0, 0, 0, 0, 0, 0, 10, 11, 12, 13,
+	}, newGratuitousARP(macAddress, ipAddress))
+}
+
+// TestNewUnsolicitedNA tests that the neighbor advertisement overrides cache entries with the
+// given MAC address.
+func TestNewUnsolicitedNA(t *testing.T) {
+	macAddress, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	ipAddress := net.ParseIP("2600:1f13:a0d:a700::5")
+
+	expected := []byte{136, 0, 0, 0, 0x20, 0, 0, 0}
+	expected = append(expected, ipAddress...)
+	expected = append(expected, 2, 1, 0x02, 0xe1, 0x48, 0x75, 0x86, 0xa4)
+	assert.Equal(t, expected, newUnsolicitedNA(macAddress, ipAddress))
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Number of ICMP echo requests sent to a gateway and how long to wait for a reply to each.
+	gatewayPingCount = 3
+	gatewayPingWait  = time.Second
+
+	// ICMP echo message types.
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	// Length of the ICMP echo messages sent, including the header.
+	icmpEchoLength = 16
+)
+
+// pingGateway reports whether the given gateway replies to ICMP echo requests sent on the given
+// link in the current netns. It is a variable so that it can be replaced in unit tests.
+var pingGateway = func(linkName string, gatewayIPAddress net.IP) (bool, error) {
+	return icmpPing(linkName, gatewayIPAddress)
+}
+
+// verifyGatewaysReachable returns an error if any of the branch gateways does not reply to pings
+// from the container-facing link in the current netns.
+func verifyGatewaysReachable(netConfig *config.NetConfig) error {
+	for _, gatewayIPAddress := range getBranchGateways(netConfig) {
+		log.Infof("Verifying that gateway %s is reachable.", gatewayIPAddress)
+		reachable, err := pingGateway(netConfig.InterfaceName, gatewayIPAddress)
+		if err != nil {
+			log.Errorf("Failed to ping gateway %s: %v.", gatewayIPAddress, err)
+			return fmt.Errorf("failed to ping gateway %s: %v", gatewayIPAddress, err)
+		}
+
+		if !reachable {
+			log.Errorf("Gateway %s is not reachable.", gatewayIPAddress)
+			return fmt.Errorf("gateway %s did not reply to %d pings on %s within %v",
+				gatewayIPAddress, gatewayPingCount, netConfig.InterfaceName, gatewayPingCount*gatewayPingWait)
+		}
+	}
+
+	return nil
+}
+
+// icmpPing sends ICMP echo requests to the given IP address on the given link and reports whether
+// any of them was answered. Requests that cannot be sent yet, e.g. while the source address is
+// still tentative, count as unanswered.
+func icmpPing(linkName string, ipAddress net.IP) (bool, error) {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		return false, err
+	}
+
+	ipv6 := ipAddress.To4() == nil
+	var fd int
+	var to unix.Sockaddr
+	if ipv6 {
+		fd, err = unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+		sa := &unix.SockaddrInet6{ZoneId: uint32(link.Attrs().Index)}
+		copy(sa.Addr[:], ipAddress.To16())
+		to = sa
+	} else {
+		fd, err = unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+		sa := &unix.SockaddrInet4{}
+		copy(sa.Addr[:], ipAddress.To4())
+		to = sa
+	}
+	if err != nil {
+		return false, err
+	}
+	defer unix.Close(fd)
+
+	err = unix.BindToDevice(fd, linkName)
+	if err != nil {
+		return false, err
+	}
+
+	tv := unix.NsecToTimeval(int64(gatewayPingWait))
+	err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return false, err
+	}
+
+	id := uint16(os.Getpid())
+	buf := make([]byte, 1500)
+
+	for seq := uint16(1); seq <= gatewayPingCount; seq++ {
+		err = unix.Sendto(fd, newICMPEchoRequest(ipv6, id, seq), 0, to)
+		if err != nil {
+			log.Debugf("Failed to send ping %d to %s: %v.", seq, ipAddress, err)
+			sleep(gatewayPingWait)
+			continue
+		}
+
+		deadline := time.Now().Add(gatewayPingWait)
+		for time.Now().Before(deadline) {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err == unix.EAGAIN || err == unix.EINTR {
+				break
+			}
+			if err != nil {
+				return false, err
+			}
+			if isICMPEchoReply(buf[:n], ipv6, id) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// newICMPEchoRequest returns an ICMP or ICMPv6 echo request with the given identifier and
+// sequence number. The kernel computes the checksum of ICMPv6 messages sent on raw sockets.
+func newICMPEchoRequest(ipv6 bool, id, seq uint16) []byte {
+	message := make([]byte, icmpEchoLength)
+	message[0] = icmpv4EchoRequest
+	if ipv6 {
+		message[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(message[4:], id)
+	binary.BigEndian.PutUint16(message[6:], seq)
+
+	if !ipv6 {
+		binary.BigEndian.PutUint16(message[2:], icmpChecksum(message))
+	}
+
+	return message
+}
+
+// isICMPEchoReply returns whether the given packet received on a raw socket is a reply to an echo
+// request with the given identifier. IPv4 packets are received with their IP header.
+func isICMPEchoReply(packet []byte, ipv6 bool, id uint16) bool {
+	replyType := byte(icmpv6EchoReply)
+	if !ipv6 {
+		if len(packet) == 0 {
+			return false
+		}
+		headerLength := int(packet[0]&0x0f) * 4
+		if len(packet) < headerLength {
+			return false
+		}
+		packet = packet[headerLength:]
+		replyType = icmpv4EchoReply
+	}
+
+	if len(packet) < 8 {
+		return false
+	}
+
+	return packet[0] == replyType && binary.BigEndian.Uint16(packet[4:]) == id
+}
+
+// icmpChecksum returns the RFC 1071 internet checksum of the given ICMP message.
+func icmpChecksum(message []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(message); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(message[i:]))
+	}
+	if len(message)%2 == 1 {
+		sum += uint32(message[len(message)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,80 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestVerifyGatewaysReachable tests that each branch gateway is pinged on the container-facing
+// link, and that an unreachable gateway fails the check.
+func TestVerifyGatewaysReachable(t *testing.T) {
+	realPingGateway := pingGateway
+	defer func() { pingGateway = realPingGateway }()
+
+	netConfig := &config.NetConfig{
+		BranchGatewayIPAddress:   net.ParseIP("10.11.0.1"),
+		BranchGatewayIPv6Address: net.ParseIP("fe80::1"),
+		InterfaceName:            "eth0",
+	}
+
+	var pinged []string
+	pingGateway = func(linkName string, gatewayIPAddress net.IP) (bool, error) {
+		assert.Equal(t, "eth0", linkName)
+		pinged = append(pinged, gatewayIPAddress.String())
+		return true, nil
+	}
+	assert.NoError(t, verifyGatewaysReachable(netConfig))
+	assert.Equal(t, []string{"10.11.0.1", "fe80::1"}, pinged)
+
+	pingGateway = func(string, net.IP) (bool, error) { return false, nil }
+	err := verifyGatewaysReachable(netConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gateway 10.11.0.1 did not reply to 3 pings on eth0")
+
+	pingGateway = func(string, net.IP) (bool, error) { return false, errors.New("network is down") }
+	err = verifyGatewaysReachable(netConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "network is down")
+}
+
+// TestICMPEcho tests the encoding of echo requests and the matching of echo replies.
+func TestICMPEcho(t *testing.T) {
+	request := newICMPEchoRequest(false, 0x1234, 1)
+	assert.Equal(t, []byte{icmpv4EchoRequest, 0, 0xe5, 0xca, 0x12, 0x34, 0, 1}, request[:8])
+	assert.Equal(t, uint16(0), icmpChecksum(request))
+
+	// IPv4 replies are received with their IP header.
+	reply := append([]byte{0x45}, make([]byte, 19)...)
+	reply = append(reply, request...)
+	reply[20] = icmpv4EchoReply
+	assert.True(t, isICMPEchoReply(reply, false, 0x1234))
+	assert.False(t, isICMPEchoReply(reply, false, 0x1235))
+	assert.False(t, isICMPEchoReply(reply[:24], false, 0x1234))
+
+	request = newICMPEchoRequest(true, 0x1234, 1)
+	assert.Equal(t, []byte{icmpv6EchoRequest, 0, 0, 0, 0x12, 0x34, 0, 1}, request[:8])
+	request[0] = icmpv6EchoReply
+	assert.True(t, isICMPEchoReply(request, true, 0x1234))
+	assert.False(t, isICMPEchoReply(request, false, 0x1234))
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// GCCommand is the command line subcommand that deletes orphaned branch links.
+	GCCommand = "gc"
+)
+
+// GC deletes the branch links in the host netns and in the given netns that are owned by
+// containers other than the live ones read from the given reader, separated by whitespace.
+// Branch links without an owner are left alone. If no netns are given, all named netns are
+// searched. GC is idempotent and writes each deletion to w.
+func GC(w io.Writer, liveContainerIDs io.Reader, netnsPaths []string) error {
+	live, err := readContainerIDs(liveContainerIDs)
+	if err != nil {
+		return fmt.Errorf("failed to read live container IDs: %v", err)
+	}
+
+	return forEachNetNS(netnsPaths, func(netnsName string) error {
+		return deleteOrphanedBranches(w, netnsName, live)
+	})
+}
+
+// deleteOrphanedBranches deletes the branch links in the current netns that are owned by
+// containers other than the given live ones.
+func deleteOrphanedBranches(w io.Writer, netnsName string, live map[string]bool) error {
+	branches, err := listBranches(netnsName)
+	if err != nil {
+		return fmt.Errorf("failed to list branch links in netns %s: %v", netnsName, err)
+	}
+
+	for _, branch := range getOrphanedBranches(branches, live) {
+		log.Infof("Deleting orphaned branch link %s of container %s in netns %s.",
+			branch.Name, branch.ContainerID, netnsName)
+		err = deleteLink(branch.Name)
+		if err != nil {
+			return fmt.Errorf("failed to delete branch link %s in netns %s: %v", branch.Name, netnsName, err)
+		}
+		fmt.Fprintf(w, "Deleted branch link %s of container %s in netns %s\n",
+			branch.Name, branch.ContainerID, netnsName)
+	}
+
+	return nil
+}
+
+// readContainerIDs reads a set of whitespace-separated container IDs, truncated to the length
+// stored in branch link aliases.
+func readContainerIDs(r io.Reader) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		ids[getContainerIDFromAlias(fmt.Sprintf(branchLinkAliasFormat, scanner.Text()))] = true
+	}
+
+	return ids, scanner.Err()
+}
+
+// getOrphanedBranches returns the branch links owned by containers that are not live.
+func getOrphanedBranches(branches []BranchInfo, live map[string]bool) []BranchInfo {
+	var orphans []BranchInfo
+	for _, branch := range branches {
+		if branch.ContainerID != "" && !live[branch.ContainerID] {
+			orphans = append(orphans, branch)
+		}
+	}
+
+	return orphans
+}
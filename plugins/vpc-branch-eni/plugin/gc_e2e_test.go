@@ -0,0 +1,99 @@
+// +build e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// TestGC tests that only the branch link of the container that is not live is deleted.
+func TestGC(t *testing.T) {
+	targetNS, err := netns.NewNetNS("testGCNS")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, targetNS.Close())
+	}()
+
+	// GC also visits the host netns, so run it from an empty netns standing in for the host.
+	hostNS, err := netns.NewNetNS("testGCHostNS")
+	require.NoError(t, err)
+	defer hostNS.Close()
+
+	err = targetNS.Run(func() error {
+		la := netlink.NewLinkAttrs()
+		la.Name = "trunk0"
+		trunk := &netlink.Dummy{LinkAttrs: la}
+		if err := netlink.LinkAdd(trunk); err != nil {
+			return err
+		}
+
+		for vlanID, containerID := range map[int]string{101: "live_container", 102: "dead_container"} {
+			la = netlink.NewLinkAttrs()
+			la.Name = fmt.Sprintf("eth%d", vlanID)
+			la.ParentIndex = trunk.Index
+			branch := &netlink.Vlan{LinkAttrs: la, VlanId: vlanID}
+			if err := netlink.LinkAdd(branch); err != nil {
+				return err
+			}
+			if err := netlink.LinkSetAlias(branch, fmt.Sprintf(branchLinkAliasFormat, containerID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Collect garbage only in the test netns, leaving the host netns alone.
+	var buf bytes.Buffer
+	gc := func() error {
+		return hostNS.Run(func() error {
+			return GC(&buf, strings.NewReader("live_container\n"), []string{targetNS.GetPath()})
+		})
+	}
+	err = gc()
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "eth102")
+	assert.NotContains(t, buf.String(), "eth101")
+
+	err = targetNS.Run(func() error {
+		_, err := netlink.LinkByName("eth101")
+		assert.NoError(t, err)
+		_, err = netlink.LinkByName("eth102")
+		assert.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// GC is safe to run repeatedly.
+	buf.Reset()
+	err = gc()
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	// GC must not delete the netns it visits.
+	_, err = os.Stat(targetNS.GetPath())
+	assert.NoError(t, err)
+}
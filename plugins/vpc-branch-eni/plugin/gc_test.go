@@ -0,0 +1,45 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetOrphanedBranches tests that only the branch links of containers that are not live are orphaned.
+func TestGetOrphanedBranches(t *testing.T) {
+	live, err := readContainerIDs(strings.NewReader("0123456789abcdef0123\n\n  fedcba9876543210\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"0123456789ab": true, "fedcba987654": true}, live)
+
+	branches := []BranchInfo{
+		{Name: "eth0", VlanID: 101, ContainerID: "0123456789ab"},
+		{Name: "eth1", VlanID: 102, ContainerID: "aaaaaaaaaaaa"},
+		{Name: "eth1.103", VlanID: 103},
+	}
+
+	orphans := getOrphanedBranches(branches, live)
+	require.Len(t, orphans, 1)
+	assert.Equal(t, "eth1", orphans[0].Name)
+
+	// No branches are live.
+	orphans = getOrphanedBranches(branches, map[string]bool{})
+	assert.Len(t, orphans, 2)
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// Name of the loopback link in the target network namespace.
+	loopbackLinkName = "lo"
+)
+
+// Hairpin operations. They are variables so that they can be replaced in unit tests.
+var (
+	linkSetHairpin = netlink.LinkSetHairpin
+	linkSetUp      = netlink.LinkSetUp
+)
+
+// enableHairpin lets the container reach itself via its branch IP addresses. Frames from a VM
+// are reflected back to its TAP link by the bridge. Packets from a container to its own
+// addresses are routed via the loopback link, which is down in new network namespaces.
+func enableHairpin(netConfig *config.NetConfig) error {
+	if netConfig.InterfaceType == config.IfTypeTAP {
+		link, err := netlink.LinkByName(netConfig.InterfaceName)
+		if err != nil {
+			log.Errorf("Failed to find TAP link %s: %v.", netConfig.InterfaceName, err)
+			return err
+		}
+
+		log.Infof("Enabling hairpin mode on TAP link %s.", netConfig.InterfaceName)
+		err = linkSetHairpin(link, true)
+		if err != nil {
+			log.Errorf("Failed to enable hairpin mode on TAP link %s: %v.", netConfig.InterfaceName, err)
+			return err
+		}
+		return nil
+	}
+
+	link, err := netlink.LinkByName(loopbackLinkName)
+	if err != nil {
+		log.Errorf("Failed to find loopback link: %v.", err)
+		return err
+	}
+
+	log.Infof("Setting loopback link state up for hairpinning.")
+	err = trace(traceOpSetUp, loopbackLinkName, func() error {
+		return linkSetUp(link)
+	})
+	if err != nil {
+		log.Errorf("Failed to set loopback link state: %v.", err)
+		return err
+	}
+
+	return nil
+}
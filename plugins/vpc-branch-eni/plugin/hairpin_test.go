@@ -0,0 +1,63 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// TestEnableHairpin tests that hairpin mode is requested on the TAP link, and that the loopback
+// link is brought up for branch interfaces.
+func TestEnableHairpin(t *testing.T) {
+	var hairpinLinks, upLinks []string
+	linkSetHairpin = func(link netlink.Link, mode bool) error {
+		assert.True(t, mode)
+		hairpinLinks = append(hairpinLinks, link.Attrs().Name)
+		return nil
+	}
+	linkSetUp = func(link netlink.Link) error {
+		upLinks = append(upLinks, link.Attrs().Name)
+		return nil
+	}
+	defer func() {
+		linkSetHairpin = netlink.LinkSetHairpin
+		linkSetUp = netlink.LinkSetUp
+	}()
+
+	// The loopback link stands in for the TAP link.
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "interfaceType":"tap", "interfaceName":"lo", "uid":"0", "gid":"0",
+		"hairpin":true}`)
+	require.NoError(t, enableHairpin(nc))
+	assert.Equal(t, []string{"lo"}, hairpinLinks)
+	assert.Empty(t, upLinks)
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "interfaceType":"vlan", "hairpin":true}`)
+	require.NoError(t, enableHairpin(nc))
+	assert.Equal(t, []string{loopbackLinkName}, upLinks)
+	assert.Len(t, hairpinLinks, 1)
+
+	// The TAP link must exist.
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "interfaceType":"tap", "interfaceName":"nonexistent0",
+		"uid":"0", "gid":"0", "hairpin":true}`)
+	assert.Error(t, enableHairpin(nc))
+}
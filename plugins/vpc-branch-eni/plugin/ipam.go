@@ -0,0 +1,163 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/containernetworking/cni/pkg/invoke"
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// leaseFunc acquires the branch IP addresses once the branch link is up in the target netns.
+type leaseFunc func() error
+
+// isDHCP returns whether the branch IP addresses are leased from a DHCP server by the dhcp IPAM
+// plugin, whose client runs on the branch link in the target netns.
+func isDHCP(netConfig *config.NetConfig) bool {
+	return netConfig.IPAM.Type == config.IPAMTypeDHCP
+}
+
+// addIPAM allocates the branch IP addresses from the IPAM plugin in the network configuration,
+// and configures the branch with the returned addresses, gateways and routes.
+func addIPAM(ctx context.Context, args *cniSkel.CmdArgs, netConfig *config.NetConfig) error {
+	data, err := config.ReadData(args)
+	if err != nil {
+		return cni.NewError(cni.ErrCodeInvalidConfig, err)
+	}
+
+	log.Infof("Allocating branch IP addresses from IPAM plugin %s.", netConfig.IPAM.Type)
+	r, err := invoke.DelegateAdd(ctx, netConfig.IPAM.Type, data, nil)
+	if err != nil {
+		log.Errorf("Failed to allocate branch IP addresses from IPAM plugin %s: %v.", netConfig.IPAM.Type, err)
+		return cni.NewError(cni.ErrCodeIPAM, err)
+	}
+
+	result, err := cniTypesCurrent.NewResultFromResult(r)
+	if err != nil {
+		log.Errorf("Failed to parse result of IPAM plugin %s: %v.", netConfig.IPAM.Type, err)
+		return cni.NewError(cni.ErrCodeIPAM, err)
+	}
+
+	applyIPAMResult(result, netConfig)
+	if len(netConfig.BranchIPAddresses) == 0 && netConfig.BranchIPv6Address == nil {
+		return cni.NewError(cni.ErrCodeIPAM,
+			fmt.Errorf("IPAM plugin %s returned no IP addresses", netConfig.IPAM.Type))
+	}
+
+	return nil
+}
+
+// deleteIPAM releases the branch IP addresses allocated from the IPAM plugin.
+func deleteIPAM(ctx context.Context, args *cniSkel.CmdArgs, netConfig *config.NetConfig) error {
+	data, err := config.ReadData(args)
+	if err != nil {
+		return cni.NewError(cni.ErrCodeInvalidConfig, err)
+	}
+
+	log.Infof("Releasing branch IP addresses to IPAM plugin %s.", netConfig.IPAM.Type)
+	err = invoke.DelegateDel(ctx, netConfig.IPAM.Type, data, nil)
+	if err != nil {
+		log.Errorf("Failed to release branch IP addresses to IPAM plugin %s: %v.", netConfig.IPAM.Type, err)
+		return cni.NewError(cni.ErrCodeIPAM, err)
+	}
+
+	return nil
+}
+
+// checkIPAM verifies that the branch IP addresses are still allocated by the IPAM plugin.
+func checkIPAM(ctx context.Context, args *cniSkel.CmdArgs, netConfig *config.NetConfig) error {
+	data, err := config.ReadData(args)
+	if err != nil {
+		return cni.NewError(cni.ErrCodeInvalidConfig, err)
+	}
+
+	err = invoke.DelegateCheck(ctx, netConfig.IPAM.Type, data, nil)
+	if err != nil {
+		log.Errorf("Failed to check branch IP addresses with IPAM plugin %s: %v.", netConfig.IPAM.Type, err)
+		return cni.NewError(cni.ErrCodeIPAM, err)
+	}
+
+	return nil
+}
+
+// applyPrevResult configures the branch with the IP addresses allocated from the IPAM plugin,
+// as recorded in the result of the previous ADD, if any.
+func applyPrevResult(netConfig *config.NetConfig) error {
+	if netConfig.PrevResult == nil {
+		return nil
+	}
+
+	result, err := cniTypesCurrent.NewResultFromResult(netConfig.PrevResult)
+	if err != nil {
+		log.Errorf("Failed to parse previous result: %v.", err)
+		return cni.NewError(cni.ErrCodeInvalidConfig, err)
+	}
+
+	applyIPAMResult(result, netConfig)
+	return nil
+}
+
+// applyIPAMResult configures the branch with the IP addresses, gateways, routes and DNS settings
+// in the given IPAM result. All IPv4 addresses and the first IPv6 address are assigned to the
+// branch. Default routes are installed via the branch gateways, and are used to set gateways that
+// are not specified along with the addresses. DNS settings in the network configuration win.
+func applyIPAMResult(result *cniTypesCurrent.Result, netConfig *config.NetConfig) {
+	for _, ipConfig := range result.IPs {
+		address := ipConfig.Address
+		if address.IP.To4() != nil {
+			netConfig.BranchIPAddresses = append(netConfig.BranchIPAddresses, address)
+			if netConfig.BranchGatewayIPAddress == nil {
+				netConfig.BranchGatewayIPAddress = ipConfig.Gateway
+			}
+		} else if netConfig.BranchIPv6Address == nil {
+			netConfig.BranchIPv6Address = &address
+			netConfig.BranchGatewayIPv6Address = ipConfig.Gateway
+		} else {
+			log.Infof("Ignoring additional IPv6 address %v from IPAM result.", address)
+		}
+	}
+
+	if len(netConfig.BranchIPAddresses) != 0 {
+		primary := netConfig.BranchIPAddresses[0]
+		netConfig.BranchIPAddress = &net.IPNet{IP: primary.IP, Mask: primary.Mask}
+	}
+
+	for _, route := range result.Routes {
+		if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+			netConfig.Routes = append(netConfig.Routes, cniTypes.Route{Dst: route.Dst, GW: route.GW})
+			continue
+		}
+
+		if route.Dst.IP.To4() != nil {
+			if netConfig.BranchGatewayIPAddress == nil {
+				netConfig.BranchGatewayIPAddress = route.GW
+			}
+		} else if netConfig.BranchGatewayIPv6Address == nil {
+			netConfig.BranchGatewayIPv6Address = route.GW
+		}
+	}
+
+	if len(netConfig.DNS.Nameservers) == 0 {
+		netConfig.DNS = result.DNS
+	}
+}
@@ -0,0 +1,167 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// testIPAMNetConfig is a network configuration delegating to the stub IPAM plugin.
+	testIPAMNetConfig = `{"cniVersion":"1.0.0", "name":"test", "type":"vpc-branch-eni", "trunkName":"eth1",
+		"branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4", "interfaceType":"vlan",
+		"ipam":{"type":"stub-ipam"}}`
+
+	// stubIPAMPluginFormat is a stub IPAM plugin that records the commands it is invoked with and
+	// prints the given result on ADD.
+	stubIPAMPluginFormat = `#!/bin/sh
+echo "$CNI_COMMAND" >> "$(dirname "$0")/commands"
+[ "$CNI_COMMAND" = "ADD" ] && echo '%s'
+exit 0
+`
+)
+
+// setupStubIPAM installs a stub IPAM plugin printing the given result into a temporary CNI_PATH.
+// It returns the directory containing the plugin, which the caller must remove.
+func setupStubIPAM(t *testing.T, result string) string {
+	return setupStubIPAMPlugin(t, "stub-ipam", result)
+}
+
+// setupStubIPAMPlugin is setupStubIPAM for a stub IPAM plugin of the given type.
+func setupStubIPAMPlugin(t *testing.T, ipamType string, result string) string {
+	dir, err := ioutil.TempDir("", "ipam")
+	require.NoError(t, err)
+
+	script := []byte(fmt.Sprintf(stubIPAMPluginFormat, result))
+	err = ioutil.WriteFile(filepath.Join(dir, ipamType), script, 0755)
+	require.NoError(t, err)
+
+	os.Setenv("CNI_PATH", dir)
+	return dir
+}
+
+// getStubIPAMCommands returns the commands that the stub IPAM plugin was invoked with.
+func getStubIPAMCommands(t *testing.T, dir string) string {
+	commands, err := ioutil.ReadFile(filepath.Join(dir, "commands"))
+	require.NoError(t, err)
+	return string(commands)
+}
+
+// TestAddIPAM tests that the branch is configured with the addresses, gateways and routes
+// allocated by the IPAM plugin, and that they are released on DEL.
+func TestAddIPAM(t *testing.T) {
+	dir := setupStubIPAM(t, `{"cniVersion":"1.0.0",
+		"ips":[{"address":"10.11.12.13/16"}, {"address":"2600:1f13:a0d:a700::5/64", "gateway":"fe80::1"}],
+		"routes":[{"dst":"0.0.0.0/0", "gw":"10.11.0.1"}, {"dst":"10.12.0.0/16", "gw":"10.11.0.2"}],
+		"dns":{"nameservers":["10.11.0.2"]}}`)
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CNI_PATH")
+
+	args := &cniSkel.CmdArgs{StdinData: []byte(testIPAMNetConfig)}
+	nc := newTestNetConfig(t, testIPAMNetConfig)
+	err := addIPAM(context.TODO(), args, nc)
+	require.NoError(t, err)
+
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String())
+	require.Len(t, nc.BranchIPAddresses, 1)
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddresses[0].String())
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+	assert.Equal(t, "2600:1f13:a0d:a700::5/64", nc.BranchIPv6Address.String())
+	assert.Equal(t, "fe80::1", nc.BranchGatewayIPv6Address.String())
+	require.Len(t, nc.Routes, 1)
+	assert.Equal(t, "10.12.0.0/16", nc.Routes[0].Dst.String())
+	assert.Equal(t, "10.11.0.2", nc.Routes[0].GW.String())
+	assert.Equal(t, []string{"10.11.0.2"}, nc.DNS.Nameservers)
+
+	err = deleteIPAM(context.TODO(), args, nc)
+	require.NoError(t, err)
+	assert.Equal(t, "ADD\nDEL\n", getStubIPAMCommands(t, dir))
+}
+
+// TestAddIPAMNoAddresses tests that ADD fails if the IPAM plugin allocates no addresses.
+func TestAddIPAMNoAddresses(t *testing.T) {
+	dir := setupStubIPAM(t, `{"cniVersion":"1.0.0"}`)
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CNI_PATH")
+
+	args := &cniSkel.CmdArgs{StdinData: []byte(testIPAMNetConfig)}
+	err := addIPAM(context.TODO(), args, newTestNetConfig(t, testIPAMNetConfig))
+	require.Error(t, err)
+	assert.Equal(t, cni.ErrCodeIPAM, err.(*cniTypes.Error).Code)
+}
+
+// TestApplyPrevResult tests that the IP addresses allocated on ADD are recovered from its result.
+func TestApplyPrevResult(t *testing.T) {
+	nc := newTestNetConfig(t, `{"cniVersion":"1.0.0", "name":"test", "type":"vpc-branch-eni", "trunkName":"eth1",
+		"branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4", "interfaceType":"vlan",
+		"ipam":{"type":"stub-ipam"}, "prevResult":{"cniVersion":"1.0.0",
+		"ips":[{"address":"10.11.12.13/16", "gateway":"10.11.0.1", "interface":0}],
+		"routes":[{"dst":"0.0.0.0/0", "gw":"10.11.0.1"}]}}`)
+
+	err := applyPrevResult(nc)
+	require.NoError(t, err)
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String())
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+	assert.Empty(t, nc.Routes)
+}
+
+// TestDHCPLease tests that the DHCP lease is deferred until the branch link is set up in the
+// target netns, and released on DEL.
+func TestDHCPLease(t *testing.T) {
+	dir := setupStubIPAMPlugin(t, "dhcp", `{"cniVersion":"1.0.0",
+		"ips":[{"address":"10.11.12.13/16", "gateway":"10.11.0.1"}], "dns":{"nameservers":["10.11.0.2"]}}`)
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CNI_PATH")
+
+	netConfig := `{"cniVersion":"1.0.0", "name":"test", "type":"vpc-branch-eni", "trunkName":"eth1",
+		"branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4", "interfaceType":"vlan",
+		"ipam":{"type":"dhcp"}}`
+	args := &cniSkel.CmdArgs{
+		ContainerID: "container_1",
+		Netns:       "/var/run/netns/vpc-branch-eni-nonexistent",
+		IfName:      testIfName,
+		StdinData:   []byte(netConfig),
+	}
+	nc := newTestNetConfig(t, netConfig)
+	assert.True(t, isDHCP(nc))
+
+	// ADD fails before the branch link is set up, so no lease is acquired, but the lease is
+	// still released on failure.
+	plugin := &Plugin{}
+	assert.Error(t, plugin.Add(args))
+	assert.Equal(t, "DEL\n", getStubIPAMCommands(t, dir))
+
+	// The lease provides the branch addresses, gateway and DNS settings.
+	require.NoError(t, addIPAM(context.TODO(), args, nc))
+	assert.Equal(t, "10.11.12.13/16", nc.BranchIPAddress.String())
+	assert.Equal(t, "10.11.0.1", nc.BranchGatewayIPAddress.String())
+	assert.Equal(t, []string{"10.11.0.2"}, nc.DNS.Nameservers)
+
+	assert.NoError(t, plugin.Del(args))
+	assert.Equal(t, "DEL\nADD\nDEL\n", getStubIPAMCommands(t, dir))
+}
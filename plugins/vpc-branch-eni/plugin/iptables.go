@@ -0,0 +1,105 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"github.com/aws/amazon-vpc-cni-plugins/network/firewall"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// iptablesClient is the subset of the iptables API used to install rules in the target netns.
+type iptablesClient interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	Delete(table, chain string, rulespec ...string) error
+}
+
+// newIPTables creates an iptables client using the given firewall backend. It is a variable so
+// that it can be replaced in unit tests.
+var newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+	return firewall.New(backend, proto)
+}
+
+// iptablesRule is an iptables rule installed by this plugin with the given firewall backend.
+type iptablesRule struct {
+	backend  string
+	proto    iptables.Protocol
+	table    string
+	chain    string
+	rulespec []string
+}
+
+// addIPTablesRules appends the given iptables rules, in order, unless they already exist.
+func addIPTablesRules(rules []iptablesRule) error {
+	for _, rule := range rules {
+		log.Infof("Adding iptables rule: %s %s %v.", rule.table, rule.chain, rule.rulespec)
+		ipt, err := newIPTables(rule.backend, rule.proto)
+		if err != nil {
+			log.Errorf("Failed to create iptables client: %v.", err)
+			return err
+		}
+
+		err = ipt.AppendUnique(rule.table, rule.chain, rule.rulespec...)
+		if err != nil {
+			log.Errorf("Failed to add iptables rule: %v.", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteIPTablesRules deletes the given iptables rules that exist.
+func deleteIPTablesRules(rules []iptablesRule) error {
+	for _, rule := range rules {
+		ipt, err := newIPTables(rule.backend, rule.proto)
+		if err != nil {
+			log.Errorf("Failed to create iptables client: %v.", err)
+			return err
+		}
+
+		// DEL can be called multiple times and thus must be idempotent.
+		exists, err := ipt.Exists(rule.table, rule.chain, rule.rulespec...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		log.Infof("Deleting iptables rule: %s %s %v.", rule.table, rule.chain, rule.rulespec)
+		err = ipt.Delete(rule.table, rule.chain, rule.rulespec...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getIPTablesProtocols returns the iptables protocols of the configured IP address families.
+func getIPTablesProtocols(netConfig *config.NetConfig) []iptables.Protocol {
+	var protos []iptables.Protocol
+	if len(netConfig.BranchIPAddresses) != 0 {
+		protos = append(protos, iptables.ProtocolIPv4)
+	}
+	if netConfig.BranchIPv6Address != nil {
+		protos = append(protos, iptables.ProtocolIPv6)
+	}
+
+	return protos
+}
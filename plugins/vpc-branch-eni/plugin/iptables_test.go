@@ -0,0 +1,121 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/firewall"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockIPTables records the iptables rules requested for each protocol.
+type mockIPTables struct {
+	proto   iptables.Protocol
+	rules   *[]string
+	deleted *[]string
+}
+
+func (m *mockIPTables) format(table, chain string, rulespec []string) string {
+	return fmt.Sprintf("%d %s %s %s", m.proto, table, chain, strings.Join(rulespec, " "))
+}
+
+func (m *mockIPTables) AppendUnique(table, chain string, rulespec ...string) error {
+	*m.rules = append(*m.rules, m.format(table, chain, rulespec))
+	return nil
+}
+
+func (m *mockIPTables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	rule := m.format(table, chain, rulespec)
+	for _, r := range *m.rules {
+		if r == rule {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockIPTables) Delete(table, chain string, rulespec ...string) error {
+	*m.deleted = append(*m.deleted, m.format(table, chain, rulespec))
+	return nil
+}
+
+// mockIPTablesLayer replaces the iptables layer with a mock recording the requested rules.
+func mockIPTablesLayer() (rules *[]string, deleted *[]string) {
+	rules = &[]string{}
+	deleted = &[]string{}
+	newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+		return &mockIPTables{proto: proto, rules: rules, deleted: deleted}, nil
+	}
+	return rules, deleted
+}
+
+// restoreIPTablesLayer restores the real iptables layer.
+func restoreIPTablesLayer() {
+	newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+		return firewall.New(backend, proto)
+	}
+}
+
+// TestDeleteIPTablesRulesIdempotent tests that deleting missing iptables rules succeeds.
+func TestDeleteIPTablesRulesIdempotent(t *testing.T) {
+	_, deleted := mockIPTablesLayer()
+	defer restoreIPTablesLayer()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "mtu":9001, "tcpMSSClamp":true, "interfaceType":"vlan"}`)
+
+	err := deleteIPTablesRules(newMSSClampRules(nc))
+	assert.NoError(t, err)
+	assert.Empty(t, *deleted)
+}
+
+// TestIPTablesRulesFirewallBackend tests that iptables rules are installed with the configured
+// firewall backend.
+func TestIPTablesRulesFirewallBackend(t *testing.T) {
+	var backends []string
+	newIPTables = func(backend string, proto iptables.Protocol) (iptablesClient, error) {
+		backends = append(backends, backend)
+		return &mockIPTables{proto: proto, rules: &[]string{}, deleted: &[]string{}}, nil
+	}
+	defer restoreIPTablesLayer()
+
+	netConfigFmt := `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "mtu":9001, %s"tcpMSSClamp":true, "egressOnly":true,
+		"connmark":42, "interfaceType":"vlan"}`
+	for params, expected := range map[string]string{
+		``:                               firewall.BackendIPTables,
+		`"firewallBackend":"nftables", `: firewall.BackendNFTables,
+		`"firewallBackend":"iptables", `: firewall.BackendIPTables,
+	} {
+		backends = nil
+		nc := newTestNetConfig(t, fmt.Sprintf(netConfigFmt, params))
+		var rules []iptablesRule
+		rules = append(rules, newMSSClampRules(nc)...)
+		rules = append(rules, newEgressOnlyRules(nc)...)
+		rules = append(rules, newConnMarkRules(nc)...)
+
+		assert.NoError(t, addIPTablesRules(rules))
+		assert.Len(t, backends, len(rules))
+		for _, backend := range backends {
+			assert.Equal(t, expected, backend, params)
+		}
+	}
+}
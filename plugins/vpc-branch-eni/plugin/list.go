@@ -0,0 +1,191 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// ListCommand is the command line subcommand that lists the branch links.
+	ListCommand = "list"
+
+	// namedNetNSPathPattern matches the named netns created by "ip netns add".
+	namedNetNSPathPattern = "/var/run/netns/*"
+
+	// hostNetNSName is the name under which branch links in the host netns are listed.
+	hostNetNSName = "host"
+)
+
+// BranchInfo describes a branch link created by this plugin.
+type BranchInfo struct {
+	NetNS       string
+	Name        string
+	VlanID      int
+	MACAddress  string
+	IPAddresses []string
+	ContainerID string
+}
+
+// List writes a table of the branch links created by this plugin in the host netns and in the
+// given netns. If no netns are given, all named netns are searched.
+func List(w io.Writer, netnsPaths []string) error {
+	var branches []BranchInfo
+	err := forEachNetNS(netnsPaths, func(netnsName string) error {
+		nsBranches, err := listBranches(netnsName)
+		if err != nil {
+			return fmt.Errorf("failed to list branch links in netns %s: %v", netnsName, err)
+		}
+		branches = append(branches, nsBranches...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return printBranches(w, branches)
+}
+
+// forEachNetNS runs the given function in the host netns and then in each of the given netns,
+// passing it the name of the netns. If no netns are given, all named netns are used.
+func forEachNetNS(netnsPaths []string, toRun func(netnsName string) error) error {
+	if len(netnsPaths) == 0 {
+		netnsPaths, _ = filepath.Glob(namedNetNSPathPattern)
+	}
+
+	err := toRun(hostNetNSName)
+	if err != nil {
+		return err
+	}
+
+	for _, netnsPath := range netnsPaths {
+		ns, err := netns.GetNetNS(netnsPath)
+		if err != nil {
+			// Log and skip netns that disappeared in the meantime.
+			log.Errorf("Failed to find netns %s: %v.", netnsPath, err)
+			continue
+		}
+
+		netnsName := netnsPath
+		err = ns.Run(func() error {
+			return toRun(netnsName)
+		})
+		ns.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listBranches returns the branch links in the current netns, tagging them with the given netns name.
+// Branch links are VLAN links that are either tagged with the ID of the container that owns them,
+// or named after their trunk and VLAN ID.
+func listBranches(netnsName string) ([]BranchInfo, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	linkNames := make(map[int]string)
+	for _, link := range links {
+		linkNames[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	var branches []BranchInfo
+	for _, link := range links {
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok {
+			continue
+		}
+
+		containerID := getContainerIDFromAlias(vlan.Alias)
+		trunkName, trunkInNetNS := linkNames[vlan.ParentIndex]
+		isNamedBranch := trunkInNetNS && vlan.Name == fmt.Sprintf(branchLinkNameFormat, trunkName, vlan.VlanId)
+		if containerID == "" && !isNamedBranch {
+			continue
+		}
+
+		branch := BranchInfo{
+			NetNS:       netnsName,
+			Name:        vlan.Name,
+			VlanID:      vlan.VlanId,
+			MACAddress:  vlan.HardwareAddr.String(),
+			ContainerID: containerID,
+		}
+
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			// Skip the link-local addresses assigned by the kernel.
+			if addr.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			branch.IPAddresses = append(branch.IPAddresses, addr.IPNet.String())
+		}
+
+		branches = append(branches, branch)
+	}
+
+	return branches, nil
+}
+
+// getContainerIDFromAlias returns the container ID in a branch link alias, or an empty string if
+// the alias was not set by this plugin.
+func getContainerIDFromAlias(alias string) string {
+	if !strings.HasPrefix(alias, branchLinkAliasPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(alias, branchLinkAliasPrefix)
+}
+
+// printBranches writes a table of the given branch links ordered by netns and VLAN ID.
+func printBranches(w io.Writer, branches []BranchInfo) error {
+	sort.SliceStable(branches, func(i, j int) bool {
+		if branches[i].NetNS != branches[j].NetNS {
+			return branches[i].NetNS < branches[j].NetNS
+		}
+		return branches[i].VlanID < branches[j].VlanID
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NETNS\tNAME\tVLAN\tMAC\tIP\tCONTAINER")
+	for _, branch := range branches {
+		ipAddresses := strings.Join(branch.IPAddresses, ",")
+		if ipAddresses == "" {
+			ipAddresses = "-"
+		}
+		containerID := branch.ContainerID
+		if containerID == "" {
+			containerID = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			branch.NetNS, branch.Name, branch.VlanID, branch.MACAddress, ipAddresses, containerID)
+	}
+
+	return tw.Flush()
+}
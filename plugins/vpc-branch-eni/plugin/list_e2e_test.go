@@ -0,0 +1,104 @@
+// +build e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// TestListBranches tests that the branch links in a netns are listed with their owners.
+func TestListBranches(t *testing.T) {
+	targetNS, err := netns.NewNetNS("testListNS")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, targetNS.Close())
+	}()
+
+	err = targetNS.Run(func() error {
+		la := netlink.NewLinkAttrs()
+		la.Name = "trunk0"
+		trunk := &netlink.Dummy{LinkAttrs: la}
+		if err := netlink.LinkAdd(trunk); err != nil {
+			return err
+		}
+
+		// A branch link named after its trunk, as in TAP mode.
+		la = netlink.NewLinkAttrs()
+		la.Name = fmt.Sprintf(branchLinkNameFormat, "trunk0", 101)
+		la.ParentIndex = trunk.Index
+		la.HardwareAddr, _ = net.ParseMAC("02:23:45:67:89:ab")
+		if err := netlink.LinkAdd(&netlink.Vlan{LinkAttrs: la, VlanId: 101}); err != nil {
+			return err
+		}
+
+		// A renamed branch link tagged with its container, as in VLAN mode.
+		la = netlink.NewLinkAttrs()
+		la.Name = "eth0"
+		la.ParentIndex = trunk.Index
+		la.HardwareAddr, _ = net.ParseMAC("02:23:45:67:89:ac")
+		branch := &netlink.Vlan{LinkAttrs: la, VlanId: 102}
+		if err := netlink.LinkAdd(branch); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetAlias(branch, fmt.Sprintf(branchLinkAliasFormat, "container_1")); err != nil {
+			return err
+		}
+		addr, _ := netlink.ParseAddr("10.11.12.13/16")
+		return netlink.AddrAdd(branch, addr)
+	})
+	require.NoError(t, err)
+
+	var branches []BranchInfo
+	err = targetNS.Run(func() error {
+		var err error
+		branches, err = listBranches(targetNS.GetPath())
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, branches, 2)
+
+	byVlanID := map[int]BranchInfo{}
+	for _, branch := range branches {
+		byVlanID[branch.VlanID] = branch
+	}
+	assert.Equal(t, "trunk0.101", byVlanID[101].Name)
+	assert.Equal(t, "02:23:45:67:89:ab", byVlanID[101].MACAddress)
+	assert.Equal(t, "", byVlanID[101].ContainerID)
+	assert.Equal(t, "eth0", byVlanID[102].Name)
+	assert.Equal(t, "02:23:45:67:89:ac", byVlanID[102].MACAddress)
+	assert.Equal(t, []string{"10.11.12.13/16"}, byVlanID[102].IPAddresses)
+	assert.Equal(t, "container_1", byVlanID[102].ContainerID)
+
+	var buf bytes.Buffer
+	err = List(&buf, []string{targetNS.GetPath()})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "container_1")
+	assert.Contains(t, buf.String(), "trunk0.101")
+
+	// Listing must not delete the netns it visits.
+	_, err = os.Stat(targetNS.GetPath())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,66 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetContainerIDFromAlias tests that only aliases set by this plugin yield a container ID.
+func TestGetContainerIDFromAlias(t *testing.T) {
+	alias := fmt.Sprintf(branchLinkAliasFormat, "0123456789abcdef0123")
+	assert.Equal(t, "0123456789ab", getContainerIDFromAlias(alias))
+	assert.Equal(t, "", getContainerIDFromAlias("uplink"))
+	assert.Equal(t, "", getContainerIDFromAlias(""))
+}
+
+// TestPrintBranches tests that branch links are printed ordered by netns and VLAN ID.
+func TestPrintBranches(t *testing.T) {
+	branches := []BranchInfo{
+		{
+			NetNS:       "/var/run/netns/test",
+			Name:        "eth0",
+			VlanID:      102,
+			MACAddress:  "02:23:45:67:89:ac",
+			IPAddresses: []string{"10.11.12.14/16", "2600:1f13:a0d:a700::5/64"},
+			ContainerID: "0123456789ab",
+		},
+		{
+			NetNS:      "/var/run/netns/test",
+			Name:       "eth1.101",
+			VlanID:     101,
+			MACAddress: "02:23:45:67:89:ab",
+		},
+	}
+
+	var buf bytes.Buffer
+	err := printBranches(&buf, branches)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"NETNS", "NAME", "VLAN", "MAC", "IP", "CONTAINER"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"/var/run/netns/test", "eth1.101", "101", "02:23:45:67:89:ab", "-", "-"},
+		strings.Fields(lines[1]))
+	assert.Equal(t, []string{"/var/run/netns/test", "eth0", "102", "02:23:45:67:89:ac",
+		"10.11.12.14/16,2600:1f13:a0d:a700::5/64", "0123456789ab"}, strings.Fields(lines[2]))
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Name format of the lock file of a host link, keyed by link name.
+	linkLockFileFormat = "link.%s.lock"
+
+	// Name format of the lock file of a trunk, keyed by trunk name.
+	trunkLockFileFormat = "%s.lock"
+
+	// Permissions of the lock files and of the directory holding them.
+	lockFileMode = 0600
+	lockDirMode  = 0700
+
+	// Interval between attempts to acquire a lock held by another invocation.
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+// lockDir is the directory holding the host-level lock files. It is a variable so that it can
+// be replaced in unit tests.
+var lockDir = "/var/run/vpc-branch-eni"
+
+// hostLock is a host-level lock serializing the invocations that change the same resource, such
+// as a branch link. Lock files are left in place once released, as deleting them would let another
+// invocation lock a new file while a third still waits on the deleted one.
+type hostLock struct {
+	file *os.File
+}
+
+// lockLink acquires the lock of the host link with the given name, which is either a branch link
+// or an adopted interface. It waits for other invocations to release the lock until ctx is done,
+// and then returns a timeout error.
+func lockLink(ctx context.Context, linkName string) (*hostLock, error) {
+	return lockFile(ctx, fmt.Sprintf(linkLockFileFormat, linkName))
+}
+
+// lockTrunk acquires the lock of the given trunk, which guards the state of the trunk shared by
+// all of its branches. It is always acquired after the lock of a link, never before.
+func lockTrunk(ctx context.Context, trunkName string) (*hostLock, error) {
+	return lockFile(ctx, fmt.Sprintf(trunkLockFileFormat, trunkName))
+}
+
+// lockFile acquires the lock held on the given file in the lock directory.
+func lockFile(ctx context.Context, name string) (*hostLock, error) {
+	err := os.MkdirAll(lockDir, lockDirMode)
+	if err != nil {
+		log.Errorf("Failed to create lock directory %s: %v.", lockDir, err)
+		return nil, cni.NewError(cni.ErrCodeInternal, err)
+	}
+
+	path := filepath.Join(lockDir, name)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, lockFileMode)
+	if err != nil {
+		log.Errorf("Failed to open lock file %s: %v.", path, err)
+		return nil, cni.NewError(cni.ErrCodeInternal, err)
+	}
+
+	// Locks are held by open files rather than processes, so concurrent invocations in the same
+	// process are serialized too.
+	for {
+		err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			log.Debugf("Acquired lock %s.", path)
+			return &hostLock{file: file}, nil
+		}
+		if err != unix.EWOULDBLOCK {
+			file.Close()
+			log.Errorf("Failed to acquire lock %s: %v.", path, err)
+			return nil, cni.NewError(cni.ErrCodeInternal, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			log.Errorf("Timed out waiting for lock %s: %v.", path, ctx.Err())
+			return nil, cni.NewError(cni.ErrCodeTimeout,
+				fmt.Errorf("timed out waiting for lock %s held by another invocation", path))
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// unlock releases the lock.
+func (lock *hostLock) unlock() {
+	// Closing the file releases the lock.
+	err := lock.file.Close()
+	if err != nil {
+		log.Errorf("Failed to release lock %s: %v.", lock.file.Name(), err)
+	}
+}
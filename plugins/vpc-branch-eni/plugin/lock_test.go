@@ -0,0 +1,120 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockLockDir replaces the lock directory with a temporary one, and returns a function removing it.
+func mockLockDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "vpc-branch-eni-lock")
+	require.NoError(t, err)
+	realLockDir := lockDir
+	lockDir = filepath.Join(dir, "locks")
+	return func() {
+		lockDir = realLockDir
+		os.RemoveAll(dir)
+	}
+}
+
+// TestLockLinkSerializes tests that concurrent invocations setting up the same branch are
+// serialized, so that exactly one of them creates the branch link and the other finds it.
+func TestLockLinkSerializes(t *testing.T) {
+	defer mockLockDir(t)()
+
+	var linksLock sync.Mutex
+	links := make(map[string]bool)
+	created := 0
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := lockLink(context.TODO(), "eth1.101")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer lock.unlock()
+
+			// Check for the link, and create it after a delay letting the other invocation race.
+			linksLock.Lock()
+			exists := links["eth1.101"]
+			linksLock.Unlock()
+			if exists {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			linksLock.Lock()
+			links["eth1.101"] = true
+			created++
+			linksLock.Unlock()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, created)
+
+	info, err := os.Stat(filepath.Join(lockDir, "link.eth1.101.lock"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(lockFileMode), info.Mode().Perm())
+}
+
+// TestLockLinkTimeout tests that waiting for a lock held by another invocation times out, and
+// that locks of other branches are independent.
+func TestLockLinkTimeout(t *testing.T) {
+	defer mockLockDir(t)()
+
+	lock, err := lockLink(context.TODO(), "eth1.101")
+	require.NoError(t, err)
+
+	other, err := lockLink(context.TODO(), "eth1.102")
+	require.NoError(t, err)
+	other.unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = lockLink(ctx, "eth1.101")
+	require.Error(t, err)
+	cniErr, ok := err.(*cniTypes.Error)
+	require.True(t, ok)
+	assert.Equal(t, cni.ErrCodeTimeout, cniErr.Code)
+
+	// The lock can be acquired again once released.
+	lock.unlock()
+	lock, err = lockLink(context.TODO(), "eth1.101")
+	require.NoError(t, err)
+	lock.unlock()
+}
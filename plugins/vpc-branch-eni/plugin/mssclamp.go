@@ -0,0 +1,68 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"strconv"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+const (
+	// Table of iptables rules clamping the TCP MSS.
+	mssClampTable = "mangle"
+
+	// Sizes of the IP and TCP headers subtracted from the MTU to compute the TCP MSS.
+	ipv4TCPHeaderLength = 40
+	ipv6TCPHeaderLength = 60
+)
+
+// getTCPMSS returns the TCP MSS for the given MTU and IP address family.
+func getTCPMSS(mtu int, ipv6 bool) int {
+	if ipv6 {
+		return mtu - ipv6TCPHeaderLength
+	}
+	return mtu - ipv4TCPHeaderLength
+}
+
+// newMSSClampRules returns the iptables rules that clamp the MSS of the TCP connections on the
+// branch interface to its MTU, for each configured IP address family. Both the SYNs sent and
+// received are clamped, so that the MSS is limited in both directions.
+func newMSSClampRules(netConfig *config.NetConfig) []iptablesRule {
+	var rules []iptablesRule
+	for _, proto := range getIPTablesProtocols(netConfig) {
+		mss := strconv.Itoa(getTCPMSS(netConfig.MTU, proto == iptables.ProtocolIPv6))
+		rules = append(rules,
+			iptablesRule{
+				backend: netConfig.FirewallBackend,
+				proto:   proto,
+				table:   mssClampTable,
+				chain:   "POSTROUTING",
+				rulespec: []string{"-o", netConfig.InterfaceName, "-p", "tcp",
+					"--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--set-mss", mss},
+			},
+			iptablesRule{
+				backend: netConfig.FirewallBackend,
+				proto:   proto,
+				table:   mssClampTable,
+				chain:   "PREROUTING",
+				rulespec: []string{"-i", netConfig.InterfaceName, "-p", "tcp",
+					"--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--set-mss", mss},
+			})
+	}
+
+	return rules
+}
@@ -0,0 +1,48 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMSSClampRules tests that the TCP MSS clamping rules are requested with the MSS computed
+// from the MTU for each address family, and deleted on DEL.
+func TestMSSClampRules(t *testing.T) {
+	rules, deleted := mockIPTablesLayer()
+	defer restoreIPTablesLayer()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"branchIPAddress":"10.11.12.13/16", "branchIPv6Address":"2600:1f13:a0d:a700::5/64",
+		"mtu":1500, "tcpMSSClamp":true, "interfaceName":"eth0", "interfaceType":"vlan"}`)
+
+	err := addIPTablesRules(newMSSClampRules(nc))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d mangle POSTROUTING -o eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1460", iptables.ProtocolIPv4),
+		fmt.Sprintf("%d mangle PREROUTING -i eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1460", iptables.ProtocolIPv4),
+		fmt.Sprintf("%d mangle POSTROUTING -o eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1440", iptables.ProtocolIPv6),
+		fmt.Sprintf("%d mangle PREROUTING -i eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1440", iptables.ProtocolIPv6),
+	}, *rules)
+
+	err = deleteIPTablesRules(newMSSClampRules(nc))
+	assert.NoError(t, err)
+	assert.Equal(t, *rules, *deleted)
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"sort"
+	"unsafe"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Legacy ethtool commands that set a single offload feature, from linux/ethtool.h.
+	ethtoolSRXCSUM = 0x15
+	ethtoolSTXCSUM = 0x17
+	ethtoolSSG     = 0x19
+	ethtoolSTSO    = 0x1f
+	ethtoolSGSO    = 0x24
+	ethtoolSGRO    = 0x2c
+)
+
+// offloadSetCommands maps offload feature names to the ethtool commands that set them.
+var offloadSetCommands = map[string]uint32{
+	config.OffloadRXChecksum: ethtoolSRXCSUM,
+	config.OffloadTXChecksum: ethtoolSTXCSUM,
+	config.OffloadSG:         ethtoolSSG,
+	config.OffloadTSO:        ethtoolSTSO,
+	config.OffloadGSO:        ethtoolSGSO,
+	config.OffloadGRO:        ethtoolSGRO,
+}
+
+// ethtoolValue is the struct ethtool_value passed with single-value ethtool commands.
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+// ethtoolIfreq is the struct ifreq passed to the SIOCETHTOOL ioctl, padded to the size of the
+// kernel's union of request fields.
+type ethtoolIfreq struct {
+	name [unix.IFNAMSIZ]byte
+	data uintptr
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+// ethtoolSet issues the given single-value ethtool command on the given link. It is a variable
+// so that it can be replaced in unit tests.
+var ethtoolSet = func(linkName string, value *ethtoolValue) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var ifr ethtoolIfreq
+	copy(ifr.name[:unix.IFNAMSIZ-1], linkName)
+	ifr.data = uintptr(unsafe.Pointer(value))
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setOffloads enables or disables the given offload features on the given link, in name order.
+func setOffloads(linkName string, offloads map[string]bool) error {
+	var names []string
+	for name := range offloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := &ethtoolValue{cmd: offloadSetCommands[name]}
+		if offloads[name] {
+			value.data = 1
+		}
+
+		log.Infof("Setting offload %s of link %s to %t.", name, linkName, offloads[name])
+		err := ethtoolSet(linkName, value)
+		if err != nil {
+			log.Errorf("Failed to set offload %s of link %s: %v.", name, linkName, err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// TestSetOffloads tests the ethtool requests that toggle offload features on the branch link.
+func TestSetOffloads(t *testing.T) {
+	var linkNames []string
+	var values []ethtoolValue
+	realEthtoolSet := ethtoolSet
+	ethtoolSet = func(linkName string, value *ethtoolValue) error {
+		linkNames = append(linkNames, linkName)
+		values = append(values, *value)
+		return nil
+	}
+	defer func() { ethtoolSet = realEthtoolSet }()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"offloads":{"tso":false, "gro":true, "gso":false}, "interfaceType":"vlan"}`)
+	err := setOffloads("eth1.100", nc.Offloads)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"eth1.100", "eth1.100", "eth1.100"}, linkNames)
+	assert.Equal(t, []ethtoolValue{
+		{cmd: ethtoolSGRO, data: 1},
+		{cmd: ethtoolSGSO, data: 0},
+		{cmd: ethtoolSTSO, data: 0},
+	}, values)
+
+	// Failures are returned.
+	ethtoolSet = func(linkName string, value *ethtoolValue) error {
+		return unix.EOPNOTSUPP
+	}
+	assert.Error(t, setOffloads("eth1.100", nc.Offloads))
+
+	// Nothing is requested without offloads.
+	assert.NoError(t, setOffloads("eth1.100", nil))
+}
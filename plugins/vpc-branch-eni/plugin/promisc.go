@@ -0,0 +1,218 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// Name format of the file recording the branches that need promiscuous mode on a trunk.
+	trunkPromiscuousFileFormat = "%s.promisc"
+)
+
+// Promiscuous mode operations. They are variables so that they can be replaced in unit tests.
+var (
+	linkSetPromiscOn  = netlink.SetPromiscOn
+	linkSetPromiscOff = netlink.SetPromiscOff
+)
+
+// trunkPromiscuousState records the branches that need promiscuous mode on a trunk, along with the
+// original mode of the trunk. It is kept in the lock directory, which does not survive a reboot,
+// as the mode of the trunk does not either.
+type trunkPromiscuousState struct {
+	WasPromiscuous bool     `json:"wasPromiscuous"`
+	Branches       []string `json:"branches"`
+}
+
+// getTrunkPromiscuousKey returns the key identifying a branch in the trunk promiscuous state.
+// It is known to both ADD and DEL, even when the runtime passes no netns to DEL.
+func getTrunkPromiscuousKey(containerID string, netConfig *config.NetConfig) string {
+	return fmt.Sprintf("%s:%s", containerID, netConfig.InterfaceName)
+}
+
+// enableTrunkPromiscuous enables promiscuous mode on the given trunk link, so that it receives
+// frames addressed to branch MAC addresses it does not own. The branch is recorded in the trunk
+// promiscuous state, along with the original mode of the trunk if it is the first branch.
+func enableTrunkPromiscuous(ctx context.Context, trunkName string, branchKey string) error {
+	lock, err := lockTrunk(ctx, trunkName)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	link, err := linkByName(trunkName)
+	if err != nil {
+		log.Errorf("Failed to find trunk link %s: %v.", trunkName, err)
+		return err
+	}
+
+	state, err := readTrunkPromiscuousState(trunkName)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &trunkPromiscuousState{WasPromiscuous: link.Attrs().Promisc != 0}
+	}
+	if !state.hasBranch(branchKey) {
+		state.Branches = append(state.Branches, branchKey)
+	}
+
+	// Record the branch before changing the mode, so that a failure is rolled back by DEL.
+	err = writeTrunkPromiscuousState(trunkName, state)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Enabling promiscuous mode on trunk link %s for %d branches.", trunkName, len(state.Branches))
+	err = linkSetPromiscOn(link)
+	if err != nil {
+		log.Errorf("Failed to enable promiscuous mode on trunk link %s: %v.", trunkName, err)
+		return err
+	}
+
+	return nil
+}
+
+// restoreTrunkPromiscuous removes the branch from the trunk promiscuous state, and restores the
+// original promiscuous mode of the given trunk link once no other branch needs it. Trunks that
+// were already promiscuous are left untouched.
+func restoreTrunkPromiscuous(ctx context.Context, trunkName string, branchKey string) error {
+	lock, err := lockTrunk(ctx, trunkName)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	state, err := readTrunkPromiscuousState(trunkName)
+	if err != nil {
+		return err
+	}
+	if state == nil || !state.hasBranch(branchKey) {
+		log.Infof("Branch %s did not enable promiscuous mode on trunk link %s.", branchKey, trunkName)
+		return nil
+	}
+
+	state.removeBranch(branchKey)
+	if len(state.Branches) > 0 {
+		log.Infof("Keeping promiscuous mode on trunk link %s for %d branches.", trunkName, len(state.Branches))
+		return writeTrunkPromiscuousState(trunkName, state)
+	}
+
+	if !state.WasPromiscuous {
+		link, err := linkByName(trunkName)
+		if err != nil {
+			log.Errorf("Failed to find trunk link %s: %v.", trunkName, err)
+			return err
+		}
+
+		log.Infof("Disabling promiscuous mode on trunk link %s.", trunkName)
+		err = linkSetPromiscOff(link)
+		if err != nil {
+			log.Errorf("Failed to disable promiscuous mode on trunk link %s: %v.", trunkName, err)
+			return err
+		}
+	}
+
+	return deleteTrunkPromiscuousState(trunkName)
+}
+
+// hasBranch returns whether the given branch is recorded in the state.
+func (state *trunkPromiscuousState) hasBranch(branchKey string) bool {
+	for _, key := range state.Branches {
+		if key == branchKey {
+			return true
+		}
+	}
+	return false
+}
+
+// removeBranch removes the given branch from the state.
+func (state *trunkPromiscuousState) removeBranch(branchKey string) {
+	var branches []string
+	for _, key := range state.Branches {
+		if key != branchKey {
+			branches = append(branches, key)
+		}
+	}
+	state.Branches = branches
+}
+
+// getTrunkPromiscuousStatePath returns the path to the promiscuous state of the given trunk.
+func getTrunkPromiscuousStatePath(trunkName string) string {
+	return filepath.Join(lockDir, fmt.Sprintf(trunkPromiscuousFileFormat, trunkName))
+}
+
+// readTrunkPromiscuousState reads the promiscuous state of the given trunk. A missing file is not
+// an error, and results in a nil state.
+func readTrunkPromiscuousState(trunkName string) (*trunkPromiscuousState, error) {
+	path := getTrunkPromiscuousStatePath(trunkName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		log.Errorf("Failed to read trunk promiscuous state %s: %v.", path, err)
+		return nil, err
+	}
+
+	var state trunkPromiscuousState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		log.Errorf("Failed to parse trunk promiscuous state %s: %v.", path, err)
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// writeTrunkPromiscuousState writes the promiscuous state of the given trunk. The caller holds
+// the lock of the trunk, which lives in the same directory.
+func writeTrunkPromiscuousState(trunkName string, state *trunkPromiscuousState) error {
+	path := getTrunkPromiscuousStatePath(trunkName)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(path, data, lockFileMode)
+	if err != nil {
+		log.Errorf("Failed to write trunk promiscuous state %s: %v.", path, err)
+		return err
+	}
+
+	return nil
+}
+
+// deleteTrunkPromiscuousState deletes the promiscuous state of the given trunk.
+func deleteTrunkPromiscuousState(trunkName string) error {
+	path := getTrunkPromiscuousStatePath(trunkName)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to delete trunk promiscuous state %s: %v.", path, err)
+		return err
+	}
+
+	return nil
+}
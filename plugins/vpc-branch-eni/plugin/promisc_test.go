@@ -0,0 +1,117 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+// TestTrunkPromiscuous tests that promiscuous mode is enabled on the trunk on ADD, and that the
+// original mode is restored only when the last branch that needs promiscuous mode is deleted.
+func TestTrunkPromiscuous(t *testing.T) {
+	defer mockLockDir(t)()
+
+	var promiscOn, promiscOff []string
+	linkSetPromiscOn = func(link netlink.Link) error {
+		promiscOn = append(promiscOn, link.Attrs().Name)
+		return nil
+	}
+	linkSetPromiscOff = func(link netlink.Link) error {
+		promiscOff = append(promiscOff, link.Attrs().Name)
+		return nil
+	}
+	defer func() {
+		linkSetPromiscOn = netlink.SetPromiscOn
+		linkSetPromiscOff = netlink.SetPromiscOff
+	}()
+
+	// The loopback link stands in for the trunk, and is not promiscuous.
+	lo, err := netlink.LinkByName(loopbackLinkName)
+	require.NoError(t, err)
+	require.Zero(t, lo.Attrs().Promisc)
+
+	ctx := context.Background()
+
+	// Two branches on the same trunk enable promiscuous mode, and the original mode is recorded.
+	require.NoError(t, enableTrunkPromiscuous(ctx, loopbackLinkName, "container_a:eth1"))
+	require.NoError(t, enableTrunkPromiscuous(ctx, loopbackLinkName, "container_b:eth1"))
+	assert.Equal(t, []string{loopbackLinkName, loopbackLinkName}, promiscOn)
+	state, err := readTrunkPromiscuousState(loopbackLinkName)
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.False(t, state.WasPromiscuous)
+	assert.Equal(t, []string{"container_a:eth1", "container_b:eth1"}, state.Branches)
+
+	// A repeated ADD does not record the branch twice.
+	require.NoError(t, enableTrunkPromiscuous(ctx, loopbackLinkName, "container_a:eth1"))
+	state, err = readTrunkPromiscuousState(loopbackLinkName)
+	require.NoError(t, err)
+	assert.Len(t, state.Branches, 2)
+
+	// Deleting the first branch keeps the trunk promiscuous for the second one.
+	require.NoError(t, restoreTrunkPromiscuous(ctx, loopbackLinkName, "container_a:eth1"))
+	assert.Empty(t, promiscOff)
+	state, err = readTrunkPromiscuousState(loopbackLinkName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"container_b:eth1"}, state.Branches)
+
+	// A repeated DEL, or one for a branch that did not enable promiscuous mode, changes nothing.
+	require.NoError(t, restoreTrunkPromiscuous(ctx, loopbackLinkName, "container_a:eth1"))
+	require.NoError(t, restoreTrunkPromiscuous(ctx, loopbackLinkName, "container_c:eth1"))
+	assert.Empty(t, promiscOff)
+
+	// Deleting the last branch restores the original mode and deletes the state.
+	require.NoError(t, restoreTrunkPromiscuous(ctx, loopbackLinkName, "container_b:eth1"))
+	assert.Equal(t, []string{loopbackLinkName}, promiscOff)
+	state, err = readTrunkPromiscuousState(loopbackLinkName)
+	require.NoError(t, err)
+	assert.Nil(t, state)
+
+	// Trunks that were already promiscuous are left untouched.
+	promiscOff = nil
+	linkByName = func(name string) (netlink.Link, error) {
+		la := netlink.NewLinkAttrs()
+		la.Name = name
+		la.Promisc = 1
+		return &netlink.Device{LinkAttrs: la}, nil
+	}
+	defer func() {
+		linkByName = netlink.LinkByName
+	}()
+	require.NoError(t, enableTrunkPromiscuous(ctx, "eth1", "container_a:eth1"))
+	require.NoError(t, restoreTrunkPromiscuous(ctx, "eth1", "container_a:eth1"))
+	assert.Empty(t, promiscOff)
+	linkByName = netlink.LinkByName
+
+	// The trunk link must exist.
+	assert.Error(t, enableTrunkPromiscuous(ctx, "nonexistent0", "container_a:eth1"))
+}
+
+// TestGetTrunkPromiscuousKey tests that a branch is keyed by its container and interface name,
+// which DEL knows even when the runtime passes no netns.
+func TestGetTrunkPromiscuousKey(t *testing.T) {
+	netConfig := &config.NetConfig{InterfaceName: "eth1"}
+	assert.Equal(t, "container_a:eth1", getTrunkPromiscuousKey("container_a", netConfig))
+	assert.NotEqual(t, getTrunkPromiscuousKey("container_a", netConfig),
+		getTrunkPromiscuousKey("container_b", netConfig))
+}
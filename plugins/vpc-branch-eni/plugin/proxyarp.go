@@ -0,0 +1,110 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// Paths of the sysctls that enable proxy ARP and proxy NDP on an interface.
+	proxyARPSysctlFormat = "/proc/sys/net/ipv4/conf/%s/proxy_arp"
+	proxyNDPSysctlFormat = "/proc/sys/net/ipv6/conf/%s/proxy_ndp"
+
+	// Sysctl value that enables a feature.
+	sysctlEnabled = "1"
+)
+
+// Neighbor operations. They are variables so that they can be replaced in unit tests.
+var (
+	neighSet = netlink.NeighSet
+	neighDel = netlink.NeighDel
+)
+
+// enableProxyARP enables proxy ARP, and proxy NDP for IPv6 branches, on the trunk link and
+// installs proxy neighbor entries for the branch gateways.
+func enableProxyARP(trunkName string, trunkIndex int, netConfig *config.NetConfig) error {
+	if gateways := getIPv4Gateways(netConfig); len(gateways) != 0 {
+		log.Infof("Enabling proxy ARP for gateways %v on trunk %s.", gateways, trunkName)
+		err := writeSysctl(fmt.Sprintf(proxyARPSysctlFormat, trunkName), sysctlEnabled)
+		if err != nil {
+			log.Errorf("Failed to enable proxy ARP on trunk %s: %v.", trunkName, err)
+			return err
+		}
+
+		for _, gateway := range gateways {
+			err = neighSet(newProxyNeigh(trunkIndex, gateway))
+			if err != nil {
+				log.Errorf("Failed to add proxy neighbor entry for gateway %s: %v.", gateway, err)
+				return err
+			}
+		}
+	}
+
+	if netConfig.BranchGatewayIPv6Address != nil {
+		log.Infof("Enabling proxy NDP for gateway %s on trunk %s.", netConfig.BranchGatewayIPv6Address, trunkName)
+		err := writeSysctl(fmt.Sprintf(proxyNDPSysctlFormat, trunkName), sysctlEnabled)
+		if err != nil {
+			log.Errorf("Failed to enable proxy NDP on trunk %s: %v.", trunkName, err)
+			return err
+		}
+
+		err = neighSet(newProxyNeigh(trunkIndex, netConfig.BranchGatewayIPv6Address))
+		if err != nil {
+			log.Errorf("Failed to add proxy neighbor entry for gateway %s: %v.",
+				netConfig.BranchGatewayIPv6Address, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disableProxyARP deletes the proxy neighbor entries for the branch gateways from the trunk link.
+// The proxy ARP and proxy NDP sysctls are left enabled, as they are shared by all branches on
+// the trunk.
+func disableProxyARP(trunkIndex int, netConfig *config.NetConfig) error {
+	for _, gateway := range getBranchGateways(netConfig) {
+		log.Infof("Deleting proxy neighbor entry for gateway %s.", gateway)
+		err := neighDel(newProxyNeigh(trunkIndex, gateway))
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to delete proxy neighbor entry for gateway %s: %v.", gateway, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newProxyNeigh returns a proxy neighbor entry for the given IP address on the given link.
+func newProxyNeigh(linkIndex int, ipAddress net.IP) *netlink.Neigh {
+	family := netlink.FAMILY_V4
+	if ipAddress.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	return &netlink.Neigh{
+		LinkIndex: linkIndex,
+		Family:    family,
+		Flags:     netlink.NTF_PROXY,
+		IP:        ipAddress,
+	}
+}
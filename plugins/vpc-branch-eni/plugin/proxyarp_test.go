@@ -0,0 +1,124 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// proxyARPOps records the sysctl and neighbor operations requested by the plugin.
+type proxyARPOps struct {
+	sysctls     map[string]string
+	addedNeighs []*netlink.Neigh
+	deletedIPs  []string
+}
+
+// mockProxyARPOps replaces the sysctl and neighbor operations with ones recording the requests.
+// Neighbor deletions fail with the given error.
+func mockProxyARPOps(neighDelErr error) *proxyARPOps {
+	ops := &proxyARPOps{sysctls: make(map[string]string)}
+	writeSysctl = func(path string, value string) error {
+		ops.sysctls[path] = value
+		return nil
+	}
+	neighSet = func(neigh *netlink.Neigh) error {
+		ops.addedNeighs = append(ops.addedNeighs, neigh)
+		return nil
+	}
+	neighDel = func(neigh *netlink.Neigh) error {
+		ops.deletedIPs = append(ops.deletedIPs, neigh.IP.String())
+		return neighDelErr
+	}
+	return ops
+}
+
+// restoreProxyARPOps restores the real sysctl and neighbor operations.
+func restoreProxyARPOps() {
+	writeSysctl = realWriteSysctl
+	neighSet = netlink.NeighSet
+	neighDel = netlink.NeighDel
+}
+
+// TestEnableProxyARP tests the sysctls and proxy neighbor entries requested for IPv4 and IPv6 gateways.
+func TestEnableProxyARP(t *testing.T) {
+	ops := mockProxyARPOps(nil)
+	defer restoreProxyARPOps()
+
+	nc := newTestNetConfig(t, `{"cniVersion":"0.3.1", "trunkName":"eth1", "branchVlanID":"101",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20",
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "proxyARP":true, "interfaceType":"vlan"}`)
+
+	err := enableProxyARP("eth1", 3, nc)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"/proc/sys/net/ipv4/conf/eth1/proxy_arp": "1",
+		"/proc/sys/net/ipv6/conf/eth1/proxy_ndp": "1",
+	}, ops.sysctls)
+
+	require.Len(t, ops.addedNeighs, 2)
+	assert.Equal(t, &netlink.Neigh{
+		LinkIndex: 3,
+		Family:    netlink.FAMILY_V4,
+		Flags:     netlink.NTF_PROXY,
+		IP:        net.ParseIP("172.31.16.1"),
+	}, ops.addedNeighs[0])
+	assert.Equal(t, &netlink.Neigh{
+		LinkIndex: 3,
+		Family:    netlink.FAMILY_V6,
+		Flags:     netlink.NTF_PROXY,
+		IP:        net.ParseIP("2600:1f13:a0d:a700::1"),
+	}, ops.addedNeighs[1])
+}
+
+// TestEnableProxyARPIPv4Only tests that proxy NDP is not enabled for IPv4-only branches.
+func TestEnableProxyARPIPv4Only(t *testing.T) {
+	ops := mockProxyARPOps(nil)
+	defer restoreProxyARPOps()
+
+	nc := newTestNetConfig(t, `{"cniVersion":"0.3.1", "trunkName":"eth1", "branchVlanID":"101",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20",
+		"proxyARP":true, "interfaceType":"vlan"}`)
+
+	err := enableProxyARP("eth1", 3, nc)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"/proc/sys/net/ipv4/conf/eth1/proxy_arp": "1"}, ops.sysctls)
+	require.Len(t, ops.addedNeighs, 1)
+	assert.Equal(t, "172.31.16.1", ops.addedNeighs[0].IP.String())
+}
+
+// TestDisableProxyARP tests that proxy neighbor entries are deleted, ignoring missing ones.
+func TestDisableProxyARP(t *testing.T) {
+	ops := mockProxyARPOps(unix.ENOENT)
+	defer restoreProxyARPOps()
+
+	nc := newTestNetConfig(t, `{"cniVersion":"0.3.1", "trunkName":"eth1", "branchVlanID":"101",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20",
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "proxyARP":true, "interfaceType":"vlan"}`)
+
+	err := disableProxyARP(3, nc)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"172.31.16.1", "2600:1f13:a0d:a700::1"}, ops.deletedIPs)
+	assert.Empty(t, ops.sysctls)
+}
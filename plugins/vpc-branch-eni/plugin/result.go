@@ -0,0 +1,143 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
+)
+
+var (
+	// Default route destinations for each IP address family.
+	defaultIPv4RouteDst = net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 8*net.IPv4len)}
+	defaultIPv6RouteDst = net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)}
+)
+
+// newResult generates the CNI result for a branch interface configured in the given netns.
+func newResult(ifName string, netnsPath string, netConfig *config.NetConfig) *cniTypesCurrent.Result {
+	result := &cniTypesCurrent.Result{
+		Interfaces: []*cniTypesCurrent.Interface{
+			{
+				Name:    ifName,
+				Mac:     getInterfaceMACAddress(netConfig).String(),
+				Sandbox: netnsPath,
+			},
+		},
+		DNS: netConfig.DNS,
+	}
+
+	// All IP addresses are assigned to the branch interface, which is the first interface in
+	// the result. Gateways are reported even if no default routes are installed via them.
+	ifIndex := 0
+
+	for _, ipAddress := range netConfig.BranchIPAddresses {
+		result.IPs = append(result.IPs, &cniTypesCurrent.IPConfig{
+			Interface: &ifIndex,
+			Address:   ipAddress,
+			Gateway:   netConfig.BranchGatewayIPAddress,
+		})
+	}
+
+	if netConfig.BranchIPv6Address != nil {
+		result.IPs = append(result.IPs, &cniTypesCurrent.IPConfig{
+			Interface: &ifIndex,
+			Address:   *netConfig.BranchIPv6Address,
+			Gateway:   netConfig.BranchGatewayIPv6Address,
+		})
+	}
+
+	// Externally managed TAP links are connected by the VMM to the bridge in the target netns.
+	if isExternalTAP(netConfig) {
+		result.Interfaces = append(result.Interfaces, &cniTypesCurrent.Interface{
+			Name:    fmt.Sprintf(bridgeNameFormat, netConfig.BranchVlanID),
+			Sandbox: netnsPath,
+		})
+	}
+
+	// Only the default routes actually installed are reported, followed by the static routes.
+	for _, route := range getDefaultRoutes(netConfig) {
+		result.Routes = append(result.Routes, &cniTypes.Route{Dst: route.Dst, GW: route.GW})
+	}
+
+	for _, route := range netConfig.Routes {
+		result.Routes = append(result.Routes, &cniTypes.Route{Dst: route.Dst, GW: route.GW})
+	}
+
+	// Record the trunk interface in use when failover between multiple trunks is configured,
+	// so that DEL can target the same trunk.
+	if len(netConfig.TrunkNames)+len(netConfig.TrunkMACAddresses) > 1 {
+		trunk := &cniTypesCurrent.Interface{Name: netConfig.TrunkName}
+		if netConfig.TrunkMACAddress != nil {
+			trunk.Mac = netConfig.TrunkMACAddress.String()
+		}
+		result.Interfaces = append(result.Interfaces, trunk)
+	}
+
+	// Record the original name of an adopted interface, so that DEL can restore it.
+	if isAdoptedInterface(netConfig) {
+		adopted := &cniTypesCurrent.Interface{Name: netConfig.AdoptInterfaceName}
+		if netConfig.AdoptInterfaceMAC != nil {
+			adopted.Mac = netConfig.AdoptInterfaceMAC.String()
+		}
+		result.Interfaces = append(result.Interfaces, adopted)
+	}
+
+	return result
+}
+
+// getDefaultRoutes returns the default routes installed via the branch link, one for each
+// configured address family with a gateway, unless default route installation is disabled.
+func getDefaultRoutes(netConfig *config.NetConfig) []cniTypes.Route {
+	if !netConfig.InstallDefaultRoute {
+		return nil
+	}
+
+	var routes []cniTypes.Route
+	if len(netConfig.BranchIPAddresses) != 0 && netConfig.BranchGatewayIPAddress != nil {
+		routes = append(routes, cniTypes.Route{Dst: defaultIPv4RouteDst, GW: netConfig.BranchGatewayIPAddress})
+	}
+	if netConfig.BranchIPv6Address != nil && netConfig.BranchGatewayIPv6Address != nil {
+		routes = append(routes, cniTypes.Route{Dst: defaultIPv6RouteDst, GW: netConfig.BranchGatewayIPv6Address})
+	}
+
+	return routes
+}
+
+// getTrunkNameFromResult returns the name of the trunk interface recorded in the given result,
+// which is the only host interface in it. It returns an empty string if there is none.
+func getTrunkNameFromResult(prevResult cniTypes.Result) string {
+	if prevResult == nil {
+		return ""
+	}
+
+	result, err := cniTypesCurrent.GetResult(prevResult)
+	if err != nil {
+		log.Errorf("Failed to parse previous result: %v.", err)
+		return ""
+	}
+
+	for _, iface := range result.Interfaces {
+		if iface.Sandbox == "" {
+			return iface.Name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,205 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
+	cniTypesCreate "github.com/containernetworking/cni/pkg/types/create"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIfName    = "eth0"
+	testNetnsPath = "/var/run/netns/test"
+)
+
+// newTestNetConfig parses a NetConfig for tests.
+func newTestNetConfig(t *testing.T, netConfig string) *config.NetConfig {
+	nc, err := config.New(&cniSkel.CmdArgs{StdinData: []byte(netConfig)})
+	require.NoError(t, err)
+	return nc
+}
+
+// TestResultInterfaceIPLinkage tests that the printed CNI result links IPs to the branch interface.
+func TestResultInterfaceIPLinkage(t *testing.T) {
+	nc := newTestNetConfig(t, `{"cniVersion":"0.3.1", "trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddresses":["172.31.19.6/20", "172.31.19.7/20"],
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "interfaceType":"vlan"}`)
+
+	result := newResult(testIfName, testNetnsPath, nc)
+
+	// Round-trip the result through its serialized form as a CNI runtime would see it.
+	versionedResult, err := result.GetAsVersion(nc.CNIVersion)
+	require.NoError(t, err)
+	data, err := json.Marshal(versionedResult)
+	require.NoError(t, err)
+	parsedResult, err := cniTypesCreate.Create(nc.CNIVersion, data)
+	require.NoError(t, err)
+	r, err := cniTypesCurrent.GetResult(parsedResult)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(r.Interfaces))
+	assert.Equal(t, testIfName, r.Interfaces[0].Name)
+	assert.Equal(t, "02:e1:48:75:86:a4", r.Interfaces[0].Mac)
+	assert.Equal(t, testNetnsPath, r.Interfaces[0].Sandbox)
+
+	require.Equal(t, 3, len(r.IPs))
+	assert.Equal(t, "172.31.19.6/20", r.IPs[0].Address.String())
+	assert.Equal(t, "172.31.19.7/20", r.IPs[1].Address.String())
+	assert.Equal(t, "2600:1f13:a0d:a700::5/64", r.IPs[2].Address.String())
+	assert.Nil(t, r.IPs[2].Address.IP.To4())
+	for _, ip := range r.IPs {
+		require.NotNil(t, ip.Interface)
+		assert.Equal(t, 0, *ip.Interface)
+	}
+	assert.Equal(t, "172.31.16.1", r.IPs[0].Gateway.String())
+	assert.Equal(t, "2600:1f13:a0d:a700::1", r.IPs[2].Gateway.String())
+
+	require.Equal(t, 2, len(r.Routes))
+	assert.Equal(t, "0.0.0.0/0", r.Routes[0].Dst.String())
+	assert.Equal(t, "172.31.16.1", r.Routes[0].GW.String())
+	assert.Equal(t, "::/0", r.Routes[1].Dst.String())
+	assert.Equal(t, "2600:1f13:a0d:a700::1", r.Routes[1].GW.String())
+}
+
+// TestResultWithoutIPAddresses tests that the result contains only the interface if no IP
+// addresses are configured.
+func TestResultWithoutIPAddresses(t *testing.T) {
+	nc := newTestNetConfig(t, `{"cniVersion":"0.3.1", "trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "uid":"42", "gid":"42"}`)
+
+	result := newResult(testIfName, testNetnsPath, nc)
+
+	assert.Equal(t, 1, len(result.Interfaces))
+	assert.Empty(t, result.IPs)
+	assert.Empty(t, result.Routes)
+}
+
+// TestResultSpecVersions tests that the result is marshaled in the schema of the requested spec version.
+func TestResultSpecVersions(t *testing.T) {
+	for _, version := range []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0"} {
+		t.Run(version, func(t *testing.T) {
+			nc := newTestNetConfig(t, `{"cniVersion":"`+version+`", "trunkName":"eth1", "branchVlanID":"100",
+				"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20", "interfaceType":"vlan"}`)
+
+			versionedResult, err := newResult(testIfName, testNetnsPath, nc).GetAsVersion(nc.CNIVersion)
+			require.NoError(t, err)
+			data, err := json.Marshal(versionedResult)
+			require.NoError(t, err)
+
+			var r map[string]interface{}
+			err = json.Unmarshal(data, &r)
+			require.NoError(t, err)
+
+			assert.Equal(t, version, r["cniVersion"])
+			require.Len(t, r["interfaces"], 1)
+			require.Len(t, r["ips"], 1)
+			require.Len(t, r["routes"], 1)
+
+			ip := r["ips"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, "172.31.19.6/20", ip["address"])
+			assert.Equal(t, "172.31.16.1", ip["gateway"])
+			assert.Equal(t, float64(0), ip["interface"])
+
+			// The IP address family is implied by the address since spec version 1.0.0.
+			if version == "1.0.0" {
+				assert.NotContains(t, ip, "version")
+			} else {
+				assert.Equal(t, "4", ip["version"])
+			}
+		})
+	}
+}
+
+// TestResultGatewayFromPerContainerArgs tests that the result carries the derived gateway when
+// the branch IP address is passed only in per-container args.
+func TestResultGatewayFromPerContainerArgs(t *testing.T) {
+	nc, err := config.New(&cniSkel.CmdArgs{
+		StdinData: []byte(`{"cniVersion":"0.3.1", "trunkName":"eth1", "interfaceType":"vlan"}`),
+		Args:      "BranchVlanID=100;BranchMACAddress=02:e1:48:75:86:a4;BranchIPAddress=172.31.19.6/20",
+	})
+	require.NoError(t, err)
+
+	r := newResult(testIfName, testNetnsPath, nc)
+	require.Equal(t, 1, len(r.IPs))
+	assert.Equal(t, "172.31.16.1", r.IPs[0].Gateway.String())
+	require.Equal(t, 1, len(r.Routes))
+	assert.Equal(t, "172.31.16.1", r.Routes[0].GW.String())
+}
+
+// TestResultRecordsTrunk tests that the trunk in use is recorded in the result and found again.
+func TestResultRecordsTrunk(t *testing.T) {
+	nc := newTestNetConfig(t, `{"cniVersion":"1.0.0", "trunkName":["eth1", "eth2"], "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20", "interfaceType":"vlan"}`)
+	nc.TrunkName = "eth2"
+
+	result := newResult(testIfName, testNetnsPath, nc)
+	require.Len(t, result.Interfaces, 2)
+	assert.Equal(t, testNetnsPath, result.Interfaces[0].Sandbox)
+	assert.Equal(t, "eth2", result.Interfaces[1].Name)
+	assert.Equal(t, "eth2", getTrunkNameFromResult(result))
+
+	// A single trunk is not recorded.
+	nc = newTestNetConfig(t, `{"cniVersion":"1.0.0", "trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20", "interfaceType":"vlan"}`)
+	result = newResult(testIfName, testNetnsPath, nc)
+	assert.Len(t, result.Interfaces, 1)
+	assert.Equal(t, "", getTrunkNameFromResult(result))
+	assert.Equal(t, "", getTrunkNameFromResult(nil))
+}
+
+// TestResultInterfaceMACAddress tests that the result reports the effective in-container MAC address.
+func TestResultInterfaceMACAddress(t *testing.T) {
+	nc := newTestNetConfig(t, `{"cniVersion":"1.0.0", "trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "interfaceMACAddress":"02:42:42:42:42:42", "interfaceType":"vlan"}`)
+	result := newResult(testIfName, testNetnsPath, nc)
+	assert.Equal(t, "02:42:42:42:42:42", result.Interfaces[0].Mac)
+
+	nc.InterfaceMACAddress = nil
+	result = newResult(testIfName, testNetnsPath, nc)
+	assert.Equal(t, "02:e1:48:75:86:a4", result.Interfaces[0].Mac)
+}
+
+// TestDefaultRoutesDisabled tests that no default routes are requested when their installation is
+// disabled, while the gateways are still reported in the result.
+func TestDefaultRoutesDisabled(t *testing.T) {
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20",
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "routes":[{"dst":"10.20.0.0/16"}], "interfaceType":"vlan"}`)
+	assert.True(t, nc.InstallDefaultRoute)
+	assert.Len(t, getDefaultRoutes(nc), 2)
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100",
+		"branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20",
+		"branchIPv6Address":"2600:1f13:a0d:a700::5/64", "routes":[{"dst":"10.20.0.0/16"}],
+		"installDefaultRoute":false, "interfaceType":"vlan"}`)
+	assert.False(t, nc.InstallDefaultRoute)
+	assert.Empty(t, getDefaultRoutes(nc))
+
+	result := newResult(testIfName, testNetnsPath, nc)
+	require.Len(t, result.IPs, 2)
+	assert.Equal(t, "172.31.16.1", result.IPs[0].Gateway.String())
+	assert.Equal(t, "2600:1f13:a0d:a700::1", result.IPs[1].Gateway.String())
+	require.Len(t, result.Routes, 1)
+	assert.Equal(t, "10.20.0.0/16", result.Routes[0].Dst.String())
+}
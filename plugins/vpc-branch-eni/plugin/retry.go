@@ -0,0 +1,71 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"time"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/sys/unix"
+)
+
+// retryPolicy defines how many times and how often a failed netlink operation is retried.
+type retryPolicy struct {
+	// maxAttempts is the maximum number of attempts, including the first one.
+	maxAttempts int
+	// backoff is the delay before the first retry. It doubles with each subsequent retry.
+	backoff time.Duration
+}
+
+var (
+	// defaultRetryPolicy is the retry policy for netlink operations that can fail transiently.
+	defaultRetryPolicy = retryPolicy{
+		maxAttempts: 3,
+		backoff:     100 * time.Millisecond,
+	}
+
+	// transientErrors is the set of netlink errors that are worth retrying.
+	transientErrors = map[unix.Errno]bool{
+		unix.EBUSY:   true,
+		unix.ENOBUFS: true,
+		unix.EAGAIN:  true,
+		unix.EINTR:   true,
+	}
+
+	// sleep pauses between retries. It is a variable so that it can be replaced in unit tests.
+	sleep = time.Sleep
+)
+
+// run calls fn until it succeeds, fails with a non-transient error, or the maximum number of
+// attempts is reached. It returns the error from the last attempt.
+func (policy retryPolicy) run(operation string, fn func() error) error {
+	backoff := policy.backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransientError(err) || attempt >= policy.maxAttempts {
+			return err
+		}
+
+		log.Infof("Retrying %s in %v after transient error: %v.", operation, backoff, err)
+		sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransientError returns whether the given error is a transient netlink error.
+func isTransientError(err error) bool {
+	errno, ok := err.(unix.Errno)
+	return ok && transientErrors[errno]
+}
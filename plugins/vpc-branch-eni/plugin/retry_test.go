@@ -0,0 +1,85 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// mockNetlinkOp returns a netlink operation that fails with the given errors before succeeding.
+func mockNetlinkOp(errs ...error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+// mockSleep replaces sleep with a function recording the requested delays.
+func mockSleep() *[]time.Duration {
+	var delays []time.Duration
+	sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+	return &delays
+}
+
+// TestRetryTransientErrors tests that transient errors are retried with exponential backoff.
+func TestRetryTransientErrors(t *testing.T) {
+	delays := mockSleep()
+	defer func() { sleep = time.Sleep }()
+
+	op, calls := mockNetlinkOp(unix.EBUSY, unix.ENOBUFS)
+	err := defaultRetryPolicy.run("test", op)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, *delays)
+}
+
+// TestRetryMaxAttempts tests that the last error is returned after the maximum number of attempts.
+func TestRetryMaxAttempts(t *testing.T) {
+	mockSleep()
+	defer func() { sleep = time.Sleep }()
+
+	op, calls := mockNetlinkOp(unix.EBUSY, unix.EBUSY, unix.ENOBUFS, unix.EBUSY)
+	err := retryPolicy{maxAttempts: 3, backoff: time.Millisecond}.run("test", op)
+
+	assert.Equal(t, unix.ENOBUFS, err)
+	assert.Equal(t, 3, *calls)
+}
+
+// TestRetryFailFast tests that non-transient errors are not retried.
+func TestRetryFailFast(t *testing.T) {
+	delays := mockSleep()
+	defer func() { sleep = time.Sleep }()
+
+	for _, errno := range []unix.Errno{unix.EEXIST, unix.EINVAL} {
+		op, calls := mockNetlinkOp(errno)
+		err := defaultRetryPolicy.run("test", op)
+
+		assert.Equal(t, errno, err)
+		assert.Equal(t, 1, *calls)
+	}
+	assert.Empty(t, *delays)
+}
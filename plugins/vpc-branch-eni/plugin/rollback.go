@@ -0,0 +1,59 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	log "github.com/cihub/seelog"
+)
+
+// rollback undoes the changes made by a failed command, such as the links created before
+// the failure, so that a failed ADD does not leak resources on the host.
+type rollback struct {
+	steps    []rollbackStep
+	disarmed bool
+}
+
+// rollbackStep is a single cleanup action registered with a rollback.
+type rollbackStep struct {
+	name string
+	fn   func() error
+}
+
+// add registers a cleanup action for a resource that was just created.
+func (rb *rollback) add(name string, fn func() error) {
+	rb.steps = append(rb.steps, rollbackStep{name: name, fn: fn})
+}
+
+// disarm prevents the registered cleanup actions from running. It is called once the
+// command has succeeded.
+func (rb *rollback) disarm() {
+	rb.disarmed = true
+}
+
+// run calls the registered cleanup actions in the reverse order they were added, unless the
+// rollback was disarmed. Cleanup failures are logged and do not stop the remaining actions.
+func (rb *rollback) run() {
+	if rb.disarmed {
+		return
+	}
+
+	for i := len(rb.steps) - 1; i >= 0; i-- {
+		step := rb.steps[i]
+		log.Infof("Rolling back %s.", step.name)
+		err := step.fn()
+		if err != nil {
+			log.Errorf("Failed to roll back %s: %v.", step.name, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// TestRollbackOnAddressAssignmentFailure tests that the branch link is deleted when
+// IP address assignment fails after the link was created.
+func TestRollbackOnAddressAssignmentFailure(t *testing.T) {
+	var deleted []string
+	var rb rollback
+
+	err := func() error {
+		defer rb.run()
+
+		// Branch link creation succeeds.
+		rb.add("branch link", func() error {
+			deleted = append(deleted, "eth1.101")
+			return nil
+		})
+
+		// IP address assignment fails with a non-transient error.
+		assignIPAddress, _ := mockNetlinkOp(unix.EADDRNOTAVAIL)
+		err := defaultRetryPolicy.run("IP address assignment", assignIPAddress)
+		if err != nil {
+			return err
+		}
+
+		rb.disarm()
+		return nil
+	}()
+
+	assert.Equal(t, unix.EADDRNOTAVAIL, err)
+	assert.Equal(t, []string{"eth1.101"}, deleted)
+}
+
+// TestRollbackReverseOrder tests that all cleanup actions run in reverse order, even if some fail.
+func TestRollbackReverseOrder(t *testing.T) {
+	var order []string
+	var rb rollback
+
+	rb.add("branch link", func() error {
+		order = append(order, "branch link")
+		return nil
+	})
+	rb.add("tap bridge", func() error {
+		order = append(order, "tap bridge")
+		return errors.New("busy")
+	})
+	rb.add("tap link", func() error {
+		order = append(order, "tap link")
+		return nil
+	})
+	rb.run()
+
+	assert.Equal(t, []string{"tap link", "tap bridge", "branch link"}, order)
+}
+
+// TestRollbackDisarmed tests that no cleanup actions run once the rollback is disarmed.
+func TestRollbackDisarmed(t *testing.T) {
+	called := false
+	var rb rollback
+
+	rb.add("branch link", func() error {
+		called = true
+		return nil
+	})
+	rb.disarm()
+	rb.run()
+
+	assert.False(t, called)
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"net"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Rule and route operations. They are variables so that they can be replaced in unit tests.
+var (
+	ruleAdd  = netlink.RuleAdd
+	ruleDel  = netlink.RuleDel
+	routeAdd = netlink.RouteAdd
+	routeDel = netlink.RouteDel
+)
+
+// listBranchRoutes returns the routes via the given link in the given route table. A zero
+// table is the main table. Multipath routes are listed if any of their nexthops is via the
+// link, as they have no output link of their own to filter on.
+func listBranchRoutes(link netlink.Link, table int) ([]netlink.Route, error) {
+	var filterMask uint64
+	if table != 0 {
+		filterMask = netlink.RT_FILTER_TABLE
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: table}, filterMask)
+	if err != nil {
+		return nil, err
+	}
+
+	var branchRoutes []netlink.Route
+	for _, route := range routes {
+		if isRouteViaLink(route, link.Attrs().Index) {
+			branchRoutes = append(branchRoutes, route)
+		}
+	}
+
+	return branchRoutes, nil
+}
+
+// isRouteViaLink returns whether the route, or any of its nexthops, is via the given link.
+func isRouteViaLink(route netlink.Route, linkIndex int) bool {
+	if route.LinkIndex == linkIndex {
+		return true
+	}
+
+	for _, nexthop := range route.MultiPath {
+		if nexthop.LinkIndex == linkIndex {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addBranchRules adds the ip rules that look up the branch route table for traffic sourced
+// from the branch IP addresses.
+func addBranchRules(netConfig *config.NetConfig) error {
+	for _, rule := range newBranchRules(netConfig) {
+		log.Infof("Adding %v.", rule)
+		err := trace(traceOpAddRule, rule.String(), func() error {
+			return ruleAdd(rule)
+		})
+		if err != nil {
+			log.Errorf("Failed to add %v: %v.", rule, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteBranchRules deletes the ip rules added by addBranchRules. Rules that no longer exist
+// are ignored.
+func deleteBranchRules(netConfig *config.NetConfig) error {
+	for _, rule := range newBranchRules(netConfig) {
+		log.Infof("Deleting %v.", rule)
+		err := ruleDel(rule)
+		if err != nil && err != unix.ENOENT {
+			log.Errorf("Failed to delete %v: %v.", rule, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newBranchRules returns one "from <branch IP address>" rule per branch IP address pointing at
+// the branch route table. It returns nil if no route table is configured.
+func newBranchRules(netConfig *config.NetConfig) []*netlink.Rule {
+	if netConfig.RouteTableID == 0 {
+		return nil
+	}
+
+	var rules []*netlink.Rule
+	for _, ipAddress := range getBranchIPAddresses(netConfig) {
+		rule := netlink.NewRule()
+		rule.Src = &net.IPNet{
+			IP:   ipAddress.IP,
+			Mask: net.CIDRMask(len(ipAddress.Mask)*8, len(ipAddress.Mask)*8),
+		}
+		rule.Table = netConfig.RouteTableID
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
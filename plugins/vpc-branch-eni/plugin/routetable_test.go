@@ -0,0 +1,137 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const testRouteTableNetConfig = `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+	"branchIPAddresses":["10.11.12.13/16", "10.11.12.14/16"], "branchIPv6Address":"2600:1f13:a0d:a700::5/64",
+	"routes":[{"dst":"10.20.0.0/16"}], "routeTableID":100, "interfaceType":"vlan"}`
+
+// mockRuleOps replaces the rule operations with ones recording the requested rules.
+// Rule deletions fail with the given error.
+func mockRuleOps(ruleDelErr error) (added *[]*netlink.Rule, deleted *[]*netlink.Rule) {
+	added = &[]*netlink.Rule{}
+	deleted = &[]*netlink.Rule{}
+	ruleAdd = func(rule *netlink.Rule) error {
+		*added = append(*added, rule)
+		return nil
+	}
+	ruleDel = func(rule *netlink.Rule) error {
+		*deleted = append(*deleted, rule)
+		return ruleDelErr
+	}
+	return added, deleted
+}
+
+// restoreRuleOps restores the real rule operations.
+func restoreRuleOps() {
+	ruleAdd = netlink.RuleAdd
+	ruleDel = netlink.RuleDel
+}
+
+// TestAddBranchRules tests that one rule per branch IP address points at the branch route table.
+func TestAddBranchRules(t *testing.T) {
+	added, _ := mockRuleOps(nil)
+	defer restoreRuleOps()
+
+	nc := newTestNetConfig(t, testRouteTableNetConfig)
+	err := addBranchRules(nc)
+	require.NoError(t, err)
+
+	require.Len(t, *added, 3)
+	for i, src := range []string{"10.11.12.13/32", "10.11.12.14/32", "2600:1f13:a0d:a700::5/128"} {
+		assert.Equal(t, src, (*added)[i].Src.String())
+		assert.Equal(t, 100, (*added)[i].Table)
+	}
+}
+
+// TestDeleteBranchRules tests that the branch rules are deleted on teardown, ignoring missing rules.
+func TestDeleteBranchRules(t *testing.T) {
+	_, deleted := mockRuleOps(unix.ENOENT)
+	defer restoreRuleOps()
+
+	nc := newTestNetConfig(t, testRouteTableNetConfig)
+	err := deleteBranchRules(nc)
+	require.NoError(t, err)
+	assert.Len(t, *deleted, 3)
+
+	_, _ = mockRuleOps(unix.EPERM)
+	assert.Error(t, deleteBranchRules(nc))
+}
+
+// TestBranchRulesWithoutRouteTable tests that no rules are managed without a route table.
+func TestBranchRulesWithoutRouteTable(t *testing.T) {
+	added, deleted := mockRuleOps(nil)
+	defer restoreRuleOps()
+
+	nc := newTestNetConfig(t, testBranchNetConfig)
+	require.NoError(t, addBranchRules(nc))
+	require.NoError(t, deleteBranchRules(nc))
+	assert.Empty(t, *added)
+	assert.Empty(t, *deleted)
+}
+
+// TestRoutesInRouteTable tests that the branch routes are installed into the branch route table.
+func TestRoutesInRouteTable(t *testing.T) {
+	nc := newTestNetConfig(t, testRouteTableNetConfig)
+
+	route := newStaticRoute(42, cniTypes.Route{Dst: nc.Routes[0].Dst}, nc)
+	assert.Equal(t, 100, route.Table)
+
+	_, dst, _ := net.ParseCIDR("10.30.0.0/16")
+	route = newStaticRoute(42, cniTypes.Route{Dst: *dst}, &config.NetConfig{})
+	assert.Equal(t, 0, route.Table)
+}
+
+// TestRouteProtocol tests that the routes added by the plugin are tagged with its route protocol,
+// and that only routes with that protocol are deleted.
+func TestRouteProtocol(t *testing.T) {
+	var deleted []*netlink.Route
+	routeDel = func(route *netlink.Route) error {
+		deleted = append(deleted, route)
+		return unix.ESRCH
+	}
+	defer func() { routeDel = netlink.RouteDel }()
+
+	nc := newTestNetConfig(t, testRouteTableNetConfig)
+	assert.Equal(t, config.DefaultRouteProtocol, nc.RouteProtocol)
+	nc.RouteProtocol = 200
+
+	route := newStaticRoute(42, nc.Routes[0], nc)
+	assert.Equal(t, 200, route.Protocol)
+	route = newBranchDefaultRoute(42, nc.BranchGatewayIPAddress, nc)
+	assert.Equal(t, 200, route.Protocol)
+
+	// The loopback link exists in every network namespace. Routes that are not found, including
+	// those of other protocols, are ignored.
+	require.NoError(t, deleteStaticRoutes("lo", nc))
+	require.Len(t, deleted, 1)
+	assert.Equal(t, 200, deleted[0].Protocol)
+	assert.Equal(t, "10.20.0.0/16", deleted[0].Dst.String())
+	assert.Equal(t, 100, deleted[0].Table)
+}
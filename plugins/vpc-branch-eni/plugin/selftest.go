@@ -0,0 +1,169 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SelfTestCommand is the command line subcommand that checks that the host supports the
+	// links created by the plugin.
+	SelfTestCommand = "selftest"
+
+	// Names of the checks run by the self-test.
+	selfTestCheckCapNetAdmin = "CAP_NET_ADMIN"
+	selfTestCheckNetNS       = "netns"
+	selfTestCheckVLAN        = "vlan"
+	selfTestCheckTuntap      = "tuntap"
+
+	// Names of the temporary netns and of the links created in it. The VLAN link is created on
+	// a veth link, as the loopback interface does not support VLANs.
+	selfTestNetNSNameFormat = "vpc-branch-eni-selftest-%d"
+	selfTestParentLinkName  = "selftest0"
+	selfTestPeerLinkName    = "selftest1"
+	selfTestVLANLinkName    = "selftest0.100"
+	selfTestVLANID          = 100
+	selfTestTAPLinkName     = "selftest-tap0"
+
+	// Path of the device used to create TAP links.
+	tunDevicePath = "/dev/net/tun"
+)
+
+// selfTestResult is the outcome of a self-test check.
+type selfTestResult struct {
+	name string
+	err  error
+}
+
+// SelfTest creates and deletes a VLAN link and a TAP link in a temporary netns, and writes
+// whether each capability they need is present to w. It returns an error if any check fails.
+func SelfTest(w io.Writer) error {
+	return printSelfTestResults(w, runSelfTests())
+}
+
+// runSelfTests runs the self-test checks in order. The link checks are reported as failed
+// without running if the temporary netns cannot be created.
+func runSelfTests() []selfTestResult {
+	results := []selfTestResult{{selfTestCheckCapNetAdmin, checkCapNetAdmin()}}
+
+	ns, err := netns.NewNetNS(fmt.Sprintf(selfTestNetNSNameFormat, os.Getpid()))
+	results = append(results, selfTestResult{selfTestCheckNetNS, err})
+	if err != nil {
+		log.Errorf("Failed to create self-test netns: %v.", err)
+		skipped := fmt.Errorf("not run, %s check failed", selfTestCheckNetNS)
+		return append(results,
+			selfTestResult{selfTestCheckVLAN, skipped},
+			selfTestResult{selfTestCheckTuntap, skipped})
+	}
+	defer ns.Close()
+
+	return append(results,
+		selfTestResult{selfTestCheckVLAN, ns.Run(checkVLAN)},
+		selfTestResult{selfTestCheckTuntap, ns.Run(checkTuntap)})
+}
+
+// printSelfTestResults writes the outcome of each check and a summary to w, and returns an error
+// if any check failed.
+func printSelfTestResults(w io.Writer, results []selfTestResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s: %v\n", result.name, result.err)
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", result.name)
+		}
+	}
+
+	if failed != 0 {
+		fmt.Fprintf(w, "Self-test failed: %d of %d checks failed\n", failed, len(results))
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+
+	fmt.Fprintf(w, "Self-test passed: %d checks\n", len(results))
+	return nil
+}
+
+// checkCapNetAdmin returns an error if the process does not have the CAP_NET_ADMIN capability
+// in its effective set.
+func checkCapNetAdmin() error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	err := unix.Capget(&hdr, &data[0])
+	if err != nil {
+		return err
+	}
+
+	if data[0].Effective&(1<<unix.CAP_NET_ADMIN) == 0 {
+		return fmt.Errorf("capability is not in the effective set")
+	}
+
+	return nil
+}
+
+// checkVLAN creates and deletes a VLAN link in the current network namespace, which requires
+// the 8021q kernel module.
+func checkVLAN() error {
+	la := netlink.NewLinkAttrs()
+	la.Name = selfTestParentLinkName
+	parent := &netlink.Veth{LinkAttrs: la, PeerName: selfTestPeerLinkName}
+	err := netlink.LinkAdd(parent)
+	if err != nil {
+		return fmt.Errorf("failed to create parent link: %v", err)
+	}
+	// Deleting the parent link deletes its peer and the VLAN link too.
+	defer deleteLink(selfTestParentLinkName)
+
+	link, err := netlink.LinkByName(selfTestParentLinkName)
+	if err != nil {
+		return err
+	}
+
+	la = netlink.NewLinkAttrs()
+	la.Name = selfTestVLANLinkName
+	la.ParentIndex = link.Attrs().Index
+	err = netlink.LinkAdd(&netlink.Vlan{LinkAttrs: la, VlanId: selfTestVLANID})
+	if err != nil {
+		return fmt.Errorf("failed to create VLAN link, is the 8021q module available: %v", err)
+	}
+
+	return deleteLink(selfTestVLANLinkName)
+}
+
+// checkTuntap creates and deletes a TAP link in the current network namespace, which requires
+// the tun kernel module and its device.
+func checkTuntap() error {
+	_, err := os.Stat(tunDevicePath)
+	if err != nil {
+		return err
+	}
+
+	tapLink := newTAPLink(selfTestTAPLinkName, 0, 0, &config.TAPConfig{Queues: 1})
+	err = netlink.LinkAdd(tapLink)
+	if err != nil {
+		return fmt.Errorf("failed to create TAP link: %v", err)
+	}
+
+	return deleteLink(selfTestTAPLinkName)
+}
@@ -0,0 +1,44 @@
+// +build e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSelfTest tests that all checks pass on a host that supports the links created by the
+// plugin, and that the temporary netns is deleted afterwards.
+func TestSelfTest(t *testing.T) {
+	var buf bytes.Buffer
+	err := SelfTest(&buf)
+	require.NoError(t, err, buf.String())
+
+	for _, name := range []string{
+		selfTestCheckCapNetAdmin, selfTestCheckNetNS, selfTestCheckVLAN, selfTestCheckTuntap,
+	} {
+		assert.Contains(t, buf.String(), "PASS "+name+"\n")
+	}
+	assert.Contains(t, buf.String(), "Self-test passed: 4 checks\n")
+
+	_, err = os.Stat(fmt.Sprintf("/var/run/netns/"+selfTestNetNSNameFormat, os.Getpid()))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,46 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPrintSelfTestResults tests that each check and the summary are printed, and that any
+// failed check fails the self-test.
+func TestPrintSelfTestResults(t *testing.T) {
+	var buf bytes.Buffer
+	err := printSelfTestResults(&buf, []selfTestResult{
+		{selfTestCheckCapNetAdmin, nil},
+		{selfTestCheckNetNS, nil},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "PASS CAP_NET_ADMIN\nPASS netns\nSelf-test passed: 2 checks\n", buf.String())
+
+	buf.Reset()
+	err = printSelfTestResults(&buf, []selfTestResult{
+		{selfTestCheckCapNetAdmin, nil},
+		{selfTestCheckVLAN, errors.New("operation not supported")},
+		{selfTestCheckTuntap, nil},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "PASS CAP_NET_ADMIN\nFAIL vlan: operation not supported\nPASS tuntap\n"+
+		"Self-test failed: 1 of 3 checks failed\n", buf.String())
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
+)
+
+const (
+	// Permissions of the state files and of the directories created for them.
+	stateFileMode = 0600
+	stateDirMode  = 0700
+)
+
+// writeState persists the result of ADD to the given state file, so that DEL can tear down
+// exactly what was set up. The file is replaced atomically, so that it is never partially written.
+func writeState(path string, result *cniTypesCurrent.Result) error {
+	// The result is stored in the current version, regardless of the one used by the runtime.
+	state := *result
+	state.CNIVersion = cniTypesCurrent.ImplementedSpecVersion
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), stateDirMode)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmpFile.Name(), stateFileMode)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// readState reads the result of ADD from the given state file. A missing state file is not an
+// error, and results in a nil result.
+func readState(path string) (*cniTypesCurrent.Result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result, err := cniTypesCurrent.NewResult(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*cniTypesCurrent.Result), nil
+}
+
+// deleteState deletes the given state file. A missing state file is not an error.
+func deleteState(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// loadState sets the previous result in netConfig from its state file, unless the runtime
+// already passed one. Without a state file, DEL falls back to the network configuration.
+func loadState(netConfig *config.NetConfig) error {
+	if netConfig.StateFilePath == "" || netConfig.PrevResult != nil {
+		return nil
+	}
+
+	result, err := readState(netConfig.StateFilePath)
+	if err != nil {
+		log.Errorf("Failed to read state file %s: %v.", netConfig.StateFilePath, err)
+		return err
+	}
+
+	if result == nil {
+		log.Infof("State file %s does not exist, using netconfig.", netConfig.StateFilePath)
+		return nil
+	}
+
+	log.Infof("Using previous result from state file %s.", netConfig.StateFilePath)
+	netConfig.PrevResult = result
+	return nil
+}
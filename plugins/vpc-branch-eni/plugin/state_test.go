@@ -0,0 +1,112 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	cniSkel "github.com/containernetworking/cni/pkg/skel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testStateContainerID = "7b6ec2a3d5f4"
+)
+
+// newTestStateNetConfig parses a NetConfig with a state file in the given directory for tests.
+func newTestStateNetConfig(t *testing.T, stateDir string) (*cniSkel.CmdArgs, *config.NetConfig) {
+	args := &cniSkel.CmdArgs{
+		ContainerID: testStateContainerID,
+		Netns:       "/var/run/netns/vpc-branch-eni-nonexistent",
+		IfName:      testIfName,
+		StdinData: []byte(fmt.Sprintf(`{"cniVersion":"1.0.0", "trunkName":["eth1", "eth2"],
+			"branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4", "branchIPAddress":"172.31.19.6/20",
+			"interfaceType":"vlan", "stateFile":"%s/{containerID}/{ifname}.json"}`, stateDir)),
+	}
+
+	nc, err := config.New(args)
+	require.NoError(t, err)
+	return args, nc
+}
+
+// TestStateRoundTrip tests that the result written by ADD is read back as the previous result.
+func TestStateRoundTrip(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "vpc-branch-eni-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+
+	_, nc := newTestStateNetConfig(t, stateDir)
+	assert.Equal(t, filepath.Join(stateDir, testStateContainerID, testIfName+".json"), nc.StateFilePath)
+
+	nc.TrunkName = "eth2"
+	result := newResult(testIfName, testNetnsPath, nc)
+	require.NoError(t, writeState(nc.StateFilePath, result))
+
+	info, err := os.Stat(nc.StateFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(stateFileMode), info.Mode().Perm())
+
+	// Only the state file is left behind.
+	files, err := ioutil.ReadDir(filepath.Dir(nc.StateFilePath))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	_, nc = newTestStateNetConfig(t, stateDir)
+	require.NoError(t, loadState(nc))
+	require.NotNil(t, nc.PrevResult)
+	assert.Equal(t, "eth2", getTrunkNameFromResult(nc.PrevResult))
+
+	state, err := readState(nc.StateFilePath)
+	require.NoError(t, err)
+	require.Len(t, state.IPs, 1)
+	assert.Equal(t, "172.31.19.6/20", state.IPs[0].Address.String())
+
+	require.NoError(t, deleteState(nc.StateFilePath))
+	_, err = os.Stat(nc.StateFilePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestDelMissingState tests that DEL without a state file falls back to the network configuration,
+// and that DEL deletes the state file.
+func TestDelMissingState(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "vpc-branch-eni-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+
+	args, nc := newTestStateNetConfig(t, stateDir)
+	state, err := readState(nc.StateFilePath)
+	assert.NoError(t, err)
+	assert.Nil(t, state)
+	assert.NoError(t, loadState(nc))
+	assert.Nil(t, nc.PrevResult)
+	assert.NoError(t, deleteState(nc.StateFilePath))
+
+	// The netns is already gone, so there is nothing else to tear down.
+	plugin := &Plugin{}
+	assert.NoError(t, plugin.Del(args))
+
+	require.NoError(t, writeState(nc.StateFilePath, newResult(testIfName, testNetnsPath, nc)))
+	assert.NoError(t, plugin.Del(args))
+	_, err = os.Stat(nc.StateFilePath)
+	assert.True(t, os.IsNotExist(err))
+}
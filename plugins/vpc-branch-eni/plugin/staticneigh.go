@@ -0,0 +1,80 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+)
+
+// addStaticNeighbors adds the configured permanent neighbor entries on the given link.
+func addStaticNeighbors(linkIndex int, neighbors []config.StaticNeighbor) error {
+	for _, neighbor := range neighbors {
+		log.Infof("Adding static neighbor entry %s at %s.", neighbor.IPAddress, neighbor.MACAddress)
+		err := neighSet(newStaticNeigh(linkIndex, neighbor))
+		if err != nil {
+			log.Errorf("Failed to add static neighbor entry %s: %v.", neighbor.IPAddress, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteStaticNeighbors deletes the configured permanent neighbor entries from the given link.
+// Entries that no longer exist, including those deleted along with the link, are ignored.
+func deleteStaticNeighbors(linkName string, neighbors []config.StaticNeighbor) error {
+	if len(neighbors) == 0 {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	for _, neighbor := range neighbors {
+		log.Infof("Deleting static neighbor entry %s.", neighbor.IPAddress)
+		err = neighDel(newStaticNeigh(link.Attrs().Index, neighbor))
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to delete static neighbor entry %s: %v.", neighbor.IPAddress, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newStaticNeigh returns a permanent neighbor entry for the given neighbor on the given link.
+func newStaticNeigh(linkIndex int, neighbor config.StaticNeighbor) *netlink.Neigh {
+	family := netlink.FAMILY_V4
+	if neighbor.IPAddress.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	return &netlink.Neigh{
+		LinkIndex:    linkIndex,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		IP:           neighbor.IPAddress,
+		HardwareAddr: neighbor.MACAddress,
+	}
+}
@@ -0,0 +1,82 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// TestAddStaticNeighbors tests that permanent neighbor entries are requested on the branch link.
+func TestAddStaticNeighbors(t *testing.T) {
+	ops := mockProxyARPOps(nil)
+	defer restoreProxyARPOps()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "branchIPv6Address":"2600:1f13:a0d:a700::5/64",
+		"staticNeighbors":[{"ip":"172.31.16.1", "mac":"02:00:00:00:00:01"}, {"ip":"fe80::1", "mac":"02:00:00:00:00:02"}],
+		"interfaceType":"vlan"}`)
+
+	err := addStaticNeighbors(7, nc.StaticNeighbors)
+	require.NoError(t, err)
+
+	mac1, _ := net.ParseMAC("02:00:00:00:00:01")
+	mac2, _ := net.ParseMAC("02:00:00:00:00:02")
+	assert.Equal(t, []*netlink.Neigh{
+		{
+			LinkIndex:    7,
+			Family:       netlink.FAMILY_V4,
+			State:        netlink.NUD_PERMANENT,
+			IP:           net.ParseIP("172.31.16.1"),
+			HardwareAddr: mac1,
+		},
+		{
+			LinkIndex:    7,
+			Family:       netlink.FAMILY_V6,
+			State:        netlink.NUD_PERMANENT,
+			IP:           net.ParseIP("fe80::1"),
+			HardwareAddr: mac2,
+		},
+	}, ops.addedNeighs)
+}
+
+// TestDeleteStaticNeighbors tests that missing neighbor entries and links are ignored on deletion.
+func TestDeleteStaticNeighbors(t *testing.T) {
+	ops := mockProxyARPOps(unix.ENOENT)
+	defer restoreProxyARPOps()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"101", "branchMACAddress":"02:e1:48:75:86:a4",
+		"branchIPAddress":"172.31.19.6/20", "staticNeighbors":[{"ip":"172.31.16.1", "mac":"02:00:00:00:00:01"}],
+		"interfaceType":"vlan"}`)
+
+	err := deleteStaticNeighbors("lo", nc.StaticNeighbors)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"172.31.16.1"}, ops.deletedIPs)
+
+	err = deleteStaticNeighbors("nonexistent0", nc.StaticNeighbors)
+	require.NoError(t, err)
+	assert.Len(t, ops.deletedIPs, 1)
+
+	// Other failures are returned.
+	mockProxyARPOps(unix.EPERM)
+	err = deleteStaticNeighbors("lo", nc.StaticNeighbors)
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// Root of the sysctl tree.
+	sysctlRoot = "/proc/sys"
+
+	// Permissions used when writing a sysctl.
+	sysctlFileMode = 0644
+
+	// Path of the sysctl that sets the reverse path filter mode of an interface. The effective
+	// mode is the maximum of the interface and "all" settings, so both must be cleared.
+	rpFilterSysctlFormat  = "/proc/sys/net/ipv4/conf/%s/rp_filter"
+	rpFilterAllInterfaces = "all"
+	rpFilterDisabled      = "0"
+
+	// Paths of the sysctls that disable IPv6 stateless address autoconfiguration, router
+	// advertisements and temporary (privacy extension) addresses of an interface.
+	ipv6AutoconfSysctlFormat  = "/proc/sys/net/ipv6/conf/%s/autoconf"
+	ipv6AcceptRASysctlFormat  = "/proc/sys/net/ipv6/conf/%s/accept_ra"
+	ipv6TempAddrSysctlFormat  = "/proc/sys/net/ipv6/conf/%s/use_tempaddr"
+	ipv6AutoconfSysctlDisable = "0"
+
+	// Paths of the sysctls that control accepting and sending ICMP redirects on an interface.
+	// The effective IPv4 settings of a host combine the interface and "all" settings, so both
+	// must be cleared. IPv6 has only a per-interface accept setting, and never sends redirects
+	// from hosts.
+	ipv4AcceptRedirectsSysctlFormat = "/proc/sys/net/ipv4/conf/%s/accept_redirects"
+	ipv4SendRedirectsSysctlFormat   = "/proc/sys/net/ipv4/conf/%s/send_redirects"
+	ipv6AcceptRedirectsSysctlFormat = "/proc/sys/net/ipv6/conf/%s/accept_redirects"
+	icmpRedirectsAllInterfaces      = "all"
+	icmpRedirectsSysctlDisable      = "0"
+)
+
+// writeSysctl writes a sysctl value. It is a variable so that it can be replaced in unit tests.
+var writeSysctl = func(path string, value string) error {
+	return ioutil.WriteFile(path, []byte(value), sysctlFileMode)
+}
+
+// setSysctls applies the given sysctls in the current network namespace, substituting the
+// interface name template in their keys with the given interface name.
+func setSysctls(ifName string, sysctls map[string]string) error {
+	// Apply the sysctls in a deterministic order.
+	keys := make([]string, 0, len(sysctls))
+	for key := range sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := getSysctlPath(key, ifName)
+		log.Infof("Setting sysctl %s to %s.", path, sysctls[key])
+		err := writeSysctl(path, sysctls[key])
+		if err != nil {
+			log.Errorf("Failed to set sysctl %s: %v.", path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disableRPFilter disables IPv4 reverse path filtering for the given interface in the current
+// network namespace.
+func disableRPFilter(ifName string) error {
+	for _, name := range []string{rpFilterAllInterfaces, ifName} {
+		path := fmt.Sprintf(rpFilterSysctlFormat, name)
+		log.Infof("Disabling reverse path filter %s.", path)
+		err := writeSysctl(path, rpFilterDisabled)
+		if err != nil {
+			log.Errorf("Failed to disable reverse path filter %s: %v.", path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disableIPv6Autoconf disables IPv6 stateless address autoconfiguration, router advertisements
+// and temporary addresses for the given interface in the current network namespace.
+func disableIPv6Autoconf(ifName string) error {
+	for _, format := range []string{
+		ipv6AutoconfSysctlFormat,
+		ipv6AcceptRASysctlFormat,
+		ipv6TempAddrSysctlFormat,
+	} {
+		path := fmt.Sprintf(format, ifName)
+		log.Infof("Disabling IPv6 autoconfiguration sysctl %s.", path)
+		err := writeSysctl(path, ipv6AutoconfSysctlDisable)
+		if err != nil {
+			log.Errorf("Failed to disable IPv6 autoconfiguration sysctl %s: %v.", path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disableICMPRedirects disables accepting and sending IPv4 and IPv6 ICMP redirects for the given
+// interface in the current network namespace.
+func disableICMPRedirects(ifName string) error {
+	for _, path := range []string{
+		fmt.Sprintf(ipv4AcceptRedirectsSysctlFormat, icmpRedirectsAllInterfaces),
+		fmt.Sprintf(ipv4AcceptRedirectsSysctlFormat, ifName),
+		fmt.Sprintf(ipv4SendRedirectsSysctlFormat, icmpRedirectsAllInterfaces),
+		fmt.Sprintf(ipv4SendRedirectsSysctlFormat, ifName),
+		fmt.Sprintf(ipv6AcceptRedirectsSysctlFormat, ifName),
+	} {
+		log.Infof("Disabling ICMP redirects sysctl %s.", path)
+		err := writeSysctl(path, icmpRedirectsSysctlDisable)
+		if err != nil {
+			log.Errorf("Failed to disable ICMP redirects sysctl %s: %v.", path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSysctlPath returns the path of the sysctl with the given dot-separated key. Interface names
+// may contain dots themselves, so the template is substituted after splitting the key.
+func getSysctlPath(key string, ifName string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		if part == config.SysctlIfNameTemplate {
+			parts[i] = ifName
+		}
+	}
+
+	return filepath.Join(append([]string{sysctlRoot}, parts...)...)
+}
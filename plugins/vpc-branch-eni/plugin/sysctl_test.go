@@ -0,0 +1,137 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// realWriteSysctl is the sysctl write operation used outside of unit tests.
+var realWriteSysctl = writeSysctl
+
+// mockWriteSysctl replaces the sysctl write operation with one recording the requested paths
+// and values, and failing for the given path.
+func mockWriteSysctl(failPath string) *[][2]string {
+	var writes [][2]string
+	writeSysctl = func(path string, value string) error {
+		if path == failPath {
+			return errors.New("permission denied")
+		}
+		writes = append(writes, [2]string{path, value})
+		return nil
+	}
+	return &writes
+}
+
+// TestSetSysctls tests that sysctls are applied in order with the interface name substituted.
+func TestSetSysctls(t *testing.T) {
+	writes := mockWriteSysctl("")
+	defer func() { writeSysctl = realWriteSysctl }()
+
+	err := setSysctls("eth1.101", map[string]string{
+		"net.ipv4.tcp_keepalive_time":           "600",
+		"net.ipv6.conf.all.disable_ipv6":        "0",
+		"net.ipv4.conf.{ifname}.rp_filter":      "2",
+		"net.ipv6.conf.{ifname}.accept_ra":      "0",
+		"net.ipv4.conf.{ifname}.arp_ignore":     "1",
+		"net.ipv4.neigh.{ifname}.gc_stale_time": "120",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][2]string{
+		{"/proc/sys/net/ipv4/conf/eth1.101/arp_ignore", "1"},
+		{"/proc/sys/net/ipv4/conf/eth1.101/rp_filter", "2"},
+		{"/proc/sys/net/ipv4/neigh/eth1.101/gc_stale_time", "120"},
+		{"/proc/sys/net/ipv4/tcp_keepalive_time", "600"},
+		{"/proc/sys/net/ipv6/conf/all/disable_ipv6", "0"},
+		{"/proc/sys/net/ipv6/conf/eth1.101/accept_ra", "0"},
+	}, *writes)
+}
+
+// TestSetSysctlsFailure tests that the first failure to set a sysctl is returned.
+func TestSetSysctlsFailure(t *testing.T) {
+	writes := mockWriteSysctl("/proc/sys/net/ipv4/conf/eth0/rp_filter")
+	defer func() { writeSysctl = realWriteSysctl }()
+
+	err := setSysctls("eth0", map[string]string{
+		"net.ipv4.conf.{ifname}.arp_ignore": "1",
+		"net.ipv4.conf.{ifname}.rp_filter":  "2",
+		"net.ipv4.tcp_keepalive_time":       "600",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, [][2]string{{"/proc/sys/net/ipv4/conf/eth0/arp_ignore", "1"}}, *writes)
+}
+
+// TestDisableRPFilter tests that reverse path filtering is disabled on both the interface and
+// all interfaces.
+func TestDisableRPFilter(t *testing.T) {
+	writes := mockWriteSysctl("")
+	defer func() { writeSysctl = realWriteSysctl }()
+
+	assert.NoError(t, disableRPFilter("eth1.101"))
+	assert.Equal(t, [][2]string{
+		{"/proc/sys/net/ipv4/conf/all/rp_filter", "0"},
+		{"/proc/sys/net/ipv4/conf/eth1.101/rp_filter", "0"},
+	}, *writes)
+
+	// The first failure is returned.
+	writes = mockWriteSysctl("/proc/sys/net/ipv4/conf/all/rp_filter")
+	assert.Error(t, disableRPFilter("eth0"))
+	assert.Empty(t, *writes)
+}
+
+// TestDisableIPv6Autoconf tests that IPv6 autoconfiguration, router advertisements and temporary
+// addresses are disabled on the interface.
+func TestDisableIPv6Autoconf(t *testing.T) {
+	writes := mockWriteSysctl("")
+	defer func() { writeSysctl = realWriteSysctl }()
+
+	assert.NoError(t, disableIPv6Autoconf("eth1.101"))
+	assert.Equal(t, [][2]string{
+		{"/proc/sys/net/ipv6/conf/eth1.101/autoconf", "0"},
+		{"/proc/sys/net/ipv6/conf/eth1.101/accept_ra", "0"},
+		{"/proc/sys/net/ipv6/conf/eth1.101/use_tempaddr", "0"},
+	}, *writes)
+
+	// The first failure is returned.
+	writes = mockWriteSysctl("/proc/sys/net/ipv6/conf/eth0/accept_ra")
+	assert.Error(t, disableIPv6Autoconf("eth0"))
+	assert.Equal(t, [][2]string{{"/proc/sys/net/ipv6/conf/eth0/autoconf", "0"}}, *writes)
+}
+
+// TestDisableICMPRedirects tests that accepting and sending ICMP redirects is disabled on the
+// interface, and on all interfaces where the effective IPv4 setting combines both.
+func TestDisableICMPRedirects(t *testing.T) {
+	writes := mockWriteSysctl("")
+	defer func() { writeSysctl = realWriteSysctl }()
+
+	assert.NoError(t, disableICMPRedirects("eth1.101"))
+	assert.Equal(t, [][2]string{
+		{"/proc/sys/net/ipv4/conf/all/accept_redirects", "0"},
+		{"/proc/sys/net/ipv4/conf/eth1.101/accept_redirects", "0"},
+		{"/proc/sys/net/ipv4/conf/all/send_redirects", "0"},
+		{"/proc/sys/net/ipv4/conf/eth1.101/send_redirects", "0"},
+		{"/proc/sys/net/ipv6/conf/eth1.101/accept_redirects", "0"},
+	}, *writes)
+
+	// The first failure is returned.
+	writes = mockWriteSysctl("/proc/sys/net/ipv4/conf/eth0/accept_redirects")
+	assert.Error(t, disableICMPRedirects("eth0"))
+	assert.Equal(t, [][2]string{{"/proc/sys/net/ipv4/conf/all/accept_redirects", "0"}}, *writes)
+}
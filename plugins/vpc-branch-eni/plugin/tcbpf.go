@@ -0,0 +1,267 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	log "github.com/cihub/seelog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// bpf(2) commands.
+	bpfCmdProgLoad = 5
+	bpfCmdObjGet   = 7
+
+	// Size of an eBPF instruction.
+	bpfInsnSize = 8
+
+	// Name of the ELF section holding the license of the programs in an eBPF object.
+	bpfLicenseSection = "license"
+
+	// Type and handle of the clsact qdisc, and name, handle and priority of the tc filters
+	// running the program.
+	clsactQdiscType     = "clsact"
+	clsactQdiscHandle   = 0xffff
+	tcBPFFilterName     = "vpc-branch-eni"
+	tcBPFFilterHandle   = 1
+	tcBPFFilterPriority = 1
+)
+
+// bpfProgLoadAttr is the bpf_attr union for the BPF_PROG_LOAD command.
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpfObjGetAttr is the bpf_attr union for the BPF_OBJ_GET command.
+type bpfObjGetAttr struct {
+	pathname  uint64
+	bpfFd     uint32
+	fileFlags uint32
+}
+
+// Traffic control operations. They are variables so that they can be replaced in unit tests.
+var (
+	qdiscAdd  = netlink.QdiscAdd
+	qdiscDel  = netlink.QdiscDel
+	filterAdd = netlink.FilterAdd
+)
+
+// addTCBPFProgram loads the eBPF program at the given path and attaches it to the ingress and
+// egress hooks of the given link. The link holds a reference to the program once it is attached.
+func addTCBPFProgram(linkIndex int, path string) error {
+	log.Infof("Loading eBPF tc program %s.", path)
+	fd, err := loadTCBPFProgram(path)
+	if err != nil {
+		log.Errorf("Failed to load eBPF tc program %s: %v.", path, err)
+		return cni.NewError(cni.ErrCodeInvalidConfig, fmt.Errorf("invalid tcBPFProgram %s: %v", path, err))
+	}
+	defer unix.Close(fd)
+
+	return attachTCBPFProgram(linkIndex, fd)
+}
+
+// loadTCBPFProgram returns a file descriptor for the eBPF program at the given path, which is
+// either pinned on the BPF filesystem or the first program in an ELF object file.
+func loadTCBPFProgram(path string) (int, error) {
+	var fs unix.Statfs_t
+	err := unix.Statfs(path, &fs)
+	if err != nil {
+		return -1, err
+	}
+
+	if fs.Type == unix.BPF_FS_MAGIC {
+		return getPinnedBPFProgram(path)
+	}
+
+	return loadBPFObject(path)
+}
+
+// getPinnedBPFProgram returns a file descriptor for the program pinned at the given path.
+func getPinnedBPFProgram(path string) (int, error) {
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+
+	attr := bpfObjGetAttr{pathname: uint64(uintptr(unsafe.Pointer(pathname)))}
+	fd, err := bpf(bpfCmdObjGet, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(pathname)
+	return fd, err
+}
+
+// loadBPFObject loads the first program in the given ELF object file as a tc classifier.
+// Programs using maps need their relocations resolved by a loader, and must be pinned instead.
+func loadBPFObject(path string) (int, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return -1, err
+	}
+	defer file.Close()
+
+	if file.Machine != elf.EM_BPF {
+		return -1, fmt.Errorf("%s is not an eBPF object", path)
+	}
+
+	var prog *elf.Section
+	progIndex := 0
+	license := ""
+	for i, section := range file.Sections {
+		switch {
+		case section.Name == bpfLicenseSection:
+			data, err := section.Data()
+			if err != nil {
+				return -1, err
+			}
+			license = strings.TrimRight(string(data), "\x00")
+		case prog == nil && section.Type == elf.SHT_PROGBITS &&
+			section.Flags&elf.SHF_EXECINSTR != 0 && section.Size != 0:
+			prog = section
+			progIndex = i
+		}
+	}
+	if prog == nil {
+		return -1, fmt.Errorf("eBPF object %s has no program", path)
+	}
+
+	for _, section := range file.Sections {
+		if (section.Type == elf.SHT_REL || section.Type == elf.SHT_RELA) && int(section.Info) == progIndex {
+			return -1, fmt.Errorf("eBPF object %s has relocations, programs using maps must be pinned", path)
+		}
+	}
+
+	insns, err := prog.Data()
+	if err != nil {
+		return -1, err
+	}
+	if len(insns)%bpfInsnSize != 0 {
+		return -1, fmt.Errorf("eBPF object %s has a truncated program", path)
+	}
+
+	return loadBPFProgram(insns, license)
+}
+
+// loadBPFProgram loads the given eBPF instructions as a tc classifier.
+func loadBPFProgram(insns []byte, license string) (int, error) {
+	licenseStr, err := unix.BytePtrFromString(license)
+	if err != nil {
+		return -1, err
+	}
+
+	attr := bpfProgLoadAttr{
+		progType: uint32(netlink.BPF_PROG_TYPE_SCHED_CLS),
+		insnCnt:  uint32(len(insns) / bpfInsnSize),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(licenseStr))),
+	}
+	fd, err := bpf(bpfCmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(licenseStr)
+	return fd, err
+}
+
+// bpf calls the bpf(2) system call, which returns a file descriptor for the commands used here.
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(fd), nil
+}
+
+// attachTCBPFProgram adds a clsact qdisc to the given link and attaches the given program to its
+// ingress and egress hooks in direct-action mode.
+func attachTCBPFProgram(linkIndex int, fd int) error {
+	qdisc := newClsactQdisc(linkIndex)
+	log.Infof("Adding qdisc %+v.", qdisc)
+	err := qdiscAdd(qdisc)
+	if err != nil && !os.IsExist(err) {
+		log.Errorf("Failed to add clsact qdisc: %v.", err)
+		return err
+	}
+
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		filter := &netlink.BpfFilter{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: linkIndex,
+				Parent:    parent,
+				Handle:    tcBPFFilterHandle,
+				Protocol:  unix.ETH_P_ALL,
+				Priority:  tcBPFFilterPriority,
+			},
+			Fd:           fd,
+			Name:         tcBPFFilterName,
+			DirectAction: true,
+		}
+		log.Infof("Adding eBPF tc filter %+v.", filter)
+		err = filterAdd(filter)
+		if err != nil {
+			log.Errorf("Failed to add eBPF tc filter: %v.", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// detachTCBPFProgram deletes the clsact qdisc, along with the filters running the program, from
+// the link with the given name. Missing links and qdiscs are considered already deleted.
+func detachTCBPFProgram(linkName string) error {
+	link, err := netlink.LinkByName(linkName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	qdisc := newClsactQdisc(link.Attrs().Index)
+	log.Infof("Deleting qdisc %+v.", qdisc)
+	err = qdiscDel(qdisc)
+	if err != nil && err != unix.ENOENT && err != unix.EINVAL {
+		return err
+	}
+
+	return nil
+}
+
+// newClsactQdisc returns the clsact qdisc of the given link.
+func newClsactQdisc(linkIndex int) *netlink.GenericQdisc {
+	return &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    netlink.MakeHandle(clsactQdiscHandle, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: clsactQdiscType,
+	}
+}
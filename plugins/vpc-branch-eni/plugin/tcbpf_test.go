@@ -0,0 +1,177 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// writeTestBPFObject writes an ELF object for the given machine with a trivial tc classifier,
+// which returns TC_ACT_OK, to the given directory and returns its path.
+func writeTestBPFObject(t *testing.T, dir string, machine elf.Machine) string {
+	// mov r0, 0; exit
+	prog := []byte{0xb7, 0, 0, 0, 0, 0, 0, 0, 0x95, 0, 0, 0, 0, 0, 0, 0}
+	license := []byte("GPL\x00")
+	shstrtab := []byte("\x00classifier\x00license\x00.shstrtab\x00")
+
+	headerSize := uint64(binary.Size(elf.Header64{}))
+	progOff := headerSize
+	licenseOff := progOff + uint64(len(prog))
+	shstrtabOff := licenseOff + uint64(len(license))
+	shOff := (shstrtabOff + uint64(len(shstrtab)) + 7) &^ 7
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shOff,
+		Ehsize:    uint16(headerSize),
+		Shentsize: uint16(binary.Size(elf.Section64{})),
+		Shnum:     4,
+		Shstrndx:  3,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	sections := []elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR),
+			Off: progOff, Size: uint64(len(prog)), Addralign: 8},
+		{Name: 12, Type: uint32(elf.SHT_PROGBITS), Flags: uint64(elf.SHF_ALLOC | elf.SHF_WRITE),
+			Off: licenseOff, Size: uint64(len(license)), Addralign: 1},
+		{Name: 20, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOff, Size: uint64(len(shstrtab)), Addralign: 1},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, header))
+	buf.Write(prog)
+	buf.Write(license)
+	buf.Write(shstrtab)
+	buf.Write(make([]byte, shOff-uint64(buf.Len())))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, sections))
+
+	path := filepath.Join(dir, "classifier.o")
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+// TestLoadTCBPFProgram tests that the program in an eBPF object loads, and that other files
+// are rejected.
+func TestLoadTCBPFProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpc-branch-eni-tcbpf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fd, err := loadTCBPFProgram(writeTestBPFObject(t, dir, elf.EM_BPF))
+	if err == unix.EPERM {
+		t.Skip("Loading eBPF programs is not permitted.")
+	}
+	require.NoError(t, err)
+	assert.True(t, fd >= 0)
+	unix.Close(fd)
+
+	_, err = loadTCBPFProgram(writeTestBPFObject(t, dir, elf.EM_X86_64))
+	assert.Error(t, err)
+
+	notELF := filepath.Join(dir, "notelf.o")
+	require.NoError(t, ioutil.WriteFile(notELF, []byte("not an ELF object"), 0644))
+	_, err = loadTCBPFProgram(notELF)
+	assert.Error(t, err)
+
+	_, err = loadTCBPFProgram(filepath.Join(dir, "nonexistent.o"))
+	assert.Error(t, err)
+}
+
+// TestAttachDetachTCBPFProgram tests that the program is attached to the ingress and egress hooks
+// of the clsact qdisc of the branch link, and that the qdisc is deleted on detach.
+func TestAttachDetachTCBPFProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpc-branch-eni-tcbpf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var addedQdiscs, deletedQdiscs []netlink.Qdisc
+	var filters []*netlink.BpfFilter
+	qdiscAdd = func(qdisc netlink.Qdisc) error {
+		addedQdiscs = append(addedQdiscs, qdisc)
+		return nil
+	}
+	qdiscDel = func(qdisc netlink.Qdisc) error {
+		deletedQdiscs = append(deletedQdiscs, qdisc)
+		return unix.ENOENT
+	}
+	filterAdd = func(filter netlink.Filter) error {
+		filters = append(filters, filter.(*netlink.BpfFilter))
+		return nil
+	}
+	defer func() {
+		qdiscAdd = netlink.QdiscAdd
+		qdiscDel = netlink.QdiscDel
+		filterAdd = netlink.FilterAdd
+	}()
+
+	err = addTCBPFProgram(7, writeTestBPFObject(t, dir, elf.EM_BPF))
+	if err != nil && strings.Contains(err.Error(), unix.EPERM.Error()) {
+		t.Skip("Loading eBPF programs is not permitted.")
+	}
+	require.NoError(t, err)
+
+	require.Len(t, addedQdiscs, 1)
+	assert.Equal(t, newClsactQdisc(7), addedQdiscs[0])
+	require.Len(t, filters, 2)
+	assert.Equal(t, uint32(netlink.HANDLE_MIN_INGRESS), filters[0].Parent)
+	assert.Equal(t, uint32(netlink.HANDLE_MIN_EGRESS), filters[1].Parent)
+	for _, filter := range filters {
+		assert.Equal(t, 7, filter.LinkIndex)
+		assert.True(t, filter.Fd >= 0)
+		assert.True(t, filter.DirectAction)
+	}
+
+	// A missing qdisc is considered already deleted.
+	require.NoError(t, detachTCBPFProgram("lo"))
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(t, err)
+	require.Len(t, deletedQdiscs, 1)
+	assert.Equal(t, newClsactQdisc(lo.Attrs().Index), deletedQdiscs[0])
+
+	require.NoError(t, detachTCBPFProgram("nonexistent0"))
+	assert.Len(t, deletedQdiscs, 1)
+
+	// A missing program fails ADD, and is never attached.
+	err = addTCBPFProgram(7, filepath.Join(dir, "nonexistent.o"))
+	require.Error(t, err)
+	cniErr, ok := err.(*cniTypes.Error)
+	require.True(t, ok)
+	assert.Equal(t, cni.ErrCodeInvalidConfig, cniErr.Code)
+	assert.Contains(t, cniErr.Details, "invalid tcBPFProgram")
+	assert.Len(t, addedQdiscs, 1)
+}
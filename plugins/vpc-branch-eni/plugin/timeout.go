@@ -0,0 +1,94 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// envOpTimeout is the environment variable that overrides the timeout of a CNI command,
+	// e.g. VPC_CNI_OP_TIMEOUT=1m.
+	envOpTimeout = "VPC_CNI_OP_TIMEOUT"
+
+	// defaultOpTimeout is the default timeout of a CNI command.
+	defaultOpTimeout = 30 * time.Second
+)
+
+// getOpTimeout returns the timeout of a CNI command.
+func getOpTimeout() time.Duration {
+	value := os.Getenv(envOpTimeout)
+	if value == "" {
+		return defaultOpTimeout
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Errorf("Invalid %s %q, using the default timeout %v.", envOpTimeout, value, defaultOpTimeout)
+		return defaultOpTimeout
+	}
+
+	return timeout
+}
+
+// runWithTimeout runs the given CNI command handler with a context that is cancelled when the
+// command timeout expires. Netlink calls cannot be interrupted, so the handler is expected to
+// check the context between steps, and to roll back its changes once it notices the timeout.
+// The handler is always waited for, even past the timeout, as the plugin would otherwise exit
+// before the rollback and leave the changes behind.
+//
+// The handler writes its output to a buffer, which is copied to w only if the handler succeeds,
+// so that stdout never holds both a partial result and an error.
+func runWithTimeout(command string, w io.Writer, handler func(ctx context.Context, w io.Writer) error) error {
+	timeout := getOpTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	err := handler(ctx, &output)
+	if ctx.Err() != nil {
+		log.Errorf("%s did not complete within %v: %v.", command, timeout, err)
+	}
+
+	return writeOutput(w, &output, err)
+}
+
+// writeOutput copies the output of a completed handler to w, unless the handler failed.
+func writeOutput(w io.Writer, output *bytes.Buffer, err error) error {
+	if err != nil {
+		return err
+	}
+
+	_, err = output.WriteTo(w)
+	return err
+}
+
+// checkTimeout returns a timeout error if the given context was cancelled.
+func checkTimeout(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		log.Errorf("Stopping after the operation timed out: %v.", err)
+		return cni.NewError(cni.ErrCodeTimeout, err)
+	}
+
+	return nil
+}
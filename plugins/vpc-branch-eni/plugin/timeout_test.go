@@ -0,0 +1,124 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-plugins/cni"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRunWithTimeoutRollsBack tests that a command blocked past its timeout is waited for, and
+// that its rollback completes before the timeout error is returned.
+func TestRunWithTimeoutRollsBack(t *testing.T) {
+	os.Setenv(envOpTimeout, "10ms")
+	defer os.Unsetenv(envOpTimeout)
+
+	rolledBack := false
+	var stdout bytes.Buffer
+	err := runWithTimeout("ADD", &stdout, func(ctx context.Context, w io.Writer) error {
+		var rb rollback
+		defer rb.run()
+		rb.add("link", func() error {
+			rolledBack = true
+			return nil
+		})
+
+		// Block like a hung netlink call until past the deadline.
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "partial")
+		return checkTimeout(ctx)
+	})
+
+	require.Error(t, err)
+	cniErr, ok := err.(*cniTypes.Error)
+	require.True(t, ok)
+	assert.Equal(t, cni.ErrCodeTimeout, cniErr.Code)
+	assert.True(t, rolledBack)
+	assert.Empty(t, stdout.String())
+}
+
+// TestRunWithTimeoutCompletesLate tests that the result of a command that completes after its
+// timeout without noticing it is returned, and written once.
+func TestRunWithTimeoutCompletesLate(t *testing.T) {
+	os.Setenv(envOpTimeout, "10ms")
+	defer os.Unsetenv(envOpTimeout)
+
+	var stdout bytes.Buffer
+	err := runWithTimeout("ADD", &stdout, func(ctx context.Context, w io.Writer) error {
+		<-ctx.Done()
+		fmt.Fprint(w, "result")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "result", stdout.String())
+
+	// A command that fails after its timeout returns its own error, and writes nothing.
+	stdout.Reset()
+	expected := errors.New("failed")
+	err = runWithTimeout("ADD", &stdout, func(ctx context.Context, w io.Writer) error {
+		<-ctx.Done()
+		fmt.Fprint(w, "partial")
+		return expected
+	})
+	assert.Equal(t, expected, err)
+	assert.Empty(t, stdout.String())
+}
+
+// TestRunWithTimeoutCompletes tests that the result of a command that completes in time is returned.
+func TestRunWithTimeoutCompletes(t *testing.T) {
+	var stdout bytes.Buffer
+	err := runWithTimeout("ADD", &stdout, func(ctx context.Context, w io.Writer) error {
+		fmt.Fprint(w, "result")
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "result", stdout.String())
+
+	expected := errors.New("failed")
+	err = runWithTimeout("DEL", ioutil.Discard, func(ctx context.Context, w io.Writer) error {
+		return expected
+	})
+	assert.Equal(t, expected, err)
+}
+
+// TestGetOpTimeout tests parsing of the operation timeout environment variable.
+func TestGetOpTimeout(t *testing.T) {
+	defer os.Unsetenv(envOpTimeout)
+
+	os.Unsetenv(envOpTimeout)
+	assert.Equal(t, defaultOpTimeout, getOpTimeout())
+
+	os.Setenv(envOpTimeout, "1m")
+	assert.Equal(t, time.Minute, getOpTimeout())
+
+	for _, value := range []string{"30", "-1s", "0s", "soon"} {
+		os.Setenv(envOpTimeout, value)
+		assert.Equal(t, defaultOpTimeout, getOpTimeout(), value)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"os"
+	"time"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// envTrace is the environment variable that enables step tracing.
+	// In trace mode, each discrete operation is logged with its arguments, error and duration.
+	envTrace = "VPC_CNI_TRACE"
+
+	// Names of the traced operations.
+	traceOpEnterNetNS = "enter netns"
+	traceOpExitNetNS  = "exit netns"
+	traceOpCreateLink = "create link"
+	traceOpMoveLink   = "move link"
+	traceOpRenameLink = "rename link"
+	traceOpSetMTU     = "set mtu"
+	traceOpSetMAC     = "set mac"
+	traceOpSetUp      = "set up"
+	traceOpAddAddr    = "add addr"
+	traceOpAddRoute   = "add route"
+	traceOpAddRule    = "add rule"
+)
+
+// traceEvent is a single traced operation.
+type traceEvent struct {
+	op       string
+	args     string
+	err      error
+	duration time.Duration
+}
+
+// traceSink receives the trace events. It is a variable so that it can be replaced in unit tests.
+var traceSink = logTraceEvent
+
+// isTraceEnabled returns whether step tracing is enabled.
+func isTraceEnabled() bool {
+	return os.Getenv(envTrace) == "1"
+}
+
+// logTraceEvent writes the given trace event to the plugin log.
+func logTraceEvent(event traceEvent) {
+	log.Infof("Trace: op=%q args=%q err=%v duration=%v.", event.op, event.args, event.err, event.duration)
+}
+
+// trace runs the given operation and, if tracing is enabled, reports it to the trace sink.
+// Callers pass only identifiers such as link names, MAC and IP addresses as args, never the
+// network config itself, so that tracing is safe to leave on.
+func trace(op string, args string, toRun func() error) error {
+	if !isTraceEnabled() {
+		return toRun()
+	}
+
+	start := time.Now()
+	err := toRun()
+	traceSink(traceEvent{op: op, args: args, err: err, duration: time.Since(start)})
+	return err
+}
+
+// runInNetNS runs the given function in the given netns, tracing the entry to and exit from it.
+func runInNetNS(ns netns.NetNS, toRun func() error) error {
+	if !isTraceEnabled() {
+		return ns.Run(toRun)
+	}
+
+	start := time.Now()
+	entered := false
+	err := ns.Run(func() error {
+		entered = true
+		traceSink(traceEvent{op: traceOpEnterNetNS, args: ns.GetPath(), duration: time.Since(start)})
+		start = time.Now()
+		return toRun()
+	})
+
+	if !entered {
+		traceSink(traceEvent{op: traceOpEnterNetNS, args: ns.GetPath(), err: err, duration: time.Since(start)})
+		return err
+	}
+
+	traceSink(traceEvent{op: traceOpExitNetNS, args: ns.GetPath(), err: err, duration: time.Since(start)})
+	return err
+}
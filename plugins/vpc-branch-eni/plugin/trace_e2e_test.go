@@ -0,0 +1,110 @@
+// +build e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/network/netns"
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTraceAdd tests the sequence of trace events for a successful ADD of a VLAN link.
+func TestTraceAdd(t *testing.T) {
+	ops := traceAdd(t, false)
+	assert.Equal(t, []string{
+		traceOpEnterNetNS,
+		traceOpExitNetNS,
+		traceOpCreateLink,
+		traceOpMoveLink,
+		traceOpEnterNetNS,
+		traceOpRenameLink,
+		traceOpSetUp,
+		traceOpAddAddr,
+		traceOpAddRoute,
+		traceOpExitNetNS,
+	}, ops)
+}
+
+// TestTraceAddBringUpAfterConfig tests that the VLAN link is brought up only after its
+// addresses are assigned, and before its routes are installed, with bringUpAfterConfig.
+func TestTraceAddBringUpAfterConfig(t *testing.T) {
+	ops := traceAdd(t, true)
+	assert.Equal(t, []string{
+		traceOpEnterNetNS,
+		traceOpExitNetNS,
+		traceOpCreateLink,
+		traceOpMoveLink,
+		traceOpEnterNetNS,
+		traceOpRenameLink,
+		traceOpAddAddr,
+		traceOpSetUp,
+		traceOpAddRoute,
+		traceOpExitNetNS,
+	}, ops)
+}
+
+// traceAdd runs a successful ADD of a VLAN link and returns the sequence of trace events.
+// It requires a trunk ENI with the MAC address below attached to the instance and up.
+func traceAdd(t *testing.T, bringUpAfterConfig bool) []string {
+	os.Setenv(envTrace, "1")
+	defer os.Unsetenv(envTrace)
+	var ops []string
+	var errs []error
+	traceSink = func(event traceEvent) {
+		ops = append(ops, event.op)
+		if event.err != nil {
+			errs = append(errs, event.err)
+		}
+	}
+	defer func() { traceSink = logTraceEvent }()
+
+	targetNS, err := netns.NewNetNS("testTraceNS")
+	require.NoError(t, err)
+	defer targetNS.Close()
+
+	trunkMAC, _ := net.ParseMAC("02:71:ca:81:41:1e")
+	branchMAC, _ := net.ParseMAC("02:e1:48:75:86:a4")
+	branchIP, branchPrefix, _ := net.ParseCIDR("172.31.19.6/20")
+	branchPrefix.IP = branchIP
+	netConfig := &config.NetConfig{
+		TrunkMACAddress:        trunkMAC,
+		BranchVlanID:           101,
+		VlanProtocol:           config.VlanProtocol8021Q,
+		BranchMACAddress:       branchMAC,
+		BranchIPAddress:        branchPrefix,
+		BranchIPAddresses:      []net.IPNet{*branchPrefix},
+		BranchGatewayIPAddress: net.ParseIP("172.31.16.1"),
+		InstallDefaultRoute:    true,
+		InterfaceType:          config.IfTypeVLAN,
+		InterfaceName:          "testIf",
+		BringUpAfterConfig:     bringUpAfterConfig,
+	}
+	netConfig.CNIVersion = "1.0.0"
+
+	_, err = Add(context.TODO(), "container_1", targetNS.GetPath(), netConfig)
+	require.NoError(t, err)
+	defer Del(context.TODO(), "container_1", targetNS.GetPath(), netConfig)
+
+	assert.Empty(t, errs)
+	return ops
+}
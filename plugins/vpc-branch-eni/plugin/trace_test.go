@@ -0,0 +1,155 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNetNS is a netns that runs functions in the current netns.
+type fakeNetNS struct {
+	path   string
+	setErr error
+}
+
+func (ns *fakeNetNS) GetFd() uintptr  { return 0 }
+func (ns *fakeNetNS) GetPath() string { return ns.path }
+func (ns *fakeNetNS) Close() error    { return nil }
+func (ns *fakeNetNS) Set() error      { return ns.setErr }
+
+func (ns *fakeNetNS) Run(toRun func() error) error {
+	if ns.setErr != nil {
+		return ns.setErr
+	}
+	return toRun()
+}
+
+// mockTraceSink enables tracing and records the trace events.
+func mockTraceSink() *[]traceEvent {
+	os.Setenv(envTrace, "1")
+	events := &[]traceEvent{}
+	traceSink = func(event traceEvent) {
+		*events = append(*events, event)
+	}
+	return events
+}
+
+// restoreTraceSink disables tracing and restores the real trace sink.
+func restoreTraceSink() {
+	os.Unsetenv(envTrace)
+	traceSink = logTraceEvent
+}
+
+// traceOps returns the operation names of the given trace events.
+func traceOps(events []traceEvent) []string {
+	var ops []string
+	for _, event := range events {
+		ops = append(ops, event.op)
+	}
+	return ops
+}
+
+// TestTraceDisabled tests that operations are not traced by default.
+func TestTraceDisabled(t *testing.T) {
+	events := mockTraceSink()
+	defer restoreTraceSink()
+	os.Unsetenv(envTrace)
+
+	ran := false
+	err := trace(traceOpSetUp, "eth1", func() error {
+		ran = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.Empty(t, *events)
+}
+
+// TestTraceRecordsError tests that a traced operation reports its arguments and error.
+func TestTraceRecordsError(t *testing.T) {
+	events := mockTraceSink()
+	defer restoreTraceSink()
+
+	opErr := errors.New("link not found")
+	err := trace(traceOpSetUp, "eth1", func() error { return opErr })
+	assert.Equal(t, opErr, err)
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, traceOpSetUp, (*events)[0].op)
+	assert.Equal(t, "eth1", (*events)[0].args)
+	assert.Equal(t, opErr, (*events)[0].err)
+}
+
+// TestTraceSequence tests the sequence of trace events for successful steps in a netns.
+func TestTraceSequence(t *testing.T) {
+	events := mockTraceSink()
+	defer restoreTraceSink()
+	mockRuleOps(nil)
+	defer restoreRuleOps()
+	setLinkMACAddress = func(string, net.HardwareAddr) error { return nil }
+	defer func() { setLinkMACAddress = realSetLinkMACAddress }()
+
+	nc := newTestNetConfig(t, testRouteTableNetConfig)
+	nc.InterfaceName = "eth0"
+	nc.InterfaceMACAddress, _ = net.ParseMAC("02:23:45:67:89:ac")
+
+	ns := &fakeNetNS{path: "/var/run/netns/test"}
+	err := runInNetNS(ns, func() error {
+		err := setInterfaceMACAddress(nc)
+		if err != nil {
+			return err
+		}
+		return addBranchRules(nc)
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		traceOpEnterNetNS,
+		traceOpSetMAC,
+		traceOpAddRule,
+		traceOpAddRule,
+		traceOpAddRule,
+		traceOpExitNetNS,
+	}, traceOps(*events))
+	assert.Equal(t, "/var/run/netns/test", (*events)[0].args)
+	assert.Equal(t, "eth0 02:23:45:67:89:ac", (*events)[1].args)
+	for _, event := range *events {
+		assert.NoError(t, event.err)
+	}
+}
+
+// TestTraceNetNSEnterFailure tests that a failure to enter a netns is traced without an exit.
+func TestTraceNetNSEnterFailure(t *testing.T) {
+	events := mockTraceSink()
+	defer restoreTraceSink()
+
+	setErr := errors.New("bad netns")
+	err := runInNetNS(&fakeNetNS{path: "/var/run/netns/test", setErr: setErr}, func() error {
+		t.Fatal("function ran outside of the netns")
+		return nil
+	})
+	assert.Equal(t, setErr, err)
+
+	require.Len(t, *events, 1)
+	assert.Equal(t, traceOpEnterNetNS, (*events)[0].op)
+	assert.Equal(t, setErr, (*events)[0].err)
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aws/amazon-vpc-cni-plugins/version"
+)
+
+const (
+	// VersionCommand is the command line subcommand that prints the plugin build metadata.
+	VersionCommand = version.Command
+)
+
+// PrintVersion writes the plugin name, version, git commit and build time injected at build
+// time, either as a line of text or as a JSON object.
+func PrintVersion(w io.Writer, asJSON bool) error {
+	if !asJSON {
+		_, err := fmt.Fprintln(w, version.PluginText(pluginName))
+		return err
+	}
+
+	versionInfo, err := version.PluginString(pluginName)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, versionInfo)
+	return err
+}
@@ -0,0 +1,60 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/amazon-vpc-cni-plugins/version"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPrintVersionJSON tests that the JSON version output has the plugin name and build metadata.
+func TestPrintVersionJSON(t *testing.T) {
+	version.Version = "1.2.3"
+	version.GitShortHash = "abcd123"
+	version.BuildTime = "2048-08-16T12:10:14-0800"
+
+	var buf bytes.Buffer
+	err := PrintVersion(&buf, true)
+	require.NoError(t, err)
+
+	var versionInfo map[string]string
+	err = json.Unmarshal(buf.Bytes(), &versionInfo)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"plugin":       "vpc-branch-eni",
+		"version":      "1.2.3",
+		"gitShortHash": "abcd123",
+		"built":        "2048-08-16T12:10:14-0800",
+	}, versionInfo)
+}
+
+// TestPrintVersionText tests the text version output.
+func TestPrintVersionText(t *testing.T) {
+	version.Version = "1.2.3"
+	version.GitShortHash = "abcd123"
+	version.BuildTime = "2048-08-16T12:10:14-0800"
+
+	var buf bytes.Buffer
+	err := PrintVersion(&buf, false)
+	require.NoError(t, err)
+	assert.Equal(t, "vpc-branch-eni version 1.2.3 (commit abcd123, built 2048-08-16T12:10:14-0800)\n", buf.String())
+}
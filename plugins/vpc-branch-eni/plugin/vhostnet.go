@@ -0,0 +1,54 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"os"
+
+	"github.com/aws/amazon-vpc-cni-plugins/plugins/vpc-branch-eni/config"
+
+	log "github.com/cihub/seelog"
+)
+
+const (
+	// vhostNetDevicePath is the path of the vhost-net device that accelerates TAP queues.
+	vhostNetDevicePath = "/dev/vhost-net"
+)
+
+// openVhostNet opens the vhost-net device. It is a variable so that it can be replaced in unit tests.
+var openVhostNet = func() (*os.File, error) {
+	return os.OpenFile(vhostNetDevicePath, os.O_RDWR, 0)
+}
+
+// checkVhostNet verifies that the vhost-net device is available if requested for the TAP link.
+//
+// This is only a pre-flight check, and the plugin does not attach vhost-net to the TAP link.
+// vhost-net is bound to the memory of the process that owns it, and a file descriptor opened by
+// the plugin is closed when it exits, so the VMM must open the device and attach it to the TAP
+// queues itself. Checking the device here fails ADD early instead of leaving the VMM to fall back
+// to unaccelerated queues.
+func checkVhostNet(tapCfg *config.TAPConfig) error {
+	if !tapCfg.VhostNet {
+		return nil
+	}
+
+	log.Infof("Checking for vhost-net device %s.", vhostNetDevicePath)
+	f, err := openVhostNet()
+	if err != nil {
+		log.Errorf("Failed to open vhost-net device %s: %v.", vhostNetDevicePath, err)
+		return err
+	}
+
+	return f.Close()
+}
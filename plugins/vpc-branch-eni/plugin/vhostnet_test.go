@@ -0,0 +1,58 @@
+// +build !integration,!e2e
+
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// realOpenVhostNet is the vhost-net device open operation used outside of unit tests.
+var realOpenVhostNet = openVhostNet
+
+// TestCheckVhostNet tests that the vhost-net device is opened only if requested.
+func TestCheckVhostNet(t *testing.T) {
+	opened := 0
+	openVhostNet = func() (*os.File, error) {
+		opened++
+		f, err := ioutil.TempFile("", "vhost-net")
+		if err == nil {
+			os.Remove(f.Name())
+		}
+		return f, err
+	}
+	defer func() { openVhostNet = realOpenVhostNet }()
+
+	nc := newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"interfaceType":"tap", "uid":"42", "gid":"42"}`)
+	require.NoError(t, checkVhostNet(nc.Tap))
+	assert.Equal(t, 0, opened)
+
+	nc = newTestNetConfig(t, `{"trunkName":"eth1", "branchVlanID":"100", "branchMACAddress":"02:23:45:67:89:ab",
+		"interfaceType":"tap", "uid":"42", "gid":"42", "vhostNet":true}`)
+	require.NoError(t, checkVhostNet(nc.Tap))
+	assert.Equal(t, 1, opened)
+
+	// A missing device fails the check.
+	openVhostNet = func() (*os.File, error) {
+		return nil, os.ErrNotExist
+	}
+	assert.Error(t, checkVhostNet(nc.Tap))
+}
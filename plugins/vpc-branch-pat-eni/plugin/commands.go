@@ -27,7 +27,7 @@ import (
 	log "github.com/cihub/seelog"
 	cniSkel "github.com/containernetworking/cni/pkg/skel"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
-	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/current"
+	cniTypesCurrent "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 )
@@ -199,7 +199,8 @@ func (plugin *Plugin) Del(args *cniSkel.CmdArgs) error {
 	// namespace and all virtual interfaces in it. Otherwise, leave it running.
 	if lastVethLinkDeleted && netConfig.CleanupPATNetNS {
 		log.Infof("Deleting PAT network namespace: %v.", patNetNSName)
-		err = patNetNS.Close()
+		patNetNS.Close()
+		err = netns.DeleteNetNSByName(patNetNSName)
 		if err != nil {
 			log.Errorf("Failed to delete netns: %v.", err)
 		}
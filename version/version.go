@@ -30,7 +30,11 @@ var GitShortHash string
 // BuildTime is the build time stamp.
 var BuildTime string
 
+// unknown is printed in place of build metadata that was not set at build time.
+const unknown = "unknown"
+
 type versionInfo struct {
+	Plugin       string `json:"plugin,omitempty"`
 	Version      string `json:"version"`
 	GitShortHash string `json:"gitShortHash"`
 	Built        string `json:"built"`
@@ -38,7 +42,13 @@ type versionInfo struct {
 
 // String returns a JSON version string from the versionInfo type.
 func String() (string, error) {
+	return PluginString("")
+}
+
+// PluginString returns a JSON version string for the given plugin.
+func PluginString(pluginName string) (string, error) {
 	verInfo := versionInfo{
+		Plugin:       pluginName,
 		Version:      Version,
 		GitShortHash: GitShortHash,
 		Built:        BuildTime,
@@ -51,3 +61,17 @@ func String() (string, error) {
 
 	return string(verInfoJSON), nil
 }
+
+// PluginText returns a human-readable version string for the given plugin.
+func PluginText(pluginName string) string {
+	return fmt.Sprintf("%s version %s (commit %s, built %s)",
+		pluginName, orUnknown(Version), orUnknown(GitShortHash), orUnknown(BuildTime))
+}
+
+// orUnknown returns the given build metadata, or unknown if it was not set.
+func orUnknown(value string) string {
+	if value == "" {
+		return unknown
+	}
+	return value
+}
@@ -38,3 +38,11 @@ func TestVersionString(t *testing.T) {
 	}
 	assert.Equal(t, retVersionInfo, expectedVersionInfo)
 }
+
+func TestPluginText(t *testing.T) {
+	Version = "0.1.0"
+	GitShortHash = ""
+	BuildTime = "2048-08-16T12:10:14-08:00"
+
+	assert.Equal(t, "test version 0.1.0 (commit unknown, built 2048-08-16T12:10:14-08:00)", PluginText("test"))
+}